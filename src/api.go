@@ -10,6 +10,9 @@ import (
 	"time"
 
 	"github.com/cybertec-postgresql/pgwatch3/db"
+	"github.com/cybertec-postgresql/pgwatch3/metrics"
+	"github.com/cybertec-postgresql/pgwatch3/reaper"
+	"github.com/cybertec-postgresql/pgwatch3/sinks"
 	"golang.org/x/exp/slices"
 )
 
@@ -17,6 +20,9 @@ type uiapihandler struct{}
 
 var uiapi uiapihandler
 
+// gatherer is set as soon as it is created, the UI is served before that
+var gatherer atomic.Pointer[reaper.Gatherer]
+
 func (uiapi uiapihandler) TryConnectToDB(params []byte) (err error) {
 	return db.TryDatabaseConnection(context.TODO(), string(params))
 }
@@ -185,53 +191,108 @@ func (uiapi uiapihandler) GetStats() string {
 			"totalMetricsReusedFromCacheCounter": %d,
 			"metricPointsPerMinuteLast5MinAvg": %v,
 			"metricsDropped": %d,
-			"totalMetricFetchFailuresCounter": %d
+			"totalMetricFetchFailuresCounter": %d,
+			"totalSkippedTicksCounter": %d,
+			"backedOffMetricIntervals": %s
 		},
 		"datastore": {
 			"secondsFromLastSuccessfulDatastoreWrite": %d,
 			"datastoreWriteFailuresCounter": %d,
 			"datastoreSuccessfulWritesCounter": %d,
-			"datastoreAvgSuccessfulWriteTimeMillis": %.1f
+			"datastoreAvgSuccessfulWriteTimeMillis": %.1f,
+			"timescaleCompression": %s
 		},
 		"general": {
 			"totalDatasetsFetchedCounter": %d,
 			"databasesMonitored": %d,
 			"databasesConfigured": %d,
 			"unreachableDBs": %d,
+			"circuitBrokenDBs": %s,
 			"gathererUptimeSeconds": %d
 		}
 	}`
 
-	secondsFromLastSuccessfulDatastoreWrite := atomic.LoadInt64(&lastSuccessfulDatastoreWriteTimeEpoch)
-	totalMetrics := atomic.LoadUint64(&totalMetricsFetchedCounter)
-	cacheMetrics := atomic.LoadUint64(&totalMetricsReusedFromCacheCounter)
-	totalDatasets := atomic.LoadUint64(&totalDatasetsFetchedCounter)
-	metricsDropped := atomic.LoadUint64(&totalMetricsDroppedCounter)
-	metricFetchFailuresCounter := atomic.LoadUint64(&totalMetricFetchFailuresCounter)
-	datastoreFailures := atomic.LoadUint64(&datastoreWriteFailuresCounter)
-	datastoreSuccess := atomic.LoadUint64(&datastoreWriteSuccessCounter)
-	datastoreTotalTimeMicros := atomic.LoadUint64(&datastoreTotalWriteTimeMicroseconds) // successful writes only
-	datastoreAvgSuccessfulWriteTimeMillis := float64(datastoreTotalTimeMicros) / float64(datastoreSuccess) / 1000.0
-	gathererUptimeSeconds := uint64(time.Since(gathererStartTime).Seconds())
-	metricPointsPerMinute := atomic.LoadInt64(&metricPointsPerMinuteLast5MinAvg)
-	if metricPointsPerMinute == -1 { // calculate avg. on the fly if 1st summarization hasn't happened yet
-		metricPointsPerMinute = int64((totalMetrics * 60) / gathererUptimeSeconds)
-	}
-	monitoredDbs := getMonitoredDatabasesSnapshot()
-	databasesConfigured := len(monitoredDbs) // including replicas
-	databasesMonitored := 0
-	for _, md := range monitoredDbs {
-		if shouldDbBeMonitoredBasedOnCurrentState(md) {
-			databasesMonitored++
+	var stats reaper.Stats
+	var writeStats sinks.WriteStats
+	compression := []byte("{}")
+	if g := gatherer.Load(); g != nil {
+		stats = g.GetStats()
+		writeStats = g.GetWriteStats()
+		if mw := g.MetricsReader(); mw != nil {
+			if cs, err := mw.CompressionStats(); err == nil {
+				compression, _ = json.Marshal(cs)
+			} else {
+				logger.Error("Failed to read Timescale compression stats: ", err)
+			}
 		}
 	}
-	unreachableDBsLock.RLock()
-	unreachableDBs := len(unreachableDB)
-	unreachableDBsLock.RUnlock()
+	var datastoreAvgSuccessfulWriteTimeMillis float64
+	if writeStats.WriteSuccesses > 0 {
+		datastoreAvgSuccessfulWriteTimeMillis = float64(writeStats.TotalWriteTimeMicros) / float64(writeStats.WriteSuccesses) / 1000.0
+	}
+	backedOff, _ := json.Marshal(stats.BackedOffMetricIntervals)
+	circuitBroken, _ := json.Marshal(stats.CircuitBrokenDBs)
 	return fmt.Sprintf(jsonResponseTemplate, version, dbapi, commit, date,
-		totalMetrics, cacheMetrics, metricPointsPerMinute, metricsDropped,
-		metricFetchFailuresCounter, time.Now().Unix()-secondsFromLastSuccessfulDatastoreWrite,
-		datastoreFailures, datastoreSuccess, datastoreAvgSuccessfulWriteTimeMillis,
-		totalDatasets, databasesMonitored, databasesConfigured, unreachableDBs,
-		gathererUptimeSeconds)
+		stats.MetricsFetched, stats.MetricsReusedFromCache, stats.MetricPointsPerMinute, writeStats.MetricsDropped,
+		stats.MetricFetchFailures, stats.SkippedTicks, backedOff, time.Now().Unix()-writeStats.LastSuccessfulWriteEpoch,
+		writeStats.WriteFailures, writeStats.WriteSuccesses, datastoreAvgSuccessfulWriteTimeMillis, compression,
+		stats.DatasetsFetched, stats.DatabasesMonitored, stats.DatabasesConfigured, stats.DatabasesUnreachable, circuitBroken,
+		stats.UptimeSeconds)
+}
+
+// GetHealth returns the reachability and the per metric fetch outcomes of monitored DBs, optionally for a single DB only
+func (uiapi uiapihandler) GetHealth(dbname string) (res string, err error) {
+	health := make([]reaper.DBHealth, 0)
+	if g := gatherer.Load(); g != nil {
+		health = g.GetHealth(dbname)
+	}
+	b, err := json.Marshal(health)
+	return string(b), err
+}
+
+// GetMetricOrigins returns the layer each effective metric definition came from and the layers it overrides
+func (uiapi uiapihandler) GetMetricOrigins() (res string, err error) {
+	origins := make([]metrics.MetricOrigin, 0)
+	if g := gatherer.Load(); g != nil {
+		origins = g.GetMetricDefinitionOrigins()
+	}
+	b, err := json.Marshal(origins)
+	return string(b), err
+}
+
+// GetRecommendationAcks returns acknowledged / snoozed recommendations, optionally for a single DB only
+func (uiapi uiapihandler) GetRecommendationAcks(dbname string) (res string, err error) {
+	sql := `select coalesce(jsonb_agg(to_jsonb(a) order by ra_dbname, ra_created_on), '[]')
+	from pgwatch3.reco_ack a where $1 = '' or ra_dbname = $1`
+	err = configDb.QueryRow(context.TODO(), sql, dbname).Scan(&res)
+	return
+}
+
+// AddRecommendationAck acknowledges a recommendation for a DB. If "ra_snoozed_until" is given,
+// the recommendation re-appears after that time
+func (uiapi uiapihandler) AddRecommendationAck(params []byte) error {
+	sql := `INSERT INTO pgwatch3.reco_ack(ra_dbname, ra_reco_id, ra_snoozed_until, ra_comment) VALUES ($1, $2, $3, $4)
+	ON CONFLICT (ra_dbname, ra_reco_id) DO UPDATE SET ra_snoozed_until = excluded.ra_snoozed_until,
+	ra_comment = excluded.ra_comment, ra_created_on = now()`
+	var m map[string]any
+	err := json.Unmarshal(params, &m)
+	if err == nil {
+		_, err = configDb.Exec(context.TODO(), sql, m["ra_dbname"], m["ra_reco_id"], m["ra_snoozed_until"], m["ra_comment"])
+	}
+	return err
+}
+
+// DeleteRecommendationAck removes the acknowledgement, the recommendation will be reported again
+func (uiapi uiapihandler) DeleteRecommendationAck(dbname, id string) error {
+	_, err := configDb.Exec(context.TODO(), "DELETE FROM pgwatch3.reco_ack WHERE ra_dbname = $1 AND ra_reco_id = $2", dbname, id)
+	return err
+}
+
+// GetMeasurements queries stored measurements from the metric storage
+func (uiapi uiapihandler) GetMeasurements(q sinks.MeasurementQuery) (metrics.Measurements, error) {
+	g := gatherer.Load()
+	if g == nil || g.MetricsReader() == nil {
+		return nil, sinks.ErrNoReader
+	}
+	return g.MetricsReader().ReadMeasurements(q)
 }
@@ -12,14 +12,22 @@ const (
 	ConfigPgURL Kind = iota
 	ConfigFile
 	ConfigFolder
+	ConfigHTTP
+	ConfigAdHoc
 	ConfigError
 )
 
 // ConnectionOpts specifies the database connection options
 type ConnectionOpts struct {
-	Config                    string `short:"c" long:"config" mapstructure:"config" description:"File or folder of YAML files containing info on which DBs to monitor and where to store metrics" env:"PW3_CONFIG"`
-	ServersRefreshLoopSeconds int    `long:"servers-refresh-loop-seconds" mapstructure:"servers-refresh-loop-seconds" description:"Sleep time for the main loop" env:"PW3_SERVERS_REFRESH_LOOP_SECONDS" default:"120"`
-	Init                      bool   `long:"init" description:"Initialize configuration database schema to the latest version and exit. Can be used with --upgrade"`
+	Config                    string   `short:"c" long:"config" mapstructure:"config" description:"File or folder of YAML files, http(s) URL returning the same as JSON, or config DB connection string containing info on which DBs to monitor" env:"PW3_CONFIG"`
+	ExtraConfig               []string `long:"extra-config" mapstructure:"extra-config" description:"Additional file, folder or http(s) URL sources of DBs to monitor. DBs already found in --config or a previous --extra-config are ignored" env:"PW3_EXTRA_CONFIG"`
+	ServersRefreshLoopSeconds int      `long:"servers-refresh-loop-seconds" mapstructure:"servers-refresh-loop-seconds" description:"Sleep time for the main loop" env:"PW3_SERVERS_REFRESH_LOOP_SECONDS" default:"120"`
+	Init                      bool     `long:"init" description:"Initialize configuration database schema to the latest version and exit. Can be used with --upgrade"`
+	HALeaseSeconds            int      `long:"ha-lease-seconds" mapstructure:"ha-lease-seconds" description:"Active/passive HA mode: only the gatherer holding a lease in the config DB fetches metrics, a peer takes over if not renewed for so many seconds. 0 disables" env:"PW3_HA_LEASE_SECONDS" default:"0"`
+	HAInstanceName            string   `long:"ha-instance-name" mapstructure:"ha-instance-name" description:"Unique name of this gatherer for HA leases. By default hostname:pid" env:"PW3_HA_INSTANCE_NAME"`
+	ShardCount                int      `long:"shard-count" mapstructure:"shard-count" description:"Static sharding: number of gatherers splitting the monitored DBs among themselves. 0 disables" env:"PW3_SHARD_COUNT" default:"0"`
+	ShardIndex                int      `long:"shard-index" mapstructure:"shard-index" description:"Static sharding: zero-based index of this gatherer, less than --shard-count" env:"PW3_SHARD_INDEX" default:"0"`
+	ShardMembershipSeconds    int      `long:"shard-membership-seconds" mapstructure:"shard-membership-seconds" description:"Dynamic sharding: gatherers register in the config DB and split the monitored DBs among the live members, a member not renewing its registration for so many seconds is dropped. 0 disables" env:"PW3_SHARD_MEMBERSHIP_SECONDS" default:"0"`
 }
 
 // MetricStoreOpts specifies the storage configuration to store metrics data
@@ -28,9 +36,12 @@ type MetricOpts struct {
 	RealDbnameField       string   `long:"real-dbname-field" mapstructure:"real-dbname-field" description:"Tag key for real DB name if --add-real-dbname enabled" env:"PW3_REAL_DBNAME_FIELD" default:"real_dbname"`
 	SystemIdentifierField string   `long:"system-identifier-field" mapstructure:"system-identifier-field" description:"Tag key for system identifier value if --add-system-identifier" env:"PW3_SYSTEM_IDENTIFIER_FIELD" default:"sys_id"`
 	MetricsFolder         string   `short:"m" long:"metrics-folder" mapstructure:"metrics-folder" description:"Folder of metrics definitions" env:"PW3_METRICS_FOLDER"`
+	MetricLayers          string   `long:"metric-layers" mapstructure:"metric-layers" description:"Comma separated sources of metric definitions, a metric in a later one replaces the same metric of earlier ones: builtin, folder (--metrics-folder), configdb. By default the config DB, or else the metrics folder, or else the builtin metrics" env:"PW3_METRIC_LAYERS"`
 	NoHelperFunctions     bool     `long:"no-helper-functions" mapstructure:"no-helper-functions" description:"Ignore metric definitions using helper functions (in form get_smth()) and don't also roll out any helpers automatically" env:"PW3_NO_HELPER_FUNCTIONS"`
 	PGMetricStoreConnStr  []string `long:"pg-metric-store-conn-str" mapstructure:"pg-metric-store-conn-str" description:"PG Metric Store" env:"PW3_PG_METRIC_STORE_CONN_STR"`
 	PGRetentionDays       int      `long:"pg-retention-days" mapstructure:"pg-retention-days" description:"If set, metrics older than that will be deleted" default:"14" env:"PW3_PG_RETENTION_DAYS"`
+	PGRollupTiers         string   `long:"pg-rollup-tiers" mapstructure:"pg-rollup-tiers" description:"Downsampling tiers for Postgres storage as comma separated bucket:retention_days pairs, e.g. 5m:90,1h:365" env:"PW3_PG_ROLLUP_TIERS"`
+	PGRowLevelSecurity    bool     `long:"pg-rls" mapstructure:"pg-rls" description:"Stamp Postgres metric rows with the monitored DB group and only let per-group roles read their rows via row-level security" env:"PW3_PG_RLS"`
 	PrometheusPort        int64    `long:"prometheus-port" mapstructure:"prometheus-port" description:"Prometheus port. Effective with --datastore=prometheus" default:"9187" env:"PW3_PROMETHEUS_PORT"`
 	PrometheusListenAddr  string   `long:"prometheus-listen-addr" mapstructure:"prometheus-listen-addr" description:"Network interface to listen on" default:"0.0.0.0" env:"PW3_PROMETHEUS_LISTEN_ADDR"`
 	PrometheusNamespace   string   `long:"prometheus-namespace" mapstructure:"prometheus-namespace" description:"Prefix for all non-process (thus Postgres) metrics" default:"pgwatch3" env:"PW3_PROMETHEUS_NAMESPACE"`
@@ -51,15 +62,17 @@ type LoggingOpts struct {
 // StartOpts specifies the application startup options
 type StartOpts struct {
 	// File    string `short:"f" long:"file" description:"SQL script file to execute during startup"`
-	// Upgrade bool   `long:"upgrade" description:"Upgrade database to the latest version"`
+	Upgrade       bool `long:"upgrade" mapstructure:"upgrade" description:"Upgrade configuration and metric storage database schemas to the latest version and exit. Pending migrations are also applied on every start" env:"PW3_UPGRADE"`
+	UpgradeDryRun bool `long:"upgrade-dry-run" mapstructure:"upgrade-dry-run" description:"List pending schema migrations of the configuration and metric storage databases and exit" env:"PW3_UPGRADE_DRY_RUN"`
 	// Debug   bool   `long:"debug" description:"Run in debug mode"`
 }
 
 // WebUIOpts specifies the internal web UI server options
 type WebUIOpts struct {
-	WebAddr     string `long:"web-addr" mapstructure:"web-addr" description:"TCP address in the form 'host:port' to listen on" default:":8080" env:"PW3_WEBADDR"`
-	WebUser     string `long:"web-user" mapstructure:"web-user" description:"Admin login" env:"PW3_WEBUSER"`
-	WebPassword string `long:"web-password" mapstructure:"web-password" description:"Admin password" env:"PW3_WEBPASSWORD"`
+	WebAddr          string `long:"web-addr" mapstructure:"web-addr" description:"TCP address in the form 'host:port' to listen on" default:":8080" env:"PW3_WEBADDR"`
+	WebUser          string `long:"web-user" mapstructure:"web-user" description:"Admin login" env:"PW3_WEBUSER"`
+	WebPassword      string `long:"web-password" mapstructure:"web-password" description:"Admin password" env:"PW3_WEBPASSWORD"`
+	WebMetricsPublic bool   `long:"web-metrics-public" mapstructure:"web-metrics-public" description:"Serve the self-monitoring /metrics endpoint without authentication, e.g. for Prometheus scrapers" env:"PW3_WEB_METRICS_PUBLIC"`
 }
 
 type Options struct {
@@ -82,10 +95,22 @@ type Options struct {
 	InstanceLevelCacheMaxSeconds int64          `long:"instance-level-cache-max-seconds" mapstructure:"instance-level-cache-max-seconds" description:"Max allowed staleness for instance level metric data shared between DBs of an instance. Affects 'continuous' host types only. Set to 0 to disable" env:"PW3_INSTANCE_LEVEL_CACHE_MAX_SECONDS" default:"30"`
 	MinDbSizeMB                  int64          `long:"min-db-size-mb" mapstructure:"min-db-size-mb" description:"Smaller size DBs will be ignored and not monitored until they reach the threshold." env:"PW3_MIN_DB_SIZE_MB" default:"0"`
 	MaxParallelConnectionsPerDb  int            `long:"max-parallel-connections-per-db" mapstructure:"max-parallel-connections-per-db" description:"Max parallel metric fetches per DB. Note the multiplication effect on multi-DB instances" env:"PW3_MAX_PARALLEL_CONNECTIONS_PER_DB" default:"2"`
+	MaxParallelFetches           int            `long:"max-parallel-fetches" mapstructure:"max-parallel-fetches" description:"Max metric fetches running at the same time over all monitored DBs. 0 means unlimited" env:"PW3_MAX_PARALLEL_FETCHES" default:"0"`
+	MaxParallelInstanceFetches   int            `long:"max-parallel-fetches-per-instance" mapstructure:"max-parallel-fetches-per-instance" description:"Max metric fetches running at the same time per monitored instance, i.e. over all DBs of a continuous discovery entry. 0 means unlimited" env:"PW3_MAX_PARALLEL_FETCHES_PER_INSTANCE" default:"0"`
+	ScheduleSplay                time.Duration  `long:"schedule-splay" mapstructure:"schedule-splay" description:"Spread the first fetch of each DB / metric by a stable offset up to this duration (capped to the metric interval) to avoid fetch bursts" env:"PW3_SCHEDULE_SPLAY" default:"30s"`
+	MaxBackoffFactor             int            `long:"max-backoff-factor" mapstructure:"max-backoff-factor" description:"Failing or overrunning metrics are fetched at exponentially stretched intervals, up to so many times the configured interval. 1 disables" env:"PW3_MAX_BACKOFF_FACTOR" default:"16"`
+	CircuitBreakerFailures       int            `long:"circuit-breaker-failures" mapstructure:"circuit-breaker-failures" description:"Pause all fetches of a DB after so many consecutive connection failures. 0 disables" env:"PW3_CIRCUIT_BREAKER_FAILURES" default:"5"`
+	CircuitBreakerCooldown       time.Duration  `long:"circuit-breaker-cooldown" mapstructure:"circuit-breaker-cooldown" description:"Time to pause fetches of an unreachable DB before trying again" env:"PW3_CIRCUIT_BREAKER_COOLDOWN" default:"1m"`
+	ShutdownTimeout              time.Duration  `long:"shutdown-timeout" mapstructure:"shutdown-timeout" description:"Time to wait on shutdown for in-flight fetches to finish, and then again for storing the queued measurements" env:"PW3_SHUTDOWN_TIMEOUT" default:"30s"`
+	TraceExporter                string         `long:"trace-exporter" mapstructure:"trace-exporter" description:"Export OpenTelemetry traces of metric fetches and sink writes. 'otlp' is configured via the standard OTEL_EXPORTER_OTLP_* env. vars" choice:"otlp" choice:"file" env:"PW3_TRACE_EXPORTER"`
+	TraceFile                    string         `long:"trace-file" mapstructure:"trace-file" description:"File to append JSON encoded spans to with --trace-exporter=file" default:"/tmp/pgwatch3-traces.json" env:"PW3_TRACE_FILE"`
+	TraceSampleRatio             float64        `long:"trace-sample-ratio" mapstructure:"trace-sample-ratio" description:"Share of metric fetches to trace, between 0 and 1" default:"1" env:"PW3_TRACE_SAMPLE_RATIO"`
 	Version                      bool           `long:"version" mapstructure:"version" description:"Show Git build version and exit" env:"PW3_VERSION"`
 	Ping                         bool           `long:"ping" mapstructure:"ping" description:"Try to connect to all configured DB-s, report errors and then exit" env:"PW3_PING"`
 	EmergencyPauseTriggerfile    string         `long:"emergency-pause-triggerfile" mapstructure:"emergency-pause-triggerfile" description:"When the file exists no metrics will be temporarily fetched / scraped" env:"PW3_EMERGENCY_PAUSE_TRIGGERFILE" default:"/tmp/pgwatch3-emergency-pause"`
 	TryCreateListedExtsIfMissing string         `long:"try-create-listed-exts-if-missing" mapstructure:"try-create-listed-exts-if-missing" description:"Try creating the listed extensions (comma sep.) on first connect for all monitored DBs when missing. Main usage - pg_stat_statements" env:"PW3_TRY_CREATE_LISTED_EXTS_IF_MISSING" default:""`
+	LogParseStateDir             string         `long:"log-parse-state-dir" mapstructure:"log-parse-state-dir" description:"Folder to persist server log parsing offsets to, for resuming after restarts. Default: pgwatch3/logparse in the user config dir, 'off' disables" env:"PW3_LOG_PARSE_STATE_DIR"`
+	RecoUnusedIndexDays          int            `long:"reco-unused-index-days" mapstructure:"reco-unused-index-days" description:"Recommend dropping indexes not scanned for so many days of index_stats history. Set to 0 to disable" env:"PW3_RECO_UNUSED_INDEX_DAYS" default:"14"`
 }
 
 // NewCmdOptions returns a new instance of CmdOptions with default values
@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/jackc/pgx/v5"
@@ -49,12 +51,24 @@ func (c Options) VersionOnly() bool {
 	return len(os.Args) == 2 && c.Version
 }
 
+// GetConfigKind returns where the monitored DBs are configured
 func (c Options) GetConfigKind() (_ Kind, err error) {
-	if _, err := pgx.ParseConfig(c.Connection.Config); err == nil {
+	if c.IsAdHocMode() {
+		return Kind(ConfigAdHoc), nil
+	}
+	return GetConfigKindOf(c.Connection.Config)
+}
+
+// GetConfigKindOf returns the kind of a --config or --extra-config value
+func GetConfigKindOf(location string) (_ Kind, err error) {
+	if strings.HasPrefix(location, "http://") || strings.HasPrefix(location, "https://") {
+		return Kind(ConfigHTTP), nil
+	}
+	if _, err := pgx.ParseConfig(location); err == nil {
 		return Kind(ConfigPgURL), nil
 	}
 	var fi os.FileInfo
-	if fi, err = os.Stat(c.Connection.Config); err == nil {
+	if fi, err = os.Stat(location); err == nil {
 		if fi.IsDir() {
 			return Kind(ConfigFolder), nil
 		}
@@ -70,11 +84,39 @@ func validateConfig(c *Options) error {
 	if c.MaxParallelConnectionsPerDb < 1 {
 		return errors.New("--max-parallel-connections-per-db must be >= 1")
 	}
+	if c.MaxParallelFetches < 0 || c.MaxParallelInstanceFetches < 0 {
+		return errors.New("--max-parallel-fetches and --max-parallel-fetches-per-instance must be >= 0")
+	}
+	if c.MaxBackoffFactor < 1 {
+		return errors.New("--max-backoff-factor must be >= 1")
+	}
+	if c.CircuitBreakerFailures < 0 || c.CircuitBreakerCooldown < 0 {
+		return errors.New("--circuit-breaker-failures and --circuit-breaker-cooldown must be >= 0")
+	}
+	if c.ScheduleSplay < 0 {
+		return errors.New("--schedule-splay must be >= 0")
+	}
+	if c.ShutdownTimeout < 0 {
+		return errors.New("--shutdown-timeout must be >= 0")
+	}
+	if c.TraceSampleRatio < 0 || c.TraceSampleRatio > 1 {
+		return errors.New("--trace-sample-ratio must be between 0 and 1")
+	}
+	if c.Connection.ShardCount < 0 || c.Connection.ShardCount > 0 && (c.Connection.ShardIndex < 0 || c.Connection.ShardIndex >= c.Connection.ShardCount) {
+		return errors.New("--shard-index must be between 0 and --shard-count - 1")
+	}
+	if c.Connection.ShardCount > 0 && c.Connection.ShardMembershipSeconds > 0 {
+		return errors.New("--shard-count and --shard-membership-seconds are mutually exclusive")
+	}
 
 	if c.Metric.MetricsFolder > "" && !checkFolderExistsAndReadable(c.Metric.MetricsFolder) {
 		return fmt.Errorf("Could not read --metrics-folder path %s", c.Metric.MetricsFolder)
 	}
 
+	if err := validateMetricLayers(c); err != nil {
+		return err
+	}
+
 	if err := validateAesGcmConfig(c); err != nil {
 		return err
 	}
@@ -90,6 +132,50 @@ func validateConfig(c *Options) error {
 	return nil
 }
 
+// GetLogParseStateDir returns the --log-parse-state-dir, by default in the user config dir (e.g. ~/.config on Linux) to
+// survive reboots. Empty if disabled or no user config dir is known
+func (c Options) GetLogParseStateDir() string {
+	switch c.LogParseStateDir {
+	case "off":
+		return ""
+	case "":
+		dir, err := os.UserConfigDir()
+		if err != nil {
+			return ""
+		}
+		return filepath.Join(dir, "pgwatch3", "logparse")
+	}
+	return c.LogParseStateDir
+}
+
+// GetMetricLayers returns the --metric-layers, empty if not set
+func (c Options) GetMetricLayers() []string {
+	if c.Metric.MetricLayers == "" {
+		return nil
+	}
+	layers := strings.Split(c.Metric.MetricLayers, ",")
+	for i := range layers {
+		layers[i] = strings.TrimSpace(layers[i])
+	}
+	return layers
+}
+
+func validateMetricLayers(c *Options) error {
+	seen := make(map[string]bool)
+	for _, layer := range c.GetMetricLayers() {
+		switch {
+		case layer != MetricLayerBuiltin && layer != MetricLayerFolder && layer != MetricLayerConfigDB:
+			return fmt.Errorf("--metric-layers can be of: %s, %s, %s, got: %s", MetricLayerBuiltin, MetricLayerFolder, MetricLayerConfigDB, layer)
+		case seen[layer]:
+			return fmt.Errorf("--metric-layers contains %s more than once", layer)
+		case layer == MetricLayerFolder && c.Metric.MetricsFolder == "":
+			return errors.New("--metric-layers=folder requires --metrics-folder")
+		}
+		seen[layer] = true
+	}
+	return nil
+}
+
 func validateAesGcmConfig(c *Options) error {
 	if c.AesGcmKeyphraseFile > "" {
 		_, err := os.Stat(c.AesGcmKeyphraseFile)
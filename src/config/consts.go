@@ -7,3 +7,7 @@ const DbTypePgPOOL = "pgpool"
 const DbTypePatroni = "patroni"
 const DbTypePatroniCont = "patroni-continuous-discovery"
 const DbTypePatroniNamespaceDiscovery = "patroni-namespace-discovery"
+
+const MetricLayerBuiltin = "builtin"
+const MetricLayerFolder = "folder"
+const MetricLayerConfigDB = "configdb"
@@ -66,7 +66,10 @@ func InitAndTestConfigStoreConnection(ctx context.Context, connStr string) (conf
 	}); err != nil {
 		return nil, err
 	}
-	err = ExecuteConfigSchemaScripts(ctx, configDb)
+	if err = ExecuteConfigSchemaScripts(ctx, configDb); err != nil {
+		return
+	}
+	err = MigrateConfigSchema(ctx, configDb)
 	return
 }
 
@@ -85,7 +88,10 @@ func InitAndTestMetricStoreConnection(ctx context.Context, connStr string) (metr
 	}); err != nil {
 		return nil, err
 	}
-	err = ExecuteMetricSchemaScripts(ctx, metricDb)
+	if err = ExecuteMetricSchemaScripts(ctx, metricDb); err != nil {
+		return
+	}
+	err = MigrateMetricSchema(ctx, metricDb)
 	return
 }
 
@@ -101,6 +107,8 @@ var (
 		sqlMetricEnsurePartitionTimescale,
 		sqlMetricChangeChunkIntervalTimescale,
 		sqlMetricChangeCompressionIntervalTimescale,
+		sqlMetricRollup,
+		sqlMetricRowLevelSecurity,
 	}
 )
 
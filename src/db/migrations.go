@@ -0,0 +1,160 @@
+package db
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/cybertec-postgresql/pgwatch3/log"
+	"github.com/jackc/pgx/v5"
+)
+
+// Migration is a versioned schema change. Applied migrations are recorded in the "schema_version" table of the
+// schema. As migrations also run on freshly created schemas, already containing the change, SQL must be idempotent
+type Migration struct {
+	Version     string
+	Description string
+	SQLs        []string
+}
+
+var (
+	// ConfigMigrations are applied in order to the "pgwatch3" configuration schema
+	ConfigMigrations = []Migration{
+		{
+			Version:     "0001",
+			Description: "recommendation acknowledgements",
+			SQLs: []string{`create table if not exists pgwatch3.reco_ack (
+    ra_dbname           text        not null,
+    ra_reco_id          text        not null,
+    ra_snoozed_until    timestamptz,
+    ra_comment          text,
+    ra_created_on       timestamptz not null default now(),
+    primary key (ra_dbname, ra_reco_id)
+)`},
+		},
+		{
+			Version:     "0002",
+			Description: "gatherer HA leases",
+			SQLs: []string{`create table if not exists pgwatch3.gatherer_lease (
+    gl_name         text        primary key,
+    gl_holder       text        not null,
+    gl_expires_on   timestamptz not null
+)`},
+		},
+		{
+			Version:     "0003",
+			Description: "time zone for metric schedules",
+			SQLs:        []string{`alter table pgwatch3.monitored_db add column if not exists md_timezone text`},
+		},
+	}
+	// MetricMigrations are applied in order to the "admin" metric storage schema
+	MetricMigrations = []Migration{
+		{
+			Version:     "0001",
+			Description: "typed storage schema",
+			SQLs:        []string{sqlMetricEnsurePartitionPostgres, sqlMigrationMetricTypedStorage},
+		},
+		{
+			Version:     "0002",
+			Description: "rollup tiers and retention policy functions",
+			SQLs:        []string{sqlMetricAdminFunctions, sqlMetricRollup},
+		},
+		{
+			Version:     "0003",
+			Description: "per-metric Timescale chunk, compression and retention policies",
+			SQLs: []string{
+				`DROP FUNCTION IF EXISTS admin.ensure_partition_timescale(text)`,
+				sqlMetricEnsurePartitionTimescale,
+			},
+		},
+		{
+			Version:     "0004",
+			Description: "row-level security per monitored DB group",
+			SQLs:        []string{sqlMetricRowLevelSecurity},
+		},
+	}
+)
+
+// MigrateConfigSchema applies pending configuration schema migrations
+func MigrateConfigSchema(ctx context.Context, conn PgxIface) error {
+	return migrate(ctx, conn, "pgwatch3", ConfigMigrations)
+}
+
+// MigrateMetricSchema applies pending metric storage schema migrations
+func MigrateMetricSchema(ctx context.Context, conn PgxIface) error {
+	return migrate(ctx, conn, "admin", MetricMigrations)
+}
+
+// PendingConfigMigrations returns configuration schema migrations not yet applied
+func PendingConfigMigrations(ctx context.Context, conn PgxIface) ([]Migration, error) {
+	return pendingMigrations(ctx, conn, "pgwatch3", ConfigMigrations)
+}
+
+// PendingMetricMigrations returns metric storage schema migrations not yet applied
+func PendingMetricMigrations(ctx context.Context, conn PgxIface) ([]Migration, error) {
+	return pendingMigrations(ctx, conn, "admin", MetricMigrations)
+}
+
+func pendingMigrations(ctx context.Context, conn PgxIface, schema string, migrations []Migration) ([]Migration, error) {
+	var exists bool
+	sqlVersionTableExists := "SELECT to_regclass($1) IS NOT NULL"
+	if err := conn.QueryRow(ctx, sqlVersionTableExists, schema+".schema_version").Scan(&exists); err != nil || !exists {
+		return migrations, err
+	}
+	rows, err := conn.Query(ctx, fmt.Sprintf("SELECT sv_tag FROM %s.schema_version", schema))
+	if err != nil {
+		return nil, err
+	}
+	tags, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return nil, err
+	}
+	applied := make(map[string]bool, len(tags))
+	for _, tag := range tags {
+		applied[tag] = true
+	}
+	pending := make([]Migration, 0)
+	for _, m := range migrations {
+		if !applied[m.Version] {
+			pending = append(pending, m)
+		}
+	}
+	return pending, nil
+}
+
+// migrate applies all pending migrations in one transaction. An advisory lock serializes concurrent gatherers,
+// the ones waiting will find nothing pending afterwards
+func migrate(ctx context.Context, conn PgxIface, schema string, migrations []Migration) (err error) {
+	logger := log.GetLogger(ctx)
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+	if _, err = tx.Exec(ctx, "SELECT pg_advisory_xact_lock(hashtext($1))", "pgwatch3_migrate_"+schema); err != nil {
+		return err
+	}
+	sqlVersionTable := fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s.schema_version (
+    sv_tag text primary key,
+    sv_created_on timestamptz not null default now()
+)`, schema)
+	if _, err = tx.Exec(ctx, sqlVersionTable); err != nil {
+		return err
+	}
+	pending, err := pendingMigrations(ctx, tx, schema, migrations)
+	if err != nil {
+		return err
+	}
+	sqlAddVersion := fmt.Sprintf("INSERT INTO %s.schema_version (sv_tag) VALUES ($1)", schema)
+	for _, m := range pending {
+		logger.Infof("Applying %s schema migration %s: %s", schema, m.Version, m.Description)
+		for _, sql := range m.SQLs {
+			if _, err = tx.Exec(ctx, sql); err != nil {
+				return fmt.Errorf("%s schema migration %s failed: %w", schema, m.Version, err)
+			}
+		}
+		if _, err = tx.Exec(ctx, sqlAddVersion, m.Version); err != nil {
+			return err
+		}
+	}
+	return tx.Commit(ctx)
+}
@@ -0,0 +1,61 @@
+package db_test
+
+import (
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/pashagolub/pgxmock/v3"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/cybertec-postgresql/pgwatch3/db"
+)
+
+func TestMigrateConfigSchema(t *testing.T) {
+	conn, err := pgxmock.NewPool()
+	assert.NoError(t, err)
+
+	conn.ExpectBegin()
+	conn.ExpectExec("SELECT pg_advisory_xact_lock").
+		WithArgs("pgwatch3_migrate_pgwatch3").
+		WillReturnResult(pgconn.NewCommandTag("SELECT 1"))
+	conn.ExpectExec("CREATE TABLE IF NOT EXISTS pgwatch3.schema_version").
+		WillReturnResult(pgconn.NewCommandTag("CREATE TABLE"))
+	conn.ExpectQuery("SELECT to_regclass").
+		WithArgs("pgwatch3.schema_version").
+		WillReturnRows(pgxmock.NewRows([]string{"exists"}).AddRow(true))
+	conn.ExpectQuery("SELECT sv_tag").
+		WillReturnRows(pgxmock.NewRows([]string{"sv_tag"}).AddRow("1.8.5").AddRow("0001"))
+	conn.ExpectExec("create table if not exists pgwatch3.gatherer_lease").
+		WillReturnResult(pgconn.NewCommandTag("CREATE TABLE"))
+	conn.ExpectExec("INSERT INTO pgwatch3.schema_version").
+		WithArgs("0002").
+		WillReturnResult(pgconn.NewCommandTag("INSERT 0 1"))
+	conn.ExpectExec("alter table pgwatch3.monitored_db add column if not exists md_timezone").
+		WillReturnResult(pgconn.NewCommandTag("ALTER TABLE"))
+	conn.ExpectExec("INSERT INTO pgwatch3.schema_version").
+		WithArgs("0003").
+		WillReturnResult(pgconn.NewCommandTag("INSERT 0 1"))
+	conn.ExpectCommit()
+	conn.ExpectRollback()
+	assert.NoError(t, db.MigrateConfigSchema(ctx, conn))
+
+	conn.ExpectQuery("SELECT to_regclass").
+		WithArgs("pgwatch3.schema_version").
+		WillReturnRows(pgxmock.NewRows([]string{"exists"}).AddRow(true))
+	applied := pgxmock.NewRows([]string{"sv_tag"}).AddRow("1.8.5")
+	for _, m := range db.ConfigMigrations {
+		applied.AddRow(m.Version)
+	}
+	conn.ExpectQuery("SELECT sv_tag").WillReturnRows(applied)
+	pending, err := db.PendingConfigMigrations(ctx, conn)
+	assert.NoError(t, err)
+	assert.Empty(t, pending)
+
+	conn.ExpectQuery("SELECT to_regclass").
+		WithArgs("admin.schema_version").
+		WillReturnRows(pgxmock.NewRows([]string{"exists"}).AddRow(false))
+	pending, err = db.PendingMetricMigrations(ctx, conn)
+	assert.NoError(t, err)
+	assert.Equal(t, db.MetricMigrations, pending)
+	assert.NoError(t, conn.ExpectationsWereMet())
+}
@@ -6254,3 +6254,12 @@ insert into pgwatch3.metric_attribute (ma_metric_name, ma_metric_attrs)
 select 'reco_add_index', '{"extension_version_based_overrides": [{"target_metric": "reco_add_index_ext_qualstats_2.0", "expected_extension_versions": [{"ext_name": "pg_qualstats", "ext_min_version": "2.0"}] }]}'
 on conflict (ma_metric_name)
 do update set ma_metric_attrs = pgwatch3.metric_attribute.ma_metric_attrs || '{"extension_version_based_overrides": [{"target_metric": "reco_add_index_ext_qualstats_2.0", "expected_extension_versions": [{"ext_name": "pg_qualstats", "ext_min_version": "2.0"}] }]}', ma_last_modified_on = now();
+
+-- severities for recommendations, "info" if not specified
+insert into pgwatch3.metric_attribute (ma_metric_name, ma_metric_attrs)
+select m, '{"reco_severity": "warning"}'
+from unnest(
+   array['reco_default_public_schema', 'reco_disabled_triggers', 'reco_sprocs_wo_search_path', 'reco_superusers']
+) m
+on conflict (ma_metric_name)
+do update set ma_metric_attrs = pgwatch3.metric_attribute.ma_metric_attrs || '{"reco_severity": "warning"}', ma_last_modified_on = now();
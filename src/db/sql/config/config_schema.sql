@@ -34,6 +34,7 @@ create table if not exists pgwatch3.monitored_db (
     md_only_if_master             bool        not null default false,
     md_preset_config_name_standby text        references pgwatch3.preset_config(pc_name),
     md_config_standby             jsonb,
+    md_timezone                   text,               -- for metric schedules, e.g. 'Europe/Vienna'. gatherer's time zone if not set
 
     CONSTRAINT no_colon_on_unique_name CHECK (md_name !~ ':'),
     CHECK (md_dbtype in ('postgres', 'pgbouncer', 'postgres-continuous-discovery', 'patroni', 'patroni-continuous-discovery', 'patroni-namespace-discovery', 'pgpool')),
@@ -76,7 +77,25 @@ create table if not exists metric_attribute (
     check (ma_metric_name ~ E'^[a-z0-9_\\.]+$')
 );
 
-/* this should allow auto-rollout of schema changes for future (1.6+) releases. currently only informative */
+/* acknowledged or snoozed recommendations per monitored DB, filtered out from the "recommendations" metric */
+create table if not exists pgwatch3.reco_ack (
+    ra_dbname           text        not null,
+    ra_reco_id          text        not null,
+    ra_snoozed_until    timestamptz,            -- null means acknowledged for good
+    ra_comment          text,
+    ra_created_on       timestamptz not null default now(),
+
+    primary key (ra_dbname, ra_reco_id)
+);
+
+/* expiring locks for gatherer high-availability setups, only the holder of a lease is active */
+create table if not exists pgwatch3.gatherer_lease (
+    gl_name         text        primary key,
+    gl_holder       text        not null,
+    gl_expires_on   timestamptz not null
+);
+
+/* applied schema migrations, see db/migrations.go */
 create table if not exists schema_version (
     sv_tag text primary key,
     sv_created_on timestamptz not null default now()
@@ -85,7 +85,7 @@ BEGIN
       GET DIAGNOSTICS j = ROW_COUNT;
       i := i + j;
     END LOOP;
-  ELSIF l_schema_type = 'postgres' THEN
+  ELSIF l_schema_type IN ('postgres', 'typed') THEN
     FOR r IN (
             select 'subpartitions.'|| quote_ident(c.relname) as table_name
                  from pg_class c
@@ -132,7 +132,7 @@ BEGIN
   END IF;
 
 
-  IF schema_type = 'postgres' THEN
+  IF schema_type IN ('postgres', 'typed') THEN
 
     FOR r IN (
       SELECT time_partition_name FROM (
@@ -150,6 +150,7 @@ BEGIN
         WHERE
           c.relkind IN ('r', 'p')
             AND nspname = 'subpartitions'
+            AND c.relname !~ '_rollup_' /* rollup tiers have their own retention */
             AND pg_catalog.obj_description(c.oid, 'pg_class') IN (
               'pgwatch3-generated-metric-time-lvl',
               'pgwatch3-generated-metric-dbname-time-lvl'
@@ -219,7 +220,7 @@ BEGIN
         PERFORM admin.drop_old_time_partitions(older_than_days, dry_run, 'postgres');
 
   ELSE
-    raise warning 'unsupported schema type: %', l_schema_type;
+    raise warning 'unsupported schema type: %', schema_type;
   END IF;
 
   RETURN i;
@@ -238,7 +239,7 @@ BEGIN
         SELECT st.schema_type INTO schema_type FROM admin.storage_schema_type st;
     END IF;
 
-    IF schema_type = 'postgres' THEN
+    IF schema_type IN ('postgres', 'typed') THEN
 
         RETURN QUERY
             SELECT time_partition_name FROM (
@@ -258,6 +259,7 @@ BEGIN
                 WHERE
                         c.relkind IN ('r', 'p')
                   AND nspname = 'subpartitions'
+                  AND c.relname !~ '_rollup_' /* rollup tiers have their own retention */
                   AND pg_catalog.obj_description(c.oid, 'pg_class') IN (
                         'pgwatch3-generated-metric-time-lvl',
                         'pgwatch3-generated-metric-dbname-time-lvl'
@@ -272,3 +274,42 @@ BEGIN
 END;
 $SQL$ LANGUAGE plpgsql;
 GRANT EXECUTE ON FUNCTION admin.get_old_time_partitions(int,text) TO pgwatch3;
+
+-- drop function if exists admin.get_time_partitions();
+-- select * from admin.get_time_partitions();
+CREATE OR REPLACE FUNCTION admin.get_time_partitions(
+    OUT metric text,
+    OUT dbname text,
+    OUT time_partition_name text,
+    OUT part_end timestamptz
+)
+RETURNS SETOF record AS
+/*
+  lists all time sub-partitions with the top level metric, the dbname (null for metric-time partitions) and the upper time bound.
+  used by the gatherer to apply per metric / dbname retention
+*/
+$SQL$
+    SELECT
+        coalesce(top.relname, p.relname)::text,
+        CASE WHEN top.oid IS NOT NULL THEN
+            (regexp_match(pg_catalog.pg_get_expr(p.relpartbound, p.oid), E'FOR VALUES IN \\(''(.*)''\\)'))[1]
+        END,
+        'subpartitions.' || quote_ident(c.relname),
+        (regexp_match(pg_catalog.pg_get_expr(c.relpartbound, c.oid), E'TO \\(''(.*?)''\\)'))[1]::timestamptz
+    FROM
+        pg_class c
+        JOIN pg_namespace n ON n.oid = c.relnamespace
+        JOIN pg_inherits i ON i.inhrelid = c.oid
+        JOIN pg_class p ON p.oid = i.inhparent
+        LEFT JOIN pg_inherits i2 ON i2.inhrelid = p.oid
+        LEFT JOIN pg_class top ON top.oid = i2.inhparent
+    WHERE
+        c.relkind IN ('r', 'p')
+        AND n.nspname = 'subpartitions'
+        AND pg_catalog.obj_description(c.oid, 'pg_class') IN (
+            'pgwatch3-generated-metric-time-lvl',
+            'pgwatch3-generated-metric-dbname-time-lvl'
+        )
+    ORDER BY 1, 2, 4
+$SQL$ LANGUAGE sql;
+GRANT EXECUTE ON FUNCTION admin.get_time_partitions() TO pgwatch3;
@@ -36,7 +36,7 @@ language sql;
 create table admin.storage_schema_type (
   schema_type text not null default admin.get_default_storage_type(),
   initialized_on timestamptz not null default now(),
-  check (schema_type in ('postgres', 'timescale'))
+  check (schema_type in ('postgres', 'timescale', 'typed'))
 );
 
 insert into admin.storage_schema_type default values;
@@ -104,7 +104,11 @@ BEGIN
         l_unlogged := 'UNLOGGED';
     END IF;
 
-    IF l_schema_type = 'postgres' THEN
+    IF l_schema_type = 'typed' THEN
+        l_template_table := l_template_table || '_typed';
+    END IF;
+
+    IF l_schema_type IN ('postgres', 'typed') THEN
       EXECUTE format($$CREATE %s TABLE public."%s" (LIKE %s INCLUDING INDEXES) PARTITION BY LIST (dbname)$$, l_unlogged, metric, l_template_table);
     ELSIF l_schema_type = 'timescale' THEN
         IF metric ~ 'realtime' THEN
@@ -153,5 +157,26 @@ COMMENT ON TABLE admin.metrics_template_realtime IS 'used as a template for all
 -- create index on admin.metrics_template using brin (dbname, time) with (pages_per_range=32);  /* consider BRIN instead for large data amounts */
 CREATE INDEX ON admin.metrics_template_realtime (dbname, time);
 
+/* for the "typed" schema the gatherer adds a column per metric field / tag when first seen */
+CREATE TABLE admin.metrics_template_typed (
+  time timestamptz not null default now(),
+  dbname text not null,
+  CHECK (false)
+);
+
+COMMENT ON TABLE admin.metrics_template_typed IS 'used as a template for all new metric definitions with typed columns';
+
+CREATE INDEX ON admin.metrics_template_typed (dbname, time);
+
+CREATE UNLOGGED TABLE admin.metrics_template_realtime_typed (
+  time timestamptz not null default now(),
+  dbname text not null,
+  CHECK (false)
+);
+
+COMMENT ON TABLE admin.metrics_template_realtime_typed IS 'used as a template for all new realtime metric definitions with typed columns';
+
+CREATE INDEX ON admin.metrics_template_realtime_typed (dbname, time);
+
 RESET ROLE;
 
@@ -36,6 +36,10 @@ BEGIN
       l_unlogged := 'UNLOGGED';
   END IF;
 
+  IF (SELECT schema_type FROM admin.storage_schema_type) = 'typed' THEN
+      l_template_table := l_template_table || '_typed';
+  END IF;
+
   -- 1. level
   IF NOT EXISTS (SELECT 1
                    FROM pg_tables
@@ -1,13 +1,18 @@
--- DROP FUNCTION IF EXISTS public.ensure_partition_timescale(text);
--- select * from public.ensure_partition_timescale('wal');
+-- DROP FUNCTION IF EXISTS admin.ensure_partition_timescale(text, interval, interval, interval);
+-- select * from admin.ensure_partition_timescale('wal', '1 day', '2 days', '30 days');
 
 CREATE OR REPLACE FUNCTION admin.ensure_partition_timescale(
-    metric text
+    metric text,
+    chunk_interval interval default null,
+    compress_after interval default null,
+    retention interval default null
 )
 RETURNS void AS
 /*
   creates a top level metric table if not already existing.
   expects the "metrics_template" table to exist.
+  chunk and compression intervals are taken from admin.config "timescale_chunk_interval.<metric>" / "timescale_compress_interval.<metric>",
+  then the given ones (from metric attributes), then the global admin.config "timescale_chunk_interval" / "timescale_compress_interval"
 */
 $SQL$
 DECLARE
@@ -31,15 +36,17 @@ BEGIN
                       WHERE table_name = metric
                         AND schema_name = 'public')
       THEN
-        SELECT value::interval INTO l_chunk_time_interval FROM admin.config WHERE key = 'timescale_chunk_interval';
-        IF NOT FOUND THEN
-            l_chunk_time_interval := '2 days'; -- Timescale default is 7d
-        END IF;
-
-        SELECT value::interval INTO l_compress_chunk_interval FROM admin.config WHERE key = 'timescale_compress_interval';
-        IF NOT FOUND THEN
-            l_compress_chunk_interval := '1 day';
-        END IF;
+        l_chunk_time_interval := coalesce(
+            (SELECT value::interval FROM admin.config WHERE key = 'timescale_chunk_interval.' || metric),
+            chunk_interval,
+            (SELECT value::interval FROM admin.config WHERE key = 'timescale_chunk_interval'),
+            '2 days'); -- Timescale default is 7d
+
+        l_compress_chunk_interval := coalesce(
+            (SELECT value::interval FROM admin.config WHERE key = 'timescale_compress_interval.' || metric),
+            compress_after,
+            (SELECT value::interval FROM admin.config WHERE key = 'timescale_compress_interval'),
+            '1 day');
 
         EXECUTE format($$CREATE TABLE IF NOT EXISTS public.%I (LIKE %s INCLUDING INDEXES)$$, metric, l_template_table);
         EXECUTE format($$COMMENT ON TABLE public.%I IS 'pgwatch3-generated-metric-lvl'$$, metric);
@@ -48,6 +55,7 @@ BEGIN
         SELECT ((regexp_matches(extversion, '\d+\.\d+'))[1])::numeric INTO l_timescale_version FROM pg_extension WHERE extname = 'timescaledb';
         IF l_timescale_version >= 2.0 THEN
           PERFORM add_compression_policy(format('public.%I', metric), l_compress_chunk_interval);
+          PERFORM admin.ensure_timescale_retention_policy(metric, retention);
         ELSE
           PERFORM add_compress_chunks_policy(format('public.%I', metric), l_compress_chunk_interval);
         END IF;
@@ -56,7 +64,42 @@ BEGIN
 END;
 $SQL$ LANGUAGE plpgsql;
 
-GRANT EXECUTE ON FUNCTION admin.ensure_partition_timescale(text) TO pgwatch3;
+GRANT EXECUTE ON FUNCTION admin.ensure_partition_timescale(text, interval, interval, interval) TO pgwatch3;
+
+-- select * from admin.ensure_timescale_retention_policy('wal', '30 days');
+CREATE OR REPLACE FUNCTION admin.ensure_timescale_retention_policy(
+    metric text,
+    retention interval
+)
+RETURNS boolean AS
+/*
+  sets the retention policy of a metric hypertable, null removes the policy. returns true if the policy was changed.
+  needs Timescale 2.0+
+*/
+$SQL$
+DECLARE
+    l_drop_after interval;
+BEGIN
+    SELECT (config->>'drop_after')::interval INTO l_drop_after
+      FROM timescaledb_information.jobs
+     WHERE proc_name = 'policy_retention'
+       AND hypertable_schema = 'public'
+       AND hypertable_name = metric;
+
+    IF l_drop_after IS NOT DISTINCT FROM retention THEN
+        RETURN false;
+    END IF;
+    IF l_drop_after IS NOT NULL THEN
+        PERFORM remove_retention_policy(format('public.%I', metric), if_exists => true);
+    END IF;
+    IF retention IS NOT NULL THEN
+        PERFORM add_retention_policy(format('public.%I', metric), retention);
+    END IF;
+    RETURN true;
+END;
+$SQL$ LANGUAGE plpgsql;
+
+GRANT EXECUTE ON FUNCTION admin.ensure_timescale_retention_policy(text, interval) TO pgwatch3;
 
 CREATE OR REPLACE FUNCTION admin.ensure_partition_metric_time(
     metric text,
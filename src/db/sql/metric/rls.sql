@@ -0,0 +1,95 @@
+/*
+  Row-level security for multi-tenant metric stores (--pg-rls). Metric rows are stamped with the monitored DB group in
+  the "dbgroup" column and members of the "pgwatch3_group_<group>" roles can only read the rows of their groups.
+  Roles are created by the gatherer when groups appear, login roles (e.g. for Grafana datasources) need to be granted
+  membership manually: GRANT pgwatch3_group_team1 TO grafana_team1;
+*/
+
+-- select * from admin.current_user_groups();
+CREATE OR REPLACE FUNCTION admin.current_user_groups()
+RETURNS text[] AS
+$SQL$
+  SELECT coalesce(array_agg(substr(rolname, length('pgwatch3_group_') + 1)), '{}')
+    FROM pg_roles
+   WHERE rolname LIKE 'pgwatch3\_group\_%'
+     AND pg_has_role(current_user, oid, 'MEMBER');
+$SQL$ LANGUAGE sql STABLE;
+
+GRANT EXECUTE ON FUNCTION admin.current_user_groups() TO public;
+
+-- select * from admin.ensure_group_role('default');
+CREATE OR REPLACE FUNCTION admin.ensure_group_role(
+    group_name text
+)
+RETURNS boolean AS
+/*
+  creates the reader role of a group if not existing and grants it access to all RLS enabled metric tables.
+  needs the CREATEROLE privilege. returns true if the role was created. group names longer than 48 bytes are rejected,
+  as the role name would be truncated to 63 bytes and could clash with the role of another group
+*/
+$SQL$
+DECLARE
+    l_role text := 'pgwatch3_group_' || group_name;
+    l_created boolean := false;
+    r record;
+BEGIN
+    IF octet_length(l_role) > 63 THEN
+        RAISE EXCEPTION 'group name "%" too long for a reader role, at most % bytes are supported', group_name, 63 - octet_length('pgwatch3_group_');
+    END IF;
+
+    PERFORM pg_advisory_xact_lock(hashtext(l_role));
+
+    IF NOT EXISTS (SELECT 1 FROM pg_roles WHERE rolname = l_role) THEN
+        EXECUTE format('CREATE ROLE %I NOLOGIN', l_role);
+        l_created := true;
+    END IF;
+
+    EXECUTE format('GRANT USAGE ON SCHEMA public, admin TO %I', l_role);
+    -- the DB listing is not restricted by group, roles created by older versions had access to it
+    EXECUTE format('REVOKE SELECT ON admin.all_distinct_dbname_metrics FROM %I', l_role);
+    FOR r IN (SELECT c.relname
+                FROM pg_class c
+                JOIN pg_namespace n ON n.oid = c.relnamespace
+               WHERE n.nspname = 'public'
+                 AND c.relkind IN ('r', 'p')
+                 AND c.relrowsecurity)
+    LOOP
+        EXECUTE format('GRANT SELECT ON public.%I TO %I', r.relname, l_role);
+    END LOOP;
+
+    RETURN l_created;
+END;
+$SQL$ LANGUAGE plpgsql;
+
+GRANT EXECUTE ON FUNCTION admin.ensure_group_role(text) TO pgwatch3;
+
+-- select * from admin.ensure_metric_rls('db_stats');
+CREATE OR REPLACE FUNCTION admin.ensure_metric_rls(
+    metric text
+)
+RETURNS void AS
+/*
+  adds the "dbgroup" column and the group reader policy to a top level metric table and grants reading to all group roles
+*/
+$SQL$
+DECLARE
+    r record;
+BEGIN
+    PERFORM pg_advisory_xact_lock(regexp_replace( md5(metric) , E'\\D', '', 'g')::varchar(10)::int8);
+
+    EXECUTE format('ALTER TABLE public.%I ADD COLUMN IF NOT EXISTS dbgroup text', metric);
+    EXECUTE format('ALTER TABLE public.%I ENABLE ROW LEVEL SECURITY', metric);
+
+    IF NOT EXISTS (SELECT 1 FROM pg_policies WHERE schemaname = 'public' AND tablename = metric AND policyname = 'pgwatch3_group') THEN
+        EXECUTE format($$CREATE POLICY pgwatch3_group ON public.%I FOR SELECT
+                         USING (dbgroup = ANY ((SELECT admin.current_user_groups())))$$, metric);
+    END IF;
+
+    FOR r IN (SELECT rolname FROM pg_roles WHERE rolname LIKE 'pgwatch3\_group\_%')
+    LOOP
+        EXECUTE format('GRANT SELECT ON public.%I TO %I', metric, r.rolname);
+    END LOOP;
+END;
+$SQL$ LANGUAGE plpgsql;
+
+GRANT EXECUTE ON FUNCTION admin.ensure_metric_rls(text) TO pgwatch3;
@@ -0,0 +1,107 @@
+/*
+  Downsampling of metric data into "<metric>_rollup_<tier>" tables / continuous aggregates. Numeric "data" fields are
+  aggregated into an average (same key) and a maximum ("<key>_max"), "tag_data" is preserved by grouping on it.
+*/
+
+-- select admin.rollup_state(null, '{"a": 1, "b": "x"}');
+CREATE OR REPLACE FUNCTION admin.rollup_state(state jsonb, data jsonb)
+RETURNS jsonb AS
+$SQL$
+  /* state is key = [sum, count, max] */
+  select coalesce(state, '{}') || coalesce((
+    select jsonb_object_agg(key, jsonb_build_array(
+        coalesce((state->key->>0)::float8, 0) + value::text::float8,
+        coalesce((state->key->>1)::int8, 0) + 1,
+        greatest((state->key->>2)::float8, value::text::float8)))
+    from jsonb_each(data)
+    where jsonb_typeof(value) = 'number'), '{}')
+$SQL$ LANGUAGE sql IMMUTABLE PARALLEL SAFE;
+
+CREATE OR REPLACE FUNCTION admin.rollup_combine(state1 jsonb, state2 jsonb)
+RETURNS jsonb AS
+$SQL$
+  select coalesce(state1, '{}') || coalesce((
+    select jsonb_object_agg(key, jsonb_build_array(
+        coalesce((state1->key->>0)::float8, 0) + (value->>0)::float8,
+        coalesce((state1->key->>1)::int8, 0) + (value->>1)::int8,
+        greatest((state1->key->>2)::float8, (value->>2)::float8)))
+    from jsonb_each(state2)), '{}')
+$SQL$ LANGUAGE sql IMMUTABLE PARALLEL SAFE;
+
+CREATE OR REPLACE FUNCTION admin.rollup_final(state jsonb)
+RETURNS jsonb AS
+$SQL$
+  select coalesce(
+    jsonb_object_agg(key, (value->>0)::float8 / (value->>1)::int8) || jsonb_object_agg(key || '_max', value->2),
+    '{}')
+  from jsonb_each(state)
+$SQL$ LANGUAGE sql IMMUTABLE PARALLEL SAFE;
+
+CREATE OR REPLACE AGGREGATE admin.rollup_avg_max(jsonb) (
+  sfunc = admin.rollup_state,
+  stype = jsonb,
+  combinefunc = admin.rollup_combine,
+  finalfunc = admin.rollup_final,
+  parallel = safe
+);
+
+GRANT EXECUTE ON FUNCTION admin.rollup_avg_max(jsonb) TO pgwatch3;
+
+
+-- DROP FUNCTION IF EXISTS admin.ensure_rollup_timescale(text,text,interval,int);
+-- select * from admin.ensure_rollup_timescale('wal', 'wal_rollup_1h', '1h', 365);
+CREATE OR REPLACE FUNCTION admin.ensure_rollup_timescale(
+    metric text,
+    rollup text,
+    bucket interval,
+    retention_days int
+)
+RETURNS boolean AS
+/*
+  creates a continuous aggregate with a refresh and retention policy for a metric hypertable if not already existing
+*/
+$SQL$
+BEGIN
+    PERFORM pg_advisory_xact_lock(regexp_replace( md5(rollup) , E'\\D', '', 'g')::varchar(10)::int8);
+
+    IF to_regclass(format('public.%I', rollup)) IS NOT NULL THEN
+        RETURN false;
+    END IF;
+
+    EXECUTE format($$CREATE MATERIALIZED VIEW public.%I WITH (timescaledb.continuous) AS
+        SELECT time_bucket(%L::interval, time) AS time, dbname, admin.rollup_avg_max(data) AS data, tag_data
+        FROM public.%I
+        GROUP BY 1, dbname, tag_data
+        WITH NO DATA$$, rollup, bucket, metric);
+    EXECUTE format($$COMMENT ON VIEW public.%I IS 'pgwatch3-generated-metric-rollup-lvl'$$, rollup);
+    PERFORM add_continuous_aggregate_policy(format('public.%I', rollup),
+        start_offset => bucket * 3, end_offset => bucket, schedule_interval => bucket);
+    IF retention_days > 0 THEN
+        PERFORM add_retention_policy(format('public.%I', rollup), retention_days * '1 day'::interval);
+    END IF;
+
+    RETURN true;
+END;
+$SQL$ LANGUAGE plpgsql;
+
+GRANT EXECUTE ON FUNCTION admin.ensure_rollup_timescale(text,text,interval,int) TO pgwatch3;
+
+
+-- DROP FUNCTION IF EXISTS admin.get_old_time_partitions_for_metric(text,int);
+-- select * from admin.get_old_time_partitions_for_metric('wal_rollup_1h', 365);
+CREATE OR REPLACE FUNCTION admin.get_old_time_partitions_for_metric(metric text, older_than_days int)
+    RETURNS SETOF text AS
+$SQL$
+    SELECT 'subpartitions.' || quote_ident(c.relname)
+    FROM pg_class c
+    JOIN pg_inherits i ON c.oid = i.inhrelid
+    JOIN pg_inherits i2 ON i2.inhrelid = i.inhparent
+    WHERE i2.inhparent = to_regclass(format('public.%I', metric))
+      AND c.relkind IN ('r', 'p')
+      AND pg_catalog.obj_description(c.oid, 'pg_class') = 'pgwatch3-generated-metric-dbname-time-lvl'
+      AND (regexp_match(pg_catalog.pg_get_expr(c.relpartbound, c.oid),
+            E'TO \\(''(.*?)''\\)'))[1]::timestamp < (current_date - '1day'::interval * older_than_days)
+    ORDER BY 1;
+$SQL$ LANGUAGE sql;
+
+GRANT EXECUTE ON FUNCTION admin.get_old_time_partitions_for_metric(text,int) TO pgwatch3;
@@ -0,0 +1,78 @@
+/*
+  "typed" storage schema support for metric stores created before it existed, see admin_schema.sql
+*/
+
+ALTER TABLE admin.storage_schema_type DROP CONSTRAINT IF EXISTS storage_schema_type_schema_type_check;
+ALTER TABLE admin.storage_schema_type ADD CONSTRAINT storage_schema_type_schema_type_check
+  CHECK (schema_type in ('postgres', 'timescale', 'typed'));
+
+DO $SQL$
+BEGIN
+  IF to_regclass('admin.metrics_template_typed') IS NULL THEN
+    CREATE TABLE admin.metrics_template_typed (
+      time timestamptz not null default now(),
+      dbname text not null,
+      CHECK (false)
+    );
+    COMMENT ON TABLE admin.metrics_template_typed IS 'used as a template for all new metric definitions with typed columns';
+    CREATE INDEX ON admin.metrics_template_typed (dbname, time);
+  END IF;
+  IF to_regclass('admin.metrics_template_realtime_typed') IS NULL THEN
+    CREATE UNLOGGED TABLE admin.metrics_template_realtime_typed (
+      time timestamptz not null default now(),
+      dbname text not null,
+      CHECK (false)
+    );
+    COMMENT ON TABLE admin.metrics_template_realtime_typed IS 'used as a template for all new realtime metric definitions with typed columns';
+    CREATE INDEX ON admin.metrics_template_realtime_typed (dbname, time);
+  END IF;
+END
+$SQL$;
+
+create or replace function admin.ensure_dummy_metrics_table(
+    metric text
+)
+RETURNS boolean AS
+/*
+  creates a top level metric table if not already existing (non-existing tables show ugly warnings in Grafana).
+  expects the "metrics_template" table to exist.
+*/
+$SQL$
+DECLARE
+  l_schema_type text;
+  l_template_table text := 'admin.metrics_template';
+  l_unlogged text := '';
+BEGIN
+  SELECT schema_type INTO l_schema_type FROM admin.storage_schema_type;
+
+  IF to_regclass(format('public.%I', metric)) is null
+  THEN
+    IF metric ~ 'realtime' THEN
+        l_template_table := 'admin.metrics_template_realtime';
+        l_unlogged := 'UNLOGGED';
+    END IF;
+
+    IF l_schema_type = 'typed' THEN
+        l_template_table := l_template_table || '_typed';
+    END IF;
+
+    IF l_schema_type IN ('postgres', 'typed') THEN
+      EXECUTE format($$CREATE %s TABLE public."%s" (LIKE %s INCLUDING INDEXES) PARTITION BY LIST (dbname)$$, l_unlogged, metric, l_template_table);
+    ELSIF l_schema_type = 'timescale' THEN
+        IF metric ~ 'realtime' THEN
+            EXECUTE format($$CREATE TABLE public."%s" (LIKE %s INCLUDING INDEXES) PARTITION BY RANGE (time)$$, metric, l_template_table);
+        ELSE
+            PERFORM admin.ensure_partition_timescale(metric);
+        END IF;
+    END IF;
+
+    EXECUTE format($$COMMENT ON TABLE public."%s" IS 'pgwatch3-generated-metric-lvl'$$, metric);
+
+    RETURN true;
+
+  END IF;
+
+  RETURN false;
+END;
+$SQL$ LANGUAGE plpgsql;
+GRANT EXECUTE ON FUNCTION admin.ensure_dummy_metrics_table(text) TO pgwatch3;
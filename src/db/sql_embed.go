@@ -28,3 +28,12 @@ var sqlMetricChangeChunkIntervalTimescale string
 
 //go:embed sql/metric/change_compression_interval.sql
 var sqlMetricChangeCompressionIntervalTimescale string
+
+//go:embed sql/metric/rollup.sql
+var sqlMetricRollup string
+
+//go:embed sql/metric/rls.sql
+var sqlMetricRowLevelSecurity string
+
+//go:embed sql/migrations/metric_typed_storage.sql
+var sqlMigrationMetricTypedStorage string
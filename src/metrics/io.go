@@ -2,6 +2,7 @@ package metrics
 
 import (
 	"context"
+	"fmt"
 	"io/fs"
 	"os"
 	"path"
@@ -104,13 +105,25 @@ func ReadMetricsFromFolder(ctx context.Context, folder string) (
 	metricNameRemapsNew map[string]string,
 	err error) {
 
+	log.GetLogger(ctx).Infof("Searching for metrics from path %s ...", folder)
+	if _, err = os.Stat(folder); err != nil {
+		return
+	}
+	return ReadMetricsFromFS(ctx, os.DirFS(folder))
+}
+
+// ReadMetricsFromFS reads metric definitions in the same structure as ReadMetricsFromFolder(), e.g. the built-in ones
+func ReadMetricsFromFS(ctx context.Context, fsys fs.FS) (
+	metricsMap map[string]map[uint]MetricProperties,
+	metricNameRemapsNew map[string]string,
+	err error) {
+
 	metricNamePattern := `^[a-z0-9_\.]+$`
 	regexMetricNameFilter := regexp.MustCompile(metricNamePattern)
 	regexIsDigitOrPunctuation := regexp.MustCompile(`^[\d\.]+$`)
 
 	logger := log.GetLogger(ctx)
-	logger.Infof("Searching for metrics from path %s ...", folder)
-	metricFolders, err := os.ReadDir(folder)
+	metricFolders, err := fs.ReadDir(fsys, ".")
 	if err != nil {
 		return
 	}
@@ -131,22 +144,24 @@ func ReadMetricsFromFolder(ctx context.Context, folder string) (
 			}
 			//log.Debugf("Processing metric: %s", f.Name())
 			var pgVers []fs.DirEntry
-			pgVers, err = os.ReadDir(path.Join(folder, f.Name()))
+			pgVers, err = fs.ReadDir(fsys, f.Name())
 			if err != nil {
 				return
 			}
 
-			var MetricAttrs MetricAttrs
-			if _, err = os.Stat(path.Join(folder, f.Name(), "metric_attrs.yaml")); err == nil {
-				MetricAttrs, err = ParseMetricAttrsFromYAML(path.Join(folder, f.Name(), "metric_attrs.yaml"))
-				if err != nil && MetricAttrs.MetricStorageName != "" {
-					metricNameRemapsNew[f.Name()] = MetricAttrs.MetricStorageName
+			var metricAttrs MetricAttrs
+			if _, err = fs.Stat(fsys, path.Join(f.Name(), "metric_attrs.yaml")); err == nil {
+				metricAttrs, err = parseYAMLFromFS[MetricAttrs](fsys, path.Join(f.Name(), "metric_attrs.yaml"))
+				if err != nil {
+					logger.Warningf("Ignoring invalid metric_attrs.yaml of metric %s: %v", f.Name(), err)
+				} else if metricAttrs.MetricStorageName != "" {
+					metricNameRemapsNew[f.Name()] = metricAttrs.MetricStorageName
 				}
 			}
 
 			var metricPrometheusAttrs MetricPrometheusAttrs
-			if _, err = os.Stat(path.Join(folder, f.Name(), "column_attrs.yaml")); err == nil {
-				if metricPrometheusAttrs, err = ParseMetricPrometheusAttrsFromYAML(path.Join(folder, f.Name(), "column_attrs.yaml")); err != nil {
+			if _, err = fs.Stat(fsys, path.Join(f.Name(), "column_attrs.yaml")); err == nil {
+				if metricPrometheusAttrs, err = parseYAMLFromFS[MetricPrometheusAttrs](fsys, path.Join(f.Name(), "column_attrs.yaml")); err != nil {
 					return
 				}
 			}
@@ -159,22 +174,17 @@ func ReadMetricsFromFolder(ctx context.Context, folder string) (
 					logger.Warningf("Invalid metric structure - version folder names should consist of only numerics/dots, found: %s", pgVer.Name())
 					continue
 				}
-				var dir int
-				dir, err = strconv.Atoi(pgVer.Name())
-				if err != nil {
-					return
-				}
-				dirName := uint(dir)
+				dirName := versionToInt(pgVer.Name()) // same as the "m_pg_version_from" of the config DB, e.g. 9.1 -> 901, 11 -> 1100
 				var metricDefs []fs.DirEntry
-				if metricDefs, err = os.ReadDir(path.Join(folder, f.Name(), pgVer.Name())); err != nil {
+				if metricDefs, err = fs.ReadDir(fsys, path.Join(f.Name(), pgVer.Name())); err != nil {
 					return
 				}
 
 				foundMetricDefFiles := make(map[string]bool) // to warn on accidental duplicates
 				for _, md := range metricDefs {
 					if strings.HasPrefix(md.Name(), "metric") && strings.HasSuffix(md.Name(), ".sql") {
-						p := path.Join(folder, f.Name(), pgVer.Name(), md.Name())
-						metricSQL, err := os.ReadFile(p)
+						p := path.Join(f.Name(), pgVer.Name(), md.Name())
+						metricSQL, err := fs.ReadFile(fsys, p)
 						if err != nil {
 							logger.Errorf("Failed to read metric definition at: %s", p)
 							continue
@@ -193,7 +203,7 @@ func ReadMetricsFromFolder(ctx context.Context, folder string) (
 						}
 						mvp, ok = mvpVer[dirName]
 						if !ok {
-							mvp = MetricProperties{SQL: string(metricSQL[:]), PrometheusAttrs: metricPrometheusAttrs, MetricAttrs: MetricAttrs}
+							mvp = MetricProperties{SQL: string(metricSQL[:]), PrometheusAttrs: metricPrometheusAttrs, MetricAttrs: metricAttrs}
 						}
 						mvp.CallsHelperFunctions = DoesMetricDefinitionCallHelperFunctions(mvp.SQL)
 						if strings.Contains(md.Name(), "_master") {
@@ -236,21 +246,44 @@ func ParseMetricAttrsFromYAML(path string) (a MetricAttrs, err error) {
 	return
 }
 
-// Expects "preset metrics" definition file named preset-config.yaml to be present in provided --metrics folder
-func ReadPresetMetricsConfigFromFolder(folder string) (pmm map[string]map[string]float64, err error) {
-	var (
-		pcs           []Preset
-		presetMetrics []byte
-	)
+func parseYAMLFromFS[T any](fsys fs.FS, path string) (t T, err error) {
+	var val []byte
+	if val, err = fs.ReadFile(fsys, path); err == nil {
+		err = yaml.Unmarshal(val, &t)
+	}
+	return
+}
+
+// Expects "preset metrics" definition file named preset-config.yaml to be present in provided --metrics folder.
+// Returns the intervals and the schedules of the presets
+func ReadPresetMetricsConfigFromFolder(folder string) (pmm map[string]map[string]float64, pms map[string]map[string]string, err error) {
+	var presetMetrics []byte
 	if presetMetrics, err = os.ReadFile(path.Join(folder, PresetConfigYAMLFile)); err != nil {
 		return
 	}
+	return parsePresetMetricsConfig(presetMetrics)
+}
+
+// ReadPresetMetricsConfigFromFS is the same as ReadPresetMetricsConfigFromFolder(), e.g. for the built-in presets
+func ReadPresetMetricsConfigFromFS(fsys fs.FS) (pmm map[string]map[string]float64, pms map[string]map[string]string, err error) {
+	var presetMetrics []byte
+	if presetMetrics, err = fs.ReadFile(fsys, PresetConfigYAMLFile); err != nil {
+		return
+	}
+	return parsePresetMetricsConfig(presetMetrics)
+}
+
+func parsePresetMetricsConfig(presetMetrics []byte) (pmm map[string]map[string]float64, pms map[string]map[string]string, err error) {
+	var pcs []Preset
 	if err = yaml.Unmarshal(presetMetrics, &pcs); err != nil {
-		return pmm, err
+		return
 	}
 	pmm = make(map[string]map[string]float64, len(pcs))
+	pms = make(map[string]map[string]string, len(pcs))
 	for _, pc := range pcs {
-		pmm[pc.Name] = pc.Metrics
+		if pmm[pc.Name], pms[pc.Name], err = ParseMetricIntervals(pc.Metrics); err != nil {
+			return nil, nil, fmt.Errorf("preset %s: %w", pc.Name, err)
+		}
 	}
-	return pmm, err
+	return
 }
@@ -0,0 +1,203 @@
+package metrics
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule returns the next gathering time after t, for metrics gathered at wall-clock times instead of intervals
+type Schedule interface {
+	Next(t time.Time) time.Time
+}
+
+var cronShortcuts = map[string]string{
+	"@hourly":   "0 * * * *",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@weekly":   "0 0 * * 0",
+	"@monthly":  "0 0 1 * *",
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+}
+
+// scheduleRef is the fixed reference time for nominal intervals, so that they don't change from call to call
+var scheduleRef = time.Date(2000, 1, 3, 0, 0, 0, 0, time.UTC)
+
+// ParseSchedule parses a cron expression with 5 fields (minute hour day-of-month month day-of-week, 0 or 7 = Sunday),
+// a @hourly, @daily, @weekly, @monthly or @yearly shortcut, or an "@aligned <duration>" interval aligned to the wall
+// clock, e.g. "@aligned 5m" runs on :00, :05, :10 etc. Times are evaluated in loc
+func ParseSchedule(spec string, loc *time.Location) (Schedule, error) {
+	spec = strings.TrimSpace(spec)
+	if loc == nil {
+		loc = time.Local
+	}
+	if every, ok := strings.CutPrefix(spec, "@aligned "); ok {
+		d, err := time.ParseDuration(strings.TrimSpace(every))
+		if err != nil {
+			return nil, err
+		}
+		if d < time.Second || d > 24*time.Hour {
+			return nil, fmt.Errorf("aligned interval %v must be between 1s and 24h", d)
+		}
+		return alignedSchedule{every: d, loc: loc}, nil
+	}
+	if shortcut, ok := cronShortcuts[spec]; ok {
+		spec = shortcut
+	}
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("invalid schedule %q, expected 5 cron fields, a @daily like shortcut or \"@aligned <duration>\"", spec)
+	}
+	s := cronSchedule{loc: loc, domStar: fields[2] == "*", dowStar: fields[4] == "*"}
+	var err error
+	bounds := [5][2]uint{{0, 59}, {0, 23}, {1, 31}, {1, 12}, {0, 7}}
+	for i, field := range []*uint64{&s.minute, &s.hour, &s.dom, &s.month, &s.dow} {
+		if *field, err = parseCronField(fields[i], bounds[i][0], bounds[i][1]); err != nil {
+			return nil, fmt.Errorf("invalid schedule %q: %w", spec, err)
+		}
+	}
+	if s.dow&(1<<7) > 0 { // cron allows either 0 or 7 for Sunday
+		s.dow |= 1
+	}
+	if s.Next(scheduleRef).IsZero() {
+		return nil, fmt.Errorf("schedule %q never runs", spec)
+	}
+	return s, nil
+}
+
+// parseCronField parses a comma separated list of "*", "n", "n-m" items, optionally with a "/step", into a bitmask
+func parseCronField(field string, min, max uint) (bits uint64, err error) {
+	for _, item := range strings.Split(field, ",") {
+		rng, stepStr, hasStep := strings.Cut(item, "/")
+		lo, hi, step := min, max, uint64(1)
+		if rng != "*" {
+			from, to, isRange := strings.Cut(rng, "-")
+			var v uint64
+			if v, err = strconv.ParseUint(from, 10, 8); err != nil {
+				return 0, fmt.Errorf("invalid value %q", item)
+			}
+			lo, hi = uint(v), uint(v)
+			if isRange {
+				if v, err = strconv.ParseUint(to, 10, 8); err != nil {
+					return 0, fmt.Errorf("invalid range %q", item)
+				}
+				hi = uint(v)
+			} else if hasStep {
+				hi = max
+			}
+		}
+		if hasStep {
+			if step, err = strconv.ParseUint(stepStr, 10, 8); err != nil || step == 0 {
+				return 0, fmt.Errorf("invalid step %q", item)
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return 0, fmt.Errorf("%q out of range %d-%d", item, min, max)
+		}
+		for i := lo; i <= hi; i += uint(step) {
+			bits |= 1 << i
+		}
+	}
+	return bits, nil
+}
+
+type cronSchedule struct {
+	minute, hour, dom, month, dow uint64
+	domStar, dowStar              bool // if both days are restricted, matching either is enough
+	loc                           *time.Location
+}
+
+func (s cronSchedule) dayMatches(t time.Time) bool {
+	domMatch := s.dom&(1<<uint(t.Day())) > 0
+	dowMatch := s.dow&(1<<uint(t.Weekday())) > 0
+	if s.domStar || s.dowStar {
+		return domMatch && dowMatch
+	}
+	return domMatch || dowMatch
+}
+
+// Next returns the first matching minute after t or the zero time if nothing matches within 5 years, e.g. for Feb 30
+func (s cronSchedule) Next(t time.Time) time.Time {
+	t = t.In(s.loc)
+	t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute()+1, 0, 0, s.loc)
+	for end := t.AddDate(5, 0, 0); t.Before(end); {
+		switch {
+		case s.month&(1<<uint(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, s.loc)
+		case !s.dayMatches(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, s.loc)
+		case s.hour&(1<<uint(t.Hour())) == 0:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, s.loc)
+		case s.minute&(1<<uint(t.Minute())) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+type alignedSchedule struct {
+	every time.Duration
+	loc   *time.Location
+}
+
+// Next returns the next multiple of the interval since the local midnight
+func (s alignedSchedule) Next(t time.Time) time.Time {
+	t = t.In(s.loc)
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, s.loc)
+	next := midnight.Add((t.Sub(midnight)/s.every + 1) * s.every)
+	if nextMidnight := time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, s.loc); next.After(nextMidnight) {
+		return nextMidnight // for intervals not dividing the day
+	}
+	return next
+}
+
+// NominalInterval returns the gap between two runs of the schedule after a fixed reference date in UTC. It's a display
+// value, e.g. for the interval shown in /health, and used where a rough interval is enough like for the instance level
+// cache. It's not the minimum gap, as cron schedules can have uneven gaps and daily runs in a time zone with daylight
+// saving are also 23h or 25h apart, so runs must never be spaced by it
+func NominalInterval(s Schedule) time.Duration {
+	next := s.Next(scheduleRef)
+	return s.Next(next).Sub(next)
+}
+
+// ParseMetricIntervals splits a metric config into intervals and schedules. Metrics can be configured with an interval in
+// seconds or a schedule string, see ParseSchedule(), the nominal interval of which is then used as interval, see
+// NominalInterval()
+func ParseMetricIntervals(config map[string]any) (intervals map[string]float64, schedules map[string]string, err error) {
+	if config == nil {
+		return nil, nil, nil
+	}
+	intervals = make(map[string]float64, len(config))
+	for metric, v := range config {
+		switch v := v.(type) {
+		case int:
+			intervals[metric] = float64(v)
+		case int64:
+			intervals[metric] = float64(v)
+		case float64:
+			intervals[metric] = v
+		case string:
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				intervals[metric] = f
+				continue
+			}
+			s, err := ParseSchedule(v, time.UTC)
+			if err != nil {
+				return nil, nil, fmt.Errorf("metric %s: %w", metric, err)
+			}
+			if schedules == nil {
+				schedules = make(map[string]string)
+			}
+			schedules[metric] = v
+			intervals[metric] = NominalInterval(s).Seconds()
+		default:
+			return nil, nil, errors.New("metric " + metric + ": expected an interval in seconds or a schedule")
+		}
+	}
+	return intervals, schedules, nil
+}
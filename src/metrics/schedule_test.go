@@ -0,0 +1,52 @@
+package metrics_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/cybertec-postgresql/pgwatch3/metrics"
+)
+
+func TestParseSchedule(t *testing.T) {
+	vienna, err := time.LoadLocation("Europe/Vienna")
+	assert.NoError(t, err)
+	from := time.Date(2024, 3, 29, 10, 7, 30, 0, vienna) // a Friday
+	for _, tc := range []struct {
+		spec string
+		next time.Time
+	}{
+		{"0 3 * * *", time.Date(2024, 3, 30, 3, 0, 0, 0, vienna)},
+		{"*/15 9-17 * * 1-5", time.Date(2024, 3, 29, 10, 15, 0, 0, vienna)},
+		{"30 2 * * 0", time.Date(2024, 4, 7, 2, 30, 0, 0, vienna)}, // 02:30 does not exist on the DST switch day
+		{"0 0 1 1 *", time.Date(2025, 1, 1, 0, 0, 0, 0, vienna)},
+		{"0 12 13 * 5", time.Date(2024, 3, 29, 12, 0, 0, 0, vienna)}, // day of month or day of week
+		{"@weekly", time.Date(2024, 3, 31, 0, 0, 0, 0, vienna)},
+		{"@aligned 5m", time.Date(2024, 3, 29, 10, 10, 0, 0, vienna)},
+		{"@aligned 7h", time.Date(2024, 3, 29, 14, 0, 0, 0, vienna)},
+	} {
+		s, err := metrics.ParseSchedule(tc.spec, vienna)
+		assert.NoError(t, err, tc.spec)
+		assert.True(t, tc.next.Equal(s.Next(from)), "%s: expected %v, got %v", tc.spec, tc.next, s.Next(from))
+	}
+
+	for _, spec := range []string{"", "* * * *", "60 * * * *", "*/0 * * * *", "5-1 * * * *", "0 0 30 2 *", "@aligned 0s", "@aligned 48h"} {
+		_, err := metrics.ParseSchedule(spec, vienna)
+		assert.Error(t, err, spec)
+	}
+}
+
+func TestParseMetricIntervals(t *testing.T) {
+	intervals, schedules, err := metrics.ParseMetricIntervals(map[string]any{"db_stats": 60, "wal": 30.0, "table_bloat_approx": "0 3 * * *"})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]float64{"db_stats": 60, "wal": 30, "table_bloat_approx": 86400}, intervals)
+	assert.Equal(t, map[string]string{"table_bloat_approx": "0 3 * * *"}, schedules)
+
+	intervals, _, err = metrics.ParseMetricIntervals(map[string]any{"wal": "0,5 * * * *"})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]float64{"wal": 3300}, intervals, "the gap after the reference date, not the shortest one")
+
+	_, _, err = metrics.ParseMetricIntervals(map[string]any{"db_stats": "every minute"})
+	assert.Error(t, err)
+}
@@ -0,0 +1,154 @@
+package metrics
+
+import (
+	"context"
+	"embed"
+	"errors"
+	"io/fs"
+	"sort"
+	"strings"
+
+	"github.com/cybertec-postgresql/pgwatch3/db"
+)
+
+//go:embed sql
+var builtinMetrics embed.FS
+
+// BuiltinFS returns the metric definitions, helpers and presets shipped with pgwatch3, in the --metrics-folder structure
+func BuiltinFS() fs.FS {
+	fsys, _ := fs.Sub(builtinMetrics, "sql")
+	return fsys
+}
+
+// OverlayFS merges file systems in the --metrics-folder structure, e.g. for reading presets and helpers through the
+// metric layers. A file of a later file system replaces the same file of the earlier ones, directories are merged
+type OverlayFS []fs.FS
+
+func (o OverlayFS) Open(name string) (fs.File, error) {
+	for i := len(o) - 1; i >= 0; i-- {
+		f, err := o[i].Open(name)
+		if err == nil {
+			return f, nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+	}
+	return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+}
+
+// ReadDir lists the entries of a directory over all file systems, a later one's entry replacing an earlier one's
+func (o OverlayFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	entries := make(map[string]fs.DirEntry)
+	found := false
+	for _, fsys := range o {
+		des, err := fs.ReadDir(fsys, name)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		found = true
+		for _, de := range des {
+			entries[de.Name()] = de
+		}
+	}
+	if !found {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+	}
+	ret := make([]fs.DirEntry, 0, len(entries))
+	for _, de := range entries {
+		ret = append(ret, de)
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Name() < ret[j].Name() })
+	return ret, nil
+}
+
+// MetricDefinitionSource provides metric definitions and the storage names of renamed metrics
+type MetricDefinitionSource interface {
+	// Name identifies the source when reporting where a definition came from
+	Name() string
+	GetMetricDefinitions(ctx context.Context) (MetricVersionDefs, map[string]string, error)
+}
+
+// BuiltinSource provides the metric definitions compiled into pgwatch3
+type BuiltinSource struct{}
+
+func (BuiltinSource) Name() string { return "builtin" }
+
+func (BuiltinSource) GetMetricDefinitions(ctx context.Context) (MetricVersionDefs, map[string]string, error) {
+	return ReadMetricsFromFS(ctx, BuiltinFS())
+}
+
+// FolderSource reads the metric definitions from a folder, see ReadMetricsFromFolder()
+type FolderSource struct {
+	Folder string
+}
+
+func (s FolderSource) Name() string { return "folder " + s.Folder }
+
+func (s FolderSource) GetMetricDefinitions(ctx context.Context) (MetricVersionDefs, map[string]string, error) {
+	return ReadMetricsFromFolder(ctx, s.Folder)
+}
+
+// PostgresSource reads the active metric definitions from the config DB
+type PostgresSource struct {
+	Conn db.PgxIface
+}
+
+func (PostgresSource) Name() string { return "config DB" }
+
+func (s PostgresSource) GetMetricDefinitions(ctx context.Context) (MetricVersionDefs, map[string]string, error) {
+	return ReadMetricsFromPostgres(ctx, s.Conn)
+}
+
+// MetricOrigin tells which layer the effective definition of a metric came from
+type MetricOrigin struct {
+	Metric     string   `json:"metric"`
+	Layer      string   `json:"layer"`
+	Overridden []string `json:"overridden,omitempty"` // lower layers also defining the metric, in resolution order
+}
+
+// MetricDefinitionLayers resolves metric definitions from several sources in order, a metric defined by a layer replaces
+// all versions of the same metric from the layers before it. If any layer fails, the whole resolution fails
+type MetricDefinitionLayers []MetricDefinitionSource
+
+// Name lists the layers in resolution order
+func (l MetricDefinitionLayers) Name() string {
+	names := make([]string, 0, len(l))
+	for _, src := range l {
+		names = append(names, src.Name())
+	}
+	return strings.Join(names, " < ")
+}
+
+func (l MetricDefinitionLayers) GetMetricDefinitions(ctx context.Context) (MetricVersionDefs, map[string]string, error) {
+	defs, renames, _, err := l.Resolve(ctx)
+	return defs, renames, err
+}
+
+// Resolve returns the effective metric definitions and storage names, and where each metric came from
+func (l MetricDefinitionLayers) Resolve(ctx context.Context) (defs MetricVersionDefs, renames map[string]string, origins map[string]MetricOrigin, err error) {
+	defs, renames, origins = make(MetricVersionDefs), make(map[string]string), make(map[string]MetricOrigin)
+	for _, src := range l {
+		layerDefs, layerRenames, err := src.GetMetricDefinitions(ctx)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		for metric, versions := range layerDefs {
+			origin := MetricOrigin{Metric: metric, Layer: src.Name()}
+			if prev, ok := origins[metric]; ok {
+				origin.Overridden = append(prev.Overridden, prev.Layer)
+			}
+			origins[metric] = origin
+			defs[metric] = versions
+			if name, ok := layerRenames[metric]; ok {
+				renames[metric] = name
+			} else {
+				delete(renames, metric)
+			}
+		}
+	}
+	return
+}
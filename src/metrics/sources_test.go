@@ -0,0 +1,93 @@
+package metrics_test
+
+import (
+	"context"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/cybertec-postgresql/pgwatch3/metrics"
+)
+
+func TestMetricDefinitionLayers(t *testing.T) {
+	ctx := context.Background()
+	builtin, builtinRenames, err := metrics.BuiltinSource{}.GetMetricDefinitions(ctx)
+	assert.NoError(t, err)
+	assert.Contains(t, builtin, "db_stats")
+	assert.NotContains(t, builtin, metrics.FileBasedMetricHelpersDir)
+	assert.Equal(t, map[string]string{
+		"stat_statements_no_query_text": "stat_statements",
+		"db_size_approx":                "db_size",
+		"db_stats_aurora":               "db_stats",
+		"table_stats_approx":            "table_stats",
+	}, builtinRenames, "metric_storage_name attributes should rename the storage of builtin metrics")
+
+	folder := t.TempDir()
+	assert.NoError(t, os.MkdirAll(filepath.Join(folder, "db_stats", "11"), 0755))
+	assert.NoError(t, os.WriteFile(filepath.Join(folder, "db_stats", "11", "metric.sql"), []byte("select 1 as site"), 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(folder, "db_stats", "metric_attrs.yaml"), []byte("metric_storage_name: site_db_stats"), 0644))
+
+	layers := metrics.MetricDefinitionLayers{metrics.BuiltinSource{}, metrics.FolderSource{Folder: folder}}
+	assert.Equal(t, "builtin < folder "+folder, layers.Name())
+	defs, renames, origins, err := layers.Resolve(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, defs, len(builtin))
+	assert.Equal(t, map[uint]metrics.MetricProperties{1100: {SQL: "select 1 as site", MetricAttrs: metrics.MetricAttrs{MetricStorageName: "site_db_stats"}}},
+		defs["db_stats"], "all builtin versions should be replaced")
+	assert.Equal(t, "site_db_stats", renames["db_stats"])
+	assert.Equal(t, metrics.MetricOrigin{Metric: "db_stats", Layer: "folder " + folder, Overridden: []string{"builtin"}}, origins["db_stats"])
+	assert.Equal(t, metrics.MetricOrigin{Metric: "wal", Layer: "builtin"}, origins["wal"])
+
+	layers = append(layers, metrics.FolderSource{Folder: filepath.Join(folder, "missing")})
+	_, _, _, err = layers.Resolve(ctx)
+	assert.Error(t, err, "a failing layer should fail all")
+}
+
+func TestReadMetricsFromFolderStorageName(t *testing.T) {
+	folder := t.TempDir()
+	for metric, attrs := range map[string]string{
+		"renamed": "metric_storage_name: db_stats",
+		"invalid": "metric_storage_name: [",
+	} {
+		assert.NoError(t, os.MkdirAll(filepath.Join(folder, metric, "11"), 0755))
+		assert.NoError(t, os.WriteFile(filepath.Join(folder, metric, "11", "metric.sql"), []byte("select 1"), 0644))
+		assert.NoError(t, os.WriteFile(filepath.Join(folder, metric, "metric_attrs.yaml"), []byte(attrs), 0644))
+	}
+	defs, renames, err := metrics.ReadMetricsFromFolder(context.Background(), folder)
+	assert.NoError(t, err)
+	assert.Len(t, defs, 2, "a metric with invalid attributes should still be read")
+	assert.Equal(t, map[string]string{"renamed": "db_stats"}, renames)
+}
+
+func TestOverlayFS(t *testing.T) {
+	folder := t.TempDir()
+	assert.NoError(t, os.MkdirAll(filepath.Join(folder, "db_stats", "11"), 0755))
+	assert.NoError(t, os.WriteFile(filepath.Join(folder, "db_stats", "11", "metric.sql"), []byte("select 1 as site"), 0644))
+	overlay := metrics.OverlayFS{metrics.BuiltinFS(), os.DirFS(folder)}
+
+	sql, err := fs.ReadFile(overlay, "db_stats/11/metric.sql")
+	assert.NoError(t, err)
+	assert.Equal(t, "select 1 as site", string(sql), "a later file system should win")
+	pmc, _, err := metrics.ReadPresetMetricsConfigFromFS(overlay)
+	assert.NoError(t, err, "presets missing from the folder should be read from the builtin ones")
+	assert.NotEmpty(t, pmc)
+
+	entries, err := fs.ReadDir(overlay, "db_stats")
+	assert.NoError(t, err)
+	builtinEntries, _ := fs.ReadDir(metrics.BuiltinFS(), "db_stats")
+	assert.Len(t, entries, len(builtinEntries), "directories should be merged")
+
+	helpers, err := fs.Sub(overlay, metrics.FileBasedMetricHelpersDir)
+	assert.NoError(t, err)
+	defs, _, err := metrics.ReadMetricsFromFS(context.Background(), helpers)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, defs)
+
+	_, err = overlay.Open("missing/metric.sql")
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+	_, err = metrics.OverlayFS{os.DirFS(filepath.Join(folder, "missing"))}.ReadDir(".")
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+}
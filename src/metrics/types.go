@@ -24,6 +24,11 @@ type MetricAttrs struct {
 	DisabledDays              string               `yaml:"disabled_days"`             // Cron style, 0 = Sunday. Ranges allowed: 0,2-4
 	DisableTimes              []string             `yaml:"disabled_times"`            // "11:00-13:00"
 	StatementTimeoutSeconds   int64                `yaml:"statement_timeout_seconds"` // overrides per monitored DB settings
+	RecoSeverity              string               `yaml:"reco_severity"`             // info | warning | critical, for reco_* metrics not returning a "severity" column
+	RetentionDays             int                  `yaml:"retention_days"`            // overrides --pg-retention-days for the metric
+	Schedule                  string               `yaml:"schedule"`                  // cron expression or "@aligned 5m", used if configured with an interval
+	TimescaleChunkInterval    string               `yaml:"timescale_chunk_interval"`  // e.g. "1 day", for new hypertables, overrides the admin.config default
+	TimescaleCompressAfter    string               `yaml:"timescale_compress_after"`  // e.g. "2 days", for new hypertables, overrides the admin.config default
 }
 
 type MetricProperties struct {
@@ -55,7 +60,7 @@ type MeasurementMessage struct {
 type Preset struct {
 	Name        string
 	Description string
-	Metrics     map[string]float64
+	Metrics     map[string]any // interval in seconds or a schedule, see ParseMetricIntervals()
 }
 
 const (
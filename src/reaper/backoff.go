@@ -0,0 +1,151 @@
+package reaper
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// Backoff stretches the interval of a failing or overrunning metric exponentially, up to Max times the configured
+// interval, and shrinks it back once fetches succeed within the halved interval
+type Backoff struct {
+	Factor int
+	Max    int
+	ticks  int // ticks since the last fetch while backing off
+}
+
+// Update adjusts the factor after a fetch and returns true if it changed
+func (b *Backoff) Update(failed bool, fetchTime, interval time.Duration) bool {
+	old := b.Factor
+	switch {
+	case failed || fetchTime > interval*time.Duration(b.Factor):
+		b.Factor = min(b.Factor*2, b.Max)
+	case b.Factor > 1 && fetchTime <= interval*time.Duration(b.Factor/2):
+		b.Factor /= 2
+	}
+	if b.Factor != old {
+		b.ticks = 0
+	}
+	return b.Factor != old
+}
+
+// Skip returns true if a tick is to be left out while backing off, i.e. only every Factor-th tick is fetched. The
+// missed ticks the scheduler dropped since the previous call count too. Counting ticks instead of measuring the time
+// keeps the spacing of schedules with uneven gaps, e.g. cron ones
+func (b *Backoff) Skip(missed uint64) bool {
+	if b.Factor <= 1 {
+		return false
+	}
+	b.ticks += 1 + int(missed)
+	if b.ticks < b.Factor {
+		return true
+	}
+	b.ticks = 0
+	return false
+}
+
+func (g *Gatherer) setEffectiveInterval(dbMetric string, interval float64, factor int) {
+	g.effectiveIntervalsLock.Lock()
+	defer g.effectiveIntervalsLock.Unlock()
+	if factor > 1 {
+		g.effectiveIntervals[dbMetric] = interval * float64(factor)
+	} else {
+		delete(g.effectiveIntervals, dbMetric)
+	}
+}
+
+// getEffectiveIntervals returns the stretched intervals per DB and metric
+func (g *Gatherer) getEffectiveIntervals() map[string]map[string]float64 {
+	ret := make(map[string]map[string]float64)
+	g.effectiveIntervalsLock.RLock()
+	defer g.effectiveIntervalsLock.RUnlock()
+	for dbMetric, interval := range g.effectiveIntervals {
+		db, metric, _ := strings.Cut(dbMetric, dbMetricJoinStr)
+		if ret[db] == nil {
+			ret[db] = make(map[string]float64)
+		}
+		ret[db][metric] = interval
+	}
+	return ret
+}
+
+// CircuitBreaker stops all fetches of a DB after a number of consecutive connection failures until the cooldown has
+// passed. Then a single probe fetch is let through, closing the circuit on success and opening it again on failure
+type CircuitBreaker struct {
+	sync.Mutex
+	threshold int // 0 disables
+	cooldown  time.Duration
+	failures  int
+	openUntil time.Time
+	probing   bool
+}
+
+// GetCircuitBreaker returns the circuit breaker of a DB, shared by all its metric gatherers
+func (g *Gatherer) GetCircuitBreaker(dbUnique string) *CircuitBreaker {
+	cb, _ := g.circuitBreakers.LoadOrStore(dbUnique, &CircuitBreaker{threshold: g.opts.CircuitBreakerFailures, cooldown: g.opts.CircuitBreakerCooldown})
+	return cb.(*CircuitBreaker)
+}
+
+// IsOpen returns true if fetches are currently blocked
+func (cb *CircuitBreaker) IsOpen() bool {
+	cb.Lock()
+	defer cb.Unlock()
+	return cb.threshold > 0 && cb.failures >= cb.threshold
+}
+
+// Allow returns true if a fetch can be done now
+func (cb *CircuitBreaker) Allow(now time.Time) bool {
+	cb.Lock()
+	defer cb.Unlock()
+	if cb.threshold == 0 || cb.failures < cb.threshold {
+		return true
+	}
+	if cb.probing || now.Before(cb.openUntil) {
+		return false
+	}
+	cb.probing = true
+	return true
+}
+
+// Record registers the outcome of a fetch, returning true if the circuit got opened or closed
+func (cb *CircuitBreaker) Record(now time.Time, err error) (changed bool) {
+	cb.Lock()
+	defer cb.Unlock()
+	if cb.threshold == 0 {
+		return false
+	}
+	wasOpen := cb.failures >= cb.threshold
+	cb.probing = false
+	if err == nil || !IsConnectionError(err) {
+		cb.failures = 0
+		return wasOpen
+	}
+	cb.failures++
+	if cb.failures >= cb.threshold {
+		cb.openUntil = now.Add(cb.cooldown)
+	}
+	return !wasOpen && cb.failures >= cb.threshold
+}
+
+// getOpenCircuitBreakers returns the DBs currently not fetched from
+func (g *Gatherer) getOpenCircuitBreakers() []string {
+	ret := make([]string, 0)
+	g.circuitBreakers.Range(func(key, value any) bool {
+		if value.(*CircuitBreaker).IsOpen() {
+			ret = append(ret, key.(string))
+		}
+		return true
+	})
+	return ret
+}
+
+// IsConnectionError returns true if the DB could not be reached at all, as opposed to e.g. a failing query
+func IsConnectionError(err error) bool {
+	var connectErr *pgconn.ConnectError
+	var netErr net.Error
+	return errors.As(err, &connectErr) || errors.As(err, &netErr) || strings.Contains(err.Error(), "connection refused")
+}
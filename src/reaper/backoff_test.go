@@ -0,0 +1,83 @@
+package reaper
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/cybertec-postgresql/pgwatch3/metrics"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackoff(t *testing.T) {
+	b := Backoff{Factor: 1, Max: 4}
+	interval := 10 * time.Second
+	assert.False(t, b.Update(false, time.Second, interval))
+	assert.True(t, b.Update(true, time.Second, interval))
+	assert.Equal(t, 2, b.Factor)
+	assert.True(t, b.Update(false, 25*time.Second, interval), "overrun of the stretched interval")
+	assert.Equal(t, 4, b.Factor)
+	assert.False(t, b.Update(true, time.Second, interval), "capped")
+	assert.False(t, b.Update(false, 25*time.Second, interval), "not fitting into the halved interval")
+	assert.True(t, b.Update(false, 15*time.Second, interval))
+	assert.Equal(t, 2, b.Factor)
+	assert.True(t, b.Update(false, time.Second, interval))
+	assert.Equal(t, 1, b.Factor)
+}
+
+func TestBackoffSkip(t *testing.T) {
+	schedule, err := metrics.ParseSchedule("0,5 * * * *", time.UTC) // gaps of 5 and 55 minutes
+	assert.NoError(t, err)
+	fetched := func(b *Backoff, hours int) (ret []time.Time) {
+		s := NewScheduler(0, 0)
+		job := s.Add("db1", time.Hour, 0, schedule)
+		defer s.Remove(job)
+		for end := job.next.Add(time.Duration(hours) * time.Hour); job.next.Before(end); {
+			s.tick(job.next)
+			if tick := <-job.C; !b.Skip(0) {
+				ret = append(ret, tick)
+			}
+		}
+		return
+	}
+
+	ticks := fetched(&Backoff{Factor: 1, Max: 4}, 3)
+	assert.Len(t, ticks, 6, "every tick should fire without backing off")
+	for _, tick := range ticks {
+		assert.Contains(t, []int{0, 5}, tick.Minute())
+	}
+	assert.Len(t, fetched(&Backoff{Factor: 2, Max: 4}, 3), 3, "every 2nd tick should fire")
+
+	b := Backoff{Factor: 4, Max: 4}
+	assert.True(t, b.Skip(0))
+	assert.False(t, b.Skip(2), "ticks missed by the scheduler should count")
+	assert.True(t, b.Skip(0))
+	assert.True(t, b.Update(false, time.Second, time.Minute))
+	assert.Equal(t, 2, b.Factor)
+	assert.True(t, b.Skip(0), "the count should restart with the new factor")
+	assert.False(t, b.Skip(0))
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	cb := &CircuitBreaker{threshold: 2, cooldown: time.Minute}
+	now := time.Now()
+	connErr := errors.New("dial tcp 127.0.0.1:5432: connect: connection refused")
+
+	assert.False(t, cb.Record(now, &pgconn.PgError{Code: "57014"}), "query errors don't count")
+	assert.False(t, cb.Record(now, connErr))
+	assert.True(t, cb.Allow(now))
+	assert.True(t, cb.Record(now, connErr), "opened")
+	assert.True(t, cb.IsOpen())
+	assert.False(t, cb.Allow(now.Add(30*time.Second)))
+
+	assert.True(t, cb.Allow(now.Add(time.Minute)), "probe after the cooldown")
+	assert.False(t, cb.Allow(now.Add(time.Minute)), "single probe only")
+	assert.False(t, cb.Record(now.Add(time.Minute), connErr), "still open")
+	assert.False(t, cb.Allow(now.Add(90*time.Second)))
+
+	assert.True(t, cb.Allow(now.Add(2*time.Minute)))
+	assert.True(t, cb.Record(now.Add(2*time.Minute), nil), "closed")
+	assert.False(t, cb.IsOpen())
+	assert.True(t, cb.Allow(now.Add(2*time.Minute)))
+}
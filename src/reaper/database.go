@@ -1,10 +1,10 @@
-package main
+package reaper
 
 import (
 	"context"
 	"errors"
 	"fmt"
-	"path"
+	"io/fs"
 	"strconv"
 	"strings"
 	"sync"
@@ -17,11 +17,9 @@ import (
 	"github.com/cybertec-postgresql/pgwatch3/psutil"
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
+	"go.opentelemetry.io/otel/trace"
 )
 
-var configDb db.PgxPoolIface
-var monitoredDbConnCache map[string]db.PgxPoolIface = make(map[string]db.PgxPoolIface)
-
 func IsPostgresDBType(dbType string) bool {
 	if dbType == config.DbTypeBouncer || dbType == config.DbTypePgPOOL {
 		return false
@@ -30,53 +28,53 @@ func IsPostgresDBType(dbType string) bool {
 }
 
 // every DB under monitoring should have exactly 1 sql.DB connection assigned, that will internally limit parallel access
-func InitSQLConnPoolForMonitoredDBIfNil(md MonitoredDatabase) error {
-	monitoredDbConnCacheLock.Lock()
-	defer monitoredDbConnCacheLock.Unlock()
+func (g *Gatherer) InitSQLConnPoolForMonitoredDBIfNil(md MonitoredDatabase) error {
+	g.monitoredDbConnCacheLock.Lock()
+	defer g.monitoredDbConnCacheLock.Unlock()
 
-	conn, ok := monitoredDbConnCache[md.DBUniqueName]
+	conn, ok := g.monitoredDbConnCache[md.DBUniqueName]
 	if ok && conn != nil {
 		return nil
 	}
 
-	conn, err := db.GetPostgresDBConnection(mainContext, md.ConnStr, func(conf *pgxpool.Config) error {
-		conf.MaxConns = int32(opts.MaxParallelConnectionsPerDb)
+	conn, err := db.GetPostgresDBConnection(g.mainContext, md.ConnStr, func(conf *pgxpool.Config) error {
+		conf.MaxConns = int32(g.opts.MaxParallelConnectionsPerDb)
 		return nil
 	})
 	if err != nil {
 		return err
 	}
 
-	monitoredDbConnCache[md.DBUniqueName] = conn
-	logger.Debugf("[%s] Connection pool initialized with max %d parallel connections. Conn pooling: %v", md.DBUniqueName, opts.MaxParallelConnectionsPerDb, opts.UseConnPooling)
+	g.monitoredDbConnCache[md.DBUniqueName] = conn
+	g.logger.Debugf("[%s] Connection pool initialized with max %d parallel connections. Conn pooling: %v", md.DBUniqueName, g.opts.MaxParallelConnectionsPerDb, g.opts.UseConnPooling)
 
 	return nil
 }
 
-func CloseOrLimitSQLConnPoolForMonitoredDBIfAny(dbUnique string) {
-	monitoredDbConnCacheLock.Lock()
-	defer monitoredDbConnCacheLock.Unlock()
+func (g *Gatherer) CloseOrLimitSQLConnPoolForMonitoredDBIfAny(dbUnique string) {
+	g.monitoredDbConnCacheLock.Lock()
+	defer g.monitoredDbConnCacheLock.Unlock()
 
-	conn, ok := monitoredDbConnCache[dbUnique]
+	conn, ok := g.monitoredDbConnCache[dbUnique]
 	if !ok || conn == nil {
 		return
 	}
 
-	if IsDBUndersized(dbUnique) || IsDBIgnoredBasedOnRecoveryState(dbUnique) {
+	if g.IsDBUndersized(dbUnique) || g.IsDBIgnoredBasedOnRecoveryState(dbUnique) {
 
-		if opts.UseConnPooling {
+		if g.opts.UseConnPooling {
 			s := conn.Stat()
 			if s.TotalConns() > 1 {
-				logger.Debugf("[%s] Limiting SQL connection pool to max 1 connection due to dormant state ...", dbUnique)
+				g.logger.Debugf("[%s] Limiting SQL connection pool to max 1 connection due to dormant state ...", dbUnique)
 				// conn.SetMaxIdleConns(1)
 				// conn.SetMaxOpenConns(1)
 			}
 		}
 
 	} else { // removed from config
-		logger.Debugf("[%s] Closing SQL connection pool ...", dbUnique)
+		g.logger.Debugf("[%s] Closing SQL connection pool ...", dbUnique)
 		conn.Close()
-		delete(monitoredDbConnCache, dbUnique)
+		delete(g.monitoredDbConnCache, dbUnique)
 	}
 }
 
@@ -88,29 +86,34 @@ func DBExecRead(ctx context.Context, conn db.PgxIface, sql string, args ...any)
 	return nil, err
 }
 
-func DBExecReadByDbUniqueName(ctx context.Context, dbUnique string, sql string, args ...any) (metrics.Measurements, error) {
+func (g *Gatherer) DBExecReadByDbUniqueName(ctx context.Context, dbUnique string, sql string, args ...any) (data metrics.Measurements, err error) {
 	var conn db.PgxIface
 	var md MonitoredDatabase
-	var data metrics.Measurements
-	var err error
 	var tx pgx.Tx
 	var exists bool
+	ctx, span := tracer.Start(ctx, "DBExecReadByDbUniqueName", trace.WithAttributes(attrDB.String(dbUnique)))
+	defer func() {
+		span.SetAttributes(attrRows.Int(len(data)))
+		endSpan(span, err)
+	}()
 	if strings.TrimSpace(sql) == "" {
 		return nil, errors.New("empty SQL")
 	}
-	md, err = GetMonitoredDatabaseByUniqueName(dbUnique)
+	md, err = g.GetMonitoredDatabaseByUniqueName(dbUnique)
 	if err != nil {
 		return nil, err
 	}
-	monitoredDbConnCacheLock.RLock()
+	g.monitoredDbConnCacheLock.RLock()
 	// sqlx.DB itself is parallel safe
-	conn, exists = monitoredDbConnCache[dbUnique]
-	monitoredDbConnCacheLock.RUnlock()
+	conn, exists = g.monitoredDbConnCache[dbUnique]
+	g.monitoredDbConnCacheLock.RUnlock()
 	if !exists || conn == nil {
-		logger.Errorf("SQL connection for dbUnique %s not found or nil", dbUnique) // Should always be initialized in the main loop DB discovery code ...
+		g.logger.Errorf("SQL connection for dbUnique %s not found or nil", dbUnique) // Should always be initialized in the main loop DB discovery code ...
 		return nil, errors.New("SQL connection not found or nil")
 	}
+	_, acquireSpan := tracer.Start(ctx, "acquire connection")
 	tx, err = conn.Begin(ctx)
+	endSpan(acquireSpan, err)
 	if err != nil {
 		return nil, err
 	}
@@ -118,17 +121,17 @@ func DBExecReadByDbUniqueName(ctx context.Context, dbUnique string, sql string,
 	if IsPostgresDBType(md.DBType) {
 		_, err = tx.Exec(ctx, "SET LOCAL lock_timeout TO '100ms'")
 		if err != nil {
-			atomic.AddUint64(&totalMetricFetchFailuresCounter, 1)
+			atomic.AddUint64(&g.totalMetricFetchFailuresCounter, 1)
 			return nil, err
 		}
 	}
 	if data, err = DBExecRead(ctx, tx, sql, args...); err != nil {
-		atomic.AddUint64(&totalMetricFetchFailuresCounter, 1)
+		atomic.AddUint64(&g.totalMetricFetchFailuresCounter, 1)
 	}
 	return data, err
 }
 
-func GetAllActiveHostsFromConfigDB() (metrics.Measurements, error) {
+func (g *Gatherer) GetAllActiveHostsFromConfigDB() (metrics.Measurements, error) {
 	sqlLatest := `
 		select /* pgwatch3_generated */
 		  md_name, md_group, md_dbtype, md_connstr,
@@ -136,7 +139,8 @@ func GetAllActiveHostsFromConfigDB() (metrics.Measurements, error) {
 		  md_is_superuser,
 		  coalesce(md_include_pattern, '') as md_include_pattern, coalesce(md_exclude_pattern, '') as md_exclude_pattern,
 		  coalesce(md_custom_tags::text, '{}') as md_custom_tags, 
-		  md_encryption, coalesce(md_host_config, '{}')::text as md_host_config, md_only_if_master
+		  md_encryption, coalesce(md_host_config, '{}')::text as md_host_config, md_only_if_master,
+		  coalesce(md_timezone, '') as md_timezone
 		from
 		  pgwatch3.monitored_db
 	          left join
@@ -146,49 +150,49 @@ func GetAllActiveHostsFromConfigDB() (metrics.Measurements, error) {
 		where
 		  md_is_enabled
 	`
-	return DBExecRead(mainContext, configDb, sqlLatest)
+	return DBExecRead(g.mainContext, g.configDb, sqlLatest)
 }
 
-func DBGetSizeMB(dbUnique string) (int64, error) {
+func (g *Gatherer) DBGetSizeMB(dbUnique string) (int64, error) {
 	sqlDbSize := `select /* pgwatch3_generated */ pg_database_size(current_database());`
 	var sizeMB int64
 
-	lastDBSizeCheckLock.RLock()
-	lastDBSizeCheckTime := lastDBSizeFetchTime[dbUnique]
-	lastDBSize, ok := lastDBSizeMB[dbUnique]
-	lastDBSizeCheckLock.RUnlock()
+	g.lastDBSizeCheckLock.RLock()
+	lastDBSizeCheckTime := g.lastDBSizeFetchTime[dbUnique]
+	lastDBSize, ok := g.lastDBSizeMB[dbUnique]
+	g.lastDBSizeCheckLock.RUnlock()
 
 	if !ok || lastDBSizeCheckTime.Add(dbSizeCachingInterval).Before(time.Now()) {
-		ver, err := DBGetPGVersion(mainContext, dbUnique, config.DbTypePg, false)
+		ver, err := g.DBGetPGVersion(g.mainContext, dbUnique, config.DbTypePg, false)
 		if err != nil || (ver.ExecEnv != execEnvAzureSingle) || (ver.ExecEnv == execEnvAzureSingle && ver.ApproxDBSizeB < 1e12) {
-			logger.Debugf("[%s] determining DB size ...", dbUnique)
+			g.logger.Debugf("[%s] determining DB size ...", dbUnique)
 
-			data, err := DBExecReadByDbUniqueName(mainContext, dbUnique, sqlDbSize) // can take some time on ancient FS, use 300s stmt timeout
+			data, err := g.DBExecReadByDbUniqueName(g.mainContext, dbUnique, sqlDbSize) // can take some time on ancient FS, use 300s stmt timeout
 			if err != nil {
-				logger.Errorf("[%s] failed to determine DB size...cannot apply --min-db-size-mb flag. err: %v ...", dbUnique, err)
+				g.logger.Errorf("[%s] failed to determine DB size...cannot apply --min-db-size-mb flag. err: %v ...", dbUnique, err)
 				return 0, err
 			}
 			sizeMB = data[0]["pg_database_size"].(int64) / 1048576
 		} else {
-			logger.Debugf("[%s] Using approx DB size for the --min-db-size-mb filter ...", dbUnique)
+			g.logger.Debugf("[%s] Using approx DB size for the --min-db-size-mb filter ...", dbUnique)
 			sizeMB = ver.ApproxDBSizeB / 1048576
 		}
 
-		logger.Debugf("[%s] DB size = %d MB, caching for %v ...", dbUnique, sizeMB, dbSizeCachingInterval)
+		g.logger.Debugf("[%s] DB size = %d MB, caching for %v ...", dbUnique, sizeMB, dbSizeCachingInterval)
 
-		lastDBSizeCheckLock.Lock()
-		lastDBSizeFetchTime[dbUnique] = time.Now()
-		lastDBSizeMB[dbUnique] = sizeMB
-		lastDBSizeCheckLock.Unlock()
+		g.lastDBSizeCheckLock.Lock()
+		g.lastDBSizeFetchTime[dbUnique] = time.Now()
+		g.lastDBSizeMB[dbUnique] = sizeMB
+		g.lastDBSizeCheckLock.Unlock()
 
 		return sizeMB, nil
 
 	}
-	logger.Debugf("[%s] using cached DBsize %d MB for the --min-db-size-mb filter check", dbUnique, lastDBSize)
+	g.logger.Debugf("[%s] using cached DBsize %d MB for the --min-db-size-mb filter check", dbUnique, lastDBSize)
 	return lastDBSize, nil
 }
 
-func TryDiscoverExecutionEnv(dbUnique string) string {
+func (g *Gatherer) TryDiscoverExecutionEnv(dbUnique string) string {
 	sqlPGExecEnv := `select /* pgwatch3_generated */
 	case
 	  when exists (select * from pg_settings where name = 'pg_qs.host_database' and setting = 'azure_sys') and version() ~* 'compiled by Visual C' then 'AZURE_SINGLE'
@@ -198,14 +202,14 @@ func TryDiscoverExecutionEnv(dbUnique string) string {
 	  'UNKNOWN'
 	end as exec_env;
   `
-	data, err := DBExecReadByDbUniqueName(mainContext, dbUnique, sqlPGExecEnv)
+	data, err := g.DBExecReadByDbUniqueName(g.mainContext, dbUnique, sqlPGExecEnv)
 	if err != nil {
 		return ""
 	}
 	return data[0]["exec_env"].(string)
 }
 
-func GetDBTotalApproxSize(dbUnique string) (int64, error) {
+func (g *Gatherer) GetDBTotalApproxSize(dbUnique string) (int64, error) {
 	sqlApproxDBSize := `
 	select /* pgwatch3_generated */
 		current_setting('block_size')::int8 * sum(relpages) as db_size_approx
@@ -214,14 +218,14 @@ func GetDBTotalApproxSize(dbUnique string) (int64, error) {
 	where	/* works only for v9.1+*/
 		c.relpersistence != 't';
 	`
-	data, err := DBExecReadByDbUniqueName(mainContext, dbUnique, sqlApproxDBSize)
+	data, err := g.DBExecReadByDbUniqueName(g.mainContext, dbUnique, sqlApproxDBSize)
 	if err != nil {
 		return 0, err
 	}
 	return data[0]["db_size_approx"].(int64), nil
 }
 
-func DBGetPGVersion(ctx context.Context, dbUnique string, dbType string, noCache bool) (DBVersionMapEntry, error) {
+func (g *Gatherer) DBGetPGVersion(ctx context.Context, dbUnique string, dbType string, noCache bool) (DBVersionMapEntry, error) {
 	var ver DBVersionMapEntry
 	var verNew DBVersionMapEntry
 	var ok bool
@@ -237,22 +241,24 @@ func DBGetPGVersion(ctx context.Context, dbUnique string, dbType string, noCache
 	sqlExtensions := `select /* pgwatch3_generated */ extname::text, (regexp_matches(extversion, $$\d+\.?\d+?$$))[1]::text as extversion from pg_extension order by 1;`
 	pgpoolVersion := `SHOW POOL_VERSION` // supported from pgpool2 v3.0
 
-	dbPgVersionMapLock.Lock()
-	getVerLock, ok := dbGetPgVersionMapLock[dbUnique]
+	g.dbPgVersionMapLock.Lock()
+	getVerLock, ok := g.dbGetPgVersionMapLock[dbUnique]
 	if !ok {
-		dbGetPgVersionMapLock[dbUnique] = &sync.RWMutex{}
-		getVerLock = dbGetPgVersionMapLock[dbUnique]
+		g.dbGetPgVersionMapLock[dbUnique] = &sync.RWMutex{}
+		getVerLock = g.dbGetPgVersionMapLock[dbUnique]
 	}
-	ver, ok = dbPgVersionMap[dbUnique]
-	dbPgVersionMapLock.Unlock()
+	ver, ok = g.dbPgVersionMap[dbUnique]
+	g.dbPgVersionMapLock.Unlock()
 
 	if !noCache && ok && ver.LastCheckedOn.After(time.Now().Add(time.Minute*-2)) { // use cached version for 2 min
 		//log.Debugf("using cached postgres version %s for %s", ver.Version.String(), dbUnique)
 		return ver, nil
 	}
+	ctx, span := tracer.Start(ctx, "DBGetPGVersion", trace.WithAttributes(attrDB.String(dbUnique)))
+	defer span.End()
 	getVerLock.Lock() // limit to 1 concurrent version info fetch per DB
 	defer getVerLock.Unlock()
-	logger.WithField("database", dbUnique).
+	g.logger.WithField("database", dbUnique).
 		WithField("type", dbType).Debug("determining DB version and recovery status...")
 
 	if verNew.Extensions == nil {
@@ -260,7 +266,7 @@ func DBGetPGVersion(ctx context.Context, dbUnique string, dbType string, noCache
 	}
 
 	if dbType == config.DbTypeBouncer {
-		data, err := DBExecReadByDbUniqueName(ctx, dbUnique, "show version")
+		data, err := g.DBExecReadByDbUniqueName(ctx, dbUnique, "show version")
 		if err != nil {
 			return verNew, err
 		}
@@ -271,14 +277,14 @@ func DBGetPGVersion(ctx context.Context, dbUnique string, dbType string, noCache
 		} else {
 			matches := rBouncerAndPgpoolVerMatch.FindStringSubmatch(data[0]["version"].(string))
 			if len(matches) != 1 {
-				logger.Errorf("[%s] Unexpected PgBouncer version input: %s", dbUnique, data[0]["version"].(string))
+				g.logger.Errorf("[%s] Unexpected PgBouncer version input: %s", dbUnique, data[0]["version"].(string))
 				return ver, fmt.Errorf("Unexpected PgBouncer version input: %s", data[0]["version"].(string))
 			}
 			verNew.VersionStr = matches[0]
 			verNew.Version = VersionToInt(matches[0])
 		}
 	} else if dbType == config.DbTypePgPOOL {
-		data, err := DBExecReadByDbUniqueName(ctx, dbUnique, pgpoolVersion)
+		data, err := g.DBExecReadByDbUniqueName(ctx, dbUnique, pgpoolVersion)
 		if err != nil {
 			return verNew, err
 		}
@@ -288,19 +294,19 @@ func DBGetPGVersion(ctx context.Context, dbUnique string, dbType string, noCache
 		} else {
 			matches := rBouncerAndPgpoolVerMatch.FindStringSubmatch(string(data[0]["pool_version"].([]byte)))
 			if len(matches) != 1 {
-				logger.Errorf("[%s] Unexpected PgPool version input: %s", dbUnique, data[0]["pool_version"].([]byte))
+				g.logger.Errorf("[%s] Unexpected PgPool version input: %s", dbUnique, data[0]["pool_version"].([]byte))
 				return ver, fmt.Errorf("Unexpected PgPool version input: %s", data[0]["pool_version"].([]byte))
 			}
 			verNew.VersionStr = matches[0]
 			verNew.Version = VersionToInt(matches[0])
 		}
 	} else {
-		data, err := DBExecReadByDbUniqueName(ctx, dbUnique, sql)
+		data, err := g.DBExecReadByDbUniqueName(ctx, dbUnique, sql)
 		if err != nil {
 			if noCache {
 				return ver, err
 			}
-			logger.Infof("[%s] DBGetPGVersion failed, using old cached value. err: %v", dbUnique, err)
+			g.logger.Infof("[%s] DBGetPGVersion failed, using old cached value. err: %v", dbUnique, err)
 			return ver, nil
 
 		}
@@ -309,9 +315,9 @@ func DBGetPGVersion(ctx context.Context, dbUnique string, dbType string, noCache
 		verNew.IsInRecovery = data[0]["pg_is_in_recovery"].(bool)
 		verNew.RealDbname = data[0]["current_database"].(string)
 
-		if verNew.Version > VersionToInt("10.0") && opts.Metric.SystemIdentifierField > "" {
-			logger.Debugf("[%s] determining system identifier version (pg ver: %v)", dbUnique, verNew.VersionStr)
-			data, err := DBExecReadByDbUniqueName(ctx, dbUnique, sqlSysid)
+		if verNew.Version > VersionToInt("10.0") && g.opts.Metric.SystemIdentifierField > "" {
+			g.logger.Debugf("[%s] determining system identifier version (pg ver: %v)", dbUnique, verNew.VersionStr)
+			data, err := g.DBExecReadByDbUniqueName(ctx, dbUnique, sqlSysid)
 			if err == nil && len(data) > 0 {
 				verNew.SystemIdentifier = data[0]["system_identifier"].(string)
 			}
@@ -320,17 +326,17 @@ func DBGetPGVersion(ctx context.Context, dbUnique string, dbType string, noCache
 		if ver.ExecEnv != "" {
 			verNew.ExecEnv = ver.ExecEnv // carry over as not likely to change ever
 		} else {
-			logger.Debugf("[%s] determining the execution env...", dbUnique)
-			execEnv := TryDiscoverExecutionEnv(dbUnique)
+			g.logger.Debugf("[%s] determining the execution env...", dbUnique)
+			execEnv := g.TryDiscoverExecutionEnv(dbUnique)
 			if execEnv != "" {
-				logger.Debugf("[%s] running on execution env: %s", dbUnique, execEnv)
+				g.logger.Debugf("[%s] running on execution env: %s", dbUnique, execEnv)
 				verNew.ExecEnv = execEnv
 			}
 		}
 
 		// to work around poor Azure Single Server FS functions performance for some metrics + the --min-db-size-mb filter
 		if verNew.ExecEnv == execEnvAzureSingle {
-			approxSize, err := GetDBTotalApproxSize(dbUnique)
+			approxSize, err := g.GetDBTotalApproxSize(dbUnique)
 			if err == nil {
 				verNew.ApproxDBSizeB = approxSize
 			} else {
@@ -338,60 +344,60 @@ func DBGetPGVersion(ctx context.Context, dbUnique string, dbType string, noCache
 			}
 		}
 
-		logger.Debugf("[%s] determining if monitoring user is a superuser...", dbUnique)
-		data, err = DBExecReadByDbUniqueName(ctx, dbUnique, sqlSu)
+		g.logger.Debugf("[%s] determining if monitoring user is a superuser...", dbUnique)
+		data, err = g.DBExecReadByDbUniqueName(ctx, dbUnique, sqlSu)
 		if err == nil {
 			verNew.IsSuperuser = data[0]["rolsuper"].(bool)
 		}
-		logger.Debugf("[%s] superuser=%v", dbUnique, verNew.IsSuperuser)
+		g.logger.Debugf("[%s] superuser=%v", dbUnique, verNew.IsSuperuser)
 
 		if verNew.Version >= MinExtensionInfoAvailable {
 			//log.Debugf("[%s] determining installed extensions info...", dbUnique)
-			data, err = DBExecReadByDbUniqueName(mainContext, dbUnique, sqlExtensions)
+			data, err = g.DBExecReadByDbUniqueName(g.mainContext, dbUnique, sqlExtensions)
 			if err != nil {
-				logger.Errorf("[%s] failed to determine installed extensions info: %v", dbUnique, err)
+				g.logger.Errorf("[%s] failed to determine installed extensions info: %v", dbUnique, err)
 			} else {
 				for _, dr := range data {
 					extver := VersionToInt(dr["extversion"].(string))
 					if extver == 0 {
-						logger.Error("[%s] failed to determine extension version info for extension %s: %v", dbUnique, dr["extname"])
+						g.logger.Error("[%s] failed to determine extension version info for extension %s: %v", dbUnique, dr["extname"])
 						continue
 					}
 					verNew.Extensions[dr["extname"].(string)] = extver
 				}
-				logger.Debugf("[%s] installed extensions: %+v", dbUnique, verNew.Extensions)
+				g.logger.Debugf("[%s] installed extensions: %+v", dbUnique, verNew.Extensions)
 			}
 		}
 	}
 
 	verNew.LastCheckedOn = time.Now()
-	dbPgVersionMapLock.Lock()
-	dbPgVersionMap[dbUnique] = verNew
-	dbPgVersionMapLock.Unlock()
+	g.dbPgVersionMapLock.Lock()
+	g.dbPgVersionMap[dbUnique] = verNew
+	g.dbPgVersionMapLock.Unlock()
 
 	return verNew, nil
 }
 
-func DetectSprocChanges(dbUnique string, vme DBVersionMapEntry, storageCh chan<- []metrics.MeasurementMessage, hostState map[string]map[string]string) ChangeDetectionResults {
+func (g *Gatherer) DetectSprocChanges(dbUnique string, vme DBVersionMapEntry, storageCh chan<- []metrics.MeasurementMessage, hostState map[string]map[string]string) ChangeDetectionResults {
 	detectedChanges := make(metrics.Measurements, 0)
 	var firstRun bool
 	var changeCounts ChangeDetectionResults
 
-	logger.Debugf("[%s][%s] checking for sproc changes...", dbUnique, specialMetricChangeEvents)
+	g.logger.Debugf("[%s][%s] checking for sproc changes...", dbUnique, specialMetricChangeEvents)
 	if _, ok := hostState["sproc_hashes"]; !ok {
 		firstRun = true
 		hostState["sproc_hashes"] = make(map[string]string)
 	}
 
-	mvp, err := GetMetricVersionProperties("sproc_hashes", vme, nil)
+	mvp, err := g.GetMetricVersionProperties("sproc_hashes", vme, nil)
 	if err != nil {
-		logger.Error("could not get sproc_hashes sql:", err)
+		g.logger.Error("could not get sproc_hashes sql:", err)
 		return changeCounts
 	}
 
-	data, err := DBExecReadByDbUniqueName(mainContext, dbUnique, mvp.SQL)
+	data, err := g.DBExecReadByDbUniqueName(g.mainContext, dbUnique, mvp.SQL)
 	if err != nil {
-		logger.Error("could not read sproc_hashes from monitored host: ", dbUnique, ", err:", err)
+		g.logger.Error("could not read sproc_hashes from monitored host: ", dbUnique, ", err:", err)
 		return changeCounts
 	}
 
@@ -400,7 +406,7 @@ func DetectSprocChanges(dbUnique string, vme DBVersionMapEntry, storageCh chan<-
 		prevHash, ok := hostState["sproc_hashes"][objIdent]
 		if ok { // we have existing state
 			if prevHash != dr["md5"].(string) {
-				logger.Info("detected change in sproc:", dr["tag_sproc"], ", oid:", dr["tag_oid"])
+				g.logger.Info("detected change in sproc:", dr["tag_sproc"], ", oid:", dr["tag_oid"])
 				dr["event"] = "alter"
 				detectedChanges = append(detectedChanges, dr)
 				hostState["sproc_hashes"][objIdent] = dr["md5"].(string)
@@ -408,7 +414,7 @@ func DetectSprocChanges(dbUnique string, vme DBVersionMapEntry, storageCh chan<-
 			}
 		} else { // check for new / delete
 			if !firstRun {
-				logger.Info("detected new sproc:", dr["tag_sproc"], ", oid:", dr["tag_oid"])
+				g.logger.Info("detected new sproc:", dr["tag_sproc"], ", oid:", dr["tag_oid"])
 				dr["event"] = "create"
 				detectedChanges = append(detectedChanges, dr)
 				changeCounts.Created++
@@ -428,7 +434,7 @@ func DetectSprocChanges(dbUnique string, vme DBVersionMapEntry, storageCh chan<-
 			_, ok := currentOidMap[sprocIdent]
 			if !ok {
 				splits := strings.Split(sprocIdent, dbMetricJoinStr)
-				logger.Info("detected delete of sproc:", splits[0], ", oid:", splits[1])
+				g.logger.Info("detected delete of sproc:", splits[0], ", oid:", splits[1])
 				influxEntry := make(metrics.Measurement)
 				influxEntry["event"] = "drop"
 				influxEntry["tag_sproc"] = splits[0]
@@ -447,35 +453,35 @@ func DetectSprocChanges(dbUnique string, vme DBVersionMapEntry, storageCh chan<-
 			delete(hostState["sproc_hashes"], deletedSProc)
 		}
 	}
-	logger.Debugf("[%s][%s] detected %d sproc changes", dbUnique, specialMetricChangeEvents, len(detectedChanges))
+	g.logger.Debugf("[%s][%s] detected %d sproc changes", dbUnique, specialMetricChangeEvents, len(detectedChanges))
 	if len(detectedChanges) > 0 {
-		md, _ := GetMonitoredDatabaseByUniqueName(dbUnique)
+		md, _ := g.GetMonitoredDatabaseByUniqueName(dbUnique)
 		storageCh <- []metrics.MeasurementMessage{{DBName: dbUnique, MetricName: "sproc_changes", Data: detectedChanges, CustomTags: md.CustomTags}}
 	}
 
 	return changeCounts
 }
 
-func DetectTableChanges(dbUnique string, vme DBVersionMapEntry, storageCh chan<- []metrics.MeasurementMessage, hostState map[string]map[string]string) ChangeDetectionResults {
+func (g *Gatherer) DetectTableChanges(dbUnique string, vme DBVersionMapEntry, storageCh chan<- []metrics.MeasurementMessage, hostState map[string]map[string]string) ChangeDetectionResults {
 	detectedChanges := make(metrics.Measurements, 0)
 	var firstRun bool
 	var changeCounts ChangeDetectionResults
 
-	logger.Debugf("[%s][%s] checking for table changes...", dbUnique, specialMetricChangeEvents)
+	g.logger.Debugf("[%s][%s] checking for table changes...", dbUnique, specialMetricChangeEvents)
 	if _, ok := hostState["table_hashes"]; !ok {
 		firstRun = true
 		hostState["table_hashes"] = make(map[string]string)
 	}
 
-	mvp, err := GetMetricVersionProperties("table_hashes", vme, nil)
+	mvp, err := g.GetMetricVersionProperties("table_hashes", vme, nil)
 	if err != nil {
-		logger.Error("could not get table_hashes sql:", err)
+		g.logger.Error("could not get table_hashes sql:", err)
 		return changeCounts
 	}
 
-	data, err := DBExecReadByDbUniqueName(mainContext, dbUnique, mvp.SQL)
+	data, err := g.DBExecReadByDbUniqueName(g.mainContext, dbUnique, mvp.SQL)
 	if err != nil {
-		logger.Error("could not read table_hashes from monitored host:", dbUnique, ", err:", err)
+		g.logger.Error("could not read table_hashes from monitored host:", dbUnique, ", err:", err)
 		return changeCounts
 	}
 
@@ -485,7 +491,7 @@ func DetectTableChanges(dbUnique string, vme DBVersionMapEntry, storageCh chan<-
 		//log.Debug("inspecting table:", objIdent, "hash:", prev_hash)
 		if ok { // we have existing state
 			if prevHash != dr["md5"].(string) {
-				logger.Info("detected DDL change in table:", dr["tag_table"])
+				g.logger.Info("detected DDL change in table:", dr["tag_table"])
 				dr["event"] = "alter"
 				detectedChanges = append(detectedChanges, dr)
 				hostState["table_hashes"][objIdent] = dr["md5"].(string)
@@ -493,7 +499,7 @@ func DetectTableChanges(dbUnique string, vme DBVersionMapEntry, storageCh chan<-
 			}
 		} else { // check for new / delete
 			if !firstRun {
-				logger.Info("detected new table:", dr["tag_table"])
+				g.logger.Info("detected new table:", dr["tag_table"])
 				dr["event"] = "create"
 				detectedChanges = append(detectedChanges, dr)
 				changeCounts.Created++
@@ -512,7 +518,7 @@ func DetectTableChanges(dbUnique string, vme DBVersionMapEntry, storageCh chan<-
 		for table := range hostState["table_hashes"] {
 			_, ok := currentTableMap[table]
 			if !ok {
-				logger.Info("detected drop of table:", table)
+				g.logger.Info("detected drop of table:", table)
 				influxEntry := make(metrics.Measurement)
 				influxEntry["event"] = "drop"
 				influxEntry["tag_table"] = table
@@ -531,35 +537,35 @@ func DetectTableChanges(dbUnique string, vme DBVersionMapEntry, storageCh chan<-
 		}
 	}
 
-	logger.Debugf("[%s][%s] detected %d table changes", dbUnique, specialMetricChangeEvents, len(detectedChanges))
+	g.logger.Debugf("[%s][%s] detected %d table changes", dbUnique, specialMetricChangeEvents, len(detectedChanges))
 	if len(detectedChanges) > 0 {
-		md, _ := GetMonitoredDatabaseByUniqueName(dbUnique)
+		md, _ := g.GetMonitoredDatabaseByUniqueName(dbUnique)
 		storageCh <- []metrics.MeasurementMessage{{DBName: dbUnique, MetricName: "table_changes", Data: detectedChanges, CustomTags: md.CustomTags}}
 	}
 
 	return changeCounts
 }
 
-func DetectIndexChanges(dbUnique string, vme DBVersionMapEntry, storageCh chan<- []metrics.MeasurementMessage, hostState map[string]map[string]string) ChangeDetectionResults {
+func (g *Gatherer) DetectIndexChanges(dbUnique string, vme DBVersionMapEntry, storageCh chan<- []metrics.MeasurementMessage, hostState map[string]map[string]string) ChangeDetectionResults {
 	detectedChanges := make(metrics.Measurements, 0)
 	var firstRun bool
 	var changeCounts ChangeDetectionResults
 
-	logger.Debugf("[%s][%s] checking for index changes...", dbUnique, specialMetricChangeEvents)
+	g.logger.Debugf("[%s][%s] checking for index changes...", dbUnique, specialMetricChangeEvents)
 	if _, ok := hostState["index_hashes"]; !ok {
 		firstRun = true
 		hostState["index_hashes"] = make(map[string]string)
 	}
 
-	mvp, err := GetMetricVersionProperties("index_hashes", vme, nil)
+	mvp, err := g.GetMetricVersionProperties("index_hashes", vme, nil)
 	if err != nil {
-		logger.Error("could not get index_hashes sql:", err)
+		g.logger.Error("could not get index_hashes sql:", err)
 		return changeCounts
 	}
 
-	data, err := DBExecReadByDbUniqueName(mainContext, dbUnique, mvp.SQL)
+	data, err := g.DBExecReadByDbUniqueName(g.mainContext, dbUnique, mvp.SQL)
 	if err != nil {
-		logger.Error("could not read index_hashes from monitored host:", dbUnique, ", err:", err)
+		g.logger.Error("could not read index_hashes from monitored host:", dbUnique, ", err:", err)
 		return changeCounts
 	}
 
@@ -568,7 +574,7 @@ func DetectIndexChanges(dbUnique string, vme DBVersionMapEntry, storageCh chan<-
 		prevHash, ok := hostState["index_hashes"][objIdent]
 		if ok { // we have existing state
 			if prevHash != (dr["md5"].(string) + dr["is_valid"].(string)) {
-				logger.Info("detected index change:", dr["tag_index"], ", table:", dr["table"])
+				g.logger.Info("detected index change:", dr["tag_index"], ", table:", dr["table"])
 				dr["event"] = "alter"
 				detectedChanges = append(detectedChanges, dr)
 				hostState["index_hashes"][objIdent] = dr["md5"].(string) + dr["is_valid"].(string)
@@ -576,7 +582,7 @@ func DetectIndexChanges(dbUnique string, vme DBVersionMapEntry, storageCh chan<-
 			}
 		} else { // check for new / delete
 			if !firstRun {
-				logger.Info("detected new index:", dr["tag_index"])
+				g.logger.Info("detected new index:", dr["tag_index"])
 				dr["event"] = "create"
 				detectedChanges = append(detectedChanges, dr)
 				changeCounts.Created++
@@ -595,7 +601,7 @@ func DetectIndexChanges(dbUnique string, vme DBVersionMapEntry, storageCh chan<-
 		for indexName := range hostState["index_hashes"] {
 			_, ok := currentIndexMap[indexName]
 			if !ok {
-				logger.Info("detected drop of index_name:", indexName)
+				g.logger.Info("detected drop of index_name:", indexName)
 				influxEntry := make(metrics.Measurement)
 				influxEntry["event"] = "drop"
 				influxEntry["tag_index"] = indexName
@@ -613,36 +619,36 @@ func DetectIndexChanges(dbUnique string, vme DBVersionMapEntry, storageCh chan<-
 			delete(hostState["index_hashes"], deletedIndex)
 		}
 	}
-	logger.Debugf("[%s][%s] detected %d index changes", dbUnique, specialMetricChangeEvents, len(detectedChanges))
+	g.logger.Debugf("[%s][%s] detected %d index changes", dbUnique, specialMetricChangeEvents, len(detectedChanges))
 	if len(detectedChanges) > 0 {
-		md, _ := GetMonitoredDatabaseByUniqueName(dbUnique)
+		md, _ := g.GetMonitoredDatabaseByUniqueName(dbUnique)
 		storageCh <- []metrics.MeasurementMessage{{DBName: dbUnique, MetricName: "index_changes", Data: detectedChanges, CustomTags: md.CustomTags}}
 	}
 
 	return changeCounts
 }
 
-func DetectPrivilegeChanges(dbUnique string, vme DBVersionMapEntry, storageCh chan<- []metrics.MeasurementMessage, hostState map[string]map[string]string) ChangeDetectionResults {
+func (g *Gatherer) DetectPrivilegeChanges(dbUnique string, vme DBVersionMapEntry, storageCh chan<- []metrics.MeasurementMessage, hostState map[string]map[string]string) ChangeDetectionResults {
 	detectedChanges := make(metrics.Measurements, 0)
 	var firstRun bool
 	var changeCounts ChangeDetectionResults
 
-	logger.Debugf("[%s][%s] checking object privilege changes...", dbUnique, specialMetricChangeEvents)
+	g.logger.Debugf("[%s][%s] checking object privilege changes...", dbUnique, specialMetricChangeEvents)
 	if _, ok := hostState["object_privileges"]; !ok {
 		firstRun = true
 		hostState["object_privileges"] = make(map[string]string)
 	}
 
-	mvp, err := GetMetricVersionProperties("privilege_changes", vme, nil)
+	mvp, err := g.GetMetricVersionProperties("privilege_changes", vme, nil)
 	if err != nil || mvp.SQL == "" {
-		logger.Warningf("[%s][%s] could not get SQL for 'privilege_changes'. cannot detect privilege changes", dbUnique, specialMetricChangeEvents)
+		g.logger.Warningf("[%s][%s] could not get SQL for 'privilege_changes'. cannot detect privilege changes", dbUnique, specialMetricChangeEvents)
 		return changeCounts
 	}
 
 	// returns rows of: object_type, tag_role, tag_object, privilege_type
-	data, err := DBExecReadByDbUniqueName(mainContext, dbUnique, mvp.SQL)
+	data, err := g.DBExecReadByDbUniqueName(g.mainContext, dbUnique, mvp.SQL)
 	if err != nil {
-		logger.Errorf("[%s][%s] failed to fetch object privileges info: %v", dbUnique, specialMetricChangeEvents, err)
+		g.logger.Errorf("[%s][%s] failed to fetch object privileges info: %v", dbUnique, specialMetricChangeEvents, err)
 		return changeCounts
 	}
 
@@ -654,7 +660,7 @@ func DetectPrivilegeChanges(dbUnique string, vme DBVersionMapEntry, storageCh ch
 		} else {
 			_, ok := hostState["object_privileges"][objIdent]
 			if !ok {
-				logger.Infof("[%s][%s] detected new object privileges: role=%s, object_type=%s, object=%s, privilege_type=%s",
+				g.logger.Infof("[%s][%s] detected new object privileges: role=%s, object_type=%s, object=%s, privilege_type=%s",
 					dbUnique, specialMetricChangeEvents, dr["tag_role"], dr["object_type"], dr["tag_object"], dr["privilege_type"])
 				dr["event"] = "GRANT"
 				detectedChanges = append(detectedChanges, dr)
@@ -669,7 +675,7 @@ func DetectPrivilegeChanges(dbUnique string, vme DBVersionMapEntry, storageCh ch
 		for objPrevRun := range hostState["object_privileges"] {
 			if _, ok := currentState[objPrevRun]; !ok {
 				splits := strings.Split(objPrevRun, "#:#")
-				logger.Infof("[%s][%s] detected removed object privileges: role=%s, object_type=%s, object=%s, privilege_type=%s",
+				g.logger.Infof("[%s][%s] detected removed object privileges: role=%s, object_type=%s, object=%s, privilege_type=%s",
 					dbUnique, specialMetricChangeEvents, splits[1], splits[0], splits[2], splits[3])
 				revokeEntry := make(metrics.Measurement)
 				if epochNs, ok := data[0]["epoch_ns"]; ok {
@@ -689,9 +695,9 @@ func DetectPrivilegeChanges(dbUnique string, vme DBVersionMapEntry, storageCh ch
 		}
 	}
 
-	logger.Debugf("[%s][%s] detected %d object privilege changes...", dbUnique, specialMetricChangeEvents, len(detectedChanges))
+	g.logger.Debugf("[%s][%s] detected %d object privilege changes...", dbUnique, specialMetricChangeEvents, len(detectedChanges))
 	if len(detectedChanges) > 0 {
-		md, _ := GetMonitoredDatabaseByUniqueName(dbUnique)
+		md, _ := g.GetMonitoredDatabaseByUniqueName(dbUnique)
 		storageCh <- []metrics.MeasurementMessage{
 			{
 				DBName:     dbUnique,
@@ -704,26 +710,26 @@ func DetectPrivilegeChanges(dbUnique string, vme DBVersionMapEntry, storageCh ch
 	return changeCounts
 }
 
-func DetectConfigurationChanges(dbUnique string, vme DBVersionMapEntry, storageCh chan<- []metrics.MeasurementMessage, hostState map[string]map[string]string) ChangeDetectionResults {
+func (g *Gatherer) DetectConfigurationChanges(dbUnique string, vme DBVersionMapEntry, storageCh chan<- []metrics.MeasurementMessage, hostState map[string]map[string]string) ChangeDetectionResults {
 	detectedChanges := make(metrics.Measurements, 0)
 	var firstRun bool
 	var changeCounts ChangeDetectionResults
 
-	logger.Debugf("[%s][%s] checking for configuration changes...", dbUnique, specialMetricChangeEvents)
+	g.logger.Debugf("[%s][%s] checking for configuration changes...", dbUnique, specialMetricChangeEvents)
 	if _, ok := hostState["configuration_hashes"]; !ok {
 		firstRun = true
 		hostState["configuration_hashes"] = make(map[string]string)
 	}
 
-	mvp, err := GetMetricVersionProperties("configuration_hashes", vme, nil)
+	mvp, err := g.GetMetricVersionProperties("configuration_hashes", vme, nil)
 	if err != nil {
-		logger.Errorf("[%s][%s] could not get configuration_hashes sql: %v", dbUnique, specialMetricChangeEvents, err)
+		g.logger.Errorf("[%s][%s] could not get configuration_hashes sql: %v", dbUnique, specialMetricChangeEvents, err)
 		return changeCounts
 	}
 
-	data, err := DBExecReadByDbUniqueName(mainContext, dbUnique, mvp.SQL)
+	data, err := g.DBExecReadByDbUniqueName(g.mainContext, dbUnique, mvp.SQL)
 	if err != nil {
-		logger.Errorf("[%s][%s] could not read configuration_hashes from monitored host: %v", dbUnique, specialMetricChangeEvents, err)
+		g.logger.Errorf("[%s][%s] could not read configuration_hashes from monitored host: %v", dbUnique, specialMetricChangeEvents, err)
 		return changeCounts
 	}
 
@@ -736,7 +742,7 @@ func DetectConfigurationChanges(dbUnique string, vme DBVersionMapEntry, storageC
 				if objIdent == "connection_ID" {
 					continue // ignore some weird Azure managed PG service setting
 				}
-				logger.Warningf("[%s][%s] detected settings change: %s = %s (prev: %s)",
+				g.logger.Warningf("[%s][%s] detected settings change: %s = %s (prev: %s)",
 					dbUnique, specialMetricChangeEvents, objIdent, objValue, prevРash)
 				dr["event"] = "alter"
 				detectedChanges = append(detectedChanges, dr)
@@ -745,7 +751,7 @@ func DetectConfigurationChanges(dbUnique string, vme DBVersionMapEntry, storageC
 			}
 		} else { // check for new, delete not relevant here (pg_upgrade)
 			if !firstRun {
-				logger.Warningf("[%s][%s] detected new setting: %s", dbUnique, specialMetricChangeEvents, objIdent)
+				g.logger.Warningf("[%s][%s] detected new setting: %s", dbUnique, specialMetricChangeEvents, objIdent)
 				dr["event"] = "create"
 				detectedChanges = append(detectedChanges, dr)
 				changeCounts.Created++
@@ -754,9 +760,9 @@ func DetectConfigurationChanges(dbUnique string, vme DBVersionMapEntry, storageC
 		}
 	}
 
-	logger.Debugf("[%s][%s] detected %d configuration changes", dbUnique, specialMetricChangeEvents, len(detectedChanges))
+	g.logger.Debugf("[%s][%s] detected %d configuration changes", dbUnique, specialMetricChangeEvents, len(detectedChanges))
 	if len(detectedChanges) > 0 {
-		md, _ := GetMonitoredDatabaseByUniqueName(dbUnique)
+		md, _ := g.GetMonitoredDatabaseByUniqueName(dbUnique)
 		storageCh <- []metrics.MeasurementMessage{{
 			DBName:     dbUnique,
 			MetricName: "configuration_changes",
@@ -768,12 +774,12 @@ func DetectConfigurationChanges(dbUnique string, vme DBVersionMapEntry, storageC
 	return changeCounts
 }
 
-func CheckForPGObjectChangesAndStore(dbUnique string, vme DBVersionMapEntry, storageCh chan<- []metrics.MeasurementMessage, hostState map[string]map[string]string) {
-	sprocСounts := DetectSprocChanges(dbUnique, vme, storageCh, hostState) // TODO some of Detect*() code could be unified...
-	tableСounts := DetectTableChanges(dbUnique, vme, storageCh, hostState)
-	indexСounts := DetectIndexChanges(dbUnique, vme, storageCh, hostState)
-	confСounts := DetectConfigurationChanges(dbUnique, vme, storageCh, hostState)
-	privСhangeCounts := DetectPrivilegeChanges(dbUnique, vme, storageCh, hostState)
+func (g *Gatherer) CheckForPGObjectChangesAndStore(dbUnique string, vme DBVersionMapEntry, storageCh chan<- []metrics.MeasurementMessage, hostState map[string]map[string]string) {
+	sprocСounts := g.DetectSprocChanges(dbUnique, vme, storageCh, hostState) // TODO some of Detect*() code could be unified...
+	tableСounts := g.DetectTableChanges(dbUnique, vme, storageCh, hostState)
+	indexСounts := g.DetectIndexChanges(dbUnique, vme, storageCh, hostState)
+	confСounts := g.DetectConfigurationChanges(dbUnique, vme, storageCh, hostState)
+	privСhangeCounts := g.DetectPrivilegeChanges(dbUnique, vme, storageCh, hostState)
 
 	// need to send info on all object changes as one message as Grafana applies "last wins" for annotations with similar timestamp
 	message := ""
@@ -795,13 +801,13 @@ func CheckForPGObjectChangesAndStore(dbUnique string, vme DBVersionMapEntry, sto
 
 	if message > "" {
 		message = "Detected changes for \"" + dbUnique + "\" [Created/Altered/Dropped]:" + message
-		logger.Info(message)
+		g.logger.Info(message)
 		detectedChangesSummary := make(metrics.Measurements, 0)
 		influxEntry := make(metrics.Measurement)
 		influxEntry["details"] = message
 		influxEntry["epoch_ns"] = time.Now().UnixNano()
 		detectedChangesSummary = append(detectedChangesSummary, influxEntry)
-		md, _ := GetMonitoredDatabaseByUniqueName(dbUnique)
+		md, _ := g.GetMonitoredDatabaseByUniqueName(dbUnique)
 		storageCh <- []metrics.MeasurementMessage{{DBName: dbUnique,
 			DBType:     md.DBType,
 			MetricName: "object_changes",
@@ -813,7 +819,7 @@ func CheckForPGObjectChangesAndStore(dbUnique string, vme DBVersionMapEntry, sto
 }
 
 // some extra work needed as pgpool SHOW commands don't specify the return data types for some reason
-func FetchMetricsPgpool(msg MetricFetchMessage, _ DBVersionMapEntry, mvp metrics.MetricProperties) (metrics.Measurements, error) {
+func (g *Gatherer) FetchMetricsPgpool(msg MetricFetchMessage, _ DBVersionMapEntry, mvp metrics.MetricProperties) (metrics.Measurements, error) {
 	var retData = make(metrics.Measurements, 0)
 	epochNs := time.Now().UnixNano()
 
@@ -821,9 +827,9 @@ func FetchMetricsPgpool(msg MetricFetchMessage, _ DBVersionMapEntry, mvp metrics
 
 	for _, sql := range sqlLines {
 		if strings.HasPrefix(sql, "SHOW POOL_NODES") {
-			data, err := DBExecReadByDbUniqueName(mainContext, msg.DBUniqueName, sql)
+			data, err := g.DBExecReadByDbUniqueName(g.mainContext, msg.DBUniqueName, sql)
 			if err != nil {
-				logger.Errorf("[%s][%s] Could not fetch PgPool statistics: %v", msg.DBUniqueName, msg.MetricName, err)
+				g.logger.Errorf("[%s][%s] Could not fetch PgPool statistics: %v", msg.DBUniqueName, msg.MetricName, err)
 				return data, err
 			}
 
@@ -871,13 +877,13 @@ func FetchMetricsPgpool(msg MetricFetchMessage, _ DBVersionMapEntry, mvp metrics
 			}
 		} else if strings.HasPrefix(sql, "SHOW POOL_PROCESSES") {
 			if len(retData) == 0 {
-				logger.Warningf("[%s][%s] SHOW POOL_NODES needs to be placed before SHOW POOL_PROCESSES. ignoring SHOW POOL_PROCESSES", msg.DBUniqueName, msg.MetricName)
+				g.logger.Warningf("[%s][%s] SHOW POOL_NODES needs to be placed before SHOW POOL_PROCESSES. ignoring SHOW POOL_PROCESSES", msg.DBUniqueName, msg.MetricName)
 				continue
 			}
 
-			data, err := DBExecReadByDbUniqueName(mainContext, msg.DBUniqueName, sql)
+			data, err := g.DBExecReadByDbUniqueName(g.mainContext, msg.DBUniqueName, sql)
 			if err != nil {
-				logger.Errorf("[%s][%s] Could not fetch PgPool statistics: %v", msg.DBUniqueName, msg.MetricName, err)
+				g.logger.Errorf("[%s][%s] Could not fetch PgPool statistics: %v", msg.DBUniqueName, msg.MetricName, err)
 				continue
 			}
 
@@ -888,7 +894,7 @@ func FetchMetricsPgpool(msg MetricFetchMessage, _ DBVersionMapEntry, mvp metrics
 				processesTotal++
 				v, ok := row["database"]
 				if !ok {
-					logger.Infof("[%s][%s] column 'database' not found from data returned by SHOW POOL_PROCESSES, check pool version / SQL definition", msg.DBUniqueName, msg.MetricName)
+					g.logger.Infof("[%s][%s] column 'database' not found from data returned by SHOW POOL_PROCESSES, check pool version / SQL definition", msg.DBUniqueName, msg.MetricName)
 					continue
 				}
 				if len(v.([]byte)) > 0 {
@@ -905,16 +911,16 @@ func FetchMetricsPgpool(msg MetricFetchMessage, _ DBVersionMapEntry, mvp metrics
 	return retData, nil
 }
 
-func DoesFunctionExists(dbUnique, functionName string) bool {
-	logger.Debug("Checking for function existence", dbUnique, functionName)
+func (g *Gatherer) DoesFunctionExists(dbUnique, functionName string) bool {
+	g.logger.Debug("Checking for function existence", dbUnique, functionName)
 	sql := fmt.Sprintf("select /* pgwatch3_generated */ 1 from pg_proc join pg_namespace n on pronamespace = n.oid where proname = '%s' and n.nspname = 'public'", functionName)
-	data, err := DBExecReadByDbUniqueName(mainContext, dbUnique, sql)
+	data, err := g.DBExecReadByDbUniqueName(g.mainContext, dbUnique, sql)
 	if err != nil {
-		logger.Error("Failed to check for function existence", dbUnique, functionName, err)
+		g.logger.Error("Failed to check for function existence", dbUnique, functionName, err)
 		return false
 	}
 	if len(data) > 0 {
-		logger.Debugf("Function %s exists on %s", functionName, dbUnique)
+		g.logger.Debugf("Function %s exists on %s", functionName, dbUnique)
 		return true
 	}
 	return false
@@ -923,14 +929,14 @@ func DoesFunctionExists(dbUnique, functionName string) bool {
 // Called once on daemon startup if some commonly wanted extension (most notably pg_stat_statements) is missing.
 // With newer Postgres version can even succeed if the user is not a real superuser due to some cloud-specific
 // whitelisting or "trusted extensions" (a feature from v13). Ignores errors.
-func TryCreateMissingExtensions(dbUnique string, extensionNames []string, existingExtensions map[string]uint) []string {
+func (g *Gatherer) TryCreateMissingExtensions(dbUnique string, extensionNames []string, existingExtensions map[string]uint) []string {
 	sqlAvailable := `select name::text from pg_available_extensions`
 	extsCreated := make([]string, 0)
 
 	// For security reasons don't allow to execute random strings but check that it's an existing extension
-	data, err := DBExecReadByDbUniqueName(mainContext, dbUnique, sqlAvailable)
+	data, err := g.DBExecReadByDbUniqueName(g.mainContext, dbUnique, sqlAvailable)
 	if err != nil {
-		logger.Infof("[%s] Failed to get a list of available extensions: %v", dbUnique, err)
+		g.logger.Infof("[%s] Failed to get a list of available extensions: %v", dbUnique, err)
 		return extsCreated
 	}
 
@@ -945,12 +951,12 @@ func TryCreateMissingExtensions(dbUnique string, extensionNames []string, existi
 		}
 		_, ok := availableExts[extToCreate]
 		if !ok {
-			logger.Errorf("[%s] Requested extension %s not available on instance, cannot try to create...", dbUnique, extToCreate)
+			g.logger.Errorf("[%s] Requested extension %s not available on instance, cannot try to create...", dbUnique, extToCreate)
 		} else {
 			sqlCreateExt := `create extension ` + extToCreate
-			_, err := DBExecReadByDbUniqueName(mainContext, dbUnique, sqlCreateExt)
+			_, err := g.DBExecReadByDbUniqueName(g.mainContext, dbUnique, sqlCreateExt)
 			if err != nil {
-				logger.Errorf("[%s] Failed to create extension %s (based on --try-create-listed-exts-if-missing input): %v", dbUnique, extToCreate, err)
+				g.logger.Errorf("[%s] Failed to create extension %s (based on --try-create-listed-exts-if-missing input): %v", dbUnique, extToCreate, err)
 			}
 			extsCreated = append(extsCreated, extToCreate)
 		}
@@ -960,72 +966,76 @@ func TryCreateMissingExtensions(dbUnique string, extensionNames []string, existi
 }
 
 // Called once on daemon startup to try to create "metric fething helper" functions automatically
-func TryCreateMetricsFetchingHelpers(dbUnique string) error {
-	dbPgVersion, err := DBGetPGVersion(mainContext, dbUnique, config.DbTypePg, false)
+func (g *Gatherer) TryCreateMetricsFetchingHelpers(dbUnique string) error {
+	dbPgVersion, err := g.DBGetPGVersion(g.mainContext, dbUnique, config.DbTypePg, false)
 	if err != nil {
-		logger.Errorf("Failed to fetch pg version for \"%s\": %s", dbUnique, err)
+		g.logger.Errorf("Failed to fetch pg version for \"%s\": %s", dbUnique, err)
 		return err
 	}
 
-	if fileBasedMetrics {
-		helpers, _, err := metrics.ReadMetricsFromFolder(mainContext, path.Join(opts.Metric.MetricsFolder, metrics.FileBasedMetricHelpersDir))
+	if g.fileBasedMetrics {
+		var helpers metrics.MetricVersionDefs
+		helpersFS, err := fs.Sub(g.metricsFS(), metrics.FileBasedMetricHelpersDir)
+		if err == nil {
+			helpers, _, err = metrics.ReadMetricsFromFS(g.mainContext, helpersFS)
+		}
 		if err != nil {
-			logger.Errorf("Failed to fetch helpers from \"%s\": %s", path.Join(opts.Metric.MetricsFolder, metrics.FileBasedMetricHelpersDir), err)
+			g.logger.Errorf("Failed to fetch helpers from \"%s\" of the metric layers: %s", metrics.FileBasedMetricHelpersDir, err)
 			return err
 		}
-		logger.Debug("%d helper definitions found from \"%s\"...", len(helpers), path.Join(opts.Metric.MetricsFolder, metrics.FileBasedMetricHelpersDir))
+		g.logger.Debugf("%d helper definitions found from \"%s\" of the metric layers...", len(helpers), metrics.FileBasedMetricHelpersDir)
 
 		for helperName := range helpers {
 			if strings.Contains(helperName, "windows") {
-				logger.Infof("Skipping %s rollout. Windows helpers need to be rolled out manually", helperName)
+				g.logger.Infof("Skipping %s rollout. Windows helpers need to be rolled out manually", helperName)
 				continue
 			}
-			if !DoesFunctionExists(dbUnique, helperName) {
+			if !g.DoesFunctionExists(dbUnique, helperName) {
 
-				logger.Debug("Trying to create metric fetching helpers for", dbUnique, helperName)
-				mvp, err := GetMetricVersionProperties(helperName, dbPgVersion, helpers)
+				g.logger.Debug("Trying to create metric fetching helpers for", dbUnique, helperName)
+				mvp, err := g.GetMetricVersionProperties(helperName, dbPgVersion, helpers)
 				if err != nil {
-					logger.Warning("Could not find query text for", dbUnique, helperName)
+					g.logger.Warning("Could not find query text for", dbUnique, helperName)
 					continue
 				}
-				_, err = DBExecReadByDbUniqueName(mainContext, dbUnique, mvp.SQL)
+				_, err = g.DBExecReadByDbUniqueName(g.mainContext, dbUnique, mvp.SQL)
 				if err != nil {
-					logger.Warning("Failed to create a metric fetching helper for", dbUnique, helperName)
-					logger.Warning(err)
+					g.logger.Warning("Failed to create a metric fetching helper for", dbUnique, helperName)
+					g.logger.Warning(err)
 				} else {
-					logger.Info("Successfully created metric fetching helper for", dbUnique, helperName)
+					g.logger.Info("Successfully created metric fetching helper for", dbUnique, helperName)
 				}
 			}
 		}
 
 	} else {
 		sqlHelpers := "select /* pgwatch3_generated */ distinct m_name from pgwatch3.metric where m_is_active and m_is_helper" // m_name is a helper function name
-		data, err := DBExecRead(mainContext, configDb, sqlHelpers)
+		data, err := DBExecRead(g.mainContext, g.configDb, sqlHelpers)
 		if err != nil {
-			logger.Error(err)
+			g.logger.Error(err)
 			return err
 		}
 		for _, row := range data {
 			metric := row["m_name"].(string)
 
 			if strings.Contains(metric, "windows") {
-				logger.Infof("Skipping %s rollout. Windows helpers need to be rolled out manually", metric)
+				g.logger.Infof("Skipping %s rollout. Windows helpers need to be rolled out manually", metric)
 				continue
 			}
-			if !DoesFunctionExists(dbUnique, metric) {
+			if !g.DoesFunctionExists(dbUnique, metric) {
 
-				logger.Debug("Trying to create metric fetching helpers for", dbUnique, metric)
-				mvp, err := GetMetricVersionProperties(metric, dbPgVersion, nil)
+				g.logger.Debug("Trying to create metric fetching helpers for", dbUnique, metric)
+				mvp, err := g.GetMetricVersionProperties(metric, dbPgVersion, nil)
 				if err != nil {
-					logger.Warning("Could not find query text for", dbUnique, metric)
+					g.logger.Warning("Could not find query text for", dbUnique, metric)
 					continue
 				}
-				_, err = DBExecReadByDbUniqueName(mainContext, dbUnique, mvp.SQL)
+				_, err = g.DBExecReadByDbUniqueName(g.mainContext, dbUnique, mvp.SQL)
 				if err != nil {
-					logger.Warning("Failed to create a metric fetching helper for", dbUnique, metric)
-					logger.Warning(err)
+					g.logger.Warning("Failed to create a metric fetching helper for", dbUnique, metric)
+					g.logger.Warning(err)
 				} else {
-					logger.Warning("Successfully created metric fetching helper for", dbUnique, metric)
+					g.logger.Warning("Successfully created metric fetching helper for", dbUnique, metric)
 				}
 			}
 		}
@@ -1034,12 +1044,12 @@ func TryCreateMetricsFetchingHelpers(dbUnique string) error {
 }
 
 // "resolving" reads all the DB names from the given host/port, additionally matching/not matching specified regex patterns
-func ResolveDatabasesFromConfigEntry(ce MonitoredDatabase) ([]MonitoredDatabase, error) {
+func (g *Gatherer) ResolveDatabasesFromConfigEntry(ce MonitoredDatabase) ([]MonitoredDatabase, error) {
 	var c db.PgxPoolIface
 	var err error
 	md := make([]MonitoredDatabase, 0)
 
-	c, err = db.GetPostgresDBConnection(mainContext, ce.ConnStr)
+	c, err = db.GetPostgresDBConnection(g.mainContext, ce.ConnStr)
 	if err != nil {
 		return md, err
 	}
@@ -1054,7 +1064,7 @@ func ResolveDatabasesFromConfigEntry(ce MonitoredDatabase) ([]MonitoredDatabase,
 		and case when length(trim($1)) > 0 then datname ~ $2 else true end
 		and case when length(trim($3)) > 0 then not datname ~ $4 else true end`
 
-	data, err := DBExecRead(mainContext, c, sql, ce.DBNameIncludePattern, ce.DBNameIncludePattern, ce.DBNameExcludePattern, ce.DBNameExcludePattern)
+	data, err := DBExecRead(g.mainContext, c, sql, ce.DBNameIncludePattern, ce.DBNameIncludePattern, ce.DBNameExcludePattern, ce.DBNameExcludePattern)
 	if err != nil {
 		return md, err
 	}
@@ -1067,6 +1077,9 @@ func ResolveDatabasesFromConfigEntry(ce MonitoredDatabase) ([]MonitoredDatabase,
 			Encryption:           ce.Encryption,
 			Metrics:              ce.Metrics,
 			MetricsStandby:       ce.MetricsStandby,
+			Schedules:            ce.Schedules,
+			SchedulesStandby:     ce.SchedulesStandby,
+			Timezone:             ce.Timezone,
 			PresetMetrics:        ce.PresetMetrics,
 			PresetMetricsStandby: ce.PresetMetricsStandby,
 			IsSuperuser:          ce.IsSuperuser,
@@ -1080,17 +1093,17 @@ func ResolveDatabasesFromConfigEntry(ce MonitoredDatabase) ([]MonitoredDatabase,
 }
 
 // connects actually to the instance to determine PG relevant disk paths / mounts
-func GetGoPsutilDiskPG(dbUnique string) (metrics.Measurements, error) {
+func (g *Gatherer) GetGoPsutilDiskPG(dbUnique string) (metrics.Measurements, error) {
 	sql := `select current_setting('data_directory') as dd, current_setting('log_directory') as ld, current_setting('server_version_num')::int as pgver`
 	sqlTS := `select spcname::text as name, pg_catalog.pg_tablespace_location(oid) as location from pg_catalog.pg_tablespace where not spcname like any(array[E'pg\\_%'])`
-	data, err := DBExecReadByDbUniqueName(mainContext, dbUnique, sql)
+	data, err := g.DBExecReadByDbUniqueName(g.mainContext, dbUnique, sql)
 	if err != nil || len(data) == 0 {
-		logger.Errorf("Failed to determine relevant PG disk paths via SQL: %v", err)
+		g.logger.Errorf("Failed to determine relevant PG disk paths via SQL: %v", err)
 		return nil, err
 	}
-	dataTblsp, err := DBExecReadByDbUniqueName(mainContext, dbUnique, sqlTS)
+	dataTblsp, err := g.DBExecReadByDbUniqueName(g.mainContext, dbUnique, sqlTS)
 	if err != nil {
-		logger.Infof("Failed to determine relevant PG tablespace paths via SQL: %v", err)
+		g.logger.Infof("Failed to determine relevant PG tablespace paths via SQL: %v", err)
 	}
 	return psutil.GetGoPsutilDiskPG(data, dataTblsp)
 }
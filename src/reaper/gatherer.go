@@ -0,0 +1,169 @@
+package reaper
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cybertec-postgresql/pgwatch3/config"
+	"github.com/cybertec-postgresql/pgwatch3/db"
+	"github.com/cybertec-postgresql/pgwatch3/log"
+	"github.com/cybertec-postgresql/pgwatch3/metrics"
+	"github.com/cybertec-postgresql/pgwatch3/sinks"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Gatherer fetches the configured metrics from the monitored databases and hands them over to the sinks. All state
+// is kept per gatherer, so that several independent ones can run in the same process, e.g. when embedding pgwatch3
+type Gatherer struct {
+	opts        *config.Options
+	logger      log.LoggerIface
+	configDb    db.PgxPoolIface // nil in file based mode
+	extraSinks  []sinks.Writer
+	dbSources   MonitoredDatabaseSources
+	registerer  prometheus.Registerer
+	mainContext context.Context
+
+	// monitored DBs and their connections
+	monitoredDbCache          map[string]MonitoredDatabase
+	monitoredDbCacheLock      sync.RWMutex
+	monitoredDbConnCache      map[string]db.PgxPoolIface
+	monitoredDbConnCacheLock  sync.RWMutex
+	prevLoopMonitoredDBs      []MonitoredDatabase // to be able to detect DBs removed from config
+	lastMonitoredDBsUpdate    time.Time
+	failedInitialConnectHosts map[string]bool                   // hosts that couldn't be connected to even once
+	lastFoundClusterMembers   map[string][]PatroniClusterMember // needed for cases where DCS is temporarily down, not to remove DBs right away
+	dbPgVersionMap            map[string]DBVersionMapEntry
+	dbPgVersionMapLock        sync.RWMutex
+	dbGetPgVersionMapLock     map[string]*sync.RWMutex // synchronize initial PG version detection to 1 instance for each defined host
+	unreachableDB             map[string]time.Time
+	unreachableDBsLock        sync.RWMutex
+	undersizedDBs             map[string]bool // DBs below the --min-db-size-mb limit, if set
+	undersizedDBsLock         sync.RWMutex
+	recoveryIgnoredDBs        map[string]bool // DBs in recovery state and OnlyIfMaster specified in config
+	recoveryIgnoredDBsLock    sync.RWMutex
+	lastDBSizeMB              map[string]int64
+	lastDBSizeFetchTime       map[string]time.Time // cached for DB_SIZE_CACHING_INTERVAL
+	lastDBSizeCheckLock       sync.RWMutex
+	circuitBreakers           sync.Map // [dbUnique]*CircuitBreaker
+
+	// metric definitions and schedules
+	fileBasedMetrics        bool
+	metricDefLayers         metrics.MetricDefinitionLayers
+	metricDefinitionMap     metrics.MetricVersionDefs
+	metricDefOrigins        map[string]metrics.MetricOrigin // which layer each metric definition came from
+	metricDefMapLock        sync.RWMutex
+	presetMetricDefMap      map[string]map[string]float64 // read from metrics folder in "file mode"
+	presetMetricScheduleMap map[string]map[string]string
+	hostMetricIntervalMap   map[string]float64 // [db1_metric] = 30
+	hostMetricScheduleMap   map[string]string  // [db1_metric] = "0 3 * * *"
+	effectiveIntervals      map[string]float64 // stretched intervals of backed off metrics, [db1¤¤¤metric] = 120
+	effectiveIntervalsLock  sync.RWMutex
+	scheduler               *Scheduler
+	lastSQLFetchError       sync.Map
+
+	// fetched data
+	instanceMetricCache              map[string](metrics.Measurements) // [dbUnique+metric]lastly_fetched_data
+	instanceMetricCacheLock          sync.RWMutex
+	instanceMetricCacheTimestamp     map[string]time.Time // [dbUnique+metric]last_fetch_time
+	instanceMetricCacheTimestampLock sync.RWMutex
+	recentMeasurements               map[string][]RecentMeasurement // dbUnique + dbMetricJoinStr + metric, oldest first
+	recentMeasurementsWanted         map[string]bool                // only metrics needed by Go reco checks are kept
+	recentMeasurementsLock           sync.RWMutex
+	recoChecks                       []RecoCheck
+	recoChecksLock                   sync.RWMutex
+	metricsReader                    atomic.Pointer[sinks.MultiWriter] // set as soon as the sinks are initialized
+
+	// self-monitoring
+	gathererStartTime                  time.Time
+	totalMetricFetchFailuresCounter    uint64
+	totalMetricsFetchedCounter         uint64
+	totalMetricsReusedFromCacheCounter uint64
+	totalDatasetsFetchedCounter        uint64
+	metricPointsPerMinuteLast5MinAvg   int64                    // -1 means the summarization ticker has not yet run
+	metricHealth                       map[string]*MetricHealth // [db1¤¤¤metric]
+	metricHealthLock                   sync.RWMutex
+	fetchDuration                      *prometheus.HistogramVec
+	fetchErrors                        *prometheus.CounterVec
+
+	// graceful shutdown
+	runningGatherers sync.WaitGroup
+	fetchContext     context.Context // outlives mainContext on shutdown, letting in-flight fetches finish until the deadline
+	abortFetches     context.CancelFunc
+}
+
+// NewGatherer creates a gatherer for the given options, logging to the logger of the context. The config DB
+// connection is needed, if monitored DBs and metrics are configured in a database, and nil otherwise
+func NewGatherer(ctx context.Context, opts *config.Options, configDb db.PgxPoolIface) (*Gatherer, error) {
+	configKind, err := opts.GetConfigKind()
+	if err != nil {
+		return nil, err
+	}
+	if configKind == config.ConfigPgURL && configDb == nil {
+		return nil, errors.New("no configuration database connection given")
+	}
+	g := &Gatherer{
+		opts:                             opts,
+		logger:                           log.GetLogger(ctx),
+		configDb:                         configDb,
+		registerer:                       prometheus.DefaultRegisterer,
+		mainContext:                      ctx,
+		fileBasedMetrics:                 configKind != config.ConfigPgURL,
+		monitoredDbConnCache:             make(map[string]db.PgxPoolIface),
+		failedInitialConnectHosts:        make(map[string]bool),
+		lastFoundClusterMembers:          make(map[string][]PatroniClusterMember),
+		dbPgVersionMap:                   make(map[string]DBVersionMapEntry),
+		dbGetPgVersionMapLock:            make(map[string]*sync.RWMutex),
+		unreachableDB:                    make(map[string]time.Time),
+		undersizedDBs:                    make(map[string]bool),
+		recoveryIgnoredDBs:               make(map[string]bool),
+		lastDBSizeMB:                     make(map[string]int64),
+		lastDBSizeFetchTime:              make(map[string]time.Time),
+		hostMetricIntervalMap:            make(map[string]float64),
+		hostMetricScheduleMap:            make(map[string]string),
+		effectiveIntervals:               make(map[string]float64),
+		scheduler:                        NewScheduler(opts.MaxParallelFetches, opts.MaxParallelInstanceFetches),
+		instanceMetricCache:              make(map[string](metrics.Measurements)),
+		instanceMetricCacheTimestamp:     make(map[string]time.Time),
+		recentMeasurements:               make(map[string][]RecentMeasurement),
+		recentMeasurementsWanted:         make(map[string]bool),
+		gathererStartTime:                time.Now(),
+		metricPointsPerMinuteLast5MinAvg: -1,
+		metricHealth:                     make(map[string]*MetricHealth),
+	}
+	if g.dbSources, err = g.newMonitoredDatabaseSources(configKind); err != nil {
+		return nil, err
+	}
+	if g.metricDefLayers, err = g.newMetricDefinitionLayers(configKind); err != nil {
+		return nil, err
+	}
+	g.fetchDuration, g.fetchErrors = newFetchMetrics()
+	return g, nil
+}
+
+// AddSink adds a writer receiving all measurements besides the sinks configured in the options. Must be called
+// before Run()
+func (g *Gatherer) AddSink(w sinks.Writer) {
+	g.extraSinks = append(g.extraSinks, w)
+}
+
+// SetRegisterer changes where the self-monitoring metrics are registered, by default the global Prometheus registry.
+// Needed for running several gatherers in one process. Must be called before Run()
+func (g *Gatherer) SetRegisterer(r prometheus.Registerer) {
+	g.registerer = r
+}
+
+// MetricsReader returns the sinks of a running gatherer for querying stored measurements, nil before Run()
+func (g *Gatherer) MetricsReader() *sinks.MultiWriter {
+	return g.metricsReader.Load()
+}
+
+// GetWriteStats returns the storage statistics of the gatherer's sinks, zero before Run() created them
+func (g *Gatherer) GetWriteStats() sinks.WriteStats {
+	if mw := g.metricsReader.Load(); mw != nil {
+		return mw.GetWriteStats()
+	}
+	return sinks.WriteStats{}
+}
@@ -0,0 +1,132 @@
+package reaper
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/cybertec-postgresql/pgwatch3/db"
+	"github.com/cybertec-postgresql/pgwatch3/log"
+	"github.com/jackc/pgx/v5"
+)
+
+// Lease is an expiring lock row in the pgwatch3.gatherer_lease config DB table. The holder renews it every third
+// of the TTL and steps down after two thirds without a successful renewal. A peer takes it over once it has expired,
+// e.g. as the holder died or lost the config DB connection
+type Lease struct {
+	conn    db.PgxIface
+	name    string
+	holder  string
+	ttl     time.Duration
+	held    atomic.Bool
+	changed chan struct{} // signalled when the lease is acquired or lost
+}
+
+// NewLease creates a lease that is not held yet, Run() needs to be started to acquire it
+func NewLease(conn db.PgxIface, name, holder string, ttl time.Duration) *Lease {
+	if holder == "" {
+		hostname, _ := os.Hostname()
+		holder = fmt.Sprintf("%s:%d", hostname, os.Getpid())
+	}
+	return &Lease{conn: conn, name: name, holder: holder, ttl: ttl, changed: make(chan struct{}, 1)}
+}
+
+// IsHeld returns true if this gatherer is the current lease holder
+func (l *Lease) IsHeld() bool {
+	if l == nil {
+		return true
+	}
+	return l.held.Load()
+}
+
+// Changed returns a channel signalled on lease holder changes. Never signalled for a nil lease
+func (l *Lease) Changed() <-chan struct{} {
+	if l == nil {
+		return nil
+	}
+	return l.changed
+}
+
+// tryAcquire inserts or renews the lease if it's free, expired or already held by us
+func (l *Lease) tryAcquire(ctx context.Context) (bool, error) {
+	sql := `INSERT INTO pgwatch3.gatherer_lease (gl_name, gl_holder, gl_expires_on)
+	VALUES ($1, $2, now() + $3 * interval '1 millisecond')
+	ON CONFLICT (gl_name) DO UPDATE SET gl_holder = excluded.gl_holder, gl_expires_on = excluded.gl_expires_on
+	WHERE gatherer_lease.gl_holder = excluded.gl_holder OR gatherer_lease.gl_expires_on < now()
+	RETURNING gl_holder`
+	var holder string
+	err := l.conn.QueryRow(ctx, sql, l.name, l.holder, l.ttl.Milliseconds()).Scan(&holder)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (l *Lease) setHeld(ctx context.Context, held bool) {
+	if l.held.Swap(held) == held {
+		return
+	}
+	if held {
+		log.GetLogger(ctx).Infof("Acquired the '%s' lease as %s, becoming active", l.name, l.holder)
+	} else {
+		log.GetLogger(ctx).Warningf("Lost the '%s' lease, becoming passive", l.name)
+	}
+	select {
+	case l.changed <- struct{}{}:
+	default:
+	}
+}
+
+// Run keeps acquiring or renewing the lease until the context is cancelled, then releases it for a fast takeover
+func (l *Lease) Run(ctx context.Context) {
+	logger := log.GetLogger(ctx)
+	var renewSent time.Time // of the last successful renewal, the lease expires ttl after that at the earliest
+	for {
+		attemptCtx, cancel := context.WithTimeout(ctx, l.ttl/3)
+		if l.held.Load() { // a hanging renewal must not delay stepping down
+			attemptCtx, cancel = context.WithDeadline(attemptCtx, l.stepDownAt(renewSent))
+		}
+		sent := time.Now()
+		held, err := l.tryAcquire(attemptCtx)
+		cancel()
+		switch {
+		case err != nil:
+			logger.Error("Failed to renew the HA lease: ", err)
+		case held:
+			renewSent = sent
+			l.setHeld(ctx, true)
+		default:
+			l.setHeld(ctx, false)
+		}
+		wait := l.ttl / 3
+		if l.held.Load() {
+			if untilStepDown := time.Until(l.stepDownAt(renewSent)); untilStepDown <= 0 {
+				l.setHeld(ctx, false) // a peer can take over soon, stop before that
+			} else {
+				wait = min(wait, untilStepDown)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			if l.held.Load() {
+				l.release()
+			}
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// stepDownAt returns when to give up the lease if not renewed since, leaving a third of the TTL for fetches in
+// flight to finish before a peer can take over
+func (l *Lease) stepDownAt(renewSent time.Time) time.Time {
+	return renewSent.Add(l.ttl * 2 / 3)
+}
+
+// release deletes the lease if held by us, so that peers don't need to wait for it to expire
+func (l *Lease) release() {
+	_, _ = l.conn.Exec(context.Background(), `DELETE FROM pgwatch3.gatherer_lease WHERE gl_name = $1 AND gl_holder = $2`, l.name, l.holder)
+}
@@ -0,0 +1,79 @@
+package reaper
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/pashagolub/pgxmock/v3"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLease(t *testing.T) {
+	var noHA *Lease
+	assert.True(t, noHA.IsHeld(), "without HA the gatherer is always active")
+	assert.Nil(t, noHA.Changed())
+
+	conn, err := pgxmock.NewPool()
+	assert.NoError(t, err)
+	l := NewLease(conn, "leader:", "gatherer1", time.Hour)
+
+	conn.ExpectQuery("INSERT INTO pgwatch3.gatherer_lease").
+		WithArgs("leader:", "gatherer1", time.Hour.Milliseconds()).
+		WillReturnRows(pgxmock.NewRows([]string{"gl_holder"})) // held by a peer
+	held, err := l.tryAcquire(context.Background())
+	assert.NoError(t, err)
+	assert.False(t, held)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	conn.ExpectQuery("INSERT INTO pgwatch3.gatherer_lease").
+		WithArgs("leader:", "gatherer1", time.Hour.Milliseconds()).
+		WillReturnRows(pgxmock.NewRows([]string{"gl_holder"}).AddRow("gatherer1"))
+	conn.ExpectExec("DELETE FROM pgwatch3.gatherer_lease").
+		WithArgs("leader:", "gatherer1").
+		WillReturnResult(pgxmock.NewResult("DELETE", 1))
+	done := make(chan struct{})
+	go func() {
+		l.Run(ctx)
+		close(done)
+	}()
+	<-l.Changed()
+	assert.True(t, l.IsHeld())
+	cancel()
+	<-done
+	assert.NoError(t, conn.ExpectationsWereMet())
+}
+
+func TestLeaseStepDown(t *testing.T) {
+	conn, err := pgxmock.NewPool()
+	assert.NoError(t, err)
+	ttl := 600 * time.Millisecond
+	l := NewLease(conn, "leader:", "gatherer1", ttl)
+
+	conn.ExpectQuery("INSERT INTO pgwatch3.gatherer_lease").
+		WithArgs("leader:", "gatherer1", ttl.Milliseconds()).
+		WillReturnRows(pgxmock.NewRows([]string{"gl_holder"}).AddRow("gatherer1"))
+	for i := 0; i < 5; i++ {
+		conn.ExpectQuery("INSERT INTO pgwatch3.gatherer_lease").
+			WithArgs("leader:", "gatherer1", ttl.Milliseconds()).
+			WillReturnError(errors.New("connection lost"))
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		l.Run(ctx)
+		close(done)
+	}()
+	<-l.Changed()
+	acquired := time.Now()
+	assert.True(t, l.IsHeld())
+
+	<-l.Changed()
+	lost := time.Since(acquired)
+	assert.False(t, l.IsHeld())
+	assert.Greater(t, lost, ttl/3, "a single failed renewal should not step down yet")
+	assert.Less(t, lost, ttl*5/6, "should step down well before a peer can take over")
+	cancel()
+	<-done
+}
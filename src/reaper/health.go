@@ -0,0 +1,115 @@
+package reaper
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/cybertec-postgresql/pgwatch3/metrics"
+)
+
+// MetricHealth is the outcome of the last fetches of a DB / metric
+type MetricHealth struct {
+	LastSuccess        *time.Time `json:"lastSuccess"`
+	LastError          string     `json:"lastError,omitempty"`
+	LastErrorTime      *time.Time `json:"lastErrorTime,omitempty"`
+	LastDurationMillis int64      `json:"lastDurationMillis"`
+	Rows               int        `json:"rows"`
+	Interval           float64    `json:"interval"`
+	EffectiveInterval  float64    `json:"effectiveInterval"` // differs from the interval when backing off
+}
+
+// DBHealth is the state of a monitored DB as seen by the gatherer
+type DBHealth struct {
+	DBUniqueName     string                   `json:"dbUniqueName"`
+	Group            string                   `json:"group"`
+	DBType           string                   `json:"dbType"`
+	Reachable        bool                     `json:"reachable"`
+	UnreachableSince *time.Time               `json:"unreachableSince,omitempty"`
+	CircuitOpen      bool                     `json:"circuitOpen"`
+	Version          string                   `json:"version"`
+	VersionCheckedOn *time.Time               `json:"versionCheckedOn,omitempty"`
+	IsInRecovery     bool                     `json:"isInRecovery"`
+	Dormant          bool                     `json:"dormant"`
+	Undersized       bool                     `json:"undersized"`
+	RecoveryIgnored  bool                     `json:"recoveryIgnored"`
+	Metrics          map[string]*MetricHealth `json:"metrics"`
+}
+
+// RecordMetricFetch updates the health of a DB / metric after a fetch
+func (g *Gatherer) RecordMetricFetch(dbMetric string, interval float64, backoffFactor int, duration time.Duration, msgs []metrics.MeasurementMessage, err error) {
+	g.observeFetch(dbMetric, duration.Seconds(), err)
+	now := time.Now()
+	g.metricHealthLock.Lock()
+	defer g.metricHealthLock.Unlock()
+	mh, ok := g.metricHealth[dbMetric]
+	if !ok {
+		mh = &MetricHealth{}
+		g.metricHealth[dbMetric] = mh
+	}
+	mh.Interval, mh.EffectiveInterval = interval, interval*float64(backoffFactor)
+	mh.LastDurationMillis = duration.Milliseconds()
+	if err != nil {
+		mh.LastError, mh.LastErrorTime = err.Error(), &now
+		return
+	}
+	mh.LastSuccess = &now
+	mh.Rows = 0
+	for _, msg := range msgs {
+		mh.Rows += len(msg.Data)
+	}
+}
+
+// ClearMetricHealth forgets a DB / metric no longer gathered
+func (g *Gatherer) ClearMetricHealth(dbMetric string) {
+	g.metricHealthLock.Lock()
+	delete(g.metricHealth, dbMetric)
+	g.metricHealthLock.Unlock()
+}
+
+// GetHealth returns the state of all monitored DBs, or of a single one if dbUnique is set, ordered by name
+func (g *Gatherer) GetHealth(dbUnique string) []DBHealth {
+	ret := make([]DBHealth, 0)
+	for name, md := range g.getMonitoredDatabasesSnapshot() {
+		if dbUnique != "" && name != dbUnique {
+			continue
+		}
+		dbh := DBHealth{
+			DBUniqueName:    name,
+			Group:           md.Group,
+			DBType:          md.DBType,
+			Reachable:       true,
+			Dormant:         g.IsDBDormant(name),
+			Undersized:      g.IsDBUndersized(name),
+			RecoveryIgnored: g.IsDBIgnoredBasedOnRecoveryState(name),
+			Metrics:         make(map[string]*MetricHealth),
+		}
+		g.unreachableDBsLock.RLock()
+		if since, ok := g.unreachableDB[name]; ok {
+			dbh.Reachable, dbh.UnreachableSince = false, &since
+		}
+		g.unreachableDBsLock.RUnlock()
+		if cb, ok := g.circuitBreakers.Load(name); ok {
+			dbh.CircuitOpen = cb.(*CircuitBreaker).IsOpen()
+		}
+		g.dbPgVersionMapLock.RLock()
+		if ver, ok := g.dbPgVersionMap[name]; ok {
+			dbh.Version, dbh.IsInRecovery, dbh.VersionCheckedOn = ver.VersionStr, ver.IsInRecovery, &ver.LastCheckedOn
+		}
+		g.dbPgVersionMapLock.RUnlock()
+		ret = append(ret, dbh)
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].DBUniqueName < ret[j].DBUniqueName })
+
+	g.metricHealthLock.RLock()
+	defer g.metricHealthLock.RUnlock()
+	for i := range ret {
+		for dbMetric, mh := range g.metricHealth {
+			if db, metric, _ := strings.Cut(dbMetric, dbMetricJoinStr); db == ret[i].DBUniqueName {
+				mhCopy := *mh
+				ret[i].Metrics[metric] = &mhCopy
+			}
+		}
+	}
+	return ret
+}
@@ -0,0 +1,48 @@
+package reaper
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/cybertec-postgresql/pgwatch3/metrics"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetHealth(t *testing.T) {
+	g := newTestGatherer(t, nil)
+	g.UpdateMonitoredDBCache([]MonitoredDatabase{
+		{DBUniqueName: "db2", DBType: "postgres"},
+		{DBUniqueName: "db1", DBType: "postgres", Group: "prod"},
+	})
+	g.SetDBUnreachableState("db2")
+	g.SetRecoveryIgnoredDBState("db1", true)
+
+	msgs := []metrics.MeasurementMessage{{Data: metrics.Measurements{{}, {}}}}
+	g.RecordMetricFetch("db1"+dbMetricJoinStr+"db_stats", 60, 2, time.Second, msgs, nil)
+	g.RecordMetricFetch("db1"+dbMetricJoinStr+"db_stats", 60, 4, time.Second, nil, errors.New("timeout"))
+
+	health := g.GetHealth("")
+	assert.Len(t, health, 2)
+	assert.Equal(t, "db1", health[0].DBUniqueName, "sorted by name")
+	assert.True(t, health[0].Reachable)
+	assert.True(t, health[0].RecoveryIgnored)
+	assert.True(t, health[0].Dormant)
+	assert.False(t, health[0].Undersized)
+	mh := health[0].Metrics["db_stats"]
+	assert.NotNil(t, mh.LastSuccess)
+	assert.Equal(t, "timeout", mh.LastError)
+	assert.Equal(t, 2, mh.Rows, "rows of the last successful fetch")
+	assert.EqualValues(t, 240, mh.EffectiveInterval)
+	assert.False(t, health[1].Reachable)
+	assert.NotNil(t, health[1].UnreachableSince)
+
+	g.RecordMetricFetch("db1"+dbMetricJoinStr+"db_stats", 60, 1, time.Second, msgs, nil)
+	mh = g.GetHealth("db1")[0].Metrics["db_stats"]
+	assert.Equal(t, "timeout", mh.LastError, "the last error should be kept to show flapping metrics")
+	assert.True(t, mh.LastSuccess.After(*mh.LastErrorTime))
+
+	health = g.GetHealth("db2")
+	assert.Len(t, health, 1)
+	assert.Empty(t, health[0].Metrics)
+}
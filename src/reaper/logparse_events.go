@@ -0,0 +1,340 @@
+package reaper
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cybertec-postgresql/pgwatch3/metrics"
+)
+
+// logEventPhrases holds the message beginnings identifying autovacuum and checkpoint log entries
+// (logged when log_autovacuum_min_duration / log_checkpoints are enabled)
+type logEventPhrases struct {
+	AutoVacuum   string
+	AutoAnalyze  string
+	Checkpoint   string
+	Restartpoint string
+}
+
+// PgLogEventPhrasesLocale is keyed the same as PgSeveritiesLocale. Translations are taken from the PostgreSQL
+// message catalogs and matched case-insensitively. Numeric details are extracted positionally, not via labels,
+// for non-english messages
+var PgLogEventPhrasesLocale = map[string]logEventPhrases{
+	"en": {"automatic vacuum of table", "automatic analyze of table", "checkpoint complete", "restartpoint complete"},
+	"C.": {"automatic vacuum of table", "automatic analyze of table", "checkpoint complete", "restartpoint complete"},
+	"de": {"automatisches Vacuum der Tabelle", "automatisches Analysieren der Tabelle", "Checkpoint komplett", "Restart-Punkt komplett"},
+	"fr": {"VACUUM automatique de la table", "ANALYZE automatique de la table", "checkpoint terminé", "restartpoint terminé"},
+	"it": {"vacuum automatico della tabella", "analisi automatica della tabella", "checkpoint completato", "punto di riavvio completato"},
+	"ko": {"테이블 자동 청소", "테이블 자동 분석", "체크포인트 작업 완료", "재시작 지점 완료"},
+	"pl": {"automatyczne odkurzanie tabeli", "automatyczna analiza tabeli", "punkt kontrolny zakończony", "punkt restartu zakończony"},
+	"ru": {"автоматическая очистка таблицы", "автоматический анализ таблицы", "контрольная точка завершена", "точка перезапуска завершена"},
+	"sv": {"automatisk vacuum av tabell", "automatisk analys av tabell", "checkpoint klar", "restartpunkt klar"},
+	"tr": {"tablosunun otomatik vakumu", "tablosunun otomatik analizi", "checkpoint tamamlandı", "restartpoint tamamlandı"},
+	"zh": {"的自动清理", "的自动分析", "检查点完成", "重启点完成"},
+}
+
+const (
+	specialMetricAutovacuumLog = "autovacuum_log"
+	specialMetricCheckpointLog = "checkpoint_log"
+
+	logEventMaxRecordBytes = 64 * 1024 // multi-line records exceeding that are cut
+)
+
+// logparseDerivedMetrics are stored additionally when server_log_event_counts is enabled
+var logparseDerivedMetrics = []string{specialMetricAutovacuumLog, specialMetricCheckpointLog}
+
+var (
+	logEventNumberRegex    = regexp.MustCompile(`\d+(?:\.\d+)?`)
+	logEventTableNameRegex = regexp.MustCompile(`["“”„«»「」]+([^"“”„«»「」\s]+?)["“”„«»「」]`)
+	// the table name preceding the phrase in some languages, e.g. `表"db.schema.table"的自动清理`
+	logEventNamePrefixRegex = regexp.MustCompile(`^\S{0,3}["“”„«»「」]+[^"“”„«»「」\s]+["“”„«»「」]+\s*$`)
+	logEventSQLStateRegex   = regexp.MustCompile(`^[0-9A-Z]{5},`) // the column following error_severity in CSV logs
+	// english labels are preferred when available as being robust against differences between PG versions
+	checkpointEnRegex = regexp.MustCompile(`wrote (?P<buffers_written>\d+) buffers \((?P<buffers_written_pct>[\d.]+)%\).*?` +
+		`(?P<wal_files_added>\d+) WAL file\(s\) added, (?P<wal_files_removed>\d+) removed, (?P<wal_files_recycled>\d+) recycled; ` +
+		`write=(?P<write_s>[\d.]+) s, sync=(?P<sync_s>[\d.]+) s, total=(?P<total_s>[\d.]+) s; ` +
+		`sync files=(?P<sync_files>\d+), longest=(?P<longest_sync_s>[\d.]+) s, average=(?P<average_sync_s>[\d.]+) s` +
+		`(?:; distance=(?P<distance_kb>\d+) kB, estimate=(?P<estimate_kb>\d+) kB)?`)
+	autovacuumEnRegexes = []*regexp.Regexp{
+		regexp.MustCompile(`index scans: (?P<index_scans>\d+)`),
+		regexp.MustCompile(`pages: (?P<pages_removed>\d+) removed, (?P<pages_remain>\d+) remain`),
+		regexp.MustCompile(`tuples: (?P<tuples_removed>\d+) removed, (?P<tuples_remain>\d+) remain, (?P<tuples_dead_not_removable>\d+) are dead but not yet removable`),
+		regexp.MustCompile(`avg read rate: (?P<avg_read_rate_mbs>[\d.]+) MB/s, avg write rate: (?P<avg_write_rate_mbs>[\d.]+) MB/s`),
+		regexp.MustCompile(`buffer usage: (?P<buffer_hits>\d+) hits, (?P<buffer_misses>\d+) (?:misses|reads), (?P<buffer_dirtied>\d+) dirtied`),
+		regexp.MustCompile(`WAL usage: (?P<wal_records>\d+) records, (?P<wal_fpi>\d+) full page images, (?P<wal_bytes>\d+) bytes`),
+		regexp.MustCompile(`CPU: user: (?P<cpu_user_s>[\d.]+) s, system: (?P<cpu_system_s>[\d.]+) s, elapsed: (?P<elapsed_s>[\d.]+) s`),
+	}
+	// checkpoint message numbers in the order they appear in all translations
+	checkpointPositionalFields = []string{"buffers_written", "buffers_written_pct", "wal_files_added", "wal_files_removed", "wal_files_recycled",
+		"write_s", "sync_s", "total_s", "sync_files", "longest_sync_s", "average_sync_s", "distance_kb", "estimate_kb"}
+)
+
+// logTimeLayouts are the log_timezone formats of the log_time column, with numeric offsets or zone abbreviations
+var logTimeLayouts = []string{"2006-01-02 15:04:05 -07", "2006-01-02 15:04:05 -0700", "2006-01-02 15:04:05 -07:00", "2006-01-02 15:04:05 MST"}
+
+// logEventKind is an event kind with its lowercased phrase in the server language
+type logEventKind struct {
+	kind, phrase string
+}
+
+// logEventExtractor collects autovacuum and checkpoint events from log records, which can span multiple lines
+type logEventExtractor struct {
+	lang        string
+	realDbname  string
+	lineRegex   *regexp.Regexp // for the log_time and the message of a record, the whole record is the message if not set
+	logLocation *time.Location // the server's log_timezone, for log times with zone abbreviations
+	record      strings.Builder
+	recordTime  time.Time
+	autovacuum  metrics.Measurements
+	checkpoints metrics.Measurements
+	kinds       []logEventKind // of kindsLang
+	kindsLang   string
+}
+
+// Feed takes the next complete log line. recordStart signals that the line matched the log line regex,
+// i.e. a new log record begins and the previous one can be processed
+func (e *logEventExtractor) Feed(line string, recordStart bool) {
+	if recordStart {
+		e.Flush()
+		if !e.mentionsEvent(line) {
+			return // not interested, don't buffer follow-up lines
+		}
+		if _, ok := e.detect(e.message(line)); !ok {
+			return
+		}
+		e.recordTime = e.logTime(line)
+	} else if e.record.Len() == 0 || e.record.Len() > logEventMaxRecordBytes {
+		return
+	}
+	e.record.WriteString(line)
+}
+
+// Flush processes the currently buffered record
+func (e *logEventExtractor) Flush() {
+	if e.record.Len() == 0 {
+		return
+	}
+	record := e.record.String()
+	e.record.Reset()
+	if e.recordTime.IsZero() {
+		e.recordTime = time.Now()
+	}
+	defer func() { e.recordTime = time.Time{} }()
+	msg := e.message(record)
+	kind, ok := e.detect(msg)
+	if !ok {
+		return
+	}
+	switch kind {
+	case "vacuum", "analyze":
+		if m := e.parseAutovacuum(msg, kind); m != nil {
+			e.autovacuum = append(e.autovacuum, m)
+		}
+	case "checkpoint", "restartpoint":
+		if m := e.parseCheckpoint(msg, kind); m != nil {
+			e.checkpoints = append(e.checkpoints, m)
+		}
+	}
+}
+
+// Messages returns the events gathered since the last call as metric store messages
+func (e *logEventExtractor) Messages(mdb MonitoredDatabase) []metrics.MeasurementMessage {
+	var msgs []metrics.MeasurementMessage
+	if len(e.autovacuum) > 0 {
+		msgs = append(msgs, metrics.MeasurementMessage{DBName: mdb.DBUniqueName, DBType: mdb.DBType,
+			MetricName: specialMetricAutovacuumLog, Data: e.autovacuum, CustomTags: mdb.CustomTags})
+	}
+	if len(e.checkpoints) > 0 {
+		msgs = append(msgs, metrics.MeasurementMessage{DBName: mdb.DBUniqueName, DBType: mdb.DBType,
+			MetricName: specialMetricCheckpointLog, Data: e.checkpoints, CustomTags: mdb.CustomTags})
+	}
+	e.autovacuum = nil
+	e.checkpoints = nil
+	return msgs
+}
+
+// logTime returns the log_time of a record's first line, or the zero time if it can't be parsed
+func (e *logEventExtractor) logTime(line string) time.Time {
+	if e.lineRegex == nil {
+		return time.Time{}
+	}
+	logTime := strings.Trim(RegexMatchesToMap(e.lineRegex, e.lineRegex.FindStringSubmatch(line))["log_time"], `"`)
+	if logTime == "" {
+		return time.Time{}
+	}
+	return parseLogTime(logTime, e.logLocation)
+}
+
+// parseLogTime parses a log_time value. Zone abbreviations are resolved via loc, i.e. the server's log_timezone, UTC
+// if not known. Abbreviations not defined there would be taken as UTC, such values are treated as unparseable
+func parseLogTime(logTime string, loc *time.Location) time.Time {
+	if loc == nil {
+		loc = time.UTC
+	}
+	for _, layout := range logTimeLayouts {
+		t, err := time.ParseInLocation(layout, logTime, loc)
+		if err != nil {
+			continue
+		}
+		if name, offset := t.Zone(); offset == 0 && name != "UTC" && name != "GMT" {
+			return time.Time{}
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+func (e *logEventExtractor) phrases() logEventPhrases {
+	if p, ok := PgLogEventPhrasesLocale[e.lang]; ok {
+		return p
+	}
+	return PgLogEventPhrasesLocale["en"]
+}
+
+// eventKinds returns the event kinds of the server language, lowercasing the phrases only once per language
+func (e *logEventExtractor) eventKinds() []logEventKind {
+	if e.kinds == nil || e.kindsLang != e.lang {
+		p := e.phrases()
+		e.kinds = []logEventKind{
+			{"vacuum", strings.ToLower(p.AutoVacuum)},
+			{"analyze", strings.ToLower(p.AutoAnalyze)},
+			{"checkpoint", strings.ToLower(p.Checkpoint)},
+			{"restartpoint", strings.ToLower(p.Restartpoint)},
+		}
+		e.kindsLang = e.lang
+	}
+	return e.kinds
+}
+
+// mentionsEvent is a quick check if any phrase occurs in a log line at all, before looking at the message
+func (e *logEventExtractor) mentionsEvent(line string) bool {
+	lower := strings.ToLower(line)
+	for _, k := range e.eventKinds() {
+		if strings.Contains(lower, k.phrase) {
+			return true
+		}
+	}
+	return false
+}
+
+// detect returns the event kind of a log message. The phrase has to begin the message, or follow the table name as in
+// some languages, so that e.g. logged statements containing the phrase don't count
+func (e *logEventExtractor) detect(msg string) (string, bool) {
+	lower := strings.ToLower(msg)
+	for _, k := range e.eventKinds() {
+		idx := strings.Index(lower, k.phrase)
+		if idx == 0 || idx > 0 && logEventNamePrefixRegex.MatchString(lower[:idx]) {
+			return k.kind, true
+		}
+	}
+	return "", false
+}
+
+// message returns the message of a log record, i.e. the text following the part matched by the line regex. For CSV
+// logs that's the message column after the SQL state code, with the CSV quoting undone and the detail, statement,
+// query etc. columns left out
+func (e *logEventExtractor) message(record string) string {
+	if e.lineRegex != nil {
+		if loc := e.lineRegex.FindStringIndex(record); loc != nil {
+			record = record[loc[1]:]
+		}
+	}
+	if !logEventSQLStateRegex.MatchString(record) {
+		return strings.TrimSpace(record)
+	}
+	record = record[len("00000,"):]
+	if !strings.HasPrefix(record, `"`) {
+		msg, _, _ := strings.Cut(record, ",")
+		return strings.TrimSpace(msg)
+	}
+	var msg strings.Builder
+	for i := 1; i < len(record); i++ {
+		if record[i] == '"' {
+			if i+1 == len(record) || record[i+1] != '"' {
+				break // closing quote
+			}
+			i++
+		}
+		msg.WriteByte(record[i])
+	}
+	return strings.TrimSpace(msg.String())
+}
+
+func (e *logEventExtractor) parseAutovacuum(msg, kind string) metrics.Measurement {
+	tm := logEventTableNameRegex.FindStringSubmatch(msg)
+	if len(tm) < 2 {
+		return nil
+	}
+	nameParts := strings.SplitN(tm[1], ".", 2) // db.schema.table
+	if len(nameParts) != 2 || nameParts[0] != e.realDbname {
+		return nil
+	}
+	m := metrics.Measurement{epochColumnName: e.recordTime.UnixNano(), tagPrefix + "table": nameParts[1], tagPrefix + "action": kind}
+	noDetails := len(m)
+
+	if e.lang == "en" || e.lang == "C." {
+		for _, r := range autovacuumEnRegexes {
+			matchesToMeasurement(r, r.FindStringSubmatch(msg), m)
+		}
+	} else {
+		// positional extraction: line 2 - pages, line 3 - tuples, the last line - CPU usage
+		lines := strings.Split(msg, "\n")
+		if kind == "vacuum" && len(lines) > 3 {
+			numbersToMeasurement(lines[1], []string{"pages_removed", "pages_remain"}, m)
+			numbersToMeasurement(lines[2], []string{"tuples_removed", "tuples_remain", "tuples_dead_not_removable"}, m)
+		}
+		if cpu := logEventNumberRegex.FindAllString(lines[len(lines)-1], -1); len(cpu) >= 3 {
+			numbersToMeasurement(strings.Join(cpu[len(cpu)-3:], " "), []string{"cpu_user_s", "cpu_system_s", "elapsed_s"}, m)
+		}
+	}
+	if len(m) == noDetails {
+		return nil // not the message format expected, no empty rows
+	}
+	return m
+}
+
+func (e *logEventExtractor) parseCheckpoint(msg, kind string) metrics.Measurement {
+	m := metrics.Measurement{epochColumnName: e.recordTime.UnixNano(), tagPrefix + "kind": kind}
+	noDetails := len(m)
+	if e.lang == "en" || e.lang == "C." {
+		matchesToMeasurement(checkpointEnRegex, checkpointEnRegex.FindStringSubmatch(msg), m)
+	} else {
+		if colon := strings.IndexAny(msg, ":："); colon != -1 {
+			msg = msg[colon+1:]
+		}
+		numbersToMeasurement(msg, checkpointPositionalFields, m)
+	}
+	if len(m) == noDetails {
+		return nil // not the message format expected, no empty rows
+	}
+	return m
+}
+
+func matchesToMeasurement(r *regexp.Regexp, matches []string, m metrics.Measurement) {
+	for k, v := range RegexMatchesToMap(r, matches) {
+		if v == "" {
+			continue
+		}
+		storeLogEventNumber(k, v, m)
+	}
+}
+
+func numbersToMeasurement(text string, fields []string, m metrics.Measurement) {
+	for i, v := range logEventNumberRegex.FindAllString(text, len(fields)) {
+		storeLogEventNumber(fields[i], v, m)
+	}
+}
+
+func storeLogEventNumber(field, value string, m metrics.Measurement) {
+	if strings.Contains(value, ".") {
+		if f, err := strconv.ParseFloat(value, 64); err == nil {
+			m[field] = f
+		}
+		return
+	}
+	if i, err := strconv.ParseInt(value, 10, 64); err == nil {
+		m[field] = i
+	}
+}
@@ -0,0 +1,262 @@
+package reaper
+
+import (
+	"bytes"
+	"path/filepath"
+	"regexp"
+	"time"
+
+	"github.com/cybertec-postgresql/pgwatch3/metrics"
+)
+
+// remoteLogReadChunkBytes limits the amount of data fetched via a single pg_read_binary_file() call
+const remoteLogReadChunkBytes = 1024 * 1024
+
+// remoteLogParseMinInterval is the shortest polling interval, not to spin against pg_ls_logdir() on tiny intervals
+const remoteLogParseMinInterval = time.Second
+
+type remoteLogFile struct {
+	Name         string
+	Size         int64
+	Modification time.Time
+}
+
+// remoteListLogFiles returns server log files matching the glob pattern, oldest first.
+// Requires superuser or pg_monitor membership
+func (g *Gatherer) remoteListLogFiles(dbUniqueName, pattern string) ([]remoteLogFile, error) {
+	sql := `select name, size, modification from pg_ls_logdir() order by modification, name`
+	data, err := g.DBExecReadByDbUniqueName(g.mainContext, dbUniqueName, sql)
+	if err != nil {
+		return nil, err
+	}
+	files := make([]remoteLogFile, 0, len(data))
+	for _, row := range data {
+		name, _ := row["name"].(string)
+		if ok, _ := filepath.Match(pattern, name); !ok {
+			continue
+		}
+		size, _ := row["size"].(int64)
+		mod, _ := row["modification"].(time.Time)
+		files = append(files, remoteLogFile{Name: name, Size: size, Modification: mod})
+	}
+	return files, nil
+}
+
+// remoteReadLogChunk reads a byte range from a server log file. Requires superuser or pg_read_server_files membership
+func (g *Gatherer) remoteReadLogChunk(dbUniqueName, fileName string, offset, length int64) ([]byte, error) {
+	sql := `select pg_read_binary_file(current_setting('log_directory') || '/' || $1, $2, $3, true) as chunk`
+	data, err := g.DBExecReadByDbUniqueName(g.mainContext, dbUniqueName, sql, fileName, offset, length)
+	if err != nil || len(data) == 0 {
+		return nil, err
+	}
+	chunk, _ := data[0]["chunk"].([]byte)
+	return chunk, nil
+}
+
+// resolveRemoteLogParseCheckpoint returns the index of the file to continue from, falling back
+// to the oldest file modified after the checkpoint if the checkpointed one is already gone
+func resolveRemoteLogParseCheckpoint(cp logParseCheckpoint, files []remoteLogFile) (int, int64) {
+	for i, f := range files {
+		if f.Name == cp.File {
+			return i, cp.Offset
+		}
+	}
+	for i, f := range files {
+		if f.Modification.After(cp.UpdatedOn) {
+			return i, 0
+		}
+	}
+	return -1, 0
+}
+
+// splitLogChunk returns the complete lines of a chunk and the number of bytes consumed. An incomplete last line is left
+// for the next read, unless a single line fills the whole chunk of chunkSize bytes, which is skipped then
+func splitLogChunk(chunk []byte, chunkSize int) (lines [][]byte, consumed int) {
+	lastNewline := bytes.LastIndexByte(chunk, '\n')
+	if lastNewline == -1 {
+		if len(chunk) >= chunkSize {
+			return nil, len(chunk)
+		}
+		return nil, 0
+	}
+	lines = bytes.SplitAfter(chunk[:lastNewline+1], []byte{'\n'})
+	return lines[:len(lines)-1], lastNewline + 1 // without the empty rest after the last newline
+}
+
+// remoteLogParseInterval converts the metric interval, floored to remoteLogParseMinInterval
+func remoteLogParseInterval(interval float64) time.Duration {
+	return max(time.Duration(interval*float64(time.Second)), remoteLogParseMinInterval)
+}
+
+// logparseRemoteLoop is the counterpart of logparseLoop for gatherers not running on the DB host. The current
+// log file is located via pg_ls_logdir() and new content is fetched with pg_read_binary_file() from the last offset
+func (g *Gatherer) logparseRemoteLoop(dbUniqueName, metricName string, configMap map[string]float64, controlCh <-chan ControlMessage, storeCh chan<- []metrics.MeasurementMessage) {
+	var currentFile, realDbname, serverMessagesLang string
+	var offset int64
+	var logsMatchRegex, logsMatchRegexPrev, logsFilePattern string
+	var lastSendTime time.Time
+	var lastConfigRefreshTime time.Time
+	var eventCounts = make(map[string]int64)
+	var eventCountsTotal = make(map[string]int64)
+	var mdb MonitoredDatabase
+	var config = configMap
+	var interval = config[metricName]
+	var err error
+	var firstRun = true
+	var csvlogRegex *regexp.Regexp
+	var events logEventExtractor
+
+	for {
+		if lastConfigRefreshTime.IsZero() || lastConfigRefreshTime.Add(time.Second*time.Duration(g.opts.Connection.ServersRefreshLoopSeconds)).Before(time.Now()) {
+			mdb, err = g.GetMonitoredDatabaseByUniqueName(dbUniqueName)
+			if err != nil {
+				g.logger.Errorf("[%s] Failed to refresh monitored DBs info: %s", dbUniqueName, err)
+				time.Sleep(60 * time.Second)
+				continue
+			}
+			lastConfigRefreshTime = time.Now()
+
+			logsMatchRegex = mdb.HostConfig.LogsMatchRegex
+			if logsMatchRegex == "" {
+				logsMatchRegex = CSVLogDefaultRegEx
+			}
+			logsFilePattern = CSVLogDefaultGlobSuffix
+			if mdb.HostConfig.LogsGlobPath != "" { // only the file name part is relevant for pg_ls_logdir()
+				logsFilePattern = filepath.Base(mdb.HostConfig.LogsGlobPath)
+			}
+			serverMessagesLang = g.tryDetermineLogMessagesLanguage(mdb)
+			events.logLocation = g.tryDetermineLogTimezone(mdb)
+		}
+
+		g.dbPgVersionMapLock.RLock()
+		realDbname = g.dbPgVersionMap[dbUniqueName].RealDbname
+		g.dbPgVersionMapLock.RUnlock()
+		events.lang, events.realDbname = serverMessagesLang, realDbname
+
+		if serverMessagesLang == "" {
+			g.logger.Warningf("[%s] Could not determine language (lc_collate) used for server logs, cannot parse logs...", dbUniqueName)
+			lastConfigRefreshTime = time.Time{}
+			time.Sleep(60 * time.Second)
+			continue
+		}
+
+		if logsMatchRegexPrev != logsMatchRegex {
+			csvlogRegex, err = regexp.Compile(logsMatchRegex)
+			if err != nil {
+				g.logger.Errorf("[%s] Invalid regex: %s", dbUniqueName, logsMatchRegex)
+				time.Sleep(60 * time.Second)
+				continue
+			}
+			g.logger.Infof("[%s] Changing logs parsing regex to: %s", dbUniqueName, logsMatchRegex)
+			logsMatchRegexPrev = logsMatchRegex
+			events.lineRegex = csvlogRegex
+		}
+
+		files, err := g.remoteListLogFiles(dbUniqueName, logsFilePattern)
+		if err != nil {
+			g.logger.Warningf("[%s] Failed to list server logs via pg_ls_logdir(), superuser or pg_monitor grant needed: %s. Sleeping 60s...", dbUniqueName, err)
+			time.Sleep(60 * time.Second)
+			continue
+		}
+		if len(files) == 0 {
+			g.logger.Infof("[%s] No logfiles matching '%s' found via pg_ls_logdir(). Sleeping 60s...", dbUniqueName, logsFilePattern)
+			time.Sleep(60 * time.Second)
+			continue
+		}
+
+		idx := -1
+		for i, f := range files {
+			if f.Name == currentFile {
+				idx = i
+				break
+			}
+		}
+		if idx == -1 && firstRun {
+			if cp, ok := g.loadLogParseCheckpoint(dbUniqueName); ok {
+				idx, offset = resolveRemoteLogParseCheckpoint(cp, files)
+				if idx != -1 {
+					g.logger.Infof("[%s] Resuming remote logfile parsing from checkpoint: %s at offset %d", dbUniqueName, files[idx].Name, offset)
+					currentFile = files[idx].Name
+					firstRun = false
+				}
+			}
+		}
+		if idx == -1 { // first run or the file we were reading is gone
+			idx = len(files) - 1
+			offset = 0
+			if firstRun { // skip the history, same as with local parsing
+				offset = files[idx].Size
+				firstRun = false
+			}
+			currentFile = files[idx].Name
+			g.logger.Infof("[%s] Starting to parse remote logfile: %s", dbUniqueName, currentFile)
+		}
+		if files[idx].Size < offset {
+			g.logger.Infof("[%s] Remote logfile %s was truncated, re-reading from the start", dbUniqueName, currentFile)
+			offset = 0
+		}
+
+		for offset < files[idx].Size {
+			chunk, err := g.remoteReadLogChunk(dbUniqueName, currentFile, offset, min(remoteLogReadChunkBytes, files[idx].Size-offset))
+			if err != nil {
+				g.logger.Warningf("[%s] Failed to read remote logfile %s via pg_read_binary_file(), superuser or pg_read_server_files grant needed: %s", dbUniqueName, currentFile, err)
+				break
+			}
+			lines, consumed := splitLogChunk(chunk, remoteLogReadChunkBytes)
+			if consumed == 0 {
+				break // nothing read or an incomplete line, re-read on next round
+			}
+			for _, line := range lines {
+				matched, err := g.countLogLineEvent(csvlogRegex, string(line), serverMessagesLang, realDbname, eventCounts, eventCountsTotal)
+				if err != nil {
+					g.logger.Error(err)
+					break
+				}
+				events.Feed(string(line), matched)
+			}
+			offset += int64(consumed)
+		}
+
+		rotated := offset >= files[idx].Size && idx < len(files)-1
+		if rotated {
+			currentFile = files[idx+1].Name
+			offset = 0
+			g.logger.Infof("[%s] Switching to new remote logfile: %s", dbUniqueName, currentFile)
+		}
+
+		if lastSendTime.IsZero() || time.Since(lastSendTime) > remoteLogParseInterval(interval) {
+			g.logger.Debugf("[%s] Sending log event counts for last interval to storage channel. Local eventcounts: %+v, global eventcounts: %+v", dbUniqueName, eventCounts, eventCountsTotal)
+			events.Flush()
+			select {
+			case storeCh <- append(eventCountsToMetricStoreMessages(eventCounts, eventCountsTotal, mdb), events.Messages(mdb)...):
+			case <-g.mainContext.Done():
+				return // shutting down, the sinks are closed
+			}
+			ZeroEventCounts(eventCounts)
+			ZeroEventCounts(eventCountsTotal)
+			lastSendTime = time.Now()
+			if err = g.storeLogParseCheckpoint(dbUniqueName, logParseCheckpoint{File: currentFile, Offset: offset}); err != nil {
+				g.logger.Warningf("[%s] Failed to store logparse checkpoint: %s", dbUniqueName, err)
+			}
+		}
+
+		if rotated {
+			continue // catch up with the next file right away
+		}
+		select {
+		case <-g.mainContext.Done():
+			g.logger.Debug("exiting remote logparse loop for ", dbUniqueName, metricName, " on shutdown")
+			return
+		case msg := <-controlCh:
+			g.logger.Debug("got control msg", dbUniqueName, metricName, msg)
+			if msg.Action == gathererStatusStart {
+				config = msg.Config
+				interval = config[metricName]
+			} else if msg.Action == gathererStatusStop {
+				g.logger.Debug("exiting remote logparse loop for ", dbUniqueName, metricName, " interval:", interval)
+				return
+			}
+		case <-time.After(remoteLogParseInterval(interval)):
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package reaper
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"time"
+)
+
+// logParseCheckpoint is persisted per monitored DB to resume log parsing after a restart
+// without losing or double-counting events
+type logParseCheckpoint struct {
+	File      string    `json:"file"`
+	Inode     uint64    `json:"inode"`  // 0 if not available (remote parsing, Windows)
+	Offset    int64     `json:"offset"` // bytes of fully processed lines
+	UpdatedOn time.Time `json:"updated_on"`
+}
+
+var unsafeFileNameChars = regexp.MustCompile(`[^a-zA-Z0-9_.-]`)
+
+func (g *Gatherer) logParseCheckpointPath(dbUniqueName string) string {
+	return filepath.Join(g.opts.GetLogParseStateDir(), unsafeFileNameChars.ReplaceAllString(dbUniqueName, "_")+".json")
+}
+
+func (g *Gatherer) loadLogParseCheckpoint(dbUniqueName string) (cp logParseCheckpoint, ok bool) {
+	if g.opts.GetLogParseStateDir() == "" {
+		if g.opts.LogParseStateDir != "off" {
+			g.logger.Warningf("[%s] Not resuming log parsing after restarts, no user config dir to store the offsets in, set --log-parse-state-dir", dbUniqueName)
+		}
+		return
+	}
+	b, err := os.ReadFile(g.logParseCheckpointPath(dbUniqueName))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			g.logger.Warningf("[%s] Failed to read logparse checkpoint: %s", dbUniqueName, err)
+		}
+		return
+	}
+	if err = json.Unmarshal(b, &cp); err != nil {
+		g.logger.Warningf("[%s] Ignoring invalid logparse checkpoint: %s", dbUniqueName, err)
+		return
+	}
+	return cp, cp.File != ""
+}
+
+// storeLogParseCheckpoint writes the checkpoint atomically via a temp file rename
+func (g *Gatherer) storeLogParseCheckpoint(dbUniqueName string, cp logParseCheckpoint) error {
+	if g.opts.GetLogParseStateDir() == "" || cp.File == "" {
+		return nil
+	}
+	if err := os.MkdirAll(g.opts.GetLogParseStateDir(), 0o700); err != nil {
+		return err
+	}
+	cp.UpdatedOn = time.Now()
+	b, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+	fileName := g.logParseCheckpointPath(dbUniqueName)
+	if err = os.WriteFile(fileName+".tmp", b, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(fileName+".tmp", fileName)
+}
+
+// resolveLogParseCheckpoint finds the file to continue parsing from. The checkpointed file is identified by
+// its inode, so it's found also when renamed by log rotation. If it's gone, parsing continues from the start
+// of the oldest file modified after the checkpoint was taken, so that unread rotated files are caught up on
+func (g *Gatherer) resolveLogParseCheckpoint(dbUniqueName string, cp logParseCheckpoint, files []string) (string, int64) {
+	if fi, err := os.Stat(cp.File); err == nil && (cp.Inode == 0 || fileInode(fi) == cp.Inode) {
+		return cp.File, cp.Offset
+	}
+	if cp.Inode != 0 {
+		for _, f := range files {
+			if fi, err := os.Stat(f); err == nil && fileInode(fi) == cp.Inode {
+				g.logger.Infof("[%s] Checkpointed logfile %s was renamed to %s", dbUniqueName, cp.File, f)
+				return f, cp.Offset
+			}
+		}
+	}
+	type fileMod struct {
+		name string
+		mod  time.Time
+	}
+	var newer []fileMod
+	for _, f := range files {
+		if fi, err := os.Stat(f); err == nil && fi.ModTime().After(cp.UpdatedOn) {
+			newer = append(newer, fileMod{f, fi.ModTime()})
+		}
+	}
+	if len(newer) == 0 {
+		return "", 0
+	}
+	sort.Slice(newer, func(i, j int) bool { return newer[i].mod.Before(newer[j].mod) })
+	g.logger.Infof("[%s] Checkpointed logfile %s not found, catching up from %s", dbUniqueName, cp.File, newer[0].name)
+	return newer[0].name, 0
+}
@@ -0,0 +1,187 @@
+package reaper
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/cybertec-postgresql/pgwatch3/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLogParseCheckpoint(t *testing.T) {
+	a := assert.New(t)
+	dir := t.TempDir()
+	g := newTestGatherer(t, &config.Options{LogParseStateDir: dir})
+
+	logFile := filepath.Join(dir, "postgresql-1.csv")
+	a.NoError(os.WriteFile(logFile, []byte("line1\nline2\n"), 0o600))
+	fi, err := os.Stat(logFile)
+	a.NoError(err)
+
+	_, ok := g.loadLogParseCheckpoint("db/1")
+	a.False(ok, "no checkpoint stored yet")
+
+	a.NoError(g.storeLogParseCheckpoint("db/1", logParseCheckpoint{File: logFile, Inode: fileInode(fi), Offset: 6}))
+	cp, ok := g.loadLogParseCheckpoint("db/1")
+	a.True(ok)
+	a.Equal(int64(6), cp.Offset)
+
+	// rotation by rename should be followed via the inode
+	rotated := filepath.Join(dir, "postgresql-1.csv.1")
+	a.NoError(os.Rename(logFile, rotated))
+	file, offset := g.resolveLogParseCheckpoint("db/1", cp, []string{rotated})
+	if cp.Inode != 0 {
+		a.Equal(rotated, file)
+		a.Equal(int64(6), offset)
+	}
+}
+
+func TestLogEventExtractor(t *testing.T) {
+	a := assert.New(t)
+	csvlogRegex := regexp.MustCompile(CSVLogDefaultRegEx)
+	lines := []string{
+		`2024-03-01 10:00:00.000 +01,,,1234,,65e1a0a0.4d2,1,,2024-03-01 09:00:00 CET,4/12,0,LOG,00000,"automatic vacuum of table ""mydb.public.t1"": index scans: 1` + "\n",
+		`pages: 5 removed, 120 remain, 125 scanned (100.00% of total)` + "\n",
+		`tuples: 300 removed, 1000 remain, 2 are dead but not yet removable` + "\n",
+		`buffer usage: 400 hits, 10 misses, 20 dirtied` + "\n",
+		`system usage: CPU: user: 0.01 s, system: 0.00 s, elapsed: 0.15 s",,,,,,,,,"","autovacuum worker",,0` + "\n",
+		`2024-03-01 10:00:01.000 CET,,,99,,65e1a0a0.63,2,,2024-03-01 09:00:00 CET,,0,LOG,00000,"checkpoint complete: wrote 44 buffers (0.3%); 0 WAL file(s) added, 0 removed, 1 recycled; write=4.402 s, sync=0.006 s, total=4.423 s; sync files=12, longest=0.003 s, average=0.001 s; distance=262 kB, estimate=262 kB",,,,,,,,,"","checkpointer",,0` + "\n",
+	}
+	vienna, err := time.LoadLocation("Europe/Vienna")
+	a.NoError(err)
+	events := logEventExtractor{lang: "en", realDbname: "mydb", lineRegex: csvlogRegex, logLocation: vienna}
+	for _, l := range lines {
+		events.Feed(l, csvlogRegex.MatchString(l))
+	}
+	events.Flush()
+	msgs := events.Messages(MonitoredDatabase{DBUniqueName: "mydb"})
+	a.Len(msgs, 2)
+
+	av := msgs[0].Data[0]
+	a.Equal(specialMetricAutovacuumLog, msgs[0].MetricName)
+	a.Equal("public.t1", av["tag_table"])
+	a.Equal(int64(5), av["pages_removed"])
+	a.Equal(int64(2), av["tuples_dead_not_removable"])
+	a.Equal(int64(10), av["buffer_misses"])
+	a.Equal(0.15, av["elapsed_s"])
+	a.Equal(time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC).UnixNano(), av[epochColumnName], "the log_time of the record should be used")
+
+	cp := msgs[1].Data[0]
+	a.Equal(specialMetricCheckpointLog, msgs[1].MetricName)
+	a.Equal(int64(44), cp["buffers_written"])
+	a.Equal(4.423, cp["total_s"])
+	a.Equal(int64(262), cp["estimate_kb"])
+	a.Equal(time.Date(2024, 3, 1, 9, 0, 1, 0, time.UTC).UnixNano(), cp[epochColumnName], "the abbreviation should be resolved via log_timezone")
+
+	// only the message column counts, not logged statements or queries, and records without details are left out
+	for _, l := range []string{
+		`2024-03-01 10:00:02.000 UTC,"u","mydb",77,"[local]",65e1a0a0.4d,1,"SELECT",2024-03-01 09:00:00 UTC,3/4,0,LOG,00000,"statement: select 'checkpoint complete: wrote 1 buffers (0.0%)'",,,,,,,,,"psql",,0` + "\n",
+		`2024-03-01 10:00:03.000 UTC,"u","mydb",77,"[local]",65e1a0a0.4d,2,"SELECT",2024-03-01 09:00:00 UTC,3/4,0,ERROR,42601,"syntax error",,,,,,"automatic vacuum of table ""mydb.public.t1"": index scans: 1",,,"psql",,0` + "\n",
+		`2024-03-01 10:00:04.000 UTC,,,99,,65e1a0a0.63,3,,2024-03-01 09:00:00 UTC,,0,LOG,00000,"checkpoint complete: details unknown",,,,,,,,,"","checkpointer",,0` + "\n",
+	} {
+		events.Feed(l, csvlogRegex.MatchString(l))
+	}
+	events.Flush()
+	a.Empty(events.Messages(MonitoredDatabase{DBUniqueName: "mydb"}))
+
+	// positional extraction for non-english messages
+	events = logEventExtractor{lang: "de", realDbname: "mydb", lineRegex: csvlogRegex}
+	events.Feed(`2024-03-01 10:00:01.000 UTC,,,99,,65e1a0a0.63,2,,2024-03-01 09:00:00 UTC,,0,LOG,00000,"Checkpoint komplett: 44 Puffer geschrieben (0.3%); 0 WAL-Datei(en) hinzugefügt, 0 entfernt, 1 wiederverwendet; Schreiben=4.402 s, Sync=0.006 s, gesamt=4.423 s",,,,,,,,,"","checkpointer",,0`+"\n", true)
+	events.Feed(`2024-03-01 10:00:02.000 UTC,,,98,,65e1a0a0.62,1,,2024-03-01 09:00:00 UTC,,0,LOG,00000,"automatisches Analysieren der Tabelle ""mydb.public.t1""",,,,,,,,,"","autovacuum worker",,0`+"\n", true)
+	events.Flush()
+	msgs = events.Messages(MonitoredDatabase{DBUniqueName: "mydb"})
+	a.Len(msgs, 1)
+	a.Equal(4.423, msgs[0].Data[0]["total_s"])
+
+	// the table name precedes the phrase in some languages
+	events = logEventExtractor{lang: "zh", realDbname: "mydb", lineRegex: csvlogRegex}
+	events.Feed(`2024-03-01 10:00:00.000 UTC,,,1234,,65e1a0a0.4d2,1,,2024-03-01 09:00:00 UTC,4/12,0,LOG,00000,"表""mydb.public.t1""的自动分析 系统使用: CPU: 用户: 0.01 s, 系统: 0.00 s, 已用: 0.15 s",,,,,,,,,"","autovacuum worker",,0`+"\n", true)
+	events.Flush()
+	msgs = events.Messages(MonitoredDatabase{DBUniqueName: "mydb"})
+	a.Len(msgs, 1)
+	a.Equal("public.t1", msgs[0].Data[0]["tag_table"])
+	a.Equal(0.15, msgs[0].Data[0]["elapsed_s"])
+}
+
+func TestParseLogTime(t *testing.T) {
+	vienna, err := time.LoadLocation("Europe/Vienna")
+	assert.NoError(t, err)
+	for _, tc := range []struct {
+		logTime  string
+		loc      *time.Location
+		expected time.Time
+	}{
+		{"2024-03-01 10:00:00.123 UTC", nil, time.Date(2024, 3, 1, 10, 0, 0, 123000000, time.UTC)},
+		{"2024-03-01 10:00:00 +01", nil, time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC)},
+		{"2024-03-01 10:00:00.5 -0530", vienna, time.Date(2024, 3, 1, 15, 30, 0, 500000000, time.UTC)},
+		{"2024-03-01 10:00:00 CET", vienna, time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC)},
+		{"2024-07-01 10:00:00 CEST", vienna, time.Date(2024, 7, 1, 8, 0, 0, 0, time.UTC)},
+		{"2024-03-01 10:00:00 CET", nil, time.Time{}}, // not known without log_timezone, regardless of the gatherer's zone
+		{"2024-03-01 10:00:00 XYZ", vienna, time.Time{}},
+		{"not a time", nil, time.Time{}},
+	} {
+		assert.True(t, tc.expected.Equal(parseLogTime(tc.logTime, tc.loc)), tc.logTime)
+	}
+}
+
+func TestResolveRemoteLogParseCheckpoint(t *testing.T) {
+	t0 := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+	files := []remoteLogFile{
+		{Name: "postgresql-1.csv", Size: 100, Modification: t0},
+		{Name: "postgresql-2.csv", Size: 200, Modification: t0.Add(time.Hour)},
+		{Name: "postgresql-3.csv", Size: 300, Modification: t0.Add(2 * time.Hour)},
+	}
+	for _, tc := range []struct {
+		name   string
+		cp     logParseCheckpoint
+		files  []remoteLogFile
+		idx    int
+		offset int64
+	}{
+		{"checkpointed file still there", logParseCheckpoint{File: "postgresql-2.csv", Offset: 50, UpdatedOn: t0.Add(3 * time.Hour)}, files, 1, 50},
+		{"gone, continue with the next modified one", logParseCheckpoint{File: "postgresql-0.csv", Offset: 50, UpdatedOn: t0.Add(30 * time.Minute)}, files, 1, 0},
+		{"gone, all older", logParseCheckpoint{File: "postgresql-0.csv", Offset: 50, UpdatedOn: t0.Add(3 * time.Hour)}, files, -1, 0},
+		{"no files", logParseCheckpoint{File: "postgresql-1.csv", Offset: 50}, nil, -1, 0},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			idx, offset := resolveRemoteLogParseCheckpoint(tc.cp, tc.files)
+			assert.Equal(t, tc.idx, idx)
+			assert.Equal(t, tc.offset, offset)
+		})
+	}
+}
+
+func TestSplitLogChunk(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		chunk    string
+		lines    []string
+		consumed int
+	}{
+		{"complete lines", "a\nbb\n", []string{"a\n", "bb\n"}, 5},
+		{"partial last line left for the next read", "a\nbb\nccc", []string{"a\n", "bb\n"}, 5},
+		{"only a partial line", "ccc", nil, 0},
+		{"a line longer than the chunk is skipped", "cccccccc", nil, 8},
+		{"empty lines", "\n\na\n", []string{"\n", "\n", "a\n"}, 4},
+		{"nothing read", "", nil, 0},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			lines, consumed := splitLogChunk([]byte(tc.chunk), 8)
+			var got []string
+			for _, l := range lines {
+				got = append(got, string(l))
+			}
+			assert.Equal(t, tc.lines, got)
+			assert.Equal(t, tc.consumed, consumed)
+		})
+	}
+}
+
+func TestRemoteLogParseInterval(t *testing.T) {
+	assert.Equal(t, 1500*time.Millisecond, remoteLogParseInterval(1.5))
+	assert.Equal(t, remoteLogParseMinInterval, remoteLogParseInterval(0.2), "sub-second intervals should not spin")
+	assert.Equal(t, remoteLogParseMinInterval, remoteLogParseInterval(0))
+}
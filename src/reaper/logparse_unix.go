@@ -0,0 +1,16 @@
+//go:build !windows
+
+package reaper
+
+import (
+	"os"
+	"syscall"
+)
+
+// fileInode returns the inode number used to identify log files across renames
+func fileInode(fi os.FileInfo) uint64 {
+	if stat, ok := fi.Sys().(*syscall.Stat_t); ok {
+		return stat.Ino
+	}
+	return 0
+}
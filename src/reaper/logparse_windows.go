@@ -0,0 +1,8 @@
+package reaper
+
+import "os"
+
+// fileInode is not supported on Windows, log files are identified by name only
+func fileInode(_ os.FileInfo) uint64 {
+	return 0
+}
@@ -0,0 +1,82 @@
+package reaper
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"sort"
+
+	"github.com/cybertec-postgresql/pgwatch3/config"
+	"github.com/cybertec-postgresql/pgwatch3/metrics"
+)
+
+// newMetricDefinitionLayers creates the metric definition sources from --metric-layers, by default only the config DB,
+// or else the metrics folder, or else the builtin metrics
+func (g *Gatherer) newMetricDefinitionLayers(configKind config.Kind) (metrics.MetricDefinitionLayers, error) {
+	layers := g.opts.GetMetricLayers()
+	if len(layers) == 0 {
+		switch {
+		case configKind == config.ConfigPgURL:
+			layers = []string{config.MetricLayerConfigDB}
+		case g.opts.Metric.MetricsFolder > "":
+			layers = []string{config.MetricLayerFolder}
+		default:
+			layers = []string{config.MetricLayerBuiltin}
+		}
+	}
+	var ret metrics.MetricDefinitionLayers
+	for _, layer := range layers {
+		switch layer {
+		case config.MetricLayerBuiltin:
+			ret = append(ret, metrics.BuiltinSource{})
+		case config.MetricLayerFolder:
+			ret = append(ret, metrics.FolderSource{Folder: g.opts.Metric.MetricsFolder})
+		case config.MetricLayerConfigDB:
+			if g.configDb == nil {
+				return nil, errors.New("--metric-layers=configdb needs a config database")
+			}
+			ret = append(ret, metrics.PostgresSource{Conn: g.configDb})
+		}
+	}
+	return ret, nil
+}
+
+// metricsFS returns the builtin and folder layers of --metric-layers merged per file, for reading presets and helpers.
+// Without such layers the metrics folder is used, or the builtin metrics if not set
+func (g *Gatherer) metricsFS() fs.FS {
+	var ret metrics.OverlayFS
+	for _, layer := range g.opts.GetMetricLayers() {
+		switch layer {
+		case config.MetricLayerBuiltin:
+			ret = append(ret, metrics.BuiltinFS())
+		case config.MetricLayerFolder:
+			ret = append(ret, os.DirFS(g.opts.Metric.MetricsFolder))
+		}
+	}
+	switch {
+	case len(ret) > 0:
+		return ret
+	case g.opts.Metric.MetricsFolder > "":
+		return os.DirFS(g.opts.Metric.MetricsFolder)
+	default:
+		return metrics.BuiltinFS()
+	}
+}
+
+// AddMetricDefinitionSource adds a source of metric definitions on top of the --metric-layers ones, i.e. its metrics
+// replace the same metrics from all other sources. Must be called before Run()
+func (g *Gatherer) AddMetricDefinitionSource(s metrics.MetricDefinitionSource) {
+	g.metricDefLayers = append(g.metricDefLayers, s)
+}
+
+// GetMetricDefinitionOrigins returns the layer each effective metric definition came from, ordered by metric name
+func (g *Gatherer) GetMetricDefinitionOrigins() []metrics.MetricOrigin {
+	g.metricDefMapLock.RLock()
+	ret := make([]metrics.MetricOrigin, 0, len(g.metricDefOrigins))
+	for _, origin := range g.metricDefOrigins {
+		ret = append(ret, origin)
+	}
+	g.metricDefMapLock.RUnlock()
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Metric < ret[j].Metric })
+	return ret
+}
@@ -0,0 +1,64 @@
+package reaper
+
+import (
+	"context"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cybertec-postgresql/pgwatch3/config"
+	"github.com/cybertec-postgresql/pgwatch3/metrics"
+	"github.com/stretchr/testify/assert"
+)
+
+type staticMetricSource metrics.MetricVersionDefs
+
+func (staticMetricSource) Name() string { return "static" }
+
+func (s staticMetricSource) GetMetricDefinitions(context.Context) (metrics.MetricVersionDefs, map[string]string, error) {
+	return metrics.MetricVersionDefs(s), nil, nil
+}
+
+func TestMetricDefinitionLayers(t *testing.T) {
+	g := newTestGatherer(t, nil)
+	assert.Equal(t, "builtin", g.metricDefLayers.Name(), "builtin metrics without a folder or config DB")
+
+	g.AddMetricDefinitionSource(staticMetricSource{"db_stats": {11: {SQL: "select 1"}}})
+	assert.NoError(t, g.LoadMetricDefs(context.Background()))
+	assert.Equal(t, "select 1", g.metricDefinitionMap["db_stats"][11].SQL)
+	for _, origin := range g.GetMetricDefinitionOrigins() {
+		switch origin.Metric {
+		case "db_stats":
+			assert.Equal(t, metrics.MetricOrigin{Metric: "db_stats", Layer: "static", Overridden: []string{"builtin"}}, origin)
+		case "wal":
+			assert.Equal(t, "builtin", origin.Layer)
+		}
+	}
+
+	_, err := NewGatherer(context.Background(), &config.Options{Connection: config.ConnectionOpts{Config: t.TempDir()},
+		Metric: config.MetricOpts{MetricLayers: "builtin,configdb"}}, nil)
+	assert.Error(t, err, "config DB layer without a config DB")
+}
+
+func TestMetricsFS(t *testing.T) {
+	folder := t.TempDir()
+	assert.NoError(t, os.MkdirAll(filepath.Join(folder, "db_stats", "11"), 0755))
+	assert.NoError(t, os.WriteFile(filepath.Join(folder, "db_stats", "11", "metric.sql"), []byte("select 1 as site"), 0644))
+	g := newTestGatherer(t, &config.Options{Metric: config.MetricOpts{MetricsFolder: folder, MetricLayers: "builtin,folder"}})
+
+	pmc, _, err := metrics.ReadPresetMetricsConfigFromFS(g.metricsFS())
+	assert.NoError(t, err, "presets should fall back to the builtin ones")
+	assert.Contains(t, pmc, "basic")
+	helpers, err := fs.Sub(g.metricsFS(), metrics.FileBasedMetricHelpersDir)
+	assert.NoError(t, err)
+	defs, _, err := metrics.ReadMetricsFromFS(context.Background(), helpers)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, defs, "helpers should fall back to the builtin ones")
+	assert.NoError(t, g.LoadMetricDefs(context.Background()))
+	assert.Equal(t, "select 1 as site", g.metricDefinitionMap["db_stats"][1100].SQL)
+
+	g = newTestGatherer(t, &config.Options{Metric: config.MetricOpts{MetricsFolder: folder}})
+	_, _, err = metrics.ReadPresetMetricsConfigFromFS(g.metricsFS())
+	assert.Error(t, err, "without layers the folder alone is used")
+}
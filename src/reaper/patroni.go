@@ -1,4 +1,4 @@
-package main
+package reaper
 
 import (
 	"context"
@@ -28,20 +28,17 @@ type PatroniClusterMember struct {
 	Role    string
 }
 
-var lastFoundClusterMembers = make(map[string][]PatroniClusterMember) // needed for cases where DCS is temporarily down
-// don't want to immediately remove monitoring of DBs
-
-func parseHostAndPortFromJdbcConnStr(connStr string) (string, string, error) {
+func (g *Gatherer) parseHostAndPortFromJdbcConnStr(connStr string) (string, string, error) {
 	r := regexp.MustCompile(`postgres://(.*)+:([0-9]+)/`)
 	matches := r.FindStringSubmatch(connStr)
 	if len(matches) != 3 {
-		logger.Errorf("Unexpected regex result groups:", matches)
+		g.logger.Errorf("Unexpected regex result groups:", matches)
 		return "", "", fmt.Errorf("unexpected regex result groups: %v", matches)
 	}
 	return matches[1], matches[2], nil
 }
 
-func getConsulClusterMembers(database MonitoredDatabase) ([]PatroniClusterMember, error) {
+func (g *Gatherer) getConsulClusterMembers(database MonitoredDatabase) ([]PatroniClusterMember, error) {
 	var ret []PatroniClusterMember
 
 	if len(database.HostConfig.DcsEndpoints) == 0 {
@@ -55,7 +52,7 @@ func getConsulClusterMembers(database MonitoredDatabase) ([]PatroniClusterMember
 	}
 	client, err := consul_api.NewClient(&config)
 	if err != nil {
-		logger.Error("Could not connect to Consul", err)
+		g.logger.Error("Could not connect to Consul", err)
 		return ret, err
 	}
 
@@ -64,15 +61,15 @@ func getConsulClusterMembers(database MonitoredDatabase) ([]PatroniClusterMember
 	membersPath := path.Join(database.HostConfig.Namespace, database.HostConfig.Scope, "members")
 	members, _, err := kv.List(membersPath, nil)
 	if err != nil {
-		logger.Error("Could not read Patroni members from Consul:", err)
+		g.logger.Error("Could not read Patroni members from Consul:", err)
 		return ret, err
 	}
 	for _, member := range members {
 		name := path.Base(member.Key)
-		logger.Debugf("Found a cluster member from Consul: %+v", name)
+		g.logger.Debugf("Found a cluster member from Consul: %+v", name)
 		nodeData, err := jsonTextToStringMap(string(member.Value))
 		if err != nil {
-			logger.Errorf("Could not parse Consul node data for node \"%s\": %s", name, err)
+			g.logger.Errorf("Could not parse Consul node data for node \"%s\": %s", name, err)
 			continue
 		}
 		role := nodeData["role"]
@@ -122,7 +119,7 @@ func getTransport(conf HostConfigAttrs) (*tls.Config, error) {
 	return tlsClientConfig, nil
 }
 
-func getEtcdClusterMembers(database MonitoredDatabase) ([]PatroniClusterMember, error) {
+func (g *Gatherer) getEtcdClusterMembers(database MonitoredDatabase) ([]PatroniClusterMember, error) {
 	var ret = make([]PatroniClusterMember, 0)
 	var cfg client.Config
 
@@ -144,7 +141,7 @@ func getEtcdClusterMembers(database MonitoredDatabase) ([]PatroniClusterMember,
 
 	c, err := client.New(cfg)
 	if err != nil {
-		logger.Errorf("[%s ]Could not connect to ETCD: %v", database.DBUniqueName, err)
+		g.logger.Errorf("[%s ]Could not connect to ETCD: %v", database.DBUniqueName, err)
 		return ret, err
 	}
 	kapi := c.KV
@@ -163,23 +160,23 @@ func getEtcdClusterMembers(database MonitoredDatabase) ([]PatroniClusterMember,
 
 		for _, node := range resp.Kvs {
 			scope := path.Base(string(node.Key)) // Key="/service/batman"
-			scopeMembers, err := extractEtcdScopeMembers(database, scope, kapi, true)
+			scopeMembers, err := g.extractEtcdScopeMembers(database, scope, kapi, true)
 			if err != nil {
 				continue
 			}
 			ret = append(ret, scopeMembers...)
 		}
 	} else {
-		ret, err = extractEtcdScopeMembers(database, database.HostConfig.Scope, kapi, false)
+		ret, err = g.extractEtcdScopeMembers(database, database.HostConfig.Scope, kapi, false)
 		if err != nil {
 			return ret, err
 		}
 	}
-	lastFoundClusterMembers[database.DBUniqueName] = ret
+	g.lastFoundClusterMembers[database.DBUniqueName] = ret
 	return ret, nil
 }
 
-func extractEtcdScopeMembers(database MonitoredDatabase, scope string, kapi client.KV, addScopeToName bool) ([]PatroniClusterMember, error) {
+func (g *Gatherer) extractEtcdScopeMembers(database MonitoredDatabase, scope string, kapi client.KV, addScopeToName bool) ([]PatroniClusterMember, error) {
 	var ret = make([]PatroniClusterMember, 0)
 	var name string
 	membersPath := path.Join(database.HostConfig.Namespace, scope, "members")
@@ -188,13 +185,13 @@ func extractEtcdScopeMembers(database MonitoredDatabase, scope string, kapi clie
 	if err != nil {
 		return nil, err
 	}
-	logger.Debugf("ETCD response for %s scope %s: %+v", database.DBUniqueName, scope, resp)
+	g.logger.Debugf("ETCD response for %s scope %s: %+v", database.DBUniqueName, scope, resp)
 
 	for _, node := range resp.Kvs {
-		logger.Debugf("Found a cluster member from etcd [%s:%s]: %+v", database.DBUniqueName, scope, node.Value)
+		g.logger.Debugf("Found a cluster member from etcd [%s:%s]: %+v", database.DBUniqueName, scope, node.Value)
 		nodeData, err := jsonTextToStringMap(string(node.Value))
 		if err != nil {
-			logger.Errorf("Could not parse ETCD node data for node \"%s\": %s", node, err)
+			g.logger.Errorf("Could not parse ETCD node data for node \"%s\": %s", node, err)
 			continue
 		}
 		role := nodeData["role"]
@@ -210,7 +207,7 @@ func extractEtcdScopeMembers(database MonitoredDatabase, scope string, kapi clie
 	return ret, nil
 }
 
-func getZookeeperClusterMembers(database MonitoredDatabase) ([]PatroniClusterMember, error) {
+func (g *Gatherer) getZookeeperClusterMembers(database MonitoredDatabase) ([]PatroniClusterMember, error) {
 	var ret []PatroniClusterMember
 
 	if len(database.HostConfig.DcsEndpoints) == 0 {
@@ -229,15 +226,15 @@ func getZookeeperClusterMembers(database MonitoredDatabase) ([]PatroniClusterMem
 	}
 
 	for _, member := range members {
-		logger.Debugf("Found a cluster member from Zookeeper: %+v", member)
+		g.logger.Debugf("Found a cluster member from Zookeeper: %+v", member)
 		keyData, _, err := c.Get(path.Join(database.HostConfig.Namespace, database.HostConfig.Scope, "members", member))
 		if err != nil {
-			logger.Errorf("Could not read member (%s) info from Zookeeper:", member, err)
+			g.logger.Errorf("Could not read member (%s) info from Zookeeper:", member, err)
 			continue
 		}
 		nodeData, err := jsonTextToStringMap(string(keyData))
 		if err != nil {
-			logger.Errorf("Could not parse Zookeeper node data for node \"%s\": %s", member, err)
+			g.logger.Errorf("Could not parse Zookeeper node data for node \"%s\": %s", member, err)
 			continue
 		}
 		role := nodeData["role"]
@@ -250,7 +247,7 @@ func getZookeeperClusterMembers(database MonitoredDatabase) ([]PatroniClusterMem
 	return ret, nil
 }
 
-func ResolveDatabasesFromPatroni(ce MonitoredDatabase) ([]MonitoredDatabase, error) {
+func (g *Gatherer) ResolveDatabasesFromPatroni(ce MonitoredDatabase) ([]MonitoredDatabase, error) {
 	var md []MonitoredDatabase
 	var cm []PatroniClusterMember
 	var err error
@@ -258,44 +255,44 @@ func ResolveDatabasesFromPatroni(ce MonitoredDatabase) ([]MonitoredDatabase, err
 	var dbUnique string
 
 	if ce.DBType == config.DbTypePatroniNamespaceDiscovery && ce.HostConfig.DcsType != dcsTypeEtcd {
-		logger.Warningf("Skipping Patroni monitoring entry \"%s\" as currently only ETCD namespace scanning is supported...", ce.DBUniqueName)
+		g.logger.Warningf("Skipping Patroni monitoring entry \"%s\" as currently only ETCD namespace scanning is supported...", ce.DBUniqueName)
 		return md, nil
 	}
-	logger.Debugf("Resolving Patroni nodes for \"%s\" from HostConfig: %+v", ce.DBUniqueName, ce.HostConfig)
+	g.logger.Debugf("Resolving Patroni nodes for \"%s\" from HostConfig: %+v", ce.DBUniqueName, ce.HostConfig)
 	if ce.HostConfig.DcsType == dcsTypeEtcd {
-		cm, err = getEtcdClusterMembers(ce)
+		cm, err = g.getEtcdClusterMembers(ce)
 	} else if ce.HostConfig.DcsType == dcsTypeZookeeper {
-		cm, err = getZookeeperClusterMembers(ce)
+		cm, err = g.getZookeeperClusterMembers(ce)
 	} else if ce.HostConfig.DcsType == dcsTypeConsul {
-		cm, err = getConsulClusterMembers(ce)
+		cm, err = g.getConsulClusterMembers(ce)
 	} else {
-		logger.Error("unknown DCS", ce.HostConfig.DcsType)
+		g.logger.Error("unknown DCS", ce.HostConfig.DcsType)
 		return md, errors.New("unknown DCS")
 	}
 	if err != nil {
-		logger.Warningf("Failed to get info from DCS for %s, using previous member info if any", ce.DBUniqueName)
-		cm, ok = lastFoundClusterMembers[ce.DBUniqueName]
+		g.logger.Warningf("Failed to get info from DCS for %s, using previous member info if any", ce.DBUniqueName)
+		cm, ok = g.lastFoundClusterMembers[ce.DBUniqueName]
 		if ok { // mask error from main loop not to remove monitored DBs due to "jitter"
 			err = nil
 		}
 	} else {
-		lastFoundClusterMembers[ce.DBUniqueName] = cm
+		g.lastFoundClusterMembers[ce.DBUniqueName] = cm
 	}
 	if len(cm) == 0 {
-		logger.Warningf("No Patroni cluster members found for cluster [%s:%s]", ce.DBUniqueName, ce.HostConfig.Scope)
+		g.logger.Warningf("No Patroni cluster members found for cluster [%s:%s]", ce.DBUniqueName, ce.HostConfig.Scope)
 		return md, nil
 	}
-	logger.Infof("Found %d Patroni members for entry %s", len(cm), ce.DBUniqueName)
+	g.logger.Infof("Found %d Patroni members for entry %s", len(cm), ce.DBUniqueName)
 
 	for _, m := range cm {
-		logger.Infof("Processing Patroni cluster member [%s:%s]", ce.DBUniqueName, m.Name)
+		g.logger.Infof("Processing Patroni cluster member [%s:%s]", ce.DBUniqueName, m.Name)
 		if ce.OnlyIfMaster && m.Role != "master" {
-			logger.Infof("Skipping over Patroni cluster member [%s:%s] as not a master", ce.DBUniqueName, m.Name)
+			g.logger.Infof("Skipping over Patroni cluster member [%s:%s] as not a master", ce.DBUniqueName, m.Name)
 			continue
 		}
-		host, port, err := parseHostAndPortFromJdbcConnStr(m.ConnURL)
+		host, port, err := g.parseHostAndPortFromJdbcConnStr(m.ConnURL)
 		if err != nil {
-			logger.Errorf("Could not parse Patroni conn str \"%s\" [%s:%s]: %v", m.ConnURL, ce.DBUniqueName, m.Scope, err)
+			g.logger.Errorf("Could not parse Patroni conn str \"%s\" [%s:%s]: %v", m.ConnURL, ce.DBUniqueName, m.Scope, err)
 			continue
 		}
 		if ce.OnlyIfMaster {
@@ -313,6 +310,8 @@ func ResolveDatabasesFromPatroni(ce MonitoredDatabase) ([]MonitoredDatabase, err
 				ConnStr:          ce.ConnStr,
 				Encryption:       ce.Encryption,
 				Metrics:          ce.Metrics,
+				Schedules:        ce.Schedules,
+				Timezone:         ce.Timezone,
 				PresetMetrics:    ce.PresetMetrics,
 				IsSuperuser:      ce.IsSuperuser,
 				CustomTags:       ce.CustomTags,
@@ -320,7 +319,7 @@ func ResolveDatabasesFromPatroni(ce MonitoredDatabase) ([]MonitoredDatabase, err
 				DBType:           "postgres"})
 			continue
 		}
-		c, err := db.GetPostgresDBConnection(mainContext, ce.ConnStr,
+		c, err := db.GetPostgresDBConnection(g.mainContext, ce.ConnStr,
 			func(c *pgxpool.Config) error {
 				c.ConnConfig.Host = host
 				c.ConnConfig.Database = "template1"
@@ -330,7 +329,7 @@ func ResolveDatabasesFromPatroni(ce MonitoredDatabase) ([]MonitoredDatabase, err
 				return err
 			})
 		if err != nil {
-			logger.Errorf("Could not contact Patroni member [%s:%s]: %v", ce.DBUniqueName, m.Scope, err)
+			g.logger.Errorf("Could not contact Patroni member [%s:%s]: %v", ce.DBUniqueName, m.Scope, err)
 			continue
 		}
 		defer c.Close()
@@ -343,9 +342,9 @@ func ResolveDatabasesFromPatroni(ce MonitoredDatabase) ([]MonitoredDatabase, err
 					and case when length(trim($1)) > 0 then datname ~ $2 else true end
 					and case when length(trim($3)) > 0 then not datname ~ $4 else true end`
 
-		data, err := DBExecRead(mainContext, c, sql, ce.DBNameIncludePattern, ce.DBNameIncludePattern, ce.DBNameExcludePattern, ce.DBNameExcludePattern)
+		data, err := DBExecRead(g.mainContext, c, sql, ce.DBNameIncludePattern, ce.DBNameIncludePattern, ce.DBNameExcludePattern, ce.DBNameExcludePattern)
 		if err != nil {
-			logger.Errorf("Could not get DB name listing from Patroni member [%s:%s]: %v", ce.DBUniqueName, m.Scope, err)
+			g.logger.Errorf("Could not get DB name listing from Patroni member [%s:%s]: %v", ce.DBUniqueName, m.Scope, err)
 			continue
 		}
 
@@ -362,6 +361,8 @@ func ResolveDatabasesFromPatroni(ce MonitoredDatabase) ([]MonitoredDatabase, err
 				ConnStr:          connURL.String(),
 				Encryption:       ce.Encryption,
 				Metrics:          ce.Metrics,
+				Schedules:        ce.Schedules,
+				Timezone:         ce.Timezone,
 				PresetMetrics:    ce.PresetMetrics,
 				IsSuperuser:      ce.IsSuperuser,
 				CustomTags:       ce.CustomTags,
@@ -0,0 +1,2235 @@
+package reaper
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cybertec-postgresql/pgwatch3/config"
+	"github.com/cybertec-postgresql/pgwatch3/metrics"
+	"github.com/cybertec-postgresql/pgwatch3/psutil"
+	"github.com/cybertec-postgresql/pgwatch3/sinks"
+	"github.com/jackc/pgx/v5"
+	"github.com/shopspring/decimal"
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"golang.org/x/crypto/pbkdf2"
+	"gopkg.in/yaml.v2"
+)
+
+type MonitoredDatabase struct {
+	DBUniqueName         string `yaml:"unique_name"`
+	DBUniqueNameOrig     string // to preserve belonging to a specific instance for continuous modes where DBUniqueName will be dynamic
+	Group                string
+	Encryption           string             `yaml:"encryption"`
+	ConnStr              string             `yaml:"conn_str"`
+	Metrics              map[string]float64 `yaml:"-"` // custom_metrics, see UnmarshalYAML()
+	MetricsStandby       map[string]float64 `yaml:"-"` // custom_metrics_standby
+	Schedules            map[string]string  `yaml:"-"` // metrics configured with a schedule instead of an interval
+	SchedulesStandby     map[string]string  `yaml:"-"`
+	Timezone             string             `yaml:"timezone"` // for metric schedules, by default the gatherer's one
+	DBType               string
+	DBNameIncludePattern string            `yaml:"dbname_include_pattern"`
+	DBNameExcludePattern string            `yaml:"dbname_exclude_pattern"`
+	PresetMetrics        string            `yaml:"preset_metrics"`
+	PresetMetricsStandby string            `yaml:"preset_metrics_standby"`
+	IsSuperuser          bool              `yaml:"is_superuser"`
+	IsEnabled            bool              `yaml:"is_enabled"`
+	CustomTags           map[string]string `yaml:"custom_tags"`
+	HostConfig           HostConfigAttrs   `yaml:"host_config"`
+	OnlyIfMaster         bool              `yaml:"only_if_master"`
+}
+
+// UnmarshalYAML accepts schedules besides intervals in custom_metrics, see metrics.ParseMetricIntervals()
+func (md *MonitoredDatabase) UnmarshalYAML(unmarshal func(any) error) (err error) {
+	type plain MonitoredDatabase
+	var metricConfigs struct {
+		Metrics        map[string]any `yaml:"custom_metrics"`
+		MetricsStandby map[string]any `yaml:"custom_metrics_standby"`
+	}
+	if err = unmarshal((*plain)(md)); err != nil {
+		return
+	}
+	if err = unmarshal(&metricConfigs); err != nil {
+		return
+	}
+	if md.Metrics, md.Schedules, err = metrics.ParseMetricIntervals(metricConfigs.Metrics); err != nil {
+		return
+	}
+	md.MetricsStandby, md.SchedulesStandby, err = metrics.ParseMetricIntervals(metricConfigs.MetricsStandby)
+	return
+}
+
+func (md MonitoredDatabase) GetDatabaseName() string {
+	if conf, err := pgx.ParseConfig(md.ConnStr); err == nil {
+		return conf.Database
+	}
+	return ""
+}
+
+type HostConfigAttrs struct {
+	DcsType                string   `yaml:"dcs_type"`
+	DcsEndpoints           []string `yaml:"dcs_endpoints"`
+	Scope                  string
+	Namespace              string
+	Username               string
+	Password               string
+	CAFile                 string                             `yaml:"ca_file"`
+	CertFile               string                             `yaml:"cert_file"`
+	KeyFile                string                             `yaml:"key_file"`
+	LogsGlobPath           string                             `yaml:"logs_glob_path"`   // default $data_directory / $log_directory / *.csvlog
+	LogsMatchRegex         string                             `yaml:"logs_match_regex"` // default is for CSVLOG format. needs to capture following named groups: log_time, user_name, database_name and error_severity
+	LogsRemote             bool                               `yaml:"logs_remote"`      // read logs over SQL via pg_ls_logdir() / pg_read_binary_file() instead of the local filesystem
+	PerMetricDisabledTimes []HostConfigPerMetricDisabledTimes `yaml:"per_metric_disabled_intervals"`
+	ScheduleSplaySeconds   float64                            `yaml:"schedule_splay_seconds"` // overrides --schedule-splay
+}
+
+type HostConfigPerMetricDisabledTimes struct { // metric gathering override per host / metric / time
+	Metrics       []string `yaml:"metrics"`
+	DisabledTimes []string `yaml:"disabled_times"`
+	DisabledDays  string   `yaml:"disabled_days"`
+}
+
+type ControlMessage struct {
+	Action   string // START, STOP, PAUSE
+	Config   map[string]float64
+	Schedule string
+}
+
+type MetricFetchMessage struct {
+	DBUniqueName        string
+	DBUniqueNameOrig    string
+	MetricName          string
+	DBType              string
+	Interval            time.Duration
+	CreatedOn           time.Time
+	StmtTimeoutOverride int64
+}
+
+type ChangeDetectionResults struct { // for passing around DDL/index/config change detection results
+	Created int
+	Altered int
+	Dropped int
+}
+
+type DBVersionMapEntry struct {
+	LastCheckedOn    time.Time
+	IsInRecovery     bool
+	Version          uint
+	VersionStr       string
+	RealDbname       string
+	SystemIdentifier string
+	IsSuperuser      bool // if true and no helpers are installed, use superuser SQL version of metric if available
+	Extensions       map[string]uint
+	ExecEnv          string
+	ApproxDBSizeB    int64
+}
+
+// PingError is returned by Run() with --ping if not all configured DBs could be reached
+type PingError struct {
+	Unreachable int
+	Total       int
+}
+
+func (e *PingError) Error() string {
+	return fmt.Sprintf("could not reach %d configured DB host(s) out of %d", e.Unreachable, e.Total)
+}
+
+type ExistingPartitionInfo struct {
+	StartTime time.Time
+	EndTime   time.Time
+}
+
+const (
+	epochColumnName                 string        = "epoch_ns" // this column (epoch in nanoseconds) is expected in every metric query
+	tagPrefix                       string        = "tag_"
+	metricDefinitionRefreshInterval time.Duration = time.Minute * 2 // min time before checking for new/changed metric definitions
+	persistQueueMaxSize                           = 10000           // storage queue max elements. when reaching the limit, older metrics will be dropped.
+)
+
+const (
+	gathererStatusStart     = "START"
+	gathererStatusStop      = "STOP"
+	metricdbIdent           = "metricDb"
+	configdbIdent           = "configDb"
+	contextPrometheusScrape = "prometheus-scrape"
+	dcsTypeEtcd             = "etcd"
+	dcsTypeZookeeper        = "zookeeper"
+	dcsTypeConsul           = "consul"
+
+	monitoredDbsDatastoreSyncIntervalSeconds = 600              // write actively monitored DBs listing to metrics store after so many seconds
+	monitoredDbsDatastoreSyncMetricName      = "configured_dbs" // FYI - for Postgres datastore there's also the admin.all_unique_dbnames table with all recent DB unique names with some metric data
+	recoPrefix                               = "reco_"          // special handling for metrics with such prefix, data stored in RECO_METRIC_NAME
+	recoMetricName                           = "recommendations"
+	specialMetricChangeEvents                = "change_events"
+	specialMetricServerLogEventCounts        = "server_log_event_counts"
+	specialMetricPgbouncer                   = "^pgbouncer_(stats|pools)$"
+	specialMetricPgpoolStats                 = "pgpool_stats"
+	specialMetricInstanceUp                  = "instance_up"
+	specialMetricDbSize                      = "db_size"     // can be transparently switched to db_size_approx on instances with very slow FS access (Azure Single Server)
+	specialMetricTableStats                  = "table_stats" // can be transparently switched to table_stats_approx on instances with very slow FS (Azure Single Server)
+	metricCPULoad                            = "cpu_load"
+	metricPsutilCPU                          = "psutil_cpu"
+	metricPsutilDisk                         = "psutil_disk"
+	metricPsutilDiskIoTotal                  = "psutil_disk_io_total"
+	metricPsutilMem                          = "psutil_mem"
+
+	dbSizeCachingInterval = 30 * time.Minute
+	dbMetricJoinStr       = "¤¤¤" // just some unlikely string for a DB name to avoid using maps of maps for DB+metric data
+	execEnvUnknown        = "UNKNOWN"
+	execEnvAzureSingle    = "AZURE_SINGLE"
+	execEnvAzureFlexible  = "AZURE_FLEXIBLE"
+	execEnvGoogle         = "GOOGLE"
+)
+
+var dbTypeMap = map[string]bool{config.DbTypePg: true, config.DbTypePgCont: true, config.DbTypeBouncer: true, config.DbTypePatroni: true, config.DbTypePatroniCont: true, config.DbTypePgPOOL: true, config.DbTypePatroniNamespaceDiscovery: true}
+var dbTypes = []string{config.DbTypePg, config.DbTypePgCont, config.DbTypeBouncer, config.DbTypePatroni, config.DbTypePatroniCont, config.DbTypePatroniNamespaceDiscovery} // used for informational purposes
+var specialMetrics = map[string]bool{recoMetricName: true, specialMetricChangeEvents: true, specialMetricServerLogEventCounts: true}
+var directlyFetchableOSMetrics = map[string]bool{metricPsutilCPU: true, metricPsutilDisk: true, metricPsutilDiskIoTotal: true, metricPsutilMem: true, metricCPULoad: true}
+
+var MinExtensionInfoAvailable uint = 901
+var regexIsAlpha = regexp.MustCompile("^[a-zA-Z]+$")
+var rBouncerAndPgpoolVerMatch = regexp.MustCompile(`\d+\.+\d+`) // extract $major.minor from "4.1.2 (karasukiboshi)" or "PgBouncer 1.12.0"
+var regexIsPgbouncerMetrics = regexp.MustCompile(specialMetricPgbouncer)
+
+var pgBouncerNumericCountersStartVersion = VersionToInt("1.12") // pgBouncer changed internal counters data type in v1.12
+
+// VersionToInt parses a given version and returns an integer  or
+// an error if unable to parse the version. Only parses valid semantic versions.
+// Performs checking that can find errors within the version.
+// Examples: v1.2 -> 0102, v9.6.3 -> 0906, v11 -> 1100
+func VersionToInt(v string) uint {
+	if len(v) == 0 {
+		return 0
+	}
+	var major int
+	var minor int
+
+	matches := regexp.MustCompile(`(\d+).?(\d*)`).FindStringSubmatch(v)
+	if len(matches) == 0 {
+		return 0
+	}
+	if len(matches) > 1 {
+		major, _ = strconv.Atoi(matches[1])
+		if len(matches) > 2 {
+			minor, _ = strconv.Atoi(matches[2])
+		}
+	}
+	return uint(major*100 + minor)
+}
+
+func (g *Gatherer) RestoreSQLConnPoolLimitsForPreviouslyDormantDB(dbUnique string) {
+	if !g.opts.UseConnPooling {
+		return
+	}
+	g.monitoredDbConnCacheLock.Lock()
+	defer g.monitoredDbConnCacheLock.Unlock()
+
+	conn, ok := g.monitoredDbConnCache[dbUnique]
+	if !ok || conn == nil {
+		g.logger.Error("DB conn to re-instate pool limits not found, should not happen")
+		return
+	}
+
+	g.logger.Debugf("[%s] Re-instating SQL connection pool max connections ...", dbUnique)
+
+	// conn.SetMaxIdleConns(opts.MaxParallelConnectionsPerDb)
+	// conn.SetMaxOpenConns(opts.MaxParallelConnectionsPerDb)
+
+}
+
+func (g *Gatherer) InitPGVersionInfoFetchingLockIfNil(md MonitoredDatabase) {
+	g.dbPgVersionMapLock.Lock()
+	if _, ok := g.dbGetPgVersionMapLock[md.DBUniqueName]; !ok {
+		g.dbGetPgVersionMapLock[md.DBUniqueName] = &sync.RWMutex{}
+	}
+	g.dbPgVersionMapLock.Unlock()
+}
+
+func (g *Gatherer) GetMonitoredDatabasesFromConfigDB() ([]MonitoredDatabase, error) {
+	monitoredDBs := make([]MonitoredDatabase, 0)
+	activeHostData, err := g.GetAllActiveHostsFromConfigDB()
+	groups := strings.Split(g.opts.Metric.Group, ",")
+	skippedEntries := 0
+
+	if err != nil {
+		g.logger.Errorf("Failed to read monitoring config from DB: %s", err)
+		return monitoredDBs, err
+	}
+
+	for _, row := range activeHostData {
+
+		if len(g.opts.Metric.Group) > 0 { // filter out rows with non-matching groups
+			matched := false
+			for _, group := range groups {
+				if row["md_group"].(string) == group {
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				skippedEntries++
+				continue
+			}
+		}
+		if skippedEntries > 0 {
+			g.logger.Infof("Filtered out %d config entries based on --groups input", skippedEntries)
+		}
+
+		metricConfig, metricSchedules, err := jsonTextToMap(row["md_config"].(string))
+		if err != nil {
+			g.logger.Warningf("Cannot parse metrics JSON config for \"%s\": %v", row["md_name"].(string), err)
+			continue
+		}
+		metricConfigStandby := make(map[string]float64)
+		var metricSchedulesStandby map[string]string
+		if configStandby, ok := row["md_config_standby"]; ok {
+			metricConfigStandby, metricSchedulesStandby, err = jsonTextToMap(configStandby.(string))
+			if err != nil {
+				g.logger.Warningf("Cannot parse standby metrics JSON config for \"%s\". Ignoring standby config: %v", row["md_name"].(string), err)
+			}
+		}
+		customTags, err := jsonTextToStringMap(row["md_custom_tags"].(string))
+		if err != nil {
+			g.logger.Warningf("Cannot parse custom tags JSON for \"%s\". Ignoring custom tags. Error: %v", row["md_name"].(string), err)
+			customTags = nil
+		}
+		hostConfigAttrs := HostConfigAttrs{}
+		err = yaml.Unmarshal([]byte(row["md_host_config"].(string)), &hostConfigAttrs)
+		if err != nil {
+			g.logger.Warningf("Cannot parse host config JSON for \"%s\". Ignoring host config. Error: %v", row["md_name"].(string), err)
+		}
+
+		md := MonitoredDatabase{
+			DBUniqueName:         row["md_name"].(string),
+			DBUniqueNameOrig:     row["md_name"].(string),
+			IsSuperuser:          row["md_is_superuser"].(bool),
+			ConnStr:              row["md_connstr"].(string),
+			Encryption:           row["md_encryption"].(string),
+			Metrics:              metricConfig,
+			MetricsStandby:       metricConfigStandby,
+			Schedules:            metricSchedules,
+			SchedulesStandby:     metricSchedulesStandby,
+			Timezone:             row["md_timezone"].(string),
+			DBType:               row["md_dbtype"].(string),
+			DBNameIncludePattern: row["md_include_pattern"].(string),
+			DBNameExcludePattern: row["md_exclude_pattern"].(string),
+			Group:                row["md_group"].(string),
+			HostConfig:           hostConfigAttrs,
+			OnlyIfMaster:         row["md_only_if_master"].(bool),
+			CustomTags:           customTags}
+
+		if _, ok := dbTypeMap[md.DBType]; !ok {
+			g.logger.Warningf("Ignoring host \"%s\" - unknown dbtype: %s. Expected one of: %+v", md.DBUniqueName, md.DBType, dbTypes)
+			continue
+		}
+
+		if md.Encryption == "aes-gcm-256" && g.opts.AesGcmKeyphrase != "" {
+			md.ConnStr = g.decrypt(md.DBUniqueName, g.opts.AesGcmKeyphrase, md.ConnStr)
+		}
+
+		if md.DBType == config.DbTypePgCont {
+			resolved, err := g.ResolveDatabasesFromConfigEntry(md)
+			if err != nil {
+				g.logger.Errorf("Failed to resolve DBs for \"%s\": %s", md.DBUniqueName, err)
+				if md.Encryption == "aes-gcm-256" && g.opts.AesGcmKeyphrase == "" {
+					g.logger.Errorf("No decryption key set. Use the --aes-gcm-keyphrase or --aes-gcm-keyphrase params to set")
+				}
+				continue
+			}
+			tempArr := make([]string, 0)
+			for _, rdb := range resolved {
+				monitoredDBs = append(monitoredDBs, rdb)
+				tempArr = append(tempArr, rdb.ConnStr)
+			}
+			g.logger.Debugf("Resolved %d DBs with prefix \"%s\": [%s]", len(resolved), md.DBUniqueName, strings.Join(tempArr, ", "))
+		} else if md.DBType == config.DbTypePatroni || md.DBType == config.DbTypePatroniCont || md.DBType == config.DbTypePatroniNamespaceDiscovery {
+			resolved, err := g.ResolveDatabasesFromPatroni(md)
+			if err != nil {
+				g.logger.Errorf("Failed to resolve DBs for \"%s\": %s", md.DBUniqueName, err)
+				continue
+			}
+			tempArr := make([]string, 0)
+			for _, rdb := range resolved {
+				monitoredDBs = append(monitoredDBs, rdb)
+				tempArr = append(tempArr, rdb.ConnStr)
+			}
+			g.logger.Debugf("Resolved %d DBs with prefix \"%s\": [%s]", len(resolved), md.DBUniqueName, strings.Join(tempArr, ", "))
+		} else {
+			monitoredDBs = append(monitoredDBs, md)
+		}
+	}
+	return monitoredDBs, err
+}
+
+func (g *Gatherer) GetMonitoredDatabaseByUniqueName(name string) (MonitoredDatabase, error) {
+	g.monitoredDbCacheLock.RLock()
+	defer g.monitoredDbCacheLock.RUnlock()
+	_, exists := g.monitoredDbCache[name]
+	if !exists {
+		return MonitoredDatabase{}, errors.New("DBUnique not found")
+	}
+	return g.monitoredDbCache[name], nil
+}
+
+func (g *Gatherer) UpdateMonitoredDBCache(data []MonitoredDatabase) {
+	monitoredDbCacheNew := make(map[string]MonitoredDatabase)
+
+	for _, row := range data {
+		monitoredDbCacheNew[row.DBUniqueName] = row
+	}
+
+	g.monitoredDbCacheLock.Lock()
+	g.monitoredDbCache = monitoredDbCacheNew
+	g.monitoredDbCacheLock.Unlock()
+}
+
+type UIntSlice []uint
+
+func (x UIntSlice) Len() int           { return len(x) }
+func (x UIntSlice) Less(i, j int) bool { return x[i] < x[j] }
+func (x UIntSlice) Swap(i, j int)      { x[i], x[j] = x[j], x[i] }
+
+// assumes upwards compatibility for versions
+func (g *Gatherer) GetMetricVersionProperties(metric string, vme DBVersionMapEntry, metricDefMap map[string]map[uint]metrics.MetricProperties) (metrics.MetricProperties, error) {
+	var keys UIntSlice
+	var mdm metrics.MetricVersionDefs
+
+	if metricDefMap != nil {
+		mdm = metricDefMap
+	} else {
+		g.metricDefMapLock.RLock()
+		mdm = deepCopyMetricDefinitionMap(g.metricDefinitionMap) // copy of global cache
+		g.metricDefMapLock.RUnlock()
+	}
+
+	_, ok := mdm[metric]
+	if !ok || len(mdm[metric]) == 0 {
+		g.logger.Debug("metric", metric, "not found")
+		return metrics.MetricProperties{}, errors.New("metric SQL not found")
+	}
+
+	for k := range mdm[metric] {
+		keys = append(keys, k)
+	}
+
+	sort.Sort(keys)
+
+	var bestVer uint
+	var minVer uint
+	var found bool
+	for _, ver := range keys {
+		if vme.Version >= ver {
+			bestVer = ver
+			found = true
+		}
+		if minVer == 0 || ver < minVer {
+			minVer = ver
+		}
+	}
+
+	if !found {
+		if vme.Version < minVer { // metric not yet available for given PG ver
+			return metrics.MetricProperties{}, fmt.Errorf("no suitable SQL found for metric \"%s\", server version \"%s\" too old. min defined SQL ver: %d", metric, vme.VersionStr, minVer)
+		}
+		return metrics.MetricProperties{}, fmt.Errorf("no suitable SQL found for metric \"%s\", version \"%s\"", metric, vme.VersionStr)
+	}
+
+	ret := mdm[metric][bestVer]
+
+	// check if SQL def. override defined for some specific extension version and replace the metric SQL-s if so
+	if ret.MetricAttrs.ExtensionVersionOverrides != nil && len(ret.MetricAttrs.ExtensionVersionOverrides) > 0 {
+		if vme.Extensions != nil && len(vme.Extensions) > 0 {
+			g.logger.Debugf("[%s] extension version based override request found: %+v", metric, ret.MetricAttrs.ExtensionVersionOverrides)
+			for _, extOverride := range ret.MetricAttrs.ExtensionVersionOverrides {
+				var matching = true
+				for _, extVer := range extOverride.ExpectedExtensionVersions { // "natural" sorting of metric definition assumed
+					installedExtVer, ok := vme.Extensions[extVer.ExtName]
+					if !ok || installedExtVer < VersionToInt(extVer.ExtMinVersion) {
+						matching = false
+					}
+				}
+				if matching { // all defined extensions / versions (if many) need to match
+					_, ok := mdm[extOverride.TargetMetric]
+					if !ok {
+						g.logger.Warningf("extension based override metric not found for metric %s. substitute metric name: %s", metric, extOverride.TargetMetric)
+						continue
+					}
+					mvp, err := g.GetMetricVersionProperties(extOverride.TargetMetric, vme, mdm)
+					if err != nil {
+						g.logger.Warningf("undefined extension based override for metric %s, substitute metric name: %s, version: %s not found", metric, extOverride.TargetMetric, bestVer)
+						continue
+					}
+					g.logger.Debugf("overriding metric %s based on the extension_version_based_overrides metric attribute with %s:%s", metric, extOverride.TargetMetric, bestVer)
+					if mvp.SQL != "" {
+						ret.SQL = mvp.SQL
+					}
+					if mvp.SQLSU != "" {
+						ret.SQLSU = mvp.SQLSU
+					}
+				}
+			}
+		}
+	}
+	return ret, nil
+}
+
+func (g *Gatherer) GetAllRecoMetricsForVersion(vme DBVersionMapEntry) map[string]metrics.MetricProperties {
+	mvpMap := make(map[string]metrics.MetricProperties)
+	g.metricDefMapLock.RLock()
+	defer g.metricDefMapLock.RUnlock()
+	for m := range g.metricDefinitionMap {
+		if strings.HasPrefix(m, recoPrefix) {
+			mvp, err := g.GetMetricVersionProperties(m, vme, g.metricDefinitionMap)
+			if err != nil {
+				g.logger.Warningf("Could not get SQL definition for metric \"%s\", PG %s", m, vme.VersionStr)
+			} else if !mvp.MetricAttrs.IsPrivate {
+				mvpMap[m] = mvp
+			}
+		}
+	}
+	return mvpMap
+}
+
+// RecoCheck is a recommendation implemented in Go, for advice needing logic across several metrics or
+// some history. The returned rows are stored under the "recommendations" metric with the check's topic,
+// like reco_* SQL results, and need to contain the tag_object_name, recommendation and extra_info columns
+type RecoCheck interface {
+	Topic() string
+	InputMetrics() []string // metrics the recent measurements of which are needed by the check
+	Run(c RecoCheckContext) (metrics.Measurements, error)
+}
+
+// RegisterRecoCheck adds a Go recommendation check, executed together with reco_* SQL metrics
+func (g *Gatherer) RegisterRecoCheck(c RecoCheck) {
+	g.recoChecksLock.Lock()
+	defer g.recoChecksLock.Unlock()
+	g.recoChecks = append(g.recoChecks, c)
+	g.recentMeasurementsLock.Lock()
+	defer g.recentMeasurementsLock.Unlock()
+	for _, m := range c.InputMetrics() {
+		g.recentMeasurementsWanted[m] = true
+	}
+}
+
+func (g *Gatherer) GetAllRecoChecks() []RecoCheck {
+	g.recoChecksLock.RLock()
+	defer g.recoChecksLock.RUnlock()
+	return slices.Clone(g.recoChecks)
+}
+
+func (g *Gatherer) FilterPgbouncerData(data metrics.Measurements, databaseToKeep string, vme DBVersionMapEntry) metrics.Measurements {
+	filteredData := make(metrics.Measurements, 0)
+
+	for _, dr := range data {
+		//log.Debugf("bouncer dr: %+v", dr)
+		if _, ok := dr["database"]; !ok {
+			g.logger.Warning("Expected 'database' key not found from pgbouncer_stats, not storing data")
+			continue
+		}
+		if (len(databaseToKeep) > 0 && dr["database"] != databaseToKeep) || dr["database"] == "pgbouncer" { // always ignore the internal 'pgbouncer' DB
+			g.logger.Debugf("Skipping bouncer stats for pool entry %v as not the specified DBName of %s", dr["database"], databaseToKeep)
+			continue // and all others also if a DB / pool name was specified in config
+		}
+
+		dr["tag_database"] = dr["database"] // support multiple databases / pools via tags if DbName left empty
+		delete(dr, "database")              // remove the original pool name
+
+		if vme.Version >= pgBouncerNumericCountersStartVersion { // v1.12 counters are of type numeric instead of int64
+			for k, v := range dr {
+				if k == "tag_database" {
+					continue
+				}
+				decimalCounter, err := decimal.NewFromString(string(v.([]uint8)))
+				if err != nil {
+					g.logger.Errorf("Could not parse \"%+v\" to Decimal: %s", string(v.([]uint8)), err)
+					return filteredData
+				}
+				dr[k] = decimalCounter.IntPart() // technically could cause overflow...but highly unlikely for 2^63
+			}
+		}
+		filteredData = append(filteredData, dr)
+	}
+
+	return filteredData
+}
+
+func (g *Gatherer) FetchMetrics(ctx context.Context, msg MetricFetchMessage, hostState map[string]map[string]string, storageCh chan<- []metrics.MeasurementMessage, context string) ([]metrics.MeasurementMessage, error) {
+	ctx, span := tracer.Start(ctx, "FetchMetrics", trace.WithAttributes(attrDB.String(msg.DBUniqueName), attrMetric.String(msg.MetricName)))
+	msgs, err := g.fetchMetrics(ctx, msg, hostState, storageCh, context)
+	rows := 0
+	for _, m := range msgs {
+		rows += len(m.Data)
+	}
+	span.SetAttributes(attrRows.Int(rows))
+	endSpan(span, err)
+	return msgs, err
+}
+
+func (g *Gatherer) fetchMetrics(ctx context.Context, msg MetricFetchMessage, hostState map[string]map[string]string, storageCh chan<- []metrics.MeasurementMessage, context string) ([]metrics.MeasurementMessage, error) {
+	var vme DBVersionMapEntry
+	var dbpgVersion uint
+	var err, firstErr error
+	var sql string
+	var retryWithSuperuserSQL = true
+	var data, cachedData metrics.Measurements
+	var md MonitoredDatabase
+	var fromCache, isCacheable bool
+
+	vme, err = g.DBGetPGVersion(ctx, msg.DBUniqueName, msg.DBType, false)
+	if err != nil {
+		g.logger.Error("failed to fetch pg version for ", msg.DBUniqueName, msg.MetricName, err)
+		return nil, err
+	}
+	if msg.MetricName == specialMetricDbSize || msg.MetricName == specialMetricTableStats {
+		if vme.ExecEnv == execEnvAzureSingle && vme.ApproxDBSizeB > 1e12 { // 1TB
+			subsMetricName := msg.MetricName + "_approx"
+			mvpApprox, err := g.GetMetricVersionProperties(subsMetricName, vme, nil)
+			if err == nil && mvpApprox.MetricAttrs.MetricStorageName == msg.MetricName {
+				g.logger.Infof("[%s:%s] Transparently swapping metric to %s due to hard-coded rules...", msg.DBUniqueName, msg.MetricName, subsMetricName)
+				msg.MetricName = subsMetricName
+			}
+		}
+	}
+	dbpgVersion = vme.Version
+
+	if msg.DBType == config.DbTypeBouncer {
+		dbpgVersion = 0 // version is 0.0 for all pgbouncer sql per convention
+	}
+
+	mvp, err := g.GetMetricVersionProperties(msg.MetricName, vme, nil)
+	if err != nil && msg.MetricName != recoMetricName {
+		epoch, ok := g.lastSQLFetchError.Load(msg.MetricName + dbMetricJoinStr + fmt.Sprintf("%v", dbpgVersion))
+		if !ok || ((time.Now().Unix() - epoch.(int64)) > 3600) { // complain only 1x per hour
+			g.logger.Infof("Failed to get SQL for metric '%s', version '%s': %v", msg.MetricName, vme.VersionStr, err)
+			g.lastSQLFetchError.Store(msg.MetricName+dbMetricJoinStr+fmt.Sprintf("%v", dbpgVersion), time.Now().Unix())
+		}
+		if strings.Contains(err.Error(), "too old") {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	isCacheable = IsCacheableMetric(msg, mvp)
+	if isCacheable && g.opts.InstanceLevelCacheMaxSeconds > 0 && msg.Interval.Seconds() > float64(g.opts.InstanceLevelCacheMaxSeconds) {
+		cachedData = g.GetFromInstanceCacheIfNotOlderThanSeconds(msg, g.opts.InstanceLevelCacheMaxSeconds)
+		trace.SpanFromContext(ctx).SetAttributes(attribute.Bool("pgwatch3.instance_cache_hit", len(cachedData) > 0))
+		if len(cachedData) > 0 {
+			fromCache = true
+			goto send_to_storageChannel
+		}
+	}
+
+retry_with_superuser_sql: // if 1st fetch with normal SQL fails, try with SU SQL if it's defined
+
+	sql = mvp.SQL
+
+	if g.opts.Metric.NoHelperFunctions && mvp.CallsHelperFunctions && mvp.SQLSU != "" {
+		g.logger.Debugf("[%s:%s] Using SU SQL instead of normal one due to --no-helper-functions input", msg.DBUniqueName, msg.MetricName)
+		sql = mvp.SQLSU
+		retryWithSuperuserSQL = false
+	}
+
+	if (vme.IsSuperuser || (retryWithSuperuserSQL && firstErr != nil)) && mvp.SQLSU != "" {
+		sql = mvp.SQLSU
+		retryWithSuperuserSQL = false
+	}
+	if sql == "" && !(msg.MetricName == specialMetricChangeEvents || msg.MetricName == recoMetricName) {
+		// let's ignore dummy SQL-s
+		g.logger.Debugf("[%s:%s] Ignoring fetch message - got an empty/dummy SQL string", msg.DBUniqueName, msg.MetricName)
+		return nil, nil
+	}
+
+	if (mvp.MasterOnly && vme.IsInRecovery) || (mvp.StandbyOnly && !vme.IsInRecovery) {
+		g.logger.Debugf("[%s:%s] Skipping fetching of  as server not in wanted state (IsInRecovery=%v)", msg.DBUniqueName, msg.MetricName, vme.IsInRecovery)
+		return nil, nil
+	}
+
+	if msg.MetricName == specialMetricChangeEvents && context != contextPrometheusScrape { // special handling, multiple queries + stateful
+		g.CheckForPGObjectChangesAndStore(msg.DBUniqueName, vme, storageCh, hostState) // TODO no hostState for Prometheus currently
+	} else if msg.MetricName == recoMetricName && context != contextPrometheusScrape {
+		if data, err = g.GetRecommendations(msg.DBUniqueName, vme, hostState); err != nil {
+			return nil, err
+		}
+	} else if msg.DBType == config.DbTypePgPOOL {
+		if data, err = g.FetchMetricsPgpool(msg, vme, mvp); err != nil {
+			return nil, err
+		}
+	} else {
+		data, err = g.DBExecReadByDbUniqueName(ctx, msg.DBUniqueName, sql)
+
+		if err != nil {
+			// let's soften errors to "info" from functions that expect the server to be a primary to reduce noise
+			if strings.Contains(err.Error(), "recovery is in progress") {
+				g.dbPgVersionMapLock.RLock()
+				ver := g.dbPgVersionMap[msg.DBUniqueName]
+				g.dbPgVersionMapLock.RUnlock()
+				if ver.IsInRecovery {
+					g.logger.Debugf("[%s:%s] failed to fetch metrics: %s", msg.DBUniqueName, msg.MetricName, err)
+					return nil, err
+				}
+			}
+
+			if msg.MetricName == specialMetricInstanceUp {
+				g.logger.WithError(err).Debugf("[%s:%s] failed to fetch metrics. marking instance as not up", msg.DBUniqueName, msg.MetricName)
+				data = make(metrics.Measurements, 1)
+				data[0] = metrics.Measurement{"epoch_ns": time.Now().UnixNano(), "is_up": 0} // should be updated if the "instance_up" metric definition is changed
+				goto send_to_storageChannel
+			}
+
+			if strings.Contains(err.Error(), "connection refused") {
+				g.SetDBUnreachableState(msg.DBUniqueName)
+			}
+
+			if retryWithSuperuserSQL && mvp.SQLSU != "" {
+				firstErr = err
+				g.logger.Infof("[%s:%s] Normal fetch failed, re-trying to fetch with SU SQL", msg.DBUniqueName, msg.MetricName)
+				trace.SpanFromContext(ctx).AddEvent("retrying with SU SQL", trace.WithAttributes(attribute.String("error", err.Error())))
+				goto retry_with_superuser_sql
+			}
+			if firstErr != nil {
+				g.logger.WithField("database", msg.DBUniqueName).WithField("metric", msg.MetricName).Error(err)
+				return nil, firstErr // returning the initial error
+			}
+			g.logger.Infof("[%s:%s] failed to fetch metrics: %s", msg.DBUniqueName, msg.MetricName, err)
+
+			return nil, err
+		}
+		md, err = g.GetMonitoredDatabaseByUniqueName(msg.DBUniqueName)
+		if err != nil {
+			g.logger.Errorf("[%s:%s] could not get monitored DB details", msg.DBUniqueName, err)
+			return nil, err
+		}
+
+		g.logger.WithFields(map[string]any{"database": msg.DBUniqueName, "metric": msg.MetricName, "rows": len(data)}).Info("measurements fetched")
+		if regexIsPgbouncerMetrics.MatchString(msg.MetricName) { // clean unwanted pgbouncer pool stats here as not possible in SQL
+			data = g.FilterPgbouncerData(data, md.GetDatabaseName(), vme)
+		}
+
+		g.ClearDBUnreachableStateIfAny(msg.DBUniqueName)
+
+	}
+
+	if isCacheable && g.opts.InstanceLevelCacheMaxSeconds > 0 && msg.Interval.Seconds() > float64(g.opts.InstanceLevelCacheMaxSeconds) {
+		g.PutToInstanceCache(msg, data)
+		trace.SpanFromContext(ctx).AddEvent("stored to the instance cache")
+	}
+
+send_to_storageChannel:
+
+	if (g.opts.Metric.RealDbnameField > "" || g.opts.Metric.SystemIdentifierField > "") && msg.DBType == config.DbTypePg {
+		g.dbPgVersionMapLock.RLock()
+		ver := g.dbPgVersionMap[msg.DBUniqueName]
+		g.dbPgVersionMapLock.RUnlock()
+		data = g.AddDbnameSysinfoIfNotExistsToQueryResultData(msg, data, ver)
+	}
+
+	if mvp.MetricAttrs.MetricStorageName != "" {
+		g.logger.Debugf("[%s] rerouting metric %s data to %s based on metric attributes", msg.DBUniqueName, msg.MetricName, mvp.MetricAttrs.MetricStorageName)
+		msg.MetricName = mvp.MetricAttrs.MetricStorageName
+	}
+	if fromCache {
+		md, err = g.GetMonitoredDatabaseByUniqueName(msg.DBUniqueName)
+		if err != nil {
+			g.logger.Errorf("[%s:%s] could not get monitored DB details", msg.DBUniqueName, err)
+			return nil, err
+		}
+		g.logger.Infof("[%s:%s] loaded %d rows from the instance cache", msg.DBUniqueName, msg.MetricName, len(cachedData))
+		atomic.AddUint64(&g.totalMetricsReusedFromCacheCounter, uint64(len(cachedData)))
+		return []metrics.MeasurementMessage{{DBName: msg.DBUniqueName, MetricName: msg.MetricName, Data: cachedData, CustomTags: md.CustomTags,
+			MetricDef: mvp, RealDbname: vme.RealDbname, SystemIdentifier: vme.SystemIdentifier}}, nil
+	}
+	atomic.AddUint64(&g.totalMetricsFetchedCounter, uint64(len(data)))
+	return []metrics.MeasurementMessage{{DBName: msg.DBUniqueName, MetricName: msg.MetricName, Data: data, CustomTags: md.CustomTags,
+		MetricDef: mvp, RealDbname: vme.RealDbname, SystemIdentifier: vme.SystemIdentifier}}, nil
+
+}
+
+func (g *Gatherer) SetDBUnreachableState(dbUnique string) {
+	g.unreachableDBsLock.Lock()
+	g.unreachableDB[dbUnique] = time.Now()
+	g.unreachableDBsLock.Unlock()
+}
+
+func (g *Gatherer) ClearDBUnreachableStateIfAny(dbUnique string) {
+	g.unreachableDBsLock.Lock()
+	delete(g.unreachableDB, dbUnique)
+	g.unreachableDBsLock.Unlock()
+}
+
+func (g *Gatherer) GetFromInstanceCacheIfNotOlderThanSeconds(msg MetricFetchMessage, maxAgeSeconds int64) metrics.Measurements {
+	var clonedData metrics.Measurements
+	g.instanceMetricCacheTimestampLock.RLock()
+	instanceMetricTS, ok := g.instanceMetricCacheTimestamp[msg.DBUniqueNameOrig+msg.MetricName]
+	g.instanceMetricCacheTimestampLock.RUnlock()
+	if !ok {
+		//log.Debugf("[%s:%s] no instance cache entry", msg.DBUniqueNameOrig, msg.MetricName)
+		return nil
+	}
+
+	if time.Now().Unix()-instanceMetricTS.Unix() > maxAgeSeconds {
+		//log.Debugf("[%s:%s] instance cache entry too old", msg.DBUniqueNameOrig, msg.MetricName)
+		return nil
+	}
+
+	g.logger.Debugf("[%s:%s] reading metric data from instance cache of \"%s\"", msg.DBUniqueName, msg.MetricName, msg.DBUniqueNameOrig)
+	g.instanceMetricCacheLock.RLock()
+	instanceMetricData, ok := g.instanceMetricCache[msg.DBUniqueNameOrig+msg.MetricName]
+	if !ok {
+		g.instanceMetricCacheLock.RUnlock()
+		return nil
+	}
+	clonedData = deepCopyMetricData(instanceMetricData)
+	g.instanceMetricCacheLock.RUnlock()
+
+	return clonedData
+}
+
+func (g *Gatherer) PutToInstanceCache(msg MetricFetchMessage, data metrics.Measurements) {
+	if len(data) == 0 {
+		return
+	}
+	dataCopy := deepCopyMetricData(data)
+	g.logger.Debugf("[%s:%s] filling instance cache", msg.DBUniqueNameOrig, msg.MetricName)
+	g.instanceMetricCacheLock.Lock()
+	g.instanceMetricCache[msg.DBUniqueNameOrig+msg.MetricName] = dataCopy
+	g.instanceMetricCacheLock.Unlock()
+
+	g.instanceMetricCacheTimestampLock.Lock()
+	g.instanceMetricCacheTimestamp[msg.DBUniqueNameOrig+msg.MetricName] = time.Now()
+	g.instanceMetricCacheTimestampLock.Unlock()
+}
+
+func IsCacheableMetric(msg MetricFetchMessage, mvp metrics.MetricProperties) bool {
+	if !(msg.DBType == config.DbTypePgCont || msg.DBType == config.DbTypePatroniCont) {
+		return false
+	}
+	return mvp.MetricAttrs.IsInstanceLevel
+}
+
+func (g *Gatherer) AddDbnameSysinfoIfNotExistsToQueryResultData(msg MetricFetchMessage, data metrics.Measurements, ver DBVersionMapEntry) metrics.Measurements {
+	enrichedData := make(metrics.Measurements, 0)
+
+	g.logger.Debugf("Enriching all rows of [%s:%s] with sysinfo (%s) / real dbname (%s) if set. ", msg.DBUniqueName, msg.MetricName, ver.SystemIdentifier, ver.RealDbname)
+	for _, dr := range data {
+		if g.opts.Metric.RealDbnameField > "" && ver.RealDbname > "" {
+			old, ok := dr[g.opts.Metric.RealDbnameField]
+			if !ok || old == "" {
+				dr[g.opts.Metric.RealDbnameField] = ver.RealDbname
+			}
+		}
+		if g.opts.Metric.SystemIdentifierField > "" && ver.SystemIdentifier > "" {
+			old, ok := dr[g.opts.Metric.SystemIdentifierField]
+			if !ok || old == "" {
+				dr[g.opts.Metric.SystemIdentifierField] = ver.SystemIdentifier
+			}
+		}
+		enrichedData = append(enrichedData, dr)
+	}
+	return enrichedData
+}
+
+func (g *Gatherer) StoreMetrics(metrics []metrics.MeasurementMessage, storageCh chan<- []metrics.MeasurementMessage) (int, error) {
+
+	if len(metrics) > 0 {
+		atomic.AddUint64(&g.totalDatasetsFetchedCounter, 1)
+		g.RecordRecentMeasurements(metrics)
+		storageCh <- metrics
+		return len(metrics), nil
+	}
+
+	return 0, nil
+}
+
+func deepCopyMetricData(data metrics.Measurements) metrics.Measurements {
+	newData := make(metrics.Measurements, len(data))
+
+	for i, dr := range data {
+		newRow := make(map[string]any)
+		for k, v := range dr {
+			newRow[k] = v
+		}
+		newData[i] = newRow
+	}
+
+	return newData
+}
+
+func deepCopyMetricDefinitionMap(mdm map[string]map[uint]metrics.MetricProperties) map[string]map[uint]metrics.MetricProperties {
+	newMdm := make(map[string]map[uint]metrics.MetricProperties)
+
+	for metric, verMap := range mdm {
+		newMdm[metric] = make(map[uint]metrics.MetricProperties)
+		for ver, mvp := range verMap {
+			newMdm[metric][ver] = mvp
+		}
+	}
+	return newMdm
+}
+
+// ControlMessage notifies of shutdown + interval change
+func (g *Gatherer) MetricGathererLoop(ctx context.Context, dbUniqueName, dbUniqueNameOrig, dbType, metricName string, configMap map[string]float64, schedule string, controlCh <-chan ControlMessage, storeCh chan<- []metrics.MeasurementMessage) {
+	config := configMap
+	interval := config[metricName]
+	hostState := make(map[string]map[string]string)
+	var lastUptimeS int64 = -1 // used for "server restarted" event detection
+	var lastErrorNotificationTime time.Time
+	var vme DBVersionMapEntry
+	var mvp metrics.MetricProperties
+	var err error
+	failedFetches := 0
+	// metricNameForStorage := metricName
+	lastDBVersionFetchTime := time.Unix(0, 0) // check DB ver. ev. 5 min
+	var stmtTimeoutOverride int64
+
+	l := g.logger.WithField("database", dbUniqueName).WithField("metric", metricName)
+	if metricName == specialMetricServerLogEventCounts {
+		g.runningGatherers.Done() // log parsers store right away, nothing to wait for on shutdown
+		if md, err := g.GetMonitoredDatabaseByUniqueName(dbUniqueName); err == nil && md.HostConfig.LogsRemote {
+			g.logparseRemoteLoop(dbUniqueName, metricName, configMap, controlCh, storeCh) // no return
+			return
+		}
+		g.logparseLoop(dbUniqueName, metricName, configMap, controlCh, storeCh) // no return
+		return
+	}
+
+	defer g.runningGatherers.Done()
+
+	md, _ := g.GetMonitoredDatabaseByUniqueName(dbUniqueName)
+	splay := g.opts.ScheduleSplay
+	if md.HostConfig.ScheduleSplaySeconds > 0 {
+		splay = time.Duration(md.HostConfig.ScheduleSplaySeconds * float64(time.Second))
+	}
+	instance := dbUniqueNameOrig
+	if instance == "" {
+		instance = dbUniqueName
+	}
+	parseSchedule := func(schedule string) metrics.Schedule {
+		s, err := ParseMetricSchedule(md, schedule)
+		if err != nil {
+			l.WithError(err).Errorf("Invalid schedule %q, gathering every %vs instead", schedule, interval)
+		}
+		return s
+	}
+	dbMetric := dbUniqueName + dbMetricJoinStr + metricName
+	job := g.scheduler.Add(dbMetric, time.Duration(interval*float64(time.Second)), splay, parseSchedule(schedule))
+	defer g.scheduler.Remove(job)
+	defer g.setEffectiveInterval(dbMetric, interval, 1)
+	defer g.ClearMetricHealth(dbMetric)
+	backoff := Backoff{Factor: 1, Max: max(g.opts.MaxBackoffFactor, 1)}
+	circuitBreaker := g.GetCircuitBreaker(dbUniqueName)
+	var skipped uint64 // ticks dropped by the scheduler so far
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg := <-controlCh:
+			l.Debug("got control msg", msg)
+			if msg.Action == gathererStatusStart {
+				config = msg.Config
+				interval = config[metricName]
+				g.scheduler.Reschedule(job, time.Duration(interval*float64(time.Second)), parseSchedule(msg.Schedule))
+				l.Debug("started MetricGathererLoop with interval:", interval, "schedule:", msg.Schedule)
+			} else if msg.Action == gathererStatusStop {
+				l.Debug("exiting MetricGathererLoop with interval:", interval)
+				return
+			}
+			continue
+		case <-job.C:
+		}
+		if ctx.Err() != nil {
+			return // shutting down, no new fetches
+		}
+		missed := job.Skipped() - skipped
+		skipped += missed
+		if backoff.Skip(missed) {
+			continue // backing off
+		}
+
+		if lastDBVersionFetchTime.Add(time.Minute * time.Duration(5)).Before(time.Now()) {
+			vme, err = g.DBGetPGVersion(ctx, dbUniqueName, dbType, false) // in case of errors just ignore metric "disabled" time ranges
+			if err != nil {
+				lastDBVersionFetchTime = time.Now()
+			}
+
+			mvp, err = g.GetMetricVersionProperties(metricName, vme, nil)
+			if err == nil && mvp.MetricAttrs.StatementTimeoutSeconds > 0 {
+				stmtTimeoutOverride = mvp.MetricAttrs.StatementTimeoutSeconds
+			} else {
+				stmtTimeoutOverride = 0
+			}
+		}
+
+		if g.IsMetricCurrentlyDisabledForHost(metricName, vme, dbUniqueName) {
+			l.Debugf("Ignoring fetch as metric disabled for current time range")
+			continue
+		}
+		var metricStoreMessages []metrics.MeasurementMessage
+		var err error
+		mfm := MetricFetchMessage{
+			DBUniqueName:        dbUniqueName,
+			DBUniqueNameOrig:    dbUniqueNameOrig,
+			MetricName:          metricName,
+			DBType:              dbType,
+			Interval:            time.Duration(interval * float64(time.Second)),
+			StmtTimeoutOverride: stmtTimeoutOverride,
+		}
+
+		// 1st try local overrides for some metrics if operating in push mode
+		if g.opts.DirectOSStats && IsDirectlyFetchableMetric(metricName) {
+			t1 := time.Now()
+			metricStoreMessages, err = g.FetchStatsDirectlyFromOS(mfm, vme, mvp)
+			if err != nil {
+				l.WithError(err).Errorf("Could not reader metric directly from OS")
+			} else {
+				g.RecordMetricFetch(dbMetric, interval, backoff.Factor, time.Since(t1), metricStoreMessages, nil)
+			}
+		}
+		if metricStoreMessages == nil {
+			if !circuitBreaker.Allow(time.Now()) {
+				continue
+			}
+			var release func()
+			if release, err = g.scheduler.Acquire(ctx, instance); err != nil {
+				return // shutting down
+			}
+			t1 := time.Now()
+			metricStoreMessages, err = g.FetchMetrics(g.fetchContext, mfm, hostState, storeCh, "") // in-flight fetches can finish on shutdown
+			t2 := time.Now()
+			release()
+
+			if circuitBreaker.Record(t2, err) {
+				if circuitBreaker.IsOpen() {
+					l.Warningf("Circuit breaker opened after %d connection failures, pausing all fetches of the DB for %v", g.opts.CircuitBreakerFailures, g.opts.CircuitBreakerCooldown)
+				} else {
+					l.Info("Circuit breaker closed, DB reachable again")
+				}
+			}
+			if backoff.Update(err != nil, t2.Sub(t1), time.Duration(interval*float64(time.Second))) {
+				if backoff.Factor > 1 {
+					l.Warningf("Backing off, fetching every %vs instead of %vs until fetches succeed in time", interval*float64(backoff.Factor), interval)
+				} else {
+					l.Infof("Back to the configured %vs interval", interval)
+				}
+				g.setEffectiveInterval(dbMetric, interval, backoff.Factor)
+			} else if t2.Sub(t1) > time.Duration(interval*float64(backoff.Factor)*float64(time.Second)) {
+				l.Warningf("Total fetching time of %vs bigger than %vs interval, %d ticks skipped so far", t2.Sub(t1).Truncate(time.Millisecond*100).Seconds(), interval*float64(backoff.Factor), job.Skipped())
+			}
+			g.RecordMetricFetch(dbMetric, interval, backoff.Factor, t2.Sub(t1), metricStoreMessages, err)
+		}
+
+		if err != nil {
+			failedFetches++
+			// complain only 1x per 10min per host/metric...
+			if lastErrorNotificationTime.IsZero() || lastErrorNotificationTime.Add(time.Second*time.Duration(600)).Before(time.Now()) {
+				l.WithError(err).Error("failed to fetch metric data")
+				if failedFetches > 1 {
+					l.Errorf("Total failed fetches: %d", failedFetches)
+				}
+				lastErrorNotificationTime = time.Now()
+			}
+		} else if metricStoreMessages != nil {
+			if len(metricStoreMessages[0].Data) > 0 {
+
+				// pick up "server restarted" events here to avoid doing extra selects from CheckForPGObjectChangesAndStore code
+				if metricName == "db_stats" {
+					postmasterUptimeS, ok := (metricStoreMessages[0].Data)[0]["postmaster_uptime_s"]
+					if ok {
+						if lastUptimeS != -1 {
+							if postmasterUptimeS.(int64) < lastUptimeS { // restart (or possibly also failover when host is routed) happened
+								message := "Detected server restart (or failover) of \"" + dbUniqueName + "\""
+								l.Warning(message)
+								detectedChangesSummary := make(metrics.Measurements, 0)
+								entry := metrics.Measurement{"details": message, "epoch_ns": (metricStoreMessages[0].Data)[0]["epoch_ns"]}
+								detectedChangesSummary = append(detectedChangesSummary, entry)
+								metricStoreMessages = append(metricStoreMessages,
+									metrics.MeasurementMessage{DBName: dbUniqueName, DBType: dbType,
+										MetricName: "object_changes", Data: detectedChangesSummary, CustomTags: metricStoreMessages[0].CustomTags})
+							}
+						}
+						lastUptimeS = postmasterUptimeS.(int64)
+					}
+				}
+
+				_, _ = g.StoreMetrics(metricStoreMessages, storeCh)
+			}
+		}
+	}
+}
+
+func (g *Gatherer) FetchStatsDirectlyFromOS(msg MetricFetchMessage, vme DBVersionMapEntry, mvp metrics.MetricProperties) ([]metrics.MeasurementMessage, error) {
+	var data []map[string]any
+	var err error
+
+	if msg.MetricName == metricCPULoad { // could function pointers work here?
+		data, err = psutil.GetLoadAvgLocal()
+	} else if msg.MetricName == metricPsutilCPU {
+		data, err = psutil.GetGoPsutilCPU(msg.Interval)
+	} else if msg.MetricName == metricPsutilDisk {
+		data, err = g.GetGoPsutilDiskPG(msg.DBUniqueName)
+	} else if msg.MetricName == metricPsutilDiskIoTotal {
+		data, err = psutil.GetGoPsutilDiskTotals()
+	} else if msg.MetricName == metricPsutilMem {
+		data, err = psutil.GetGoPsutilMem()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	msm := g.DatarowsToMetricstoreMessage(data, msg, vme, mvp)
+	return []metrics.MeasurementMessage{msm}, nil
+}
+
+// data + custom tags + counters
+func (g *Gatherer) DatarowsToMetricstoreMessage(data metrics.Measurements, msg MetricFetchMessage, vme DBVersionMapEntry, mvp metrics.MetricProperties) metrics.MeasurementMessage {
+	md, err := g.GetMonitoredDatabaseByUniqueName(msg.DBUniqueName)
+	if err != nil {
+		g.logger.Errorf("Could not resolve DBUniqueName %s, cannot set custom attributes for gathered data: %v", msg.DBUniqueName, err)
+	}
+
+	atomic.AddUint64(&g.totalMetricsFetchedCounter, uint64(len(data)))
+
+	return metrics.MeasurementMessage{
+		DBName:           msg.DBUniqueName,
+		DBType:           msg.DBType,
+		MetricName:       msg.MetricName,
+		CustomTags:       md.CustomTags,
+		Data:             data,
+		MetricDef:        mvp,
+		RealDbname:       vme.RealDbname,
+		SystemIdentifier: vme.SystemIdentifier,
+	}
+}
+
+func IsDirectlyFetchableMetric(metric string) bool {
+	_, ok := directlyFetchableOSMetrics[metric]
+	return ok
+}
+
+func (g *Gatherer) IsMetricCurrentlyDisabledForHost(metricName string, vme DBVersionMapEntry, dbUniqueName string) bool {
+	_, isSpecialMetric := specialMetrics[metricName]
+
+	mvp, err := g.GetMetricVersionProperties(metricName, vme, nil)
+	if err != nil {
+		if isSpecialMetric || strings.Contains(err.Error(), "too old") {
+			return false
+		}
+		g.logger.Warningf("[%s][%s] Ignoring any possible time based gathering restrictions, could not get metric details", dbUniqueName, metricName)
+		return false
+	}
+
+	md, err := g.GetMonitoredDatabaseByUniqueName(dbUniqueName) // TODO caching?
+	if err != nil {
+		g.logger.Warningf("[%s][%s] Ignoring any possible time based gathering restrictions, could not get DB details", dbUniqueName, metricName)
+		return false
+	}
+
+	if md.HostConfig.PerMetricDisabledTimes == nil && mvp.MetricAttrs.DisabledDays == "" && len(mvp.MetricAttrs.DisableTimes) == 0 {
+		//log.Debugf("[%s][%s] No time based gathering restrictions defined", dbUniqueName, metricName)
+		return false
+	}
+
+	metricHasOverrides := false
+	if md.HostConfig.PerMetricDisabledTimes != nil {
+		for _, hcdt := range md.HostConfig.PerMetricDisabledTimes {
+			if slices.Index(hcdt.Metrics, metricName) > -1 && (hcdt.DisabledDays != "" || len(hcdt.DisabledTimes) > 0) {
+				metricHasOverrides = true
+				break
+			}
+		}
+		if !metricHasOverrides && mvp.MetricAttrs.DisabledDays == "" && len(mvp.MetricAttrs.DisableTimes) == 0 {
+			//log.Debugf("[%s][%s] No time based gathering restrictions defined", dbUniqueName, metricName)
+			return false
+		}
+	}
+
+	return g.IsInDisabledTimeDayRange(time.Now(), mvp.MetricAttrs.DisabledDays, mvp.MetricAttrs.DisableTimes, md.HostConfig.PerMetricDisabledTimes, metricName, dbUniqueName)
+}
+
+// days: 0 = Sun, ranges allowed
+func (g *Gatherer) IsInDaySpan(locTime time.Time, days, _, _ string) bool {
+	//log.Debug("IsInDaySpan", locTime, days, metric, dbUnique)
+	if days == "" {
+		return false
+	}
+	curDayInt := int(locTime.Weekday())
+	daysMap := g.DaysStringToIntMap(days)
+	//log.Debugf("curDayInt %v, daysMap %+v", curDayInt, daysMap)
+	_, ok := daysMap[curDayInt]
+	return ok
+}
+
+func (g *Gatherer) DaysStringToIntMap(days string) map[int]bool { // TODO validate with some regex when reading in configs, have dbname info then
+	ret := make(map[int]bool)
+	for _, s := range strings.Split(days, ",") {
+		if strings.Contains(s, "-") {
+			dayRange := strings.Split(s, "-")
+			if len(dayRange) != 2 {
+				g.logger.Warningf("Ignoring invalid day range specification: %s. Check config", s)
+				continue
+			}
+			startDay, err := strconv.Atoi(dayRange[0])
+			endDay, err2 := strconv.Atoi(dayRange[1])
+			if err != nil || err2 != nil {
+				g.logger.Warningf("Ignoring invalid day range specification: %s. Check config", s)
+				continue
+			}
+			for i := startDay; i <= endDay && i >= 0 && i <= 7; i++ {
+				ret[i] = true
+			}
+
+		} else {
+			day, err := strconv.Atoi(s)
+			if err != nil {
+				g.logger.Warningf("Ignoring invalid day range specification: %s. Check config", days)
+				continue
+			}
+			ret[day] = true
+		}
+	}
+	if _, ok := ret[7]; ok { // Cron allows either 0 or 7 for Sunday
+		ret[0] = true
+	}
+	return ret
+}
+
+func (g *Gatherer) IsInTimeSpan(checkTime time.Time, timeRange, metric, dbUnique string) bool {
+	layout := "15:04"
+	var t1, t2 time.Time
+	var err error
+
+	timeRange = strings.TrimSpace(timeRange)
+	if len(timeRange) < 11 {
+		g.logger.Warningf("[%s][%s] invalid time range: %s. Check config", dbUnique, metric, timeRange)
+		return false
+	}
+	s1 := timeRange[0:5]
+	s2 := timeRange[6:11]
+	tz := strings.TrimSpace(timeRange[11:])
+
+	if len(tz) > 1 { // time zone specified
+		if regexIsAlpha.MatchString(tz) {
+			layout = "15:04 MST"
+		} else {
+			layout = "15:04 -0700"
+		}
+		t1, err = time.Parse(layout, s1+" "+tz)
+		if err == nil {
+			t2, err = time.Parse(layout, s2+" "+tz)
+		}
+	} else { // no time zone
+		t1, err = time.Parse(layout, s1)
+		if err == nil {
+			t2, err = time.Parse(layout, s2)
+		}
+	}
+
+	if err != nil {
+		g.logger.Warningf("[%s][%s] Ignoring invalid disabled time range: %s. Check config. Erorr: %v", dbUnique, metric, timeRange, err)
+		return false
+	}
+
+	check, err := time.Parse("15:04 -0700", strconv.Itoa(checkTime.Hour())+":"+strconv.Itoa(checkTime.Minute())+" "+t1.Format("-0700")) // UTC by default
+	if err != nil {
+		g.logger.Warningf("[%s][%s] Ignoring invalid disabled time range: %s. Check config. Error: %v", dbUnique, metric, timeRange, err)
+		return false
+	}
+
+	if t1.After(t2) {
+		t2 = t2.AddDate(0, 0, 1)
+	}
+
+	return check.Before(t2) && check.After(t1)
+}
+
+func (g *Gatherer) IsInDisabledTimeDayRange(localTime time.Time, metricAttrsDisabledDays string, metricAttrsDisabledTimes []string, hostConfigPerMetricDisabledTimes []HostConfigPerMetricDisabledTimes, metric, dbUnique string) bool {
+	hostConfigMetricMatch := false
+	for _, hcdi := range hostConfigPerMetricDisabledTimes { // host config takes precedence when both specified
+		dayMatchFound := false
+		timeMatchFound := false
+		if slices.Index(hcdi.Metrics, metric) > -1 {
+			hostConfigMetricMatch = true
+			if !dayMatchFound && hcdi.DisabledDays != "" && g.IsInDaySpan(localTime, hcdi.DisabledDays, metric, dbUnique) {
+				dayMatchFound = true
+			}
+			for _, dt := range hcdi.DisabledTimes {
+				if g.IsInTimeSpan(localTime, dt, metric, dbUnique) {
+					timeMatchFound = true
+					break
+				}
+			}
+		}
+		if hostConfigMetricMatch && (timeMatchFound || len(hcdi.DisabledTimes) == 0) && (dayMatchFound || hcdi.DisabledDays == "") {
+			//log.Debugf("[%s][%s] Host config ignored time/day match, skipping fetch", dbUnique, metric)
+			return true
+		}
+	}
+
+	if !hostConfigMetricMatch && (metricAttrsDisabledDays != "" || len(metricAttrsDisabledTimes) > 0) {
+		dayMatchFound := g.IsInDaySpan(localTime, metricAttrsDisabledDays, metric, dbUnique)
+		timeMatchFound := false
+		for _, timeRange := range metricAttrsDisabledTimes {
+			if g.IsInTimeSpan(localTime, timeRange, metric, dbUnique) {
+				timeMatchFound = true
+				break
+			}
+		}
+		if (timeMatchFound || len(metricAttrsDisabledTimes) == 0) && (dayMatchFound || metricAttrsDisabledDays == "") {
+			//log.Debugf("[%s][%s] metrics.MetricAttrs ignored time/day match, skipping fetch", dbUnique, metric)
+			return true
+		}
+	}
+
+	return false
+}
+
+func (g *Gatherer) UpdateMetricDefinitions(newMetrics map[string]map[uint]metrics.MetricProperties, _ map[string]string) {
+	g.metricDefMapLock.Lock()
+	g.metricDefinitionMap = newMetrics
+
+	g.metricDefMapLock.Unlock()
+	g.logger.Debug("metrics definitions refreshed - nr. found:", len(newMetrics))
+}
+
+// jsonTextToMap parses a metric config into intervals and schedules, see metrics.ParseMetricIntervals()
+func jsonTextToMap(jsonText string) (map[string]float64, map[string]string, error) {
+	if jsonText == "" {
+		return make(map[string]float64), nil, nil
+	}
+	var hostConfig map[string]any
+	if err := json.Unmarshal([]byte(jsonText), &hostConfig); err != nil {
+		return nil, nil, err
+	}
+	return metrics.ParseMetricIntervals(hostConfig)
+}
+
+func jsonTextToStringMap(jsonText string) (map[string]string, error) {
+	retmap := make(map[string]string)
+	if jsonText == "" {
+		return retmap, nil
+	}
+	var iMap map[string]any
+	if err := json.Unmarshal([]byte(jsonText), &iMap); err != nil {
+		return nil, err
+	}
+	for k, v := range iMap {
+		retmap[k] = fmt.Sprintf("%v", v)
+	}
+	return retmap, nil
+}
+
+func ExpandEnvVarsForConfigEntryIfStartsWithDollar(md MonitoredDatabase) (MonitoredDatabase, int) {
+	var changed int
+
+	if strings.HasPrefix(md.Encryption, "$") {
+		md.Encryption = os.ExpandEnv(md.Encryption)
+		changed++
+	}
+	if strings.HasPrefix(md.DBType, "$") {
+		md.DBType = os.ExpandEnv(md.DBType)
+		changed++
+	}
+	if strings.HasPrefix(md.DBUniqueName, "$") {
+		md.DBUniqueName = os.ExpandEnv(md.DBUniqueName)
+		changed++
+	}
+	if strings.HasPrefix(md.DBNameIncludePattern, "$") {
+		md.DBNameIncludePattern = os.ExpandEnv(md.DBNameIncludePattern)
+		changed++
+	}
+	if strings.HasPrefix(md.DBNameExcludePattern, "$") {
+		md.DBNameExcludePattern = os.ExpandEnv(md.DBNameExcludePattern)
+		changed++
+	}
+	if strings.HasPrefix(md.PresetMetrics, "$") {
+		md.PresetMetrics = os.ExpandEnv(md.PresetMetrics)
+		changed++
+	}
+	if strings.HasPrefix(md.PresetMetricsStandby, "$") {
+		md.PresetMetricsStandby = os.ExpandEnv(md.PresetMetricsStandby)
+		changed++
+	}
+
+	return md, changed
+}
+
+func (g *Gatherer) ConfigFileToMonitoredDatabases(configFilePath string) ([]MonitoredDatabase, error) {
+	g.logger.Debugf("Converting monitoring YAML config to MonitoredDatabase from path %s ...", configFilePath)
+	yamlFile, err := os.ReadFile(configFilePath)
+	if err != nil {
+		g.logger.Errorf("Error reading file %s: %s", configFilePath, err)
+		return make([]MonitoredDatabase, 0), err
+	}
+	return g.ParseMonitoringConfig(yamlFile, configFilePath)
+}
+
+// ParseMonitoringConfig returns the enabled entries of a YAML or JSON list of monitored DBs, source is used for logging
+func (g *Gatherer) ParseMonitoringConfig(data []byte, source string) ([]MonitoredDatabase, error) {
+	hostList := make([]MonitoredDatabase, 0)
+	c := make([]MonitoredDatabase, 0) // there can be multiple configs in a single file
+	if err := yaml.Unmarshal(data, &c); err != nil {
+		g.logger.Errorf("Unmarshaling error: %v", err)
+		return hostList, err
+	}
+	for _, v := range c {
+		if v.DBType == "" {
+			v.DBType = config.DbTypePg
+		}
+		if v.IsEnabled {
+			g.logger.Debugf("Found active monitoring config entry: %#v", v)
+			if v.Group == "" {
+				v.Group = "default"
+			}
+			vExp, changed := ExpandEnvVarsForConfigEntryIfStartsWithDollar(v)
+			if changed > 0 {
+				g.logger.Debugf("[%s] %d config attributes expanded from ENV", vExp.DBUniqueName, changed)
+			}
+			hostList = append(hostList, vExp)
+		}
+	}
+	if len(hostList) == 0 {
+		g.logger.Warningf("Could not find any valid monitoring configs from: %s", source)
+	}
+	return hostList, nil
+}
+
+// reads through the YAML files containing descriptions on which hosts to monitor
+func (g *Gatherer) ReadMonitoringConfigFromFileOrFolder(fileOrFolder string) ([]MonitoredDatabase, error) {
+	hostList := make([]MonitoredDatabase, 0)
+
+	fi, err := os.Stat(fileOrFolder)
+	if err != nil {
+		g.logger.Errorf("Could not Stat() path: %s", fileOrFolder)
+		return hostList, err
+	}
+	switch mode := fi.Mode(); {
+	case mode.IsDir():
+		g.logger.Infof("Reading monitoring config from path %s ...", fileOrFolder)
+
+		err := filepath.Walk(fileOrFolder, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err // abort on first failure
+			}
+			if info.Mode().IsRegular() && (strings.HasSuffix(strings.ToLower(info.Name()), ".yaml") || strings.HasSuffix(strings.ToLower(info.Name()), ".yml")) {
+				g.logger.Debug("Found YAML config file:", info.Name())
+				mdbs, err := g.ConfigFileToMonitoredDatabases(path)
+				if err == nil {
+					hostList = append(hostList, mdbs...)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			g.logger.Errorf("Could not successfully Walk() path %s: %s", fileOrFolder, err)
+			return hostList, err
+		}
+	case mode.IsRegular():
+		hostList, err = g.ConfigFileToMonitoredDatabases(fileOrFolder)
+	}
+
+	return hostList, err
+}
+
+// Resolves regexes if exact DBs were not specified exact
+func (g *Gatherer) GetMonitoredDatabasesFromMonitoringConfig(mc []MonitoredDatabase) []MonitoredDatabase {
+	md := make([]MonitoredDatabase, 0)
+	if len(mc) == 0 {
+		return md
+	}
+	for _, e := range mc {
+		//log.Debugf("Processing config item: %#v", e)
+		if e.Metrics == nil && len(e.PresetMetrics) > 0 {
+			mdef, ok := g.presetMetricDefMap[e.PresetMetrics]
+			if !ok {
+				g.logger.Errorf("Failed to resolve preset config \"%s\" for \"%s\"", e.PresetMetrics, e.DBUniqueName)
+				continue
+			}
+			e.Metrics = mdef
+			e.Schedules = g.presetMetricScheduleMap[e.PresetMetrics]
+		}
+		if _, ok := dbTypeMap[e.DBType]; !ok {
+			g.logger.Warningf("Ignoring host \"%s\" - unknown dbtype: %s. Expected one of: %+v", e.DBUniqueName, e.DBType, dbTypes)
+			continue
+		}
+		if e.IsEnabled && e.Encryption == "aes-gcm-256" && g.opts.AesGcmKeyphrase != "" {
+			e.ConnStr = g.decrypt(e.DBUniqueName, g.opts.AesGcmKeyphrase, e.ConnStr)
+		}
+		if e.DBType == config.DbTypePatroni && e.GetDatabaseName() == "" {
+			g.logger.Warningf("Ignoring host \"%s\" as \"dbname\" attribute not specified but required by dbtype=patroni", e.DBUniqueName)
+			continue
+		}
+		if e.DBType == config.DbTypePg && e.GetDatabaseName() == "" {
+			g.logger.Warningf("Ignoring host \"%s\" as \"dbname\" attribute not specified but required by dbtype=postgres", e.DBUniqueName)
+			continue
+		}
+		if len(e.GetDatabaseName()) == 0 || e.DBType == config.DbTypePgCont || e.DBType == config.DbTypePatroni || e.DBType == config.DbTypePatroniCont || e.DBType == config.DbTypePatroniNamespaceDiscovery {
+			if e.DBType == config.DbTypePgCont {
+				g.logger.Debugf("Adding \"%s\" (host=%s, port=%s) to continuous monitoring ...", e.DBUniqueName, e.ConnStr)
+			}
+			var foundDbs []MonitoredDatabase
+			var err error
+
+			if e.DBType == config.DbTypePatroni || e.DBType == config.DbTypePatroniCont || e.DBType == config.DbTypePatroniNamespaceDiscovery {
+				foundDbs, err = g.ResolveDatabasesFromPatroni(e)
+			} else {
+				foundDbs, err = g.ResolveDatabasesFromConfigEntry(e)
+			}
+			if err != nil {
+				g.logger.Errorf("Failed to resolve DBs for \"%s\": %s", e.DBUniqueName, err)
+				continue
+			}
+			tempArr := make([]string, 0)
+			for _, r := range foundDbs {
+				md = append(md, r)
+				tempArr = append(tempArr, r.GetDatabaseName())
+			}
+			g.logger.Debugf("Resolved %d DBs with prefix \"%s\": [%s]", len(foundDbs), e.DBUniqueName, strings.Join(tempArr, ", "))
+		} else {
+			md = append(md, e)
+		}
+	}
+	return md
+}
+
+func (g *Gatherer) getMonitoredDatabasesSnapshot() map[string]MonitoredDatabase {
+	mdSnap := make(map[string]MonitoredDatabase)
+
+	if g.monitoredDbCache != nil {
+		g.monitoredDbCacheLock.RLock()
+		defer g.monitoredDbCacheLock.RUnlock()
+
+		for _, row := range g.monitoredDbCache {
+			mdSnap[row.DBUniqueName] = row
+		}
+	}
+
+	return mdSnap
+}
+
+func (g *Gatherer) StatsServerHandler(w http.ResponseWriter, _ *http.Request) {
+	jsonResponseTemplate := `
+{
+	"secondsFromLastSuccessfulDatastoreWrite": %d,
+	"totalMetricsFetchedCounter": %d,
+	"totalMetricsReusedFromCacheCounter": %d,
+	"totalDatasetsFetchedCounter": %d,
+	"metricPointsPerMinuteLast5MinAvg": %v,
+	"metricsDropped": %d,
+	"totalMetricFetchFailuresCounter": %d,
+	"totalSkippedTicksCounter": %d,
+	"datastoreWriteFailuresCounter": %d,
+	"datastoreSuccessfulWritesCounter": %d,
+	"datastoreAvgSuccessfulWriteTimeMillis": %.1f,
+	"databasesMonitored": %d,
+	"databasesConfigured": %d,
+	"unreachableDBs": %d,
+	"gathererUptimeSeconds": %d
+}
+`
+	writeStats := g.GetWriteStats()
+	var datastoreAvgSuccessfulWriteTimeMillis float64
+	if writeStats.WriteSuccesses > 0 {
+		datastoreAvgSuccessfulWriteTimeMillis = float64(writeStats.TotalWriteTimeMicros) / float64(writeStats.WriteSuccesses) / 1000.0
+	}
+	stats := g.GetStats()
+	_, _ = io.WriteString(w, fmt.Sprintf(jsonResponseTemplate, time.Now().Unix()-writeStats.LastSuccessfulWriteEpoch, stats.MetricsFetched, stats.MetricsReusedFromCache, stats.DatasetsFetched, stats.MetricPointsPerMinute, writeStats.MetricsDropped, stats.MetricFetchFailures, stats.SkippedTicks, writeStats.WriteFailures, writeStats.WriteSuccesses, datastoreAvgSuccessfulWriteTimeMillis, stats.DatabasesMonitored, stats.DatabasesConfigured, stats.DatabasesUnreachable, stats.UptimeSeconds))
+}
+
+// Calculates 1min avg metric fetching statistics for last 5min for StatsServerHandler to display
+func (g *Gatherer) StatsSummarizer(ctx context.Context) {
+	var prevMetricsCounterValue uint64
+	var currentMetricsCounterValue uint64
+	ticker := time.NewTicker(time.Minute * 5)
+	lastSummarization := g.gathererStartTime
+	for {
+		select {
+		case now := <-ticker.C:
+			currentMetricsCounterValue = atomic.LoadUint64(&g.totalMetricsFetchedCounter)
+			atomic.StoreInt64(&g.metricPointsPerMinuteLast5MinAvg, int64(math.Round(float64(currentMetricsCounterValue-prevMetricsCounterValue)*60/now.Sub(lastSummarization).Seconds())))
+			prevMetricsCounterValue = currentMetricsCounterValue
+			lastSummarization = now
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func FilterMonitoredDatabasesByGroup(monitoredDBs []MonitoredDatabase, group string) ([]MonitoredDatabase, int) {
+	ret := make([]MonitoredDatabase, 0)
+	groups := strings.Split(group, ",")
+	for _, md := range monitoredDBs {
+		// matched := false
+		for _, g := range groups {
+			if md.Group == g {
+				ret = append(ret, md)
+				break
+			}
+		}
+	}
+	return ret, len(monitoredDBs) - len(ret)
+}
+
+// Encrypt encrypts a password with the passphrase, to be stored in the configuration instead of the plain text one.
+// Used when --password-to-encrypt is set
+func Encrypt(passphrase, plaintext string) string {
+	key, salt := deriveKey(passphrase, nil)
+	iv := make([]byte, 12)
+	_, _ = rand.Read(iv)
+	b, _ := aes.NewCipher(key)
+	aesgcm, _ := cipher.NewGCM(b)
+	data := aesgcm.Seal(nil, iv, []byte(plaintext), nil)
+	return hex.EncodeToString(salt) + "-" + hex.EncodeToString(iv) + "-" + hex.EncodeToString(data)
+}
+
+func deriveKey(passphrase string, salt []byte) ([]byte, []byte) {
+	if salt == nil {
+		salt = make([]byte, 8)
+		_, _ = rand.Read(salt)
+	}
+	return pbkdf2.Key([]byte(passphrase), salt, 1000, 32, sha256.New), salt
+}
+
+func (g *Gatherer) decrypt(dbUnique, passphrase, ciphertext string) string {
+	arr := strings.Split(ciphertext, "-")
+	if len(arr) != 3 {
+		g.logger.Warningf("Aes-gcm-256 encrypted password for \"%s\" should consist of 3 parts - using 'as is'", dbUnique)
+		return ciphertext
+	}
+	salt, _ := hex.DecodeString(arr[0])
+	iv, _ := hex.DecodeString(arr[1])
+	data, _ := hex.DecodeString(arr[2])
+	key, _ := deriveKey(passphrase, salt)
+	b, _ := aes.NewCipher(key)
+	aesgcm, _ := cipher.NewGCM(b)
+	data, _ = aesgcm.Open(nil, iv, data, nil)
+	//log.Debug("decoded", string(data))
+	return string(data)
+}
+
+func SyncMonitoredDBsToDatastore(ctx context.Context, monitoredDbs []MonitoredDatabase, persistenceChannel chan []metrics.MeasurementMessage) {
+	if len(monitoredDbs) > 0 {
+		msms := make([]metrics.MeasurementMessage, len(monitoredDbs))
+		now := time.Now()
+
+		for _, mdb := range monitoredDbs {
+			db := metrics.Measurement{
+				"tag_group":                   mdb.Group,
+				"master_only":                 mdb.OnlyIfMaster,
+				"epoch_ns":                    now.UnixNano(),
+				"continuous_discovery_prefix": mdb.DBUniqueNameOrig,
+			}
+			for k, v := range mdb.CustomTags {
+				db[tagPrefix+k] = v
+			}
+			msms = append(msms, metrics.MeasurementMessage{
+				DBName:     mdb.DBUniqueName,
+				MetricName: monitoredDbsDatastoreSyncMetricName,
+				Data:       metrics.Measurements{db},
+			})
+		}
+		select {
+		case persistenceChannel <- msms:
+			//continue
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (g *Gatherer) shouldDbBeMonitoredBasedOnCurrentState(md MonitoredDatabase) bool {
+	return !g.IsDBDormant(md.DBUniqueName)
+}
+
+func ControlChannelsMapToList(controlChannels map[string]chan ControlMessage) []string {
+	controlChannelList := make([]string, len(controlChannels))
+	i := 0
+	for key := range controlChannels {
+		controlChannelList[i] = key
+		i++
+	}
+	return controlChannelList
+}
+
+func (g *Gatherer) CloseResourcesForRemovedMonitoredDBs(metricsWriter *sinks.MultiWriter, currentDBs, prevLoopDBs []MonitoredDatabase, shutDownDueToRoleChange map[string]bool) {
+	var curDBsMap = make(map[string]bool)
+
+	for _, curDB := range currentDBs {
+		curDBsMap[curDB.DBUniqueName] = true
+	}
+
+	for _, prevDB := range prevLoopDBs {
+		if _, ok := curDBsMap[prevDB.DBUniqueName]; !ok { // removed from config
+			g.CloseOrLimitSQLConnPoolForMonitoredDBIfAny(prevDB.DBUniqueName)
+			g.ClearRecentMeasurements(prevDB.DBUniqueName)
+			g.circuitBreakers.Delete(prevDB.DBUniqueName)
+			_ = metricsWriter.SyncMetrics(prevDB.DBUniqueName, "", "remove")
+		}
+	}
+
+	// or to be ignored due to current instance state
+	for roleChangedDB := range shutDownDueToRoleChange {
+		g.CloseOrLimitSQLConnPoolForMonitoredDBIfAny(roleChangedDB)
+		_ = metricsWriter.SyncMetrics(roleChangedDB, "", "remove")
+	}
+}
+
+func (g *Gatherer) SetUndersizedDBState(dbUnique string, state bool) {
+	g.undersizedDBsLock.Lock()
+	g.undersizedDBs[dbUnique] = state
+	g.undersizedDBsLock.Unlock()
+}
+
+func (g *Gatherer) IsDBUndersized(dbUnique string) bool {
+	g.undersizedDBsLock.RLock()
+	defer g.undersizedDBsLock.RUnlock()
+	undersized, ok := g.undersizedDBs[dbUnique]
+	if ok {
+		return undersized
+	}
+	return false
+}
+
+func (g *Gatherer) SetRecoveryIgnoredDBState(dbUnique string, state bool) {
+	g.recoveryIgnoredDBsLock.Lock()
+	g.recoveryIgnoredDBs[dbUnique] = state
+	g.recoveryIgnoredDBsLock.Unlock()
+}
+
+func (g *Gatherer) IsDBIgnoredBasedOnRecoveryState(dbUnique string) bool {
+	g.recoveryIgnoredDBsLock.RLock()
+	defer g.recoveryIgnoredDBsLock.RUnlock()
+	recoveryIgnored, ok := g.recoveryIgnoredDBs[dbUnique]
+	if ok {
+		return recoveryIgnored
+	}
+	return false
+}
+
+func (g *Gatherer) IsDBDormant(dbUnique string) bool {
+	return g.IsDBUndersized(dbUnique) || g.IsDBIgnoredBasedOnRecoveryState(dbUnique)
+}
+
+func (g *Gatherer) DoesEmergencyTriggerfileExist() bool {
+	// Main idea of the feature is to be able to quickly free monitored DBs / network of any extra "monitoring effect" load.
+	// In highly automated K8s / IaC environments such a temporary change might involve pull requests, peer reviews, CI/CD etc
+	// which can all take too long vs "exec -it pgwatch3-pod -- touch /tmp/pgwatch3-emergency-pause".
+	// After creating the file it can still take up to --servers-refresh-loop-seconds (2min def.) for change to take effect!
+	if g.opts.EmergencyPauseTriggerfile == "" {
+		return false
+	}
+	_, err := os.Stat(g.opts.EmergencyPauseTriggerfile)
+	return err == nil
+}
+
+func (g *Gatherer) LoadMetricDefs(ctx context.Context) error {
+	metricDefs, renamingDefs, origins, err := g.metricDefLayers.Resolve(ctx)
+	if err != nil {
+		return err
+	}
+	g.UpdateMetricDefinitions(metricDefs, renamingDefs)
+	g.metricDefMapLock.Lock()
+	g.metricDefOrigins = origins
+	g.metricDefMapLock.Unlock()
+	if len(g.metricDefLayers) > 1 {
+		perLayer := make(map[string]int)
+		for _, origin := range origins {
+			perLayer[origin.Layer]++
+		}
+		g.logger.Debugf("Effective metric definitions per layer of %s: %v", g.metricDefLayers.Name(), perLayer)
+	}
+	return nil
+}
+
+func (g *Gatherer) SyncMetricDefs(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(metricDefinitionRefreshInterval):
+			// reread metric definitions
+		}
+		if err := g.LoadMetricDefs(ctx); err != nil {
+			g.logger.Errorf("Could not refresh metric definitions: %w", err)
+		}
+	}
+}
+
+// Run gathers the metrics of the monitored databases until the context is cancelled, then shuts down gracefully
+func (g *Gatherer) Run(ctx context.Context) (err error) {
+	g.mainContext = ctx
+	g.fetchContext, g.abortFetches = context.WithCancel(context.WithoutCancel(g.mainContext))
+	var metricsWriter *sinks.MultiWriter
+
+	if !g.opts.Ping {
+		go g.StatsSummarizer(g.mainContext)
+	}
+
+	controlChannels := make(map[string](chan ControlMessage)) // [db1+metric1]=chan
+	measurementCh := make(chan []metrics.MeasurementMessage, 10000)
+	if err = g.registerSelfMetrics(measurementCh); err != nil {
+		return err
+	}
+
+	var monitoredDbs []MonitoredDatabase
+	var hostLastKnownStatusInRecovery = make(map[string]bool) // isInRecovery
+	var metricConfig map[string]float64                       // set to host.Metrics or host.MetricsStandby (in case optional config defined and in recovery state
+
+	if err = g.LoadMetricDefs(g.mainContext); err != nil {
+		return fmt.Errorf("could not load metric definitions: %w", err)
+	}
+	go g.SyncMetricDefs(g.mainContext)
+	g.RegisterBuiltinRecoChecks()
+
+	// sinks outlive mainContext, to store what's fetched until the end of a graceful shutdown
+	sinkContext := context.WithoutCancel(g.mainContext)
+	if metricsWriter, err = sinks.NewMultiWriter(sinkContext, g.opts, g.metricDefinitionMap, g.extraSinks...); err != nil {
+		return err
+	}
+	if err = metricsWriter.RegisterMetrics(g.registerer); err != nil {
+		return fmt.Errorf("could not register sink metrics: %w", err)
+	}
+	metricsWriter.SetDBGroupResolver(func(dbUnique string) string {
+		md, err := g.GetMonitoredDatabaseByUniqueName(dbUnique)
+		if err != nil {
+			return ""
+		}
+		return md.Group
+	})
+	g.metricsReader.Store(metricsWriter)
+	writeContext, stopWriting := context.WithCancel(sinkContext)
+	defer stopWriting()
+	writerDone := make(chan struct{})
+	if !g.opts.Ping {
+		go func() {
+			metricsWriter.WriteMetrics(writeContext, measurementCh)
+			close(writerDone)
+		}()
+	}
+
+	go g.scheduler.Run(g.mainContext)
+
+	var haLease *Lease // nil if not in HA mode, meaning always active
+	if g.opts.Connection.HALeaseSeconds > 0 && !g.opts.Ping {
+		if g.configDb == nil {
+			return errors.New("--ha-lease-seconds needs a config database")
+		}
+		haLease = NewLease(g.configDb, "leader:"+g.opts.Metric.Group, g.opts.Connection.HAInstanceName, time.Duration(g.opts.Connection.HALeaseSeconds)*time.Second)
+		go haLease.Run(g.mainContext)
+	}
+
+	var shards *ShardMembership // nil if not using dynamic sharding
+	if g.opts.Connection.ShardMembershipSeconds > 0 && !g.opts.Ping {
+		if g.configDb == nil {
+			return errors.New("--shard-membership-seconds needs a config database")
+		}
+		shards = NewShardMembership(g.configDb, g.opts.Metric.Group, g.opts.Connection.HAInstanceName, time.Duration(g.opts.Connection.ShardMembershipSeconds)*time.Second)
+		if err = shards.Refresh(g.mainContext); err != nil {
+			return fmt.Errorf("could not register as a shard member: %w", err)
+		}
+		go shards.Run(g.mainContext)
+	}
+
+	firstLoop := true
+	mainLoopCount := 0
+	dbSourcesChanged := g.dbSources.Watch(g.mainContext)
+
+	for { //main loop
+		hostsToShutDownDueToRoleChange := make(map[string]bool) // hosts went from master to standby and have "only if master" set
+		var controlChannelNameList []string
+		gatherersShutDown := 0
+
+		if g.fileBasedMetrics || len(g.opts.Connection.ExtraConfig) > 0 { // presets used by file and http sources
+			pmc, pms, err := metrics.ReadPresetMetricsConfigFromFS(g.metricsFS())
+			if err != nil {
+				if firstLoop {
+					return fmt.Errorf("could not read preset metric config: %w", err)
+				} else {
+					g.logger.Errorf("Could not read preset metric config : %w", err)
+				}
+			} else {
+				g.presetMetricDefMap = pmc
+				g.presetMetricScheduleMap = pms
+				g.logger.Infof("%d preset metric definitions found", len(pmc))
+				g.logger.Debugf("Loaded preset metric config: %#v", pmc)
+
+			}
+		}
+
+		// failing sources return their last valid DBs, so errors are only left for sources never read successfully
+		if dbs, err := g.dbSources.GetMonitoredDatabases(g.mainContext); err == nil {
+			monitoredDbs = dbs
+		} else if firstLoop {
+			return fmt.Errorf("could not fetch monitored DBs - check config: %w", err)
+		} else {
+			g.logger.Error("Could not fetch monitored DBs from all sources, continuing with the others. err: ", err)
+			monitoredDbs = dbs
+		}
+
+		if g.DoesEmergencyTriggerfileExist() {
+			g.logger.Warningf("Emergency pause triggerfile detected at %s, ignoring currently configured DBs", g.opts.EmergencyPauseTriggerfile)
+			monitoredDbs = make([]MonitoredDatabase, 0)
+		}
+
+		if !haLease.IsHeld() {
+			g.logger.Info("Passive HA gatherer, waiting for the active one to stop renewing its lease")
+			monitoredDbs = make([]MonitoredDatabase, 0)
+		}
+
+		if members, me := g.shard(shards); len(members) > 0 {
+			var removedCount int
+			monitoredDbs, removedCount = FilterMonitoredDatabasesByShard(monitoredDbs, members, me)
+			g.logger.Infof("Shard member %s of %d, leaving %d databases to other members", me, len(members), removedCount)
+		}
+
+		g.UpdateMonitoredDBCache(monitoredDbs)
+
+		if g.lastMonitoredDBsUpdate.IsZero() || g.lastMonitoredDBsUpdate.Before(time.Now().Add(-1*time.Second*monitoredDbsDatastoreSyncIntervalSeconds)) {
+			monitoredDbsCopy := make([]MonitoredDatabase, len(monitoredDbs))
+			copy(monitoredDbsCopy, monitoredDbs)
+			go SyncMonitoredDBsToDatastore(g.mainContext, monitoredDbsCopy, measurementCh)
+			g.lastMonitoredDBsUpdate = time.Now()
+		}
+
+		g.logger.
+			WithField("databases", len(monitoredDbs)).
+			WithField("metrics", len(g.metricDefinitionMap)).
+			Log(func() logrus.Level {
+				if firstLoop && len(monitoredDbs)*len(g.metricDefinitionMap) == 0 {
+					return logrus.WarnLevel
+				}
+				return logrus.InfoLevel
+			}(), "host info refreshed")
+
+		firstLoop = false // only used for failing when 1st config reading fails
+
+		for _, host := range monitoredDbs {
+			g.logger.WithField("database", host.DBUniqueName).
+				WithField("metric", host.Metrics).
+				WithField("tags", host.CustomTags).
+				WithField("config", host.HostConfig).Debug()
+
+			dbUnique := host.DBUniqueName
+			dbUniqueOrig := host.DBUniqueNameOrig
+			dbType := host.DBType
+			metricConfig = host.Metrics
+			wasInstancePreviouslyDormant := g.IsDBDormant(dbUnique)
+
+			if host.Encryption == "aes-gcm-256" && len(g.opts.AesGcmKeyphrase) == 0 && len(g.opts.AesGcmKeyphraseFile) == 0 {
+				// Warn if any encrypted hosts found but no keyphrase given
+				g.logger.Warningf("Encrypted password type found for host \"%s\", but no decryption keyphrase specified. Use --aes-gcm-keyphrase or --aes-gcm-keyphrase-file params", dbUnique)
+			}
+
+			err := g.InitSQLConnPoolForMonitoredDBIfNil(host)
+			if err != nil {
+				g.logger.Warningf("Could not init SQL connection pool for %s, retrying on next main loop. Err: %v", dbUnique, err)
+				continue
+			}
+
+			g.InitPGVersionInfoFetchingLockIfNil(host)
+
+			_, connectFailedSoFar := g.failedInitialConnectHosts[dbUnique]
+
+			if connectFailedSoFar { // idea is not to spwan any runners before we've successfully pinged the DB
+				var err error
+				var ver DBVersionMapEntry
+
+				if connectFailedSoFar {
+					g.logger.Infof("retrying to connect to uninitialized DB \"%s\"...", dbUnique)
+				} else {
+					g.logger.Infof("new host \"%s\" found, checking connectivity...", dbUnique)
+				}
+
+				ver, err = g.DBGetPGVersion(g.mainContext, dbUnique, dbType, true)
+				if err != nil {
+					g.logger.Errorf("could not start metric gathering for DB \"%s\" due to connection problem: %s", dbUnique, err)
+					if g.opts.AdHocConnString != "" {
+						g.logger.Errorf("will retry in %ds...", g.opts.Connection.ServersRefreshLoopSeconds)
+					}
+					g.failedInitialConnectHosts[dbUnique] = true
+					continue
+				}
+				g.logger.Infof("Connect OK. [%s] is on version %s (in recovery: %v)", dbUnique, ver.VersionStr, ver.IsInRecovery)
+				if connectFailedSoFar {
+					delete(g.failedInitialConnectHosts, dbUnique)
+				}
+				if ver.IsInRecovery && host.OnlyIfMaster {
+					g.logger.Infof("[%s] not added to monitoring due to 'master only' property", dbUnique)
+					continue
+				}
+				metricConfig = host.Metrics
+				hostLastKnownStatusInRecovery[dbUnique] = ver.IsInRecovery
+				if ver.IsInRecovery && len(host.MetricsStandby) > 0 {
+					metricConfig = host.MetricsStandby
+				}
+
+				if !g.opts.Ping && (host.IsSuperuser || g.opts.IsAdHocMode() && g.opts.AdHocCreateHelpers) && IsPostgresDBType(dbType) && !ver.IsInRecovery {
+					if g.opts.Metric.NoHelperFunctions {
+						g.logger.Infof("[%s] Skipping rollout out helper functions due to the --no-helper-functions flag ...", dbUnique)
+					} else {
+						g.logger.Infof("Trying to create helper functions if missing for \"%s\"...", dbUnique)
+						_ = g.TryCreateMetricsFetchingHelpers(dbUnique)
+					}
+				}
+
+			}
+
+			if IsPostgresDBType(host.DBType) {
+				var DBSizeMB int64
+
+				if g.opts.MinDbSizeMB >= 8 { // an empty DB is a bit less than 8MB
+					DBSizeMB, _ = g.DBGetSizeMB(dbUnique) // ignore errors, i.e. only remove from monitoring when we're certain it's under the threshold
+					if DBSizeMB != 0 {
+						if DBSizeMB < g.opts.MinDbSizeMB {
+							g.logger.Infof("[%s] DB will be ignored due to the --min-db-size-mb filter. Current (up to %v cached) DB size = %d MB", dbUnique, dbSizeCachingInterval, DBSizeMB)
+							hostsToShutDownDueToRoleChange[dbUnique] = true // for the case when DB size was previosly above the threshold
+							g.SetUndersizedDBState(dbUnique, true)
+							continue
+						}
+						g.SetUndersizedDBState(dbUnique, false)
+					}
+				}
+				ver, err := g.DBGetPGVersion(g.mainContext, dbUnique, host.DBType, false)
+				if err == nil { // ok to ignore error, re-tried on next loop
+					lastKnownStatusInRecovery := hostLastKnownStatusInRecovery[dbUnique]
+					if ver.IsInRecovery && host.OnlyIfMaster {
+						g.logger.Infof("[%s] to be removed from monitoring due to 'master only' property and status change", dbUnique)
+						hostsToShutDownDueToRoleChange[dbUnique] = true
+						g.SetRecoveryIgnoredDBState(dbUnique, true)
+						continue
+					} else if lastKnownStatusInRecovery != ver.IsInRecovery {
+						if ver.IsInRecovery && len(host.MetricsStandby) > 0 {
+							g.logger.Warningf("Switching metrics collection for \"%s\" to standby config...", dbUnique)
+							metricConfig = host.MetricsStandby
+							hostLastKnownStatusInRecovery[dbUnique] = true
+						} else {
+							g.logger.Warningf("Switching metrics collection for \"%s\" to primary config...", dbUnique)
+							metricConfig = host.Metrics
+							hostLastKnownStatusInRecovery[dbUnique] = false
+							g.SetRecoveryIgnoredDBState(dbUnique, false)
+						}
+					}
+				}
+
+				if wasInstancePreviouslyDormant && !g.IsDBDormant(dbUnique) {
+					g.RestoreSQLConnPoolLimitsForPreviouslyDormantDB(dbUnique)
+				}
+
+				if mainLoopCount == 0 && g.opts.TryCreateListedExtsIfMissing != "" && !ver.IsInRecovery {
+					extsToCreate := strings.Split(g.opts.TryCreateListedExtsIfMissing, ",")
+					extsCreated := g.TryCreateMissingExtensions(dbUnique, extsToCreate, ver.Extensions)
+					g.logger.Infof("[%s] %d/%d extensions created based on --try-create-listed-exts-if-missing input %v", dbUnique, len(extsCreated), len(extsToCreate), extsCreated)
+				}
+			}
+
+			if g.opts.Ping {
+				continue // don't launch metric fetching threads
+			}
+
+			for metricName, interval := range metricConfig {
+				metric := metricName
+				metricDefOk := false
+
+				if strings.HasPrefix(metric, recoPrefix) {
+					metric = recoMetricName
+				}
+				// interval := metricConfig[metric]
+
+				if metric == recoMetricName {
+					metricDefOk = true
+				} else {
+					g.metricDefMapLock.RLock()
+					_, metricDefOk = g.metricDefinitionMap[metric]
+					g.metricDefMapLock.RUnlock()
+				}
+
+				dbMetric := dbUnique + dbMetricJoinStr + metric
+				_, chOk := controlChannels[dbMetric]
+				schedule := g.GetMetricSchedule(host, metric, hostLastKnownStatusInRecovery[dbUnique] && len(host.MetricsStandby) > 0)
+
+				if metricDefOk && !chOk { // initialize a new per db/per metric control channel
+					if interval > 0 {
+						g.hostMetricIntervalMap[dbMetric] = interval
+						g.hostMetricScheduleMap[dbMetric] = schedule
+						g.logger.WithField("database", dbUnique).WithField("metric", metric).WithField("interval", interval).WithField("schedule", schedule).Info("starting gatherer")
+						controlChannels[dbMetric] = make(chan ControlMessage, 1)
+
+						metricNameForStorage := metricName
+						if _, isSpecialMetric := specialMetrics[metricName]; !isSpecialMetric {
+							vme, err := g.DBGetPGVersion(g.mainContext, dbUnique, dbType, false)
+							if err != nil {
+								g.logger.Warning("Failed to determine possible re-routing name, Grafana dashboards with re-routed metrics might not show all hosts")
+							} else {
+								mvp, err := g.GetMetricVersionProperties(metricName, vme, nil)
+								if err != nil && !strings.Contains(err.Error(), "too old") {
+									g.logger.Warning("Failed to determine possible re-routing name, Grafana dashboards with re-routed metrics might not show all hosts")
+								} else if mvp.MetricAttrs.MetricStorageName != "" {
+									metricNameForStorage = mvp.MetricAttrs.MetricStorageName
+								}
+							}
+						}
+
+						if err := metricsWriter.SyncMetrics(dbUnique, metricNameForStorage, "add"); err != nil {
+							g.logger.Error(err)
+						}
+						if metric == specialMetricServerLogEventCounts {
+							for _, derived := range logparseDerivedMetrics {
+								if err := metricsWriter.SyncMetrics(dbUnique, derived, "add"); err != nil {
+									g.logger.Error(err)
+								}
+							}
+						}
+
+						g.runningGatherers.Add(1)
+						go g.MetricGathererLoop(g.mainContext, dbUnique, dbUniqueOrig, dbType, metric, metricConfig, schedule, controlChannels[dbMetric], measurementCh)
+					}
+				} else if (!metricDefOk && chOk) || interval <= 0 {
+					// metric definition files were recently removed or interval set to zero
+					g.logger.Warning("shutting down metric", metric, "for", host.DBUniqueName)
+					controlChannels[dbMetric] <- ControlMessage{Action: gathererStatusStop}
+					delete(controlChannels, dbMetric)
+				} else if !metricDefOk {
+					epoch, ok := g.lastSQLFetchError.Load(metric)
+					if !ok || ((time.Now().Unix() - epoch.(int64)) > 3600) { // complain only 1x per hour
+						g.logger.Warningf("metric definition \"%s\" not found for \"%s\"", metric, dbUnique)
+						g.lastSQLFetchError.Store(metric, time.Now().Unix())
+					}
+				} else {
+					// check if interval or schedule has changed
+					if g.hostMetricIntervalMap[dbMetric] != interval || g.hostMetricScheduleMap[dbMetric] != schedule {
+						g.logger.Warning("sending interval update for", dbUnique, metric)
+						controlChannels[dbMetric] <- ControlMessage{Action: gathererStatusStart, Config: metricConfig, Schedule: schedule}
+						g.hostMetricIntervalMap[dbMetric] = interval
+						g.hostMetricScheduleMap[dbMetric] = schedule
+					}
+				}
+			}
+		}
+
+		if g.opts.Ping {
+			if len(g.failedInitialConnectHosts) > 0 {
+				return &PingError{Unreachable: len(g.failedInitialConnectHosts), Total: len(monitoredDbs)}
+			}
+			g.logger.Infof("All configured %d DB hosts were reachable", len(monitoredDbs))
+			return nil
+		}
+
+		if mainLoopCount == 0 {
+			goto MainLoopSleep
+		}
+
+		// loop over existing channels and stop workers if DB or metric removed from config
+		// or state change makes it uninteresting
+		g.logger.Debug("checking if any workers need to be shut down...")
+		controlChannelNameList = ControlChannelsMapToList(controlChannels)
+
+		for _, dbMetric := range controlChannelNameList {
+			var currentMetricConfig map[string]float64
+			var dbInfo MonitoredDatabase
+			var ok, dbRemovedFromConfig bool
+			singleMetricDisabled := false
+			splits := strings.Split(dbMetric, dbMetricJoinStr)
+			db := splits[0]
+			metric := splits[1]
+			//log.Debugf("Checking if need to shut down worker for [%s:%s]...", db, metric)
+
+			_, wholeDbShutDownDueToRoleChange := hostsToShutDownDueToRoleChange[db]
+			if !wholeDbShutDownDueToRoleChange {
+				g.monitoredDbCacheLock.RLock()
+				dbInfo, ok = g.monitoredDbCache[db]
+				g.monitoredDbCacheLock.RUnlock()
+				if !ok { // normal removing of DB from config
+					dbRemovedFromConfig = true
+					g.logger.Debugf("DB %s removed from config, shutting down all metric worker processes...", db)
+				}
+			}
+
+			if !(wholeDbShutDownDueToRoleChange || dbRemovedFromConfig) { // maybe some single metric was disabled
+				g.dbPgVersionMapLock.RLock()
+				verInfo, ok := g.dbPgVersionMap[db]
+				g.dbPgVersionMapLock.RUnlock()
+				if !ok {
+					g.logger.Warningf("Could not find PG version info for DB %s, skipping shutdown check of metric worker process for %s", db, metric)
+					continue
+				}
+
+				if verInfo.IsInRecovery && len(dbInfo.MetricsStandby) > 0 {
+					currentMetricConfig = dbInfo.MetricsStandby
+				} else {
+					currentMetricConfig = dbInfo.Metrics
+				}
+
+				interval, isMetricActive := currentMetricConfig[metric]
+				if !isMetricActive || interval <= 0 {
+					singleMetricDisabled = true
+				}
+			}
+
+			if g.mainContext.Err() != nil || wholeDbShutDownDueToRoleChange || dbRemovedFromConfig || singleMetricDisabled {
+				g.logger.Infof("shutting down gatherer for [%s:%s] ...", db, metric)
+				controlChannels[dbMetric] <- ControlMessage{Action: gathererStatusStop}
+				delete(controlChannels, dbMetric)
+				g.logger.Debugf("control channel for [%s:%s] deleted", db, metric)
+				gatherersShutDown++
+				g.ClearDBUnreachableStateIfAny(db)
+				if err := metricsWriter.SyncMetrics(db, metric, "remove"); err != nil {
+					g.logger.Error(err)
+				}
+				if metric == specialMetricServerLogEventCounts {
+					for _, derived := range logparseDerivedMetrics {
+						if err := metricsWriter.SyncMetrics(db, derived, "remove"); err != nil {
+							g.logger.Error(err)
+						}
+					}
+				}
+			}
+		}
+
+		if gatherersShutDown > 0 {
+			g.logger.Warningf("sent STOP message to %d gatherers (it might take some time for them to stop though)", gatherersShutDown)
+		}
+
+		// Destroy conn pools and metric writers
+		g.CloseResourcesForRemovedMonitoredDBs(metricsWriter, monitoredDbs, g.prevLoopMonitoredDBs, hostsToShutDownDueToRoleChange)
+
+	MainLoopSleep:
+		mainLoopCount++
+		g.prevLoopMonitoredDBs = monitoredDbs
+
+		g.logger.Debugf("main sleeping %ds...", g.opts.Connection.ServersRefreshLoopSeconds)
+		select {
+		case <-time.After(time.Second * time.Duration(g.opts.Connection.ServersRefreshLoopSeconds)):
+			// pass
+		case <-haLease.Changed():
+			// take over or stop right away
+		case <-shards.Changed():
+			// rebalance right away
+		case <-dbSourcesChanged:
+			// re-read the monitored DBs right away
+		case <-g.mainContext.Done():
+			g.GracefulShutdown(stopWriting, writerDone, metricsWriter, measurementCh)
+			return nil
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package reaper
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/cybertec-postgresql/pgwatch3/config"
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestGatherer creates a file based gatherer with an empty config folder
+func newTestGatherer(t *testing.T, opts *config.Options) *Gatherer {
+	if opts == nil {
+		opts = &config.Options{}
+	}
+	opts.Connection.Config = t.TempDir()
+	g, err := NewGatherer(context.Background(), opts, nil)
+	assert.NoError(t, err)
+	return g
+}
+
+func TestNewGatherer(t *testing.T) {
+	_, err := NewGatherer(context.Background(), &config.Options{Connection: config.ConnectionOpts{Config: "postgresql://localhost/pgwatch3"}}, nil)
+	assert.Error(t, err, "config DB connection needed")
+
+	g1, g2 := newTestGatherer(t, nil), newTestGatherer(t, nil)
+	assert.True(t, g1.fileBasedMetrics)
+	g1.SetDBUnreachableState("db1")
+	g1.UpdateMonitoredDBCache([]MonitoredDatabase{{DBUniqueName: "db1"}})
+	assert.False(t, g1.GetHealth("db1")[0].Reachable)
+	assert.Empty(t, g2.GetHealth(""), "gatherers should not share state")
+	assert.Zero(t, g2.GetStats().DatabasesUnreachable)
+	assert.WithinDuration(t, time.Now(), g1.gathererStartTime, time.Minute)
+}
+
+func TestVersionToInt(t *testing.T) {
+	tests := []struct {
+		arg  string
+		want uint
+	}{
+		{"", 0},
+		{"foo", 0},
+		{"13", 1300},
+		{"3.0", 300},
+		{"9.6.3", 906},
+		{"v9.6-beta2", 906},
+	}
+	for _, tt := range tests {
+		if got := VersionToInt(tt.arg); got != tt.want {
+			t.Errorf("VersionToInt() = %v, want %v", got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,253 @@
+package reaper
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/cybertec-postgresql/pgwatch3/metrics"
+	"github.com/cybertec-postgresql/pgwatch3/sinks"
+)
+
+const (
+	recentMeasurementsMaxCount = 60    // data sets per DB and metric
+	recentMeasurementsMaxRows  = 10000 // rows over all data sets per DB and metric, the latest set is always kept
+
+	unusedIndexHistoryWindow = 24 * time.Hour // stored index_stats searched for the scan counts of MinDays ago
+	unusedIndexHistoryLimit  = 100000
+)
+
+// RecentMeasurement is a single stored data set of a metric
+type RecentMeasurement struct {
+	Time time.Time
+	Data metrics.Measurements
+}
+
+// RecordRecentMeasurements keeps the last data sets of metrics used as inputs for Go recommendation checks
+func (g *Gatherer) RecordRecentMeasurements(msgs []metrics.MeasurementMessage) {
+	g.recentMeasurementsLock.Lock()
+	defer g.recentMeasurementsLock.Unlock()
+	for _, msg := range msgs {
+		if !g.recentMeasurementsWanted[msg.MetricName] || len(msg.Data) == 0 {
+			continue
+		}
+		key := msg.DBName + dbMetricJoinStr + msg.MetricName
+		recent := append(g.recentMeasurements[key], RecentMeasurement{Time: time.Now(), Data: deepCopyMetricData(msg.Data)})
+		if len(recent) > recentMeasurementsMaxCount {
+			recent = recent[len(recent)-recentMeasurementsMaxCount:]
+		}
+		rows := 0
+		for i := len(recent) - 1; i >= 0; i-- {
+			if rows += len(recent[i].Data); rows > recentMeasurementsMaxRows && i < len(recent)-1 {
+				recent = recent[i+1:]
+				break
+			}
+		}
+		g.recentMeasurements[key] = slices.Clip(recent)
+	}
+}
+
+// ClearRecentMeasurements drops stored data sets for a DB removed from monitoring
+func (g *Gatherer) ClearRecentMeasurements(dbUnique string) {
+	g.recentMeasurementsLock.Lock()
+	defer g.recentMeasurementsLock.Unlock()
+	for key := range g.recentMeasurements {
+		if strings.HasPrefix(key, dbUnique+dbMetricJoinStr) {
+			delete(g.recentMeasurements, key)
+		}
+	}
+}
+
+// RecoCheckContext gives Go recommendation checks access to the monitored DB and its recent measurements
+type RecoCheckContext struct {
+	DBUniqueName string
+	VersionInfo  DBVersionMapEntry
+	State        map[string]string // kept between runs, per check and DB
+	g            *Gatherer
+}
+
+// Query executes SQL on the monitored DB
+func (c RecoCheckContext) Query(sql string, args ...any) (metrics.Measurements, error) {
+	return c.g.DBExecReadByDbUniqueName(c.g.mainContext, c.DBUniqueName, sql, args...)
+}
+
+// Recent returns the last stored data sets of a metric, oldest first
+func (c RecoCheckContext) Recent(metricName string) []RecentMeasurement {
+	c.g.recentMeasurementsLock.RLock()
+	defer c.g.recentMeasurementsLock.RUnlock()
+	return append([]RecentMeasurement(nil), c.g.recentMeasurements[c.DBUniqueName+dbMetricJoinStr+metricName]...)
+}
+
+// History returns the stored measurements of a metric in the given time range, oldest first, read from the sinks
+func (c RecoCheckContext) History(metricName string, from, to time.Time, limit int) (metrics.Measurements, error) {
+	mw := c.g.MetricsReader()
+	if mw == nil {
+		return nil, sinks.ErrNoReader
+	}
+	return mw.ReadMeasurements(sinks.MeasurementQuery{Metric: metricName, DBName: c.DBUniqueName, From: from, To: to, Limit: limit})
+}
+
+// RegisterBuiltinRecoChecks adds the Go checks shipped with pgwatch3
+func (g *Gatherer) RegisterBuiltinRecoChecks() {
+	g.RegisterRecoCheck(sharedBuffersRecoCheck{})
+	g.RegisterRecoCheck(unusedIndexesRecoCheck{MinDays: g.opts.RecoUnusedIndexDays})
+	g.RegisterRecoCheck(wraparoundRecoCheck{})
+}
+
+func toFloat64(v any) (float64, bool) {
+	switch n := v.(type) {
+	case int64:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	case float64:
+		return n, true
+	case float32:
+		return float64(n), true
+	}
+	return 0, false
+}
+
+// sharedBuffersRecoCheck advises increasing shared_buffers when the buffer cache hit ratio
+// is low while shared_buffers is only a small fraction of the RAM
+type sharedBuffersRecoCheck struct{}
+
+func (sharedBuffersRecoCheck) Topic() string { return "shared_buffers_too_small" }
+
+func (sharedBuffersRecoCheck) InputMetrics() []string { return []string{"db_stats", metricPsutilMem} }
+
+func (sharedBuffersRecoCheck) Run(c RecoCheckContext) (metrics.Measurements, error) {
+	const minHitRatio, maxRAMShare, minBlocksAccessed = 0.95, 0.25, 100000
+	stats := c.Recent("db_stats")
+	mem := c.Recent(metricPsutilMem)
+	if len(stats) < 2 || len(mem) == 0 {
+		return nil, nil
+	}
+	first, last := stats[0].Data[0], stats[len(stats)-1].Data[0]
+	hit1, ok1 := toFloat64(first["blks_hit"])
+	read1, ok2 := toFloat64(first["blks_read"])
+	hit2, ok3 := toFloat64(last["blks_hit"])
+	read2, ok4 := toFloat64(last["blks_read"])
+	ramBytes, ok5 := toFloat64(mem[len(mem)-1].Data[0]["total"])
+	if !(ok1 && ok2 && ok3 && ok4 && ok5) || ramBytes <= 0 {
+		return nil, nil
+	}
+	hits, reads := hit2-hit1, read2-read1
+	if hits < 0 || reads < 0 || hits+reads < minBlocksAccessed { // stats reset or too little activity
+		return nil, nil
+	}
+	hitRatio := hits / (hits + reads)
+	data, err := c.Query(`select setting::int8 * current_setting('block_size')::int8 as shared_buffers_b from pg_settings where name = 'shared_buffers'`)
+	if err != nil || len(data) == 0 {
+		return nil, err
+	}
+	sb, _ := toFloat64(data[0]["shared_buffers_b"])
+	if hitRatio >= minHitRatio || sb/ramBytes >= maxRAMShare {
+		return nil, nil
+	}
+	return metrics.Measurements{{
+		"tag_object_name": "shared_buffers",
+		"recommendation":  fmt.Sprintf("consider increasing shared_buffers up to %d MB (25%% of RAM)", int64(ramBytes*maxRAMShare/1024/1024)),
+		"extra_info": fmt.Sprintf("buffer cache hit ratio %.1f%% over the last %s, shared_buffers %d MB, RAM %d MB",
+			hitRatio*100, stats[len(stats)-1].Time.Sub(stats[0].Time).Round(time.Minute), int64(sb/1024/1024), int64(ramBytes/1024/1024)),
+		"severity": recoSeverityWarning,
+	}}, nil
+}
+
+// unusedIndexesRecoCheck reports non-constraint indexes not scanned at all for at least MinDays, comparing the latest
+// index_stats with the stored ones of MinDays ago
+type unusedIndexesRecoCheck struct {
+	MinDays int
+}
+
+func (unusedIndexesRecoCheck) Topic() string { return "unused_index_history" }
+
+func (unusedIndexesRecoCheck) InputMetrics() []string { return []string{"index_stats"} }
+
+func (u unusedIndexesRecoCheck) Run(c RecoCheckContext) (metrics.Measurements, error) {
+	recent := c.Recent("index_stats")
+	if len(recent) == 0 || u.MinDays <= 0 {
+		return nil, nil
+	}
+	last := recent[len(recent)-1]
+	until := last.Time.Add(-time.Hour * 24 * time.Duration(u.MinDays))
+	history, err := c.History("index_stats", until.Add(-unusedIndexHistoryWindow), until, unusedIndexHistoryLimit)
+	if err != nil {
+		return nil, err
+	}
+	baselineScans := make(map[any]float64) // the last stored scan count of every index before the period
+	for _, row := range history {
+		if scans, ok := toFloat64(row["idx_scan"]); ok {
+			baselineScans[row["tag_index_full_name"]] = scans
+		}
+	}
+	var ret metrics.Measurements
+	for _, row := range last.Data {
+		isPk, _ := toFloat64(row["is_pk_int"])
+		isUnique, _ := toFloat64(row["is_uq_or_exc"])
+		if isPk == 1 || isUnique == 1 {
+			continue
+		}
+		prevScans, ok := baselineScans[row["tag_index_full_name"]]
+		scans, ok2 := toFloat64(row["idx_scan"])
+		if !ok || !ok2 || scans != prevScans {
+			continue
+		}
+		ret = append(ret, metrics.Measurement{
+			"tag_object_name": row["tag_index_full_name"],
+			"recommendation":  fmt.Sprintf("DROP INDEX %v;", row["tag_index_full_name"]),
+			"extra_info":      fmt.Sprintf("no index scans during the last %d days, index size %v bytes. Check also replicas before dropping", u.MinDays, row["index_size_b"]),
+		})
+	}
+	return ret, nil
+}
+
+// wraparoundRecoCheck estimates the time left until the transaction ID wraparound protection kicks in,
+// based on the XID consumption rate between runs
+type wraparoundRecoCheck struct{}
+
+func (wraparoundRecoCheck) Topic() string { return "wraparound_eta" }
+
+func (wraparoundRecoCheck) InputMetrics() []string { return nil }
+
+func (wraparoundRecoCheck) Run(c RecoCheckContext) (metrics.Measurements, error) {
+	const xidStopLimit = math.MaxInt32 - 3_000_000 // new XIDs are refused after that
+	const warnDays, criticalDays = 30, 7
+	data, err := c.Query(`select txid_snapshot_xmax(txid_current_snapshot())::int8 as xid_counter,
+		(select max(age(datfrozenxid)) from pg_database)::int8 as max_xid_age`)
+	if err != nil || len(data) == 0 {
+		return nil, err
+	}
+	xidCounter, _ := toFloat64(data[0]["xid_counter"])
+	maxAge, _ := toFloat64(data[0]["max_xid_age"])
+	now := float64(time.Now().Unix())
+
+	prevCounter, prevTime := c.State["xid_counter"], c.State["time"]
+	c.State["xid_counter"], c.State["time"] = fmt.Sprint(int64(xidCounter)), fmt.Sprint(int64(now))
+	var pc, pt float64
+	if _, err := fmt.Sscan(prevCounter, &pc); err != nil {
+		return nil, nil
+	}
+	if _, err := fmt.Sscan(prevTime, &pt); err != nil || now <= pt || xidCounter <= pc {
+		return nil, nil
+	}
+	rate := (xidCounter - pc) / (now - pt) // XIDs per second
+	etaDays := (xidStopLimit - maxAge) / rate / 86400
+	if etaDays > warnDays {
+		return nil, nil
+	}
+	severity := recoSeverityWarning
+	if etaDays <= criticalDays {
+		severity = recoSeverityCritical
+	}
+	return metrics.Measurements{{
+		"tag_object_name": "-",
+		"recommendation":  "transaction ID wraparound approaching - make sure (auto)vacuum freezing keeps up, check long running transactions and replication slots",
+		"extra_info":      fmt.Sprintf("at the current rate of %.0f XIDs/s the wraparound stop limit is reached in %.1f days (oldest datfrozenxid age %.0f)", rate, etaDays, maxAge),
+		"severity":        severity,
+	}}, nil
+}
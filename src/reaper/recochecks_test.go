@@ -0,0 +1,104 @@
+package reaper
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/cybertec-postgresql/pgwatch3/config"
+	"github.com/cybertec-postgresql/pgwatch3/metrics"
+	"github.com/cybertec-postgresql/pgwatch3/sinks"
+	"github.com/stretchr/testify/assert"
+)
+
+// historySink is a readable sink returning the given rows for any query, recording the last one
+type historySink struct {
+	rows  metrics.Measurements
+	query sinks.MeasurementQuery
+}
+
+func (*historySink) SyncMetric(_, _, _ string) error { return nil }
+
+func (*historySink) Write(_ []metrics.MeasurementMessage) error { return nil }
+
+func (h *historySink) ReadMeasurements(q sinks.MeasurementQuery) (metrics.Measurements, error) {
+	h.query = q
+	return h.rows, nil
+}
+
+func TestRecoID(t *testing.T) {
+	assert.Equal(t, RecoID("a", "b"), RecoID("a", "b"), "reco ID should be stable")
+	assert.NotEqual(t, RecoID("a", "b"), RecoID("a", "c"))
+}
+
+func TestRecordRecentMeasurements(t *testing.T) {
+	g := newTestGatherer(t, nil)
+	g.RegisterRecoCheck(unusedIndexesRecoCheck{MinDays: 7})
+	c := RecoCheckContext{DBUniqueName: "db1", g: g}
+	record := func(metric string, rows int) {
+		g.RecordRecentMeasurements([]metrics.MeasurementMessage{{DBName: "db1", MetricName: metric, Data: make(metrics.Measurements, rows)}})
+	}
+
+	record("db_stats", 1)
+	assert.Empty(t, c.Recent("db_stats"), "only metrics needed by the checks should be kept")
+
+	for i := 0; i < recentMeasurementsMaxCount+5; i++ {
+		record("index_stats", 1)
+	}
+	assert.Len(t, c.Recent("index_stats"), recentMeasurementsMaxCount)
+
+	record("index_stats", recentMeasurementsMaxRows-1)
+	assert.Len(t, c.Recent("index_stats"), 2, "older data sets should be dropped over the row limit")
+	record("index_stats", recentMeasurementsMaxRows+1)
+	assert.Len(t, c.Recent("index_stats"), 1, "the latest data set should be kept even if over the row limit")
+
+	g.ClearRecentMeasurements("db1")
+	assert.Empty(t, c.Recent("index_stats"))
+}
+
+func TestUnusedIndexesRecoCheck(t *testing.T) {
+	indexStats := func(scansUsed, scansUnused any) metrics.Measurements {
+		return metrics.Measurements{
+			{"tag_index_full_name": "public.idx_used", "idx_scan": scansUsed, "is_pk_int": int32(0), "is_uq_or_exc": int32(0)},
+			{"tag_index_full_name": "public.idx_unused", "idx_scan": scansUnused, "is_pk_int": int32(0), "is_uq_or_exc": int32(0)},
+			{"tag_index_full_name": "public.pk", "idx_scan": int64(0), "is_pk_int": int32(1), "is_uq_or_exc": int32(1)},
+		}
+	}
+	newCheck := func(t *testing.T, history metrics.Measurements) (unusedIndexesRecoCheck, RecoCheckContext, *historySink) {
+		g := newTestGatherer(t, nil)
+		check := unusedIndexesRecoCheck{MinDays: 7}
+		g.RegisterRecoCheck(check)
+		sink := &historySink{rows: history}
+		mw, err := sinks.NewMultiWriter(context.Background(), &config.Options{}, nil, sink)
+		assert.NoError(t, err)
+		g.metricsReader.Store(mw)
+		g.RecordRecentMeasurements([]metrics.MeasurementMessage{{DBName: "db1", MetricName: "index_stats", Data: indexStats(int64(20), int64(3))}})
+		return check, RecoCheckContext{DBUniqueName: "db1", g: g}, sink
+	}
+
+	t.Run("unused", func(t *testing.T) {
+		// stored JSONB values are read back as float64
+		check, c, sink := newCheck(t, append(indexStats(float64(5), float64(1)), indexStats(float64(10), float64(3))...))
+		recos, err := check.Run(c)
+		assert.NoError(t, err)
+		assert.Len(t, recos, 1)
+		assert.Equal(t, "public.idx_unused", recos[0]["tag_object_name"])
+		assert.Equal(t, "index_stats", sink.query.Metric)
+		assert.Equal(t, "db1", sink.query.DBName)
+		assert.WithinDuration(t, time.Now().AddDate(0, 0, -7), sink.query.To, time.Minute)
+	})
+
+	t.Run("not enough history", func(t *testing.T) {
+		check, c, _ := newCheck(t, nil)
+		recos, err := check.Run(c)
+		assert.NoError(t, err)
+		assert.Empty(t, recos)
+	})
+
+	t.Run("no readable sink", func(t *testing.T) {
+		check, c, _ := newCheck(t, nil)
+		c.g.metricsReader.Store(nil)
+		_, err := check.Run(c)
+		assert.ErrorIs(t, err, sinks.ErrNoReader)
+	})
+}
@@ -0,0 +1,189 @@
+package reaper
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cybertec-postgresql/pgwatch3/metrics"
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	recoSeverityInfo     = "info"
+	recoSeverityWarning  = "warning"
+	recoSeverityCritical = "critical"
+
+	recoStatusNew      = "new"      // first seen in the current run
+	recoStatusOpen     = "open"     // seen also in the previous run
+	recoStatusResolved = "resolved" // seen in the previous run but not anymore
+
+	recoHostStateKey = "recommendations" // hostState key for the previous run results
+)
+
+var recoSeverities = map[string]bool{recoSeverityInfo: true, recoSeverityWarning: true, recoSeverityCritical: true}
+
+// RecoID returns a stable identifier for a recommendation, used for diffing and acknowledging
+func RecoID(topic, objectName string) string {
+	h := sha1.Sum([]byte(topic + dbMetricJoinStr + objectName))
+	return hex.EncodeToString(h[:8])
+}
+
+// GetRecoAcks returns IDs of acknowledged or currently snoozed recommendations for the DB. Acks
+// are only supported with the config DB
+func (g *Gatherer) GetRecoAcks(dbUnique string) (map[string]bool, error) {
+	acks := make(map[string]bool)
+	if g.configDb == nil {
+		return acks, nil
+	}
+	rows, err := g.configDb.Query(g.mainContext, `select ra_reco_id from pgwatch3.reco_ack
+		where ra_dbname = $1 and (ra_snoozed_until is null or ra_snoozed_until > now())`, dbUnique)
+	if err != nil {
+		return nil, err
+	}
+	ids, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	for _, id := range ids {
+		acks[id] = true
+	}
+	return acks, err
+}
+
+// previous run state is kept in hostState as id -> topic, object name, severity, first seen epoch
+func encodeRecoState(topic, objectName, severity string, firstSeen int64) string {
+	return strings.Join([]string{topic, objectName, severity, strconv.FormatInt(firstSeen, 10)}, dbMetricJoinStr)
+}
+
+func decodeRecoState(s string) (topic, objectName, severity string, firstSeen int64, err error) {
+	parts := strings.Split(s, dbMetricJoinStr)
+	if len(parts) != 4 {
+		return "", "", "", 0, fmt.Errorf("invalid recommendation state %q, expected 4 fields, got %d", s, len(parts))
+	}
+	if parts[0] == "" || !recoSeverities[parts[2]] {
+		return "", "", "", 0, fmt.Errorf("invalid recommendation state %q, empty topic or unknown severity", s)
+	}
+	if firstSeen, err = strconv.ParseInt(parts[3], 10, 64); err != nil || firstSeen <= 0 {
+		return "", "", "", 0, fmt.Errorf("invalid recommendation state %q, bad first seen epoch", s)
+	}
+	return parts[0], parts[1], parts[2], firstSeen, nil
+}
+
+// GetRecommendations runs all reco_* metrics and stores the results under one metric. Every recommendation gets a
+// stable "reco_id" tag, a severity and a status compared to the previous run, i.e. "new", "open" or "resolved".
+// Acknowledged / snoozed recommendations are left out
+func (g *Gatherer) GetRecommendations(dbUnique string, vme DBVersionMapEntry, hostState map[string]map[string]string) (metrics.Measurements, error) {
+	retData := make(metrics.Measurements, 0)
+	startTimeEpochNs := time.Now().UnixNano()
+
+	acks, err := g.GetRecoAcks(dbUnique)
+	if err != nil {
+		g.logger.Warningf("[%s] Could not read acknowledged recommendations: %v", dbUnique, err)
+	}
+	prevState := hostState[recoHostStateKey]
+	currState := make(map[string]string)
+
+	recoMetrics := g.GetAllRecoMetricsForVersion(vme)
+	g.logger.Debugf("Processing %d recommendation metrics for \"%s\"", len(recoMetrics), dbUnique)
+
+	addReco := func(d metrics.Measurement, defaultSeverity string) {
+		topic := fmt.Sprint(d["tag_reco_topic"])
+		objectName := fmt.Sprint(d["tag_object_name"])
+		id := RecoID(topic, objectName)
+
+		severity, _ := d["severity"].(string)
+		if !recoSeverities[severity] {
+			severity = defaultSeverity
+		}
+		if !recoSeverities[severity] {
+			severity = recoSeverityInfo
+		}
+
+		status, firstSeen := recoStatusNew, startTimeEpochNs
+		if prev, ok := prevState[id]; ok {
+			if _, _, _, prevFirstSeen, err := decodeRecoState(prev); err != nil {
+				g.logger.Warningf("[%s] Treating recommendation %s as new: %v", dbUnique, id, err)
+			} else {
+				status, firstSeen = recoStatusOpen, prevFirstSeen
+			}
+		}
+		currState[id] = encodeRecoState(topic, objectName, severity, firstSeen)
+		if acks[id] {
+			return // tracked still, to not show up as "new" when the snooze expires
+		}
+
+		d["tag_reco_id"] = id
+		d["severity"] = severity
+		d["status"] = status
+		d["first_seen_epoch_ns"] = firstSeen
+		d[epochColumnName] = startTimeEpochNs
+		d["major_ver"] = vme.Version / 10
+		retData = append(retData, d)
+	}
+
+	for m, mvp := range recoMetrics {
+		data, err := g.DBExecReadByDbUniqueName(g.mainContext, dbUnique, mvp.SQL)
+		if err != nil {
+			if strings.Contains(err.Error(), "does not exist") { // some more exotic extensions missing is expected, don't pollute the error log
+				g.logger.Infof("[%s:%s] Could not execute recommendations SQL: %v", dbUnique, m, err)
+			} else {
+				g.logger.Errorf("[%s:%s] Could not execute recommendations SQL: %v", dbUnique, m, err)
+			}
+			continue
+		}
+		for _, d := range data {
+			addReco(d, mvp.MetricAttrs.RecoSeverity)
+		}
+	}
+
+	for _, check := range g.GetAllRecoChecks() {
+		stateKey := recoHostStateKey + dbMetricJoinStr + check.Topic()
+		if hostState[stateKey] == nil {
+			hostState[stateKey] = make(map[string]string)
+		}
+		data, err := check.Run(RecoCheckContext{DBUniqueName: dbUnique, VersionInfo: vme, State: hostState[stateKey], g: g})
+		if err != nil {
+			g.logger.Infof("[%s:%s] Could not execute recommendations check: %v", dbUnique, check.Topic(), err)
+			continue
+		}
+		for _, d := range data {
+			d["tag_reco_topic"] = check.Topic()
+			addReco(d, recoSeverityInfo)
+		}
+	}
+
+	for id, prev := range prevState {
+		if _, ok := currState[id]; ok || acks[id] {
+			continue
+		}
+		topic, objectName, severity, firstSeen, err := decodeRecoState(prev)
+		if err != nil {
+			g.logger.Warningf("[%s] Not reporting recommendation %s as resolved: %v", dbUnique, id, err)
+			continue
+		}
+		retData = append(retData, metrics.Measurement{
+			"tag_reco_id":         id,
+			"tag_reco_topic":      topic,
+			"tag_object_name":     objectName,
+			"recommendation":      "resolved",
+			"severity":            severity,
+			"status":              recoStatusResolved,
+			"first_seen_epoch_ns": firstSeen,
+			epochColumnName:       startTimeEpochNs,
+			"major_ver":           vme.Version / 10,
+		})
+	}
+	hostState[recoHostStateKey] = currState
+
+	if len(retData) == 0 { // insert a dummy entry minimally so that Grafana can show at least a dropdown
+		dummy := make(metrics.Measurement)
+		dummy["tag_reco_topic"] = "dummy"
+		dummy["tag_object_name"] = "-"
+		dummy["recommendation"] = "no recommendations"
+		dummy[epochColumnName] = startTimeEpochNs
+		dummy["major_ver"] = vme.Version / 10
+		retData = append(retData, dummy)
+	}
+	return retData, nil
+}
@@ -0,0 +1,109 @@
+package reaper
+
+import (
+	"testing"
+
+	"github.com/cybertec-postgresql/pgwatch3/metrics"
+	"github.com/pashagolub/pgxmock/v3"
+	"github.com/stretchr/testify/assert"
+)
+
+// staticRecoCheck returns the given object names as recommendations
+type staticRecoCheck struct {
+	objects *[]string
+}
+
+func (staticRecoCheck) Topic() string { return "static" }
+
+func (staticRecoCheck) InputMetrics() []string { return nil }
+
+func (s staticRecoCheck) Run(_ RecoCheckContext) (metrics.Measurements, error) {
+	var ret metrics.Measurements
+	for _, o := range *s.objects {
+		ret = append(ret, metrics.Measurement{"tag_object_name": o, "recommendation": "fix " + o, "severity": recoSeverityWarning})
+	}
+	return ret, nil
+}
+
+func TestRecoState(t *testing.T) {
+	topic, objectName, severity, firstSeen, err := decodeRecoState(encodeRecoState("unused_index", "public.idx", recoSeverityWarning, 42))
+	assert.NoError(t, err)
+	assert.Equal(t, []any{"unused_index", "public.idx", recoSeverityWarning, int64(42)}, []any{topic, objectName, severity, firstSeen})
+
+	for _, s := range []string{
+		"",
+		"unused_index" + dbMetricJoinStr + "public.idx",
+		encodeRecoState("", "public.idx", recoSeverityWarning, 42),
+		encodeRecoState("unused_index", "public.idx", "urgent", 42),
+		encodeRecoState("unused_index", "public.idx", recoSeverityWarning, 0),
+		encodeRecoState("unused_index", "public.idx", recoSeverityWarning, 42) + dbMetricJoinStr + "extra",
+		"unused_index" + dbMetricJoinStr + "public.idx" + dbMetricJoinStr + recoSeverityWarning + dbMetricJoinStr + "yesterday",
+	} {
+		_, _, _, _, err := decodeRecoState(s)
+		assert.Error(t, err, s)
+	}
+}
+
+func TestGetRecommendationsStatus(t *testing.T) {
+	objects := []string{"a", "b"}
+	g := newTestGatherer(t, nil)
+	g.RegisterRecoCheck(staticRecoCheck{&objects})
+	hostState := make(map[string]map[string]string)
+	byObject := func(data metrics.Measurements) map[any]metrics.Measurement {
+		ret := make(map[any]metrics.Measurement)
+		for _, d := range data {
+			ret[d["tag_object_name"]] = d
+		}
+		return ret
+	}
+
+	data, err := g.GetRecommendations("db1", DBVersionMapEntry{}, hostState)
+	assert.NoError(t, err)
+	first := byObject(data)
+	assert.Len(t, first, 2)
+	assert.Equal(t, recoStatusNew, first["a"]["status"])
+	assert.Equal(t, RecoID("static", "a"), first["a"]["tag_reco_id"])
+
+	objects = []string{"a"}
+	data, err = g.GetRecommendations("db1", DBVersionMapEntry{}, hostState)
+	assert.NoError(t, err)
+	second := byObject(data)
+	assert.Len(t, second, 2)
+	assert.Equal(t, recoStatusOpen, second["a"]["status"], "persisting")
+	assert.Equal(t, first["a"]["first_seen_epoch_ns"], second["a"]["first_seen_epoch_ns"])
+	assert.Equal(t, recoStatusResolved, second["b"]["status"])
+	assert.Equal(t, recoSeverityWarning, second["b"]["severity"])
+	assert.Equal(t, first["b"]["first_seen_epoch_ns"], second["b"]["first_seen_epoch_ns"])
+
+	hostState[recoHostStateKey][RecoID("static", "a")] = "corrupt"
+	hostState[recoHostStateKey][RecoID("static", "gone")] = "corrupt"
+	data, err = g.GetRecommendations("db1", DBVersionMapEntry{}, hostState)
+	assert.NoError(t, err)
+	assert.Len(t, data, 1, "a malformed state of a gone recommendation should not be reported as resolved")
+	assert.Equal(t, recoStatusNew, data[0]["status"], "a malformed state should start over")
+}
+
+func TestGetRecommendationsAcked(t *testing.T) {
+	objects := []string{"a", "b"}
+	g := newTestGatherer(t, nil)
+	g.RegisterRecoCheck(staticRecoCheck{&objects})
+	conn, err := pgxmock.NewPool()
+	assert.NoError(t, err)
+	g.configDb = conn
+	hostState := make(map[string]map[string]string)
+
+	conn.ExpectQuery("reco_ack").WithArgs("db1").WillReturnRows(pgxmock.NewRows([]string{"ra_reco_id"}).AddRow(RecoID("static", "a")))
+	data, err := g.GetRecommendations("db1", DBVersionMapEntry{}, hostState)
+	assert.NoError(t, err)
+	assert.Len(t, data, 1)
+	assert.Equal(t, "b", data[0]["tag_object_name"], "acked recommendations should be left out")
+
+	objects = nil
+	conn.ExpectQuery("reco_ack").WithArgs("db1").WillReturnRows(pgxmock.NewRows([]string{"ra_reco_id"}).AddRow(RecoID("static", "a")))
+	data, err = g.GetRecommendations("db1", DBVersionMapEntry{}, hostState)
+	assert.NoError(t, err)
+	assert.Len(t, data, 1)
+	assert.Equal(t, "b", data[0]["tag_object_name"], "acked recommendations should not be reported as resolved")
+	assert.Equal(t, recoStatusResolved, data[0]["status"])
+	assert.NoError(t, conn.ExpectationsWereMet())
+}
@@ -0,0 +1,269 @@
+package reaper
+
+import (
+	"container/heap"
+	"context"
+	"hash/fnv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cybertec-postgresql/pgwatch3/metrics"
+)
+
+// ScheduledJob is a DB / metric pair woken up by the Scheduler at fixed-rate ticks or by a schedule. Ticks are delivered
+// on C, a tick arriving while the previous one is still not consumed (fetch overrun or waiting for a concurrency slot) is
+// skipped
+type ScheduledJob struct {
+	C        <-chan time.Time
+	ticks    chan time.Time
+	interval time.Duration
+	schedule metrics.Schedule // nil for fixed-rate ticks
+	next     time.Time
+	index    int // position in the queue, -1 if removed
+	skipped  uint64
+}
+
+// Skipped returns the number of ticks skipped due to overruns
+func (j *ScheduledJob) Skipped() uint64 {
+	return atomic.LoadUint64(&j.skipped)
+}
+
+type scheduleQueue []*ScheduledJob
+
+func (q scheduleQueue) Len() int           { return len(q) }
+func (q scheduleQueue) Less(i, j int) bool { return q[i].next.Before(q[j].next) }
+func (q scheduleQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index, q[j].index = i, j
+}
+func (q *scheduleQueue) Push(x any) {
+	job := x.(*ScheduledJob)
+	job.index = len(*q)
+	*q = append(*q, job)
+}
+func (q *scheduleQueue) Pop() any {
+	old := *q
+	job := old[len(old)-1]
+	old[len(old)-1] = nil
+	job.index = -1
+	*q = old[:len(old)-1]
+	return job
+}
+
+// Scheduler drives all metric gatherers from a single timer over a priority queue of the next ticks. Ticks are at a
+// fixed rate, i.e. not drifting by the fetch duration, and phase shifted per DB by the splay to avoid bursts. Fetches
+// are limited globally and per instance via Acquire()
+type Scheduler struct {
+	sync.Mutex
+	queue          scheduleQueue
+	wakeup         chan struct{}
+	global         chan struct{} // nil if unlimited
+	perInstanceMax int
+	instances      map[string]chan struct{}
+	skipped        uint64 // ticks skipped by all jobs
+}
+
+// NewScheduler creates a scheduler with the given concurrency limits, 0 meaning unlimited
+func NewScheduler(maxParallel, maxParallelPerInstance int) *Scheduler {
+	s := &Scheduler{wakeup: make(chan struct{}, 1), perInstanceMax: maxParallelPerInstance, instances: make(map[string]chan struct{})}
+	if maxParallel > 0 {
+		s.global = make(chan struct{}, maxParallel)
+	}
+	return s
+}
+
+// SplayOffset returns a stable offset in [0, splay) for the key, so that the phase of a DB stays the same over restarts
+func SplayOffset(key string, splay time.Duration) time.Duration {
+	if splay <= 0 {
+		return 0
+	}
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(key))
+	return time.Duration(mix64(h.Sum64()) % uint64(splay))
+}
+
+// minJobInterval is the shortest interval between fixed-rate ticks, shorter or invalid intervals are raised to it
+const minJobInterval = 100 * time.Millisecond
+
+// Add schedules a new job. Without a schedule the first tick is after the splay offset of the key, capped to the
+// interval. Scheduled jobs are not splayed, not to break the wall-clock alignment
+func (s *Scheduler) Add(key string, interval, splay time.Duration, schedule metrics.Schedule) *ScheduledJob {
+	interval = max(interval, minJobInterval)
+	ticks := make(chan time.Time, 1)
+	job := &ScheduledJob{C: ticks, ticks: ticks, interval: interval, schedule: schedule}
+	if schedule != nil {
+		job.next = schedule.Next(time.Now())
+	} else {
+		job.next = time.Now().Add(SplayOffset(key, min(splay, interval)))
+	}
+	s.Lock()
+	heap.Push(&s.queue, job)
+	s.Unlock()
+	s.notify()
+	return job
+}
+
+// Reschedule changes the interval and schedule of a job. Fixed-rate ticks are rescheduled relative to the previous one
+func (s *Scheduler) Reschedule(job *ScheduledJob, interval time.Duration, schedule metrics.Schedule) {
+	interval = max(interval, minJobInterval)
+	s.Lock()
+	if job.index >= 0 {
+		if schedule != nil {
+			job.next = schedule.Next(time.Now())
+		} else {
+			job.next = job.next.Add(interval - job.interval)
+		}
+		job.interval, job.schedule = interval, schedule
+		heap.Fix(&s.queue, job.index)
+	}
+	s.Unlock()
+	s.notify()
+}
+
+// Remove stops delivering ticks to the job
+func (s *Scheduler) Remove(job *ScheduledJob) {
+	s.Lock()
+	if job.index >= 0 {
+		heap.Remove(&s.queue, job.index)
+	}
+	s.Unlock()
+}
+
+func (s *Scheduler) notify() {
+	select {
+	case s.wakeup <- struct{}{}:
+	default:
+	}
+}
+
+// tick delivers all due ticks and returns the time until the next one
+func (s *Scheduler) tick(now time.Time) time.Duration {
+	s.Lock()
+	defer s.Unlock()
+	for len(s.queue) > 0 {
+		job := s.queue[0]
+		if job.next.After(now) {
+			return job.next.Sub(now)
+		}
+		select {
+		case job.ticks <- job.next:
+		default:
+			s.skip(job, 1)
+		}
+		if job.schedule != nil {
+			job.next = job.schedule.Next(job.next)
+			var missed uint64
+			for !job.next.IsZero() && !job.next.After(now) {
+				job.next = job.schedule.Next(job.next)
+				missed++
+			}
+			s.skip(job, missed)
+			if job.next.IsZero() { // never runs again
+				heap.Pop(&s.queue)
+				continue
+			}
+		} else {
+			job.next = job.next.Add(job.interval)
+			if behind := now.Sub(job.next); behind >= 0 { // the scheduler itself was held up, e.g. after a suspend
+				missed := int64(behind/job.interval) + 1
+				job.next = job.next.Add(time.Duration(missed) * job.interval)
+				s.skip(job, uint64(missed))
+			}
+		}
+		heap.Fix(&s.queue, 0)
+	}
+	return time.Hour
+}
+
+func (s *Scheduler) skip(job *ScheduledJob, n uint64) {
+	atomic.AddUint64(&job.skipped, n)
+	atomic.AddUint64(&s.skipped, n)
+}
+
+// Run delivers the ticks until the context is cancelled
+func (s *Scheduler) Run(ctx context.Context) {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.wakeup:
+			if !timer.Stop() {
+				<-timer.C
+			}
+		case <-timer.C:
+		}
+		timer.Reset(s.tick(time.Now()))
+	}
+}
+
+// Acquire waits for a free global and per instance fetch slot. The returned function releases the slots
+func (s *Scheduler) Acquire(ctx context.Context, instance string) (release func(), err error) {
+	var inst chan struct{}
+	if s.perInstanceMax > 0 {
+		s.Lock()
+		if inst = s.instances[instance]; inst == nil {
+			inst = make(chan struct{}, s.perInstanceMax)
+			s.instances[instance] = inst
+		}
+		s.Unlock()
+		select {
+		case inst <- struct{}{}:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	if s.global != nil {
+		select {
+		case s.global <- struct{}{}:
+		case <-ctx.Done():
+			if inst != nil {
+				<-inst
+			}
+			return nil, ctx.Err()
+		}
+	}
+	return func() {
+		if s.global != nil {
+			<-s.global
+		}
+		if inst != nil {
+			<-inst
+		}
+	}, nil
+}
+
+// GetMetricSchedule returns the schedule of a metric from the primary or standby config of the DB, falling back to the
+// "schedule" metric attribute. Empty for interval based gathering
+func (g *Gatherer) GetMetricSchedule(md MonitoredDatabase, metric string, standby bool) string {
+	schedules := md.Schedules
+	if standby {
+		schedules = md.SchedulesStandby
+	}
+	if schedule, ok := schedules[metric]; ok {
+		return schedule
+	}
+	g.metricDefMapLock.RLock()
+	defer g.metricDefMapLock.RUnlock()
+	for _, mvp := range g.metricDefinitionMap[metric] { // metric attributes are the same for all versions
+		return mvp.MetricAttrs.Schedule
+	}
+	return ""
+}
+
+// ParseMetricSchedule parses the schedule in the time zone of the DB, the gatherer's one by default
+func ParseMetricSchedule(md MonitoredDatabase, schedule string) (metrics.Schedule, error) {
+	if schedule == "" {
+		return nil, nil
+	}
+	loc, err := time.LoadLocation(md.Timezone)
+	if err != nil {
+		return nil, err
+	}
+	if md.Timezone == "" {
+		loc = time.Local
+	}
+	return metrics.ParseSchedule(schedule, loc)
+}
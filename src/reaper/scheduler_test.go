@@ -0,0 +1,120 @@
+package reaper
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/cybertec-postgresql/pgwatch3/metrics"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v2"
+)
+
+func TestSplayOffset(t *testing.T) {
+	splay := 30 * time.Second
+	assert.Equal(t, SplayOffset("db1¤¤¤db_stats", splay), SplayOffset("db1¤¤¤db_stats", splay), "offset must be stable")
+	assert.NotEqual(t, SplayOffset("db1¤¤¤db_stats", splay), SplayOffset("db2¤¤¤db_stats", splay))
+	assert.Less(t, SplayOffset("db1¤¤¤db_stats", splay), splay)
+	assert.Zero(t, SplayOffset("db1¤¤¤db_stats", 0))
+}
+
+func TestSchedulerTick(t *testing.T) {
+	s := NewScheduler(0, 0)
+	job := s.Add("db1", time.Minute, 0, nil)
+	start := job.next
+
+	assert.Equal(t, time.Minute, s.tick(start.Add(-time.Minute)), "not due yet")
+	s.tick(start)
+	assert.Equal(t, start, <-job.C)
+	assert.Equal(t, start.Add(time.Minute), job.next, "next tick must not drift by the fetch time")
+
+	s.tick(start.Add(time.Minute))
+	s.tick(start.Add(2 * time.Minute)) // previous tick not consumed, i.e. fetch overrun
+	assert.EqualValues(t, 1, job.Skipped())
+	assert.Equal(t, start.Add(time.Minute), <-job.C)
+
+	s.tick(start.Add(10*time.Minute + time.Second)) // scheduler held up
+	assert.EqualValues(t, 8, job.Skipped())
+	assert.Equal(t, start.Add(11*time.Minute), job.next)
+
+	s.Remove(job)
+	assert.Equal(t, time.Hour, s.tick(start.Add(time.Hour)))
+}
+
+func TestSchedulerSubSecondInterval(t *testing.T) {
+	s := NewScheduler(0, 0)
+	job := s.Add("db1", 250*time.Millisecond, 0, nil)
+	start := job.next
+	s.tick(start)
+	assert.Equal(t, start, <-job.C)
+	assert.Equal(t, start.Add(250*time.Millisecond), job.next, "fractional intervals must not be truncated")
+
+	s.Reschedule(job, 0, nil)
+	assert.Equal(t, minJobInterval, job.interval, "invalid intervals should be raised to the minimum")
+	assert.NotPanics(t, func() { s.tick(start.Add(time.Second)) }, "a held up scheduler must not divide by a zero interval")
+	s.Remove(job)
+
+	job = s.Add("db2", -time.Second, 0, nil)
+	assert.Equal(t, minJobInterval, job.interval)
+	s.Remove(job)
+}
+
+func TestSchedulerTickSchedule(t *testing.T) {
+	schedule, err := metrics.ParseSchedule("@aligned 5m", time.UTC)
+	assert.NoError(t, err)
+	s := NewScheduler(0, 0)
+	job := s.Add("db1", 5*time.Minute, time.Minute, schedule)
+	start := job.next
+	assert.Zero(t, start.Minute()%5, "aligned schedules must not be splayed")
+	assert.Zero(t, start.Second())
+
+	s.tick(start.Add(time.Second))
+	assert.Equal(t, start, <-job.C)
+	assert.Equal(t, start.Add(5*time.Minute), job.next)
+	s.tick(start.Add(16 * time.Minute))
+	assert.EqualValues(t, 2, job.Skipped(), "ticks of 10 and 15 minutes missed")
+	assert.Equal(t, start.Add(20*time.Minute), job.next)
+}
+
+func TestSchedulerAcquire(t *testing.T) {
+	s := NewScheduler(2, 1)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	release1, err := s.Acquire(ctx, "instance1")
+	assert.NoError(t, err)
+	release2, err := s.Acquire(ctx, "instance2")
+	assert.NoError(t, err)
+	_, err = s.Acquire(ctx, "instance1")
+	assert.ErrorIs(t, err, context.DeadlineExceeded, "per instance limit reached")
+	release1()
+	release2()
+	release, err := s.Acquire(context.Background(), "instance1")
+	assert.NoError(t, err)
+	release()
+}
+
+func TestMonitoredDatabaseSchedules(t *testing.T) {
+	g := newTestGatherer(t, nil)
+	var mds []MonitoredDatabase
+	err := yaml.Unmarshal([]byte(`
+- unique_name: db1
+  timezone: Europe/Vienna
+  custom_metrics:
+    db_stats: 60
+    table_bloat_approx: "0 3 * * *"
+  custom_metrics_standby:
+    wal_receiver: "@aligned 5m"
+- unique_name: db2
+  preset_metrics: basic
+`), &mds)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]float64{"db_stats": 60, "table_bloat_approx": 86400}, mds[0].Metrics)
+	assert.Equal(t, map[string]string{"table_bloat_approx": "0 3 * * *"}, mds[0].Schedules)
+	assert.Equal(t, "0 3 * * *", g.GetMetricSchedule(mds[0], "table_bloat_approx", false))
+	assert.Equal(t, "@aligned 5m", g.GetMetricSchedule(mds[0], "wal_receiver", true))
+	assert.Nil(t, mds[1].Metrics, "presets are resolved later for nil metrics")
+
+	schedule, err := ParseMetricSchedule(mds[0], "0 3 * * *")
+	assert.NoError(t, err)
+	assert.Equal(t, "Europe/Vienna", schedule.Next(time.Now()).Location().String())
+}
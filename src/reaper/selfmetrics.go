@@ -0,0 +1,124 @@
+package reaper
+
+import (
+	"errors"
+	"strings"
+	"sync/atomic"
+
+	"github.com/cybertec-postgresql/pgwatch3/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Self-monitoring metrics of the gatherer, served with the Go runtime and process ones on the web UI's /metrics
+// endpoint and together with the measurements by the Prometheus sink
+
+const selfMetricsNamespace = "pgwatch3"
+
+// newFetchMetrics creates the duration histogram and error counter of the metric fetches of a gatherer
+func newFetchMetrics() (*prometheus.HistogramVec, *prometheus.CounterVec) {
+	return prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: selfMetricsNamespace,
+			Name:      "fetch_duration_seconds",
+			Help:      "Duration of metric fetches, including waiting for a connection",
+			Buckets:   prometheus.ExponentialBuckets(0.001, 4, 9),
+		}, []string{"metric"}), prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: selfMetricsNamespace,
+			Name:      "fetch_errors_total",
+			Help:      "Number of failed metric fetches",
+		}, []string{"metric"})
+}
+
+// observeFetch records the duration and outcome of a fetch of the DB / metric
+func (g *Gatherer) observeFetch(dbMetric string, seconds float64, err error) {
+	_, metric, _ := strings.Cut(dbMetric, dbMetricJoinStr)
+	g.fetchDuration.WithLabelValues(metric).Observe(seconds)
+	if err != nil {
+		g.fetchErrors.WithLabelValues(metric).Inc()
+	}
+}
+
+func counterFunc(name, help string, counter *uint64) prometheus.Collector {
+	return prometheus.NewCounterFunc(prometheus.CounterOpts{Namespace: selfMetricsNamespace, Name: name, Help: help},
+		func() float64 { return float64(atomic.LoadUint64(counter)) })
+}
+
+func gaugeFunc(name, help string, f func() int) prometheus.Collector {
+	return prometheus.NewGaugeFunc(prometheus.GaugeOpts{Namespace: selfMetricsNamespace, Name: name, Help: help},
+		func() float64 { return float64(f()) })
+}
+
+// poolCollector reports the connection pool usage of all monitored DBs
+type poolCollector struct {
+	g                          *Gatherer
+	acquired, idle, total, max *prometheus.Desc
+}
+
+func newPoolCollector(g *Gatherer) *poolCollector {
+	desc := func(name, help string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName(selfMetricsNamespace, "pool", name), help, []string{"dbname"}, nil)
+	}
+	return &poolCollector{
+		g:        g,
+		acquired: desc("acquired_connections", "Connections currently in use"),
+		idle:     desc("idle_connections", "Idle connections"),
+		total:    desc("total_connections", "Connections open or being opened"),
+		max:      desc("max_connections", "Maximum size of the pool"),
+	}
+}
+
+func (pc *poolCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- pc.acquired
+	ch <- pc.idle
+	ch <- pc.total
+	ch <- pc.max
+}
+
+func (pc *poolCollector) Collect(ch chan<- prometheus.Metric) {
+	pc.g.monitoredDbConnCacheLock.RLock()
+	defer pc.g.monitoredDbConnCacheLock.RUnlock()
+	for dbUnique, conn := range pc.g.monitoredDbConnCache {
+		if conn == nil {
+			continue
+		}
+		stat := conn.Stat()
+		ch <- prometheus.MustNewConstMetric(pc.acquired, prometheus.GaugeValue, float64(stat.AcquiredConns()), dbUnique)
+		ch <- prometheus.MustNewConstMetric(pc.idle, prometheus.GaugeValue, float64(stat.IdleConns()), dbUnique)
+		ch <- prometheus.MustNewConstMetric(pc.total, prometheus.GaugeValue, float64(stat.TotalConns()), dbUnique)
+		ch <- prometheus.MustNewConstMetric(pc.max, prometheus.GaugeValue, float64(stat.MaxConns()), dbUnique)
+	}
+}
+
+// registerSelfMetrics exposes the gatherer's counters, the measurement queue and the connection pools on its
+// Prometheus registerer, by default the global one
+func (g *Gatherer) registerSelfMetrics(measurementCh chan []metrics.MeasurementMessage) error {
+	collectors := []prometheus.Collector{
+		g.fetchDuration,
+		g.fetchErrors,
+		newPoolCollector(g),
+		counterFunc("metrics_fetched_total", "Number of rows fetched from monitored DBs", &g.totalMetricsFetchedCounter),
+		counterFunc("metrics_reused_from_cache_total", "Number of rows served from the instance level cache", &g.totalMetricsReusedFromCacheCounter),
+		counterFunc("datasets_fetched_total", "Number of metric fetches returning data", &g.totalDatasetsFetchedCounter),
+		counterFunc("metric_fetch_failures_total", "Number of failed metric queries", &g.totalMetricFetchFailuresCounter),
+		counterFunc("skipped_ticks_total", "Number of scheduled fetches skipped due to overruns", &g.scheduler.skipped),
+		gaugeFunc("measurement_queue_length", "Sets of measurements waiting to be written to the sinks",
+			func() int { return len(measurementCh) }),
+		gaugeFunc("measurement_queue_capacity", "Maximum number of sets of measurements waiting to be written",
+			func() int { return cap(measurementCh) }),
+		gaugeFunc("databases_configured", "Number of monitored DBs, including discovered ones",
+			func() int { return len(g.getMonitoredDatabasesSnapshot()) }),
+		gaugeFunc("databases_unreachable", "Number of monitored DBs that could not be connected to",
+			func() int {
+				g.unreachableDBsLock.RLock()
+				defer g.unreachableDBsLock.RUnlock()
+				return len(g.unreachableDB)
+			}),
+		gaugeFunc("databases_circuit_broken", "Number of monitored DBs with fetches paused by the circuit breaker",
+			func() int { return len(g.getOpenCircuitBreakers()) }),
+	}
+	for _, c := range collectors {
+		if err := g.registerer.Register(c); err != nil && !errors.As(err, &prometheus.AlreadyRegisteredError{}) {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+package reaper
+
+import (
+	"context"
+	"hash/fnv"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/cybertec-postgresql/pgwatch3/db"
+	"github.com/cybertec-postgresql/pgwatch3/log"
+	"github.com/jackc/pgx/v5"
+)
+
+// ShardOwner returns the member responsible for the key using rendezvous hashing, so that on membership changes only
+// the keys of the joined or left members move
+func ShardOwner(key string, members []string) (owner string) {
+	var maxWeight uint64
+	for _, m := range members {
+		h := fnv.New64a()
+		_, _ = h.Write([]byte(m + "\x00" + key))
+		if w := mix64(h.Sum64()); owner == "" || w > maxWeight {
+			owner, maxWeight = m, w
+		}
+	}
+	return
+}
+
+// mix64 is the splitmix64 finalizer, FNV alone spreads similar keys like "db1", "db2" poorly
+func mix64(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	return x ^ x>>31
+}
+
+// FilterMonitoredDatabasesByShard keeps the DBs owned by the member "me". DBs are hashed on DBUniqueNameOrig, so that
+// all DBs of a continuously discovered instance stay together and the instance level cache keeps working
+func FilterMonitoredDatabasesByShard(monitoredDBs []MonitoredDatabase, members []string, me string) ([]MonitoredDatabase, int) {
+	ret := make([]MonitoredDatabase, 0)
+	for _, md := range monitoredDBs {
+		key := md.DBUniqueNameOrig
+		if key == "" { // not set for plain YAML config entries
+			key = md.DBUniqueName
+		}
+		if ShardOwner(key, members) == me {
+			ret = append(ret, md)
+		}
+	}
+	return ret, len(monitoredDBs) - len(ret)
+}
+
+// ShardMembership registers the gatherer as a shard member via a pgwatch3.gatherer_lease row per member and keeps track
+// of the live members, for dynamic sharding
+type ShardMembership struct {
+	lease   *Lease
+	prefix  string
+	members []string
+	sync.RWMutex
+	changed chan struct{} // signalled when members join or leave
+}
+
+// NewShardMembership creates a membership for the gatherers of a --group
+func NewShardMembership(conn db.PgxIface, group, instance string, ttl time.Duration) *ShardMembership {
+	prefix := "member:" + group + ":"
+	lease := NewLease(conn, "", instance, ttl)
+	lease.name = prefix + lease.holder
+	return &ShardMembership{lease: lease, prefix: prefix, changed: make(chan struct{}, 1)}
+}
+
+// shard returns the static shards with --shard-count, otherwise the live members of the dynamic sharding and our
+// member name. Without sharding members are empty
+func (g *Gatherer) shard(sm *ShardMembership) (members []string, me string) {
+	if g.opts.Connection.ShardCount > 0 {
+		for i := 0; i < g.opts.Connection.ShardCount; i++ {
+			members = append(members, strconv.Itoa(i))
+		}
+		return members, strconv.Itoa(g.opts.Connection.ShardIndex)
+	}
+	return sm.Shard()
+}
+
+// Shard returns the live members and our member name. Without dynamic sharding members are empty
+func (sm *ShardMembership) Shard() (members []string, me string) {
+	if sm == nil {
+		return nil, ""
+	}
+	sm.RLock()
+	defer sm.RUnlock()
+	return sm.members, sm.lease.holder
+}
+
+// Changed returns a channel signalled on membership changes. Never signalled for a nil membership
+func (sm *ShardMembership) Changed() <-chan struct{} {
+	if sm == nil {
+		return nil
+	}
+	return sm.changed
+}
+
+// Refresh renews our membership and reads the live members
+func (sm *ShardMembership) Refresh(ctx context.Context) error {
+	if _, err := sm.lease.tryAcquire(ctx); err != nil {
+		return err
+	}
+	rows, err := sm.lease.conn.Query(ctx, `SELECT gl_holder FROM pgwatch3.gatherer_lease
+	WHERE left(gl_name, length($1)) = $1 AND gl_expires_on > now() ORDER BY 1`, sm.prefix)
+	if err != nil {
+		return err
+	}
+	members, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return err
+	}
+	sm.Lock()
+	defer sm.Unlock()
+	if slices.Equal(members, sm.members) {
+		return nil
+	}
+	log.GetLogger(ctx).Infof("Shard members changed from %v to %v, rebalancing", sm.members, members)
+	sm.members = members
+	select {
+	case sm.changed <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Run keeps the membership alive until the context is cancelled, then leaves
+func (sm *ShardMembership) Run(ctx context.Context) {
+	logger := log.GetLogger(ctx)
+	for {
+		select {
+		case <-ctx.Done():
+			sm.lease.release()
+			return
+		case <-time.After(sm.lease.ttl / 3):
+		}
+		if err := sm.Refresh(ctx); err != nil {
+			logger.Error("Failed to refresh shard membership: ", err)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package reaper
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShardOwner(t *testing.T) {
+	members := []string{"a", "b", "c"}
+	owned := make(map[string]int)
+	moved := 0
+	for i := 0; i < 3000; i++ {
+		key := fmt.Sprintf("db%d", i)
+		owner := ShardOwner(key, members)
+		assert.Equal(t, owner, ShardOwner(key, []string{"c", "a", "b"}), "owner must not depend on member order")
+		owned[owner]++
+		if newOwner := ShardOwner(key, append(members, "d")); newOwner != owner {
+			assert.Equal(t, "d", newOwner, "only keys of a joined member should move")
+			moved++
+		}
+	}
+	for _, m := range members {
+		assert.InDelta(t, 1000, owned[m], 150)
+	}
+	assert.InDelta(t, 750, moved, 150)
+	assert.Empty(t, ShardOwner("db1", nil))
+}
+
+func TestFilterMonitoredDatabasesByShard(t *testing.T) {
+	mdbs := []MonitoredDatabase{
+		{DBUniqueName: "prod1_app", DBUniqueNameOrig: "prod1"},
+		{DBUniqueName: "prod1_postgres", DBUniqueNameOrig: "prod1"},
+		{DBUniqueName: "prod2", DBUniqueNameOrig: "prod2"},
+	}
+	members := []string{"0", "1"}
+	shard0, removed0 := FilterMonitoredDatabasesByShard(mdbs, members, "0")
+	shard1, removed1 := FilterMonitoredDatabasesByShard(mdbs, members, "1")
+	assert.Equal(t, len(mdbs), len(shard0)+len(shard1))
+	assert.Equal(t, len(mdbs), removed0+removed1)
+	for _, shard := range [][]MonitoredDatabase{shard0, shard1} {
+		instances := make(map[string]int)
+		for _, md := range shard {
+			instances[md.DBUniqueNameOrig]++
+		}
+		if n, ok := instances["prod1"]; ok {
+			assert.Equal(t, 2, n, "DBs of an instance should stay together")
+		}
+	}
+}
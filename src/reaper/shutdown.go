@@ -0,0 +1,60 @@
+package reaper
+
+import (
+	"context"
+	"time"
+
+	"github.com/cybertec-postgresql/pgwatch3/metrics"
+	"github.com/cybertec-postgresql/pgwatch3/sinks"
+)
+
+// GracefulShutdown stops the gatherer in order after mainContext got cancelled: waits for in-flight fetches, stops the
+// sink writer after storing all queued measurements, flushes the sinks and closes the connection pools of the monitored DBs. Both waiting
+// for the fetches and for the sinks is limited to --shutdown-timeout, measurements not stored by then are reported
+func (g *Gatherer) GracefulShutdown(stopWriting context.CancelFunc, writerDone <-chan struct{}, mw *sinks.MultiWriter, measurementCh chan []metrics.MeasurementMessage) {
+	droppedBefore := mw.GetWriteStats().MetricsDropped
+	g.logger.Info("Shutting down, waiting for in-flight fetches...")
+
+	fetchesDone := make(chan struct{})
+	go func() {
+		g.runningGatherers.Wait()
+		close(fetchesDone)
+	}()
+	select {
+	case <-fetchesDone:
+	case <-time.After(g.opts.ShutdownTimeout):
+		g.logger.Warningf("In-flight fetches not finished in %v, aborting them", g.opts.ShutdownTimeout)
+		g.abortFetches()
+	}
+
+	sinksDone := make(chan struct{})
+	go func() {
+		defer close(sinksDone)
+		stopWriting()
+		<-writerDone
+		if err := mw.Close(); err != nil {
+			g.logger.Error("Failed to flush the sinks: ", err)
+		}
+	}()
+	select {
+	case <-sinksDone:
+	case <-time.After(g.opts.ShutdownTimeout):
+		g.logger.Errorf("Shutdown deadline of %v expired, %d sets of measurements not stored", g.opts.ShutdownTimeout, len(measurementCh))
+	}
+	g.abortFetches()
+
+	g.monitoredDbConnCacheLock.Lock()
+	for dbUnique, conn := range g.monitoredDbConnCache {
+		if conn != nil {
+			conn.Close()
+		}
+		delete(g.monitoredDbConnCache, dbUnique)
+	}
+	g.monitoredDbConnCacheLock.Unlock()
+
+	if dropped := mw.GetWriteStats().MetricsDropped - droppedBefore; dropped > 0 {
+		g.logger.Warningf("Shutdown completed, %d measurements dropped meanwhile", dropped)
+	} else {
+		g.logger.Info("Shutdown completed")
+	}
+}
@@ -0,0 +1,329 @@
+package reaper
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/cybertec-postgresql/pgwatch3/config"
+	"github.com/cybertec-postgresql/pgwatch3/log"
+)
+
+const (
+	fileSourceWatchInterval = 5 * time.Second
+	httpSourceTimeout       = 30 * time.Second
+	httpSourceMaxBodySize   = 64 << 20
+)
+
+// MonitoredDatabaseSource provides the DBs to monitor, with continuous discovery and Patroni entries already resolved
+type MonitoredDatabaseSource interface {
+	// GetMonitoredDatabases returns the currently configured DBs
+	GetMonitoredDatabases(ctx context.Context) ([]MonitoredDatabase, error)
+	// Watch returns a channel signalled when the DBs changed and should be re-read before the next refresh of the
+	// main loop, or nil if the source can only be polled
+	Watch(ctx context.Context) <-chan struct{}
+}
+
+// MonitoredDatabaseSources composes several sources, a DB found in an earlier source takes precedence over a DB with
+// the same unique name in a later one
+type MonitoredDatabaseSources []MonitoredDatabaseSource
+
+// GetMonitoredDatabases returns the DBs of all sources, by the precedence of the sources. If sources fail, the DBs of
+// the others are returned together with the errors
+func (s MonitoredDatabaseSources) GetMonitoredDatabases(ctx context.Context) ([]MonitoredDatabase, error) {
+	ret := make([]MonitoredDatabase, 0)
+	seen := make(map[string]bool)
+	var errs error
+	for i, src := range s {
+		dbs, err := src.GetMonitoredDatabases(ctx)
+		if err != nil {
+			log.GetLogger(ctx).Errorf("Could not get monitored DBs from source #%d: %v", i+1, err)
+			errs = errors.Join(errs, err)
+			continue
+		}
+		for _, md := range dbs {
+			if seen[md.DBUniqueName] {
+				log.GetLogger(ctx).Debugf("Ignoring DB %s from source #%d, already defined by a previous source", md.DBUniqueName, i+1)
+				continue
+			}
+			seen[md.DBUniqueName] = true
+			ret = append(ret, md)
+		}
+	}
+	return ret, errs
+}
+
+// Watch returns a channel signalled when any of the sources changed, nil if none can be watched
+func (s MonitoredDatabaseSources) Watch(ctx context.Context) <-chan struct{} {
+	var changed chan struct{}
+	for _, src := range s {
+		ch := src.Watch(ctx)
+		if ch == nil {
+			continue
+		}
+		if changed == nil {
+			changed = make(chan struct{}, 1)
+		}
+		go func() {
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-ch:
+					select {
+					case changed <- struct{}{}:
+					default:
+					}
+				}
+			}
+		}()
+	}
+	return changed
+}
+
+// lastGoodSource keeps the last DBs read successfully from a source and returns them while the source fails, so that
+// e.g. a temporarily unreachable CMDB doesn't stop the monitoring of its DBs
+type lastGoodSource struct {
+	MonitoredDatabaseSource
+	name string
+	dbs  []MonitoredDatabase
+	read bool // dbs valid
+}
+
+func keepLastGood(src MonitoredDatabaseSource, name string) *lastGoodSource {
+	return &lastGoodSource{MonitoredDatabaseSource: src, name: name}
+}
+
+func (s *lastGoodSource) GetMonitoredDatabases(ctx context.Context) ([]MonitoredDatabase, error) {
+	dbs, err := s.MonitoredDatabaseSource.GetMonitoredDatabases(ctx)
+	if err == nil {
+		s.dbs, s.read = dbs, true
+		return dbs, nil
+	}
+	if !s.read {
+		return nil, err
+	}
+	log.GetLogger(ctx).Warningf("Could not get monitored DBs from %s, using the last valid list: %v", s.name, err)
+	return s.dbs, nil
+}
+
+// AddMonitoredDatabaseSource adds a source of DBs to monitor, with a lower precedence than the sources from the
+// options. Must be called before Run()
+func (g *Gatherer) AddMonitoredDatabaseSource(s MonitoredDatabaseSource) {
+	g.dbSources = append(g.dbSources, keepLastGood(s, fmt.Sprintf("source #%d", len(g.dbSources)+1)))
+}
+
+// newMonitoredDatabaseSources creates the sources for --config, or the ad-hoc flags, and for --extra-config
+func (g *Gatherer) newMonitoredDatabaseSources(configKind config.Kind) (MonitoredDatabaseSources, error) {
+	var sources MonitoredDatabaseSources
+	switch configKind {
+	case config.ConfigPgURL:
+		sources = append(sources, keepLastGood(configDBSource{g}, "the config DB"))
+	case config.ConfigAdHoc:
+		sources = append(sources, keepLastGood(adHocSource{g}, "the ad-hoc config"))
+	case config.ConfigFile, config.ConfigFolder:
+		sources = append(sources, keepLastGood(&fileSource{g: g, path: g.opts.Connection.Config}, g.opts.Connection.Config))
+	case config.ConfigHTTP:
+		src, err := g.newHTTPSource(g.opts.Connection.Config)
+		if err != nil {
+			return nil, err
+		}
+		sources = append(sources, keepLastGood(src, src.name))
+	}
+	for _, location := range g.opts.Connection.ExtraConfig {
+		kind, err := config.GetConfigKindOf(location)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --extra-config %s: %w", location, err)
+		}
+		switch kind {
+		case config.ConfigFile, config.ConfigFolder:
+			sources = append(sources, keepLastGood(&fileSource{g: g, path: location}, location))
+		case config.ConfigHTTP:
+			src, err := g.newHTTPSource(location)
+			if err != nil {
+				return nil, err
+			}
+			sources = append(sources, keepLastGood(src, src.name))
+		default:
+			return nil, fmt.Errorf("invalid --extra-config %s: only files, folders and http(s) URLs are supported", location)
+		}
+	}
+	return sources, nil
+}
+
+// resolveMonitoringConfig filters config entries by --group and resolves presets, continuous discovery and Patroni
+func (g *Gatherer) resolveMonitoringConfig(mc []MonitoredDatabase) []MonitoredDatabase {
+	g.logger.Debugf("Found %d monitoring config entries", len(mc))
+	if len(g.opts.Metric.Group) > 0 {
+		var removedCount int
+		mc, removedCount = FilterMonitoredDatabasesByGroup(mc, g.opts.Metric.Group)
+		g.logger.Infof("Filtered out %d config entries based on --groups=%s", removedCount, g.opts.Metric.Group)
+	}
+	monitoredDbs := g.GetMonitoredDatabasesFromMonitoringConfig(mc)
+	g.logger.Debugf("Found %d databases to monitor from %d config items...", len(monitoredDbs), len(mc))
+	return monitoredDbs
+}
+
+// configDBSource reads the monitored DBs from the config DB
+type configDBSource struct {
+	g *Gatherer
+}
+
+func (s configDBSource) GetMonitoredDatabases(context.Context) ([]MonitoredDatabase, error) {
+	return s.g.GetMonitoredDatabasesFromConfigDB()
+}
+
+func (configDBSource) Watch(context.Context) <-chan struct{} { return nil }
+
+// adHocSource monitors the single DB or instance given by the --adhoc-* flags
+type adHocSource struct {
+	g *Gatherer
+}
+
+func (s adHocSource) GetMonitoredDatabases(context.Context) ([]MonitoredDatabase, error) {
+	opts := s.g.opts
+	adhocconfig, ok := s.g.presetMetricDefMap[opts.AdHocConfig]
+	adhocschedules := s.g.presetMetricScheduleMap[opts.AdHocConfig]
+	if !ok {
+		s.g.logger.Warningf("Could not find a preset metric config named \"%s\", assuming JSON config...", opts.AdHocConfig)
+		var err error
+		if adhocconfig, adhocschedules, err = jsonTextToMap(opts.AdHocConfig); err != nil {
+			return nil, fmt.Errorf("could not parse --adhoc-config(%s): %w", opts.AdHocConfig, err)
+		}
+	}
+	md := MonitoredDatabase{DBUniqueName: opts.AdHocUniqueName, DBType: opts.AdHocDBType, Metrics: adhocconfig, Schedules: adhocschedules, ConnStr: opts.AdHocConnString}
+	if opts.AdHocDBType == config.DbTypePg {
+		return []MonitoredDatabase{md}, nil
+	}
+	resolved, err := s.g.ResolveDatabasesFromConfigEntry(md)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve DBs for ConnStr \"%s\": %w", opts.AdHocConnString, err)
+	}
+	return resolved, nil
+}
+
+func (adHocSource) Watch(context.Context) <-chan struct{} { return nil }
+
+// fileSource reads the monitored DBs from a YAML file or a folder of YAML files
+type fileSource struct {
+	g    *Gatherer
+	path string
+}
+
+func (s *fileSource) GetMonitoredDatabases(context.Context) ([]MonitoredDatabase, error) {
+	mc, err := s.g.ReadMonitoringConfigFromFileOrFolder(s.path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read/parse monitoring config from path %s: %w", s.path, err)
+	}
+	return s.g.resolveMonitoringConfig(mc), nil
+}
+
+// Watch checks the files for changes every few seconds
+func (s *fileSource) Watch(ctx context.Context) <-chan struct{} {
+	changed := make(chan struct{}, 1)
+	go func() {
+		ticker := time.NewTicker(fileSourceWatchInterval)
+		defer ticker.Stop()
+		last := s.fingerprint()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			if fp := s.fingerprint(); fp != last {
+				last = fp
+				s.g.logger.Infof("Monitoring config at %s changed", s.path)
+				select {
+				case changed <- struct{}{}:
+				default:
+				}
+			}
+		}
+	}()
+	return changed
+}
+
+// fingerprint changes when a file is added, removed or modified
+func (s *fileSource) fingerprint() string {
+	var b strings.Builder
+	_ = filepath.Walk(s.path, func(path string, info os.FileInfo, err error) error {
+		if err == nil && info.Mode().IsRegular() {
+			fmt.Fprintf(&b, "%s %d %d\n", path, info.Size(), info.ModTime().UnixNano())
+		}
+		return nil
+	})
+	return b.String()
+}
+
+// httpSource fetches the monitored DBs as JSON from an http(s) endpoint, e.g. a CMDB. The response is only parsed
+// again if the ETag changed
+type httpSource struct {
+	g      *Gatherer
+	url    string
+	name   string // URL without the password, for messages
+	client *http.Client
+	etag   string
+	cached []MonitoredDatabase // config entries of the last response
+}
+
+func (g *Gatherer) newHTTPSource(location string) (*httpSource, error) {
+	u, err := url.Parse(location)
+	if err != nil {
+		return nil, fmt.Errorf("invalid config URL: %w", err)
+	}
+	return &httpSource{g: g, url: location, name: u.Redacted(), client: &http.Client{Timeout: httpSourceTimeout}}, nil
+}
+
+func (s *httpSource) GetMonitoredDatabases(ctx context.Context) ([]MonitoredDatabase, error) {
+	if err := s.fetch(ctx); err != nil {
+		return nil, fmt.Errorf("could not fetch monitoring config from %s: %w", s.name, err)
+	}
+	return s.g.resolveMonitoringConfig(s.cached), nil
+}
+
+func (s *httpSource) fetch(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if s.etag != "" {
+		req.Header.Set("If-None-Match", s.etag)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusNotModified:
+		if s.cached == nil {
+			return errors.New("not modified, but nothing fetched before")
+		}
+		s.g.logger.Debugf("Monitoring config at %s not modified", s.name)
+		return nil
+	case http.StatusOK:
+		body, err := io.ReadAll(io.LimitReader(resp.Body, httpSourceMaxBodySize))
+		if err != nil {
+			return err
+		}
+		mc, err := s.g.ParseMonitoringConfig(body, s.name)
+		if err != nil {
+			return err
+		}
+		s.cached, s.etag = mc, resp.Header.Get("ETag")
+		return nil
+	default:
+		return fmt.Errorf("unexpected response status %s", resp.Status)
+	}
+}
+
+func (*httpSource) Watch(context.Context) <-chan struct{} { return nil }
@@ -0,0 +1,99 @@
+package reaper
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/cybertec-postgresql/pgwatch3/config"
+	"github.com/stretchr/testify/assert"
+)
+
+type staticSource struct {
+	dbs []MonitoredDatabase
+	err error
+}
+
+func (s staticSource) GetMonitoredDatabases(context.Context) ([]MonitoredDatabase, error) {
+	return s.dbs, s.err
+}
+
+func (staticSource) Watch(context.Context) <-chan struct{} { return nil }
+
+func TestMonitoredDatabaseSources(t *testing.T) {
+	ctx := context.Background()
+	sources := MonitoredDatabaseSources{
+		staticSource{dbs: []MonitoredDatabase{{DBUniqueName: "db1", Group: "first"}}},
+		staticSource{dbs: []MonitoredDatabase{{DBUniqueName: "db1", Group: "second"}, {DBUniqueName: "db2", Group: "second"}}},
+	}
+	dbs, err := sources.GetMonitoredDatabases(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, []MonitoredDatabase{{DBUniqueName: "db1", Group: "first"}, {DBUniqueName: "db2", Group: "second"}}, dbs)
+	assert.Nil(t, sources.Watch(ctx), "no source can be watched")
+
+	sources = append(MonitoredDatabaseSources{staticSource{err: errors.New("unavailable")}}, sources...)
+	dbs, err = sources.GetMonitoredDatabases(ctx)
+	assert.Error(t, err)
+	assert.Equal(t, []MonitoredDatabase{{DBUniqueName: "db1", Group: "first"}, {DBUniqueName: "db2", Group: "second"}}, dbs,
+		"the DBs of the other sources should be returned")
+}
+
+func TestLastGoodSource(t *testing.T) {
+	ctx := context.Background()
+	src := &staticSource{err: errors.New("unavailable")}
+	lg := keepLastGood(src, "test")
+	_, err := lg.GetMonitoredDatabases(ctx)
+	assert.Error(t, err, "nothing read before")
+
+	src.dbs, src.err = []MonitoredDatabase{{DBUniqueName: "db1"}}, nil
+	dbs, err := lg.GetMonitoredDatabases(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, dbs, 1)
+
+	src.dbs, src.err = nil, errors.New("unavailable")
+	dbs, err = lg.GetMonitoredDatabases(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, []MonitoredDatabase{{DBUniqueName: "db1"}}, dbs, "the last valid list should be kept")
+
+	src.dbs, src.err = nil, nil
+	_, err = lg.GetMonitoredDatabases(ctx)
+	assert.NoError(t, err)
+	src.err = errors.New("unavailable")
+	dbs, err = lg.GetMonitoredDatabases(ctx)
+	assert.NoError(t, err)
+	assert.Empty(t, dbs, "an empty list is valid too")
+}
+
+func TestHTTPSource(t *testing.T) {
+	requests, notModified := 0, 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			notModified++
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		_, _ = w.Write([]byte(`[{"unique_name": "db1", "conn_str": "postgresql://localhost/db1", "is_enabled": true, "dbtype": "postgres"},
+			{"unique_name": "db2", "conn_str": "postgresql://localhost/db2", "is_enabled": false}]`))
+	}))
+	defer srv.Close()
+
+	g := newTestGatherer(t, &config.Options{Connection: config.ConnectionOpts{ExtraConfig: []string{srv.URL}}})
+	assert.Len(t, g.dbSources, 2)
+	for i := 0; i < 2; i++ {
+		dbs, err := g.dbSources.GetMonitoredDatabases(context.Background())
+		assert.NoError(t, err)
+		if assert.Len(t, dbs, 1) {
+			assert.Equal(t, "db1", dbs[0].DBUniqueName)
+		}
+	}
+	assert.Equal(t, 2, requests)
+	assert.Equal(t, 1, notModified, "the second fetch should be answered by the ETag")
+
+	_, err := NewGatherer(context.Background(), &config.Options{Connection: config.ConnectionOpts{Config: t.TempDir(),
+		ExtraConfig: []string{"postgresql://localhost/pgwatch3"}}}, nil)
+	assert.Error(t, err, "config DBs are not supported as extra sources")
+}
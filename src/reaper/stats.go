@@ -0,0 +1,51 @@
+package reaper
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Stats are the fetching statistics of a gatherer since its start. See GetWriteStats() for the storage side
+type Stats struct {
+	MetricsFetched           uint64
+	MetricsReusedFromCache   uint64
+	DatasetsFetched          uint64
+	MetricPointsPerMinute    int64 // average of the last 5 minutes
+	MetricFetchFailures      uint64
+	SkippedTicks             uint64
+	BackedOffMetricIntervals map[string]map[string]float64 // [dbUnique][metric] = stretched interval
+	DatabasesMonitored       int
+	DatabasesConfigured      int // including replicas
+	DatabasesUnreachable     int
+	CircuitBrokenDBs         []string
+	UptimeSeconds            uint64
+}
+
+// GetStats returns the current fetching statistics
+func (g *Gatherer) GetStats() Stats {
+	stats := Stats{
+		MetricsFetched:           atomic.LoadUint64(&g.totalMetricsFetchedCounter),
+		MetricsReusedFromCache:   atomic.LoadUint64(&g.totalMetricsReusedFromCacheCounter),
+		DatasetsFetched:          atomic.LoadUint64(&g.totalDatasetsFetchedCounter),
+		MetricPointsPerMinute:    atomic.LoadInt64(&g.metricPointsPerMinuteLast5MinAvg),
+		MetricFetchFailures:      atomic.LoadUint64(&g.totalMetricFetchFailuresCounter),
+		SkippedTicks:             atomic.LoadUint64(&g.scheduler.skipped),
+		BackedOffMetricIntervals: g.getEffectiveIntervals(),
+		CircuitBrokenDBs:         g.getOpenCircuitBreakers(),
+		UptimeSeconds:            uint64(time.Since(g.gathererStartTime).Seconds()),
+	}
+	if stats.MetricPointsPerMinute == -1 { // calculate avg. on the fly if 1st summarization hasn't happened yet
+		stats.MetricPointsPerMinute = int64((stats.MetricsFetched * 60) / max(stats.UptimeSeconds, 1))
+	}
+	monitoredDbs := g.getMonitoredDatabasesSnapshot()
+	stats.DatabasesConfigured = len(monitoredDbs)
+	for _, md := range monitoredDbs {
+		if g.shouldDbBeMonitoredBasedOnCurrentState(md) {
+			stats.DatabasesMonitored++
+		}
+	}
+	g.unreachableDBsLock.RLock()
+	stats.DatabasesUnreachable = len(g.unreachableDB)
+	g.unreachableDBsLock.RUnlock()
+	return stats
+}
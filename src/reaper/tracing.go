@@ -0,0 +1,26 @@
+package reaper
+
+import (
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer is a no-op unless the global trace provider is set up, e.g. by --trace-exporter
+var tracer = otel.Tracer("github.com/cybertec-postgresql/pgwatch3")
+
+const (
+	attrDB     = attribute.Key("pgwatch3.db")
+	attrMetric = attribute.Key("pgwatch3.metric")
+	attrRows   = attribute.Key("pgwatch3.rows")
+)
+
+// endSpan marks the span as failed on errors and ends it
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
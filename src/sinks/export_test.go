@@ -0,0 +1,70 @@
+package sinks
+
+import (
+	"context"
+
+	"github.com/cybertec-postgresql/pgwatch3/config"
+	"github.com/cybertec-postgresql/pgwatch3/db"
+	"github.com/cybertec-postgresql/pgwatch3/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// NewTestPostgresWriter creates a writer on the given connection without batching and background maintenance
+func NewTestPostgresWriter(conn db.PgxPoolIface, schema DbStorageSchemaType, opts *config.Options) *PostgresWriter {
+	return &PostgresWriter{
+		Ctx:          context.Background(),
+		SinkDb:       conn,
+		MetricSchema: schema,
+		opts:         opts,
+		stats:        newSinkStats(),
+		lastError:    make(chan error, 2),
+
+		metricAttrs: make(map[string]metrics.MetricAttrs),
+		rlsMetrics:  make(map[string]bool),
+		rlsGroups:   make(map[string]bool),
+
+		GroupResolver:            func(_ string) string { return "" },
+		partitionMapMetric:       make(map[string]ExistingPartitionInfo),
+		partitionMapMetricDbname: make(map[string]map[string]ExistingPartitionInfo),
+		typedColumnsCache:        make(map[string]map[string]string),
+	}
+}
+
+// WriteBatch stores the measurements synchronously and returns the error of the batch, if any
+func (pgw *PostgresWriter) WriteBatch(msgs []metrics.MeasurementMessage) (err error) {
+	pgw.write(msgs)
+	for {
+		select {
+		case e := <-pgw.lastError:
+			err = e
+		default:
+			return
+		}
+	}
+}
+
+func (pgw *PostgresWriter) GetWriteStats() WriteStats {
+	return pgw.stats.get()
+}
+
+var TypedColumnType = typedColumnType
+var TypedColumnValue = typedColumnValue
+var EpochBucketStart = epochBucketStart
+
+func (pgw *PostgresWriter) SeedMetricAttrs(metricDefs metrics.MetricVersionDefs) {
+	pgw.seedMetricAttrs(metricDefs)
+}
+
+func init() {
+	partitionDropPause = 0
+}
+
+// PrometheusGatherers returns what the scrape endpoints of the Prometheus sinks serve, leaving out the measurements
+func (mw *MultiWriter) PrometheusGatherers() (ret []prometheus.Gatherer) {
+	for _, w := range mw.writers {
+		if promw, ok := w.(*PrometheusWriter); ok {
+			ret = append(ret, promw.gatherer(prometheus.NewRegistry()))
+		}
+	}
+	return
+}
@@ -4,28 +4,29 @@ import (
 	"context"
 	"encoding/json"
 	"io"
-	"sync/atomic"
+	"time"
 
 	"github.com/cybertec-postgresql/pgwatch3/metrics"
 	"gopkg.in/natefinch/lumberjack.v2"
 )
 
-var (
-	totalMetricsDroppedCounter    uint64
-	datastoreWriteFailuresCounter uint64
-)
-
 type JSONWriter struct {
 	ctx      context.Context
 	filename string
 	w        io.Writer
+	stats    *sinkStats
 }
 
 func NewJSONWriter(ctx context.Context, fname string) (*JSONWriter, error) {
+	return newJSONWriter(ctx, fname, newSinkStats())
+}
+
+func newJSONWriter(ctx context.Context, fname string, stats *sinkStats) (*JSONWriter, error) {
 	return &JSONWriter{
 		ctx:      ctx,
 		filename: fname,
 		w:        &lumberjack.Logger{Filename: fname, Compress: true},
+		stats:    stats,
 	}, nil
 }
 
@@ -33,6 +34,7 @@ func (jw *JSONWriter) Write(msgs []metrics.MeasurementMessage) error {
 	if len(msgs) == 0 {
 		return nil
 	}
+	t1 := time.Now()
 	enc := json.NewEncoder(jw.w)
 	for _, msg := range msgs {
 		dataRow := map[string]any{
@@ -42,10 +44,19 @@ func (jw *JSONWriter) Write(msgs []metrics.MeasurementMessage) error {
 			"custom_tags": msg.CustomTags,
 		}
 		if err := enc.Encode(dataRow); err != nil {
-			atomic.AddUint64(&datastoreWriteFailuresCounter, 1)
+			jw.stats.recordWriteFailure(0)
 			return err
 		}
 	}
+	jw.stats.recordWriteSuccess(t1)
+	return nil
+}
+
+// Close closes the file
+func (jw *JSONWriter) Close() error {
+	if c, ok := jw.w.(io.Closer); ok {
+		return c.Close()
+	}
 	return nil
 }
 
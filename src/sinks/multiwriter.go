@@ -3,13 +3,23 @@ package sinks
 import (
 	"context"
 	"errors"
+	"io"
 	"sync"
+	"time"
 
 	"github.com/cybertec-postgresql/pgwatch3/config"
 	"github.com/cybertec-postgresql/pgwatch3/log"
 	"github.com/cybertec-postgresql/pgwatch3/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
+// tracer is a no-op unless the gatherer sets up a global trace provider
+var tracer = otel.Tracer("github.com/cybertec-postgresql/pgwatch3/sinks")
+
 // Writer is an interface that writes metrics values
 type Writer interface {
 	SyncMetric(dbUnique, metricName, op string) error
@@ -19,15 +29,17 @@ type Writer interface {
 // MultiWriter ensures the simultaneous storage of data in several storages.
 type MultiWriter struct {
 	writers []Writer
+	stats   *sinkStats
 	sync.Mutex
 }
 
-// NewMultiWriter creates and returns new instance of MultiWriter struct.
-func NewMultiWriter(ctx context.Context, opts *config.Options, metricDefs metrics.MetricVersionDefs) (*MultiWriter, error) {
+// NewMultiWriter creates and returns new instance of MultiWriter struct. Besides the sinks configured in opts, the
+// given writers are used, e.g. custom ones of a program embedding the gatherer
+func NewMultiWriter(ctx context.Context, opts *config.Options, metricDefs metrics.MetricVersionDefs, writers ...Writer) (*MultiWriter, error) {
 	logger := log.GetLogger(ctx)
-	mw := &MultiWriter{}
+	mw := &MultiWriter{writers: writers, stats: newSinkStats()}
 	for _, f := range opts.Metric.JSONStorageFile {
-		jw, err := NewJSONWriter(ctx, f)
+		jw, err := newJSONWriter(ctx, f, mw.stats)
 		if err != nil {
 			return nil, err
 		}
@@ -36,7 +48,7 @@ func NewMultiWriter(ctx context.Context, opts *config.Options, metricDefs metric
 	}
 
 	for _, connstr := range opts.Metric.PGMetricStoreConnStr {
-		pgw, err := NewPostgresWriter(ctx, connstr, opts, metricDefs)
+		pgw, err := newPostgresWriter(ctx, connstr, opts, metricDefs, mw.stats)
 		if err != nil {
 			return nil, err
 		}
@@ -45,7 +57,7 @@ func NewMultiWriter(ctx context.Context, opts *config.Options, metricDefs metric
 	}
 
 	if opts.Metric.PrometheusListenAddr > "" {
-		promw, err := NewPrometheusWriter(ctx, opts)
+		promw, err := newPrometheusWriter(ctx, opts, mw.stats)
 		if err != nil {
 			return nil, err
 		}
@@ -58,12 +70,51 @@ func NewMultiWriter(ctx context.Context, opts *config.Options, metricDefs metric
 	return mw, nil
 }
 
+// RegisterMetrics exposes the write statistics of all sinks on the given Prometheus registerer. If the registerer
+// can also be gathered from, Prometheus sinks serve its metrics next to the measurements
+func (mw *MultiWriter) RegisterMetrics(r prometheus.Registerer) error {
+	for _, c := range mw.stats.collectors() {
+		if err := r.Register(c); err != nil {
+			return err
+		}
+	}
+	if g, ok := r.(prometheus.Gatherer); ok {
+		mw.Lock()
+		defer mw.Unlock()
+		for _, w := range mw.writers {
+			if promw, ok := w.(*PrometheusWriter); ok {
+				promw.ServeSelfMetrics(g)
+			}
+		}
+	}
+	return nil
+}
+
+// GetWriteStats returns the current totals of all sinks
+func (mw *MultiWriter) GetWriteStats() WriteStats {
+	return mw.stats.get()
+}
+
 func (mw *MultiWriter) AddWriter(w Writer) {
 	mw.Lock()
+	if mw.stats == nil { // zero value MultiWriter
+		mw.stats = newSinkStats()
+	}
 	mw.writers = append(mw.writers, w)
 	mw.Unlock()
 }
 
+// SetDBGroupResolver sets how Postgres sinks look up the group of a monitored DB, for group level storage settings
+func (mw *MultiWriter) SetDBGroupResolver(resolver func(dbUnique string) string) {
+	mw.Lock()
+	defer mw.Unlock()
+	for _, w := range mw.writers {
+		if pgw, ok := w.(*PostgresWriter); ok {
+			pgw.GroupResolver = resolver
+		}
+	}
+}
+
 func (mw *MultiWriter) SyncMetrics(dbUnique, metricName, op string) (err error) {
 	for _, w := range mw.writers {
 		err = errors.Join(err, w.SyncMetric(dbUnique, metricName, op))
@@ -71,20 +122,44 @@ func (mw *MultiWriter) SyncMetrics(dbUnique, metricName, op string) (err error)
 	return
 }
 
+// WriteMetrics hands the measurements over to all writers until the context is cancelled. Measurements still queued
+// then are written too before returning
 func (mw *MultiWriter) WriteMetrics(ctx context.Context, storageCh <-chan []metrics.MeasurementMessage) {
-	var err error
-	logger := log.GetLogger(ctx)
 	for {
 		select {
 		case <-ctx.Done():
+			for len(storageCh) > 0 {
+				mw.write(ctx, <-storageCh)
+			}
 			return
 		case msg := <-storageCh:
-			for _, w := range mw.writers {
-				err = w.Write(msg)
-				if err != nil {
-					logger.Error(err)
-				}
-			}
+			mw.write(ctx, msg)
+		}
+	}
+}
+
+func (mw *MultiWriter) write(ctx context.Context, msg []metrics.MeasurementMessage) {
+	for _, w := range mw.writers {
+		_, span := tracer.Start(ctx, "Writer.Write", trace.WithAttributes(attribute.String("pgwatch3.sink", sinkName(w)), attribute.Int("pgwatch3.measurement_sets", len(msg))))
+		t1 := time.Now()
+		err := w.Write(msg)
+		mw.stats.writeDuration.WithLabelValues(sinkName(w)).Observe(time.Since(t1).Seconds())
+		if err != nil {
+			mw.stats.writeErrors.WithLabelValues(sinkName(w)).Inc()
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+			log.GetLogger(ctx).Error(err)
+		}
+		span.End()
+	}
+}
+
+// Close flushes and closes all writers supporting it, e.g. writing the batched measurements of Postgres sinks
+func (mw *MultiWriter) Close() (err error) {
+	for _, w := range mw.writers {
+		if c, ok := w.(io.Closer); ok {
+			err = errors.Join(err, c.Close())
 		}
 	}
+	return
 }
@@ -0,0 +1,35 @@
+package sinks_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/cybertec-postgresql/pgwatch3/metrics"
+	"github.com/cybertec-postgresql/pgwatch3/sinks"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMultiWriterDrainsOnShutdown(t *testing.T) {
+	fname := filepath.Join(t.TempDir(), "metrics.json")
+	jw, err := sinks.NewJSONWriter(ctx, fname)
+	assert.NoError(t, err)
+	mw := &sinks.MultiWriter{}
+	mw.AddWriter(jw)
+
+	storageCh := make(chan []metrics.MeasurementMessage, 10)
+	for i := 0; i < 3; i++ {
+		storageCh <- []metrics.MeasurementMessage{{DBName: "db1", MetricName: "db_stats", Data: metrics.Measurements{{"numbackends": i}}}}
+	}
+	stopped, cancel := context.WithCancel(ctx)
+	cancel()
+	mw.WriteMetrics(stopped, storageCh)
+	assert.Empty(t, storageCh, "queued measurements must be written on stop")
+	assert.NoError(t, mw.Close())
+
+	content, err := os.ReadFile(fname)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, strings.Count(string(content), "\n"))
+}
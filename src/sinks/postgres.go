@@ -7,7 +7,7 @@ import (
 	"fmt"
 	"regexp"
 	"strings"
-	"sync/atomic"
+	"sync"
 	"time"
 
 	"github.com/cybertec-postgresql/pgwatch3/config"
@@ -15,6 +15,10 @@ import (
 	"github.com/cybertec-postgresql/pgwatch3/log"
 	"github.com/cybertec-postgresql/pgwatch3/metrics"
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
 const (
@@ -23,12 +27,32 @@ const (
 )
 
 func NewPostgresWriter(ctx context.Context, connstr string, opts *config.Options, metricDefs metrics.MetricVersionDefs) (pgw *PostgresWriter, err error) {
+	return newPostgresWriter(ctx, connstr, opts, metricDefs, newSinkStats())
+}
+
+func newPostgresWriter(ctx context.Context, connstr string, opts *config.Options, metricDefs metrics.MetricVersionDefs, stats *sinkStats) (pgw *PostgresWriter, err error) {
 	pgw = &PostgresWriter{
 		Ctx:        ctx,
+		stats:      stats,
 		MetricDefs: metricDefs,
 		opts:       opts,
 		input:      make(chan []metrics.MeasurementMessage, cacheLimit),
 		lastError:  make(chan error),
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+
+		metricAttrs: make(map[string]metrics.MetricAttrs),
+		rlsMetrics:  make(map[string]bool),
+		rlsGroups:   make(map[string]bool),
+
+		GroupResolver:            func(_ string) string { return "" },
+		partitionMapMetric:       make(map[string]ExistingPartitionInfo),
+		partitionMapMetricDbname: make(map[string]map[string]ExistingPartitionInfo),
+		typedColumnsCache:        make(map[string]map[string]string),
+	}
+	pgw.seedMetricAttrs(metricDefs)
+	if pgw.rollupTiers, err = ParseRollupTiers(opts.Metric.PGRollupTiers); err != nil {
+		return
 	}
 	if pgw.SinkDb, err = db.InitAndTestMetricStoreConnection(ctx, connstr); err != nil {
 		return
@@ -37,11 +61,16 @@ func NewPostgresWriter(ctx context.Context, connstr string, opts *config.Options
 		pgw.SinkDb.Close()
 		return
 	}
+	if pgw.MetricSchema == DbStorageSchemaTyped && len(pgw.rollupTiers) > 0 {
+		pgw.SinkDb.Close()
+		return nil, errors.New("rollup tiers are not supported for the 'typed' storage schema, unset --pg-rollup-tiers")
+	}
 	if err = pgw.EnsureBuiltinMetricDummies(); err != nil {
 		return
 	}
 	go pgw.OldPostgresMetricsDeleter()
 	go pgw.UniqueDbnamesListingMaintainer()
+	go pgw.RollupMaintainer()
 	go pgw.poll()
 	return
 }
@@ -52,8 +81,24 @@ type PostgresWriter struct {
 	MetricSchema DbStorageSchemaType
 	MetricDefs   metrics.MetricVersionDefs
 	opts         *config.Options
+	stats        *sinkStats
 	input        chan []metrics.MeasurementMessage
 	lastError    chan error
+	stop, done   chan struct{} // flushing the batch on Close()
+	rollupTiers  []RollupTier
+
+	metricAttrs     map[string]metrics.MetricAttrs // storage related attributes of lastly written metrics
+	metricAttrsLock sync.RWMutex
+
+	rlsMetrics map[string]bool // metric tables having the --pg-rls policy set up
+	rlsGroups  map[string]bool // groups having a reader role
+
+	GroupResolver func(dbUnique string) string // group of a monitored DB, for group level storage settings
+
+	forceRecreatePGMetricPartitions bool                                        // to signal override PG metrics storage cache
+	partitionMapMetric              map[string]ExistingPartitionInfo            // metric = min/max bounds
+	partitionMapMetricDbname        map[string]map[string]ExistingPartitionInfo // metric[dbname = min/max bounds]
+	typedColumnsCache               map[string]map[string]string                // metric = [column = type], for the "typed" storage schema
 }
 
 type ExistingPartitionInfo struct {
@@ -67,6 +112,7 @@ type MeasurementMessagePostgres struct {
 	Metric  string
 	Data    map[string]any
 	TagData map[string]any
+	Group   string // only set with --pg-rls
 }
 
 type DbStorageSchemaType int
@@ -74,14 +120,21 @@ type DbStorageSchemaType int
 const (
 	DbStorageSchemaPostgres DbStorageSchemaType = iota
 	DbStorageSchemaTimescale
+	DbStorageSchemaTyped // like DbStorageSchemaPostgres but with a column per metric field instead of JSONB
 )
 
 func (pgw *PostgresWriter) ReadMetricSchemaType() (err error) {
-	var isTs bool
+	var schemaType string
 	pgw.MetricSchema = DbStorageSchemaPostgres
-	sqlSchemaType := `SELECT schema_type = 'timescale' FROM admin.storage_schema_type`
-	if err = pgw.SinkDb.QueryRow(pgw.Ctx, sqlSchemaType).Scan(&isTs); err == nil && isTs {
+	sqlSchemaType := `SELECT schema_type FROM admin.storage_schema_type`
+	if err = pgw.SinkDb.QueryRow(pgw.Ctx, sqlSchemaType).Scan(&schemaType); err != nil {
+		return
+	}
+	switch schemaType {
+	case "timescale":
 		pgw.MetricSchema = DbStorageSchemaTimescale
+	case "typed":
+		pgw.MetricSchema = DbStorageSchemaTyped
 	}
 	return
 }
@@ -93,12 +146,9 @@ const (
 
 const specialMetricPgbouncer = "^pgbouncer_(stats|pools)$"
 
-var (
-	regexIsPgbouncerMetrics         = regexp.MustCompile(specialMetricPgbouncer)
-	forceRecreatePGMetricPartitions = false                                             // to signal override PG metrics storage cache
-	partitionMapMetric              = make(map[string]ExistingPartitionInfo)            // metric = min/max bounds
-	partitionMapMetricDbname        = make(map[string]map[string]ExistingPartitionInfo) // metric[dbname = min/max bounds]
-)
+var targetColumns = []string{"time", "dbname", "data", "tag_data"}
+
+var regexIsPgbouncerMetrics = regexp.MustCompile(specialMetricPgbouncer)
 
 func (pgw *PostgresWriter) SyncMetric(dbUnique, metricName, op string) error {
 	if op == "add" {
@@ -132,6 +182,7 @@ func (pgw *PostgresWriter) Write(msgs []metrics.MeasurementMessage) error {
 		// msgs sent
 	case <-time.After(highLoadTimeout):
 		// msgs dropped due to a huge load, check stdout or file for detailed log
+		pgw.stats.metricsDropped.Add(uint64(len(msgs)))
 	}
 	select {
 	case err := <-pgw.lastError:
@@ -142,6 +193,7 @@ func (pgw *PostgresWriter) Write(msgs []metrics.MeasurementMessage) error {
 }
 
 func (pgw *PostgresWriter) poll() {
+	defer close(pgw.done)
 	cache := make([]metrics.MeasurementMessage, 0, cacheLimit)
 	cacheTimeout := pgw.opts.BatchingDelay
 	tick := time.NewTicker(cacheTimeout)
@@ -163,6 +215,13 @@ func (pgw *PostgresWriter) poll() {
 			case <-tick.C:
 				pgw.write(cache)
 				cache = cache[:0]
+			case <-pgw.stop:
+				tick.Stop()
+				for len(pgw.input) > 0 {
+					cache = append(cache, <-pgw.input...)
+				}
+				pgw.write(cache)
+				return
 			case <-pgw.Ctx.Done():
 				return
 			}
@@ -170,17 +229,34 @@ func (pgw *PostgresWriter) poll() {
 	}
 }
 
+// Close writes the batched measurements and closes the connection pool. Write() must not be called anymore
+func (pgw *PostgresWriter) Close() (err error) {
+	close(pgw.stop)
+	for {
+		select {
+		case <-pgw.done:
+			pgw.SinkDb.Close()
+			return
+		case e := <-pgw.lastError: // nobody calls Write() anymore to receive it
+			err = errors.Join(err, e)
+		}
+	}
+}
+
 func (pgw *PostgresWriter) write(msgs []metrics.MeasurementMessage) {
 	if len(msgs) == 0 {
 		return
 	}
 	logger := log.GetLogger(pgw.Ctx)
+	_, span := tracer.Start(pgw.Ctx, "PostgresWriter.write", trace.WithAttributes(attribute.Int("pgwatch3.measurement_sets", len(msgs))))
+	defer span.End()
 	tsWarningPrinted := false
 	metricsToStorePerMetric := make(map[string][]MeasurementMessagePostgres)
 	rowsBatched := 0
 	totalRows := 0
 	pgPartBounds := make(map[string]ExistingPartitionInfo)                  // metric=min/max
 	pgPartBoundsDbName := make(map[string]map[string]ExistingPartitionInfo) // metric=[dbname=min/max]
+	metricDBNames := make(map[string]map[string]bool)                       // metric=[dbname], for Timescale retention
 	var err error
 
 	for _, msg := range msgs {
@@ -188,6 +264,11 @@ func (pgw *PostgresWriter) write(msgs []metrics.MeasurementMessage) {
 			continue
 		}
 		logger.WithField("data", msg.Data).WithField("len", len(msg.Data)).Debug("Sending To Postgres")
+		pgw.setMetricAttrs(msg.MetricName, msg.MetricDef.MetricAttrs)
+		var group string
+		if pgw.opts.Metric.PGRowLevelSecurity {
+			group = pgw.GroupResolver(msg.DBName)
+		}
 
 		for _, dataRow := range msg.Data {
 			var epochTime time.Time
@@ -238,12 +319,16 @@ func (pgw *PostgresWriter) write(msgs []metrics.MeasurementMessage) {
 				metricsToStorePerMetric[metricNameTemp] = make([]MeasurementMessagePostgres, 0)
 			}
 			metricsArr = append(metricsArr, MeasurementMessagePostgres{Time: epochTime, DBName: msg.DBName,
-				Metric: msg.MetricName, Data: fields, TagData: tags})
+				Metric: msg.MetricName, Data: fields, TagData: tags, Group: group})
 			metricsToStorePerMetric[metricNameTemp] = metricsArr
 
 			rowsBatched++
 
 			if pgw.MetricSchema == DbStorageSchemaTimescale {
+				if metricDBNames[msg.MetricName] == nil {
+					metricDBNames[msg.MetricName] = make(map[string]bool)
+				}
+				metricDBNames[msg.MetricName][msg.DBName] = true
 				// set min/max timestamps to check/create partitions
 				bounds, ok := pgPartBounds[msg.MetricName]
 				if !ok || (ok && epochTime.Before(bounds.StartTime)) {
@@ -254,7 +339,7 @@ func (pgw *PostgresWriter) write(msgs []metrics.MeasurementMessage) {
 					bounds.EndTime = epochTime
 					pgPartBounds[msg.MetricName] = bounds
 				}
-			} else if pgw.MetricSchema == DbStorageSchemaPostgres {
+			} else {
 				_, ok := pgPartBoundsDbName[msg.MetricName]
 				if !ok {
 					pgPartBoundsDbName[msg.MetricName] = make(map[string]ExistingPartitionInfo)
@@ -272,71 +357,106 @@ func (pgw *PostgresWriter) write(msgs []metrics.MeasurementMessage) {
 		}
 	}
 
-	if pgw.MetricSchema == DbStorageSchemaPostgres {
-		err = pgw.EnsureMetricDbnameTime(pgPartBoundsDbName, forceRecreatePGMetricPartitions)
-	} else if pgw.MetricSchema == DbStorageSchemaTimescale {
-		err = pgw.EnsureMetricTimescale(pgPartBounds, forceRecreatePGMetricPartitions)
-	} else {
+	switch pgw.MetricSchema {
+	case DbStorageSchemaPostgres, DbStorageSchemaTyped:
+		err = pgw.EnsureMetricDbnameTime(pgPartBoundsDbName, pgw.forceRecreatePGMetricPartitions)
+	case DbStorageSchemaTimescale:
+		err = pgw.EnsureMetricTimescale(pgPartBounds, metricDBNames, pgw.forceRecreatePGMetricPartitions)
+	default:
 		logger.Fatal("should never happen...")
 	}
-	if forceRecreatePGMetricPartitions {
-		forceRecreatePGMetricPartitions = false
+	if pgw.forceRecreatePGMetricPartitions {
+		pgw.forceRecreatePGMetricPartitions = false
 	}
 	if err != nil {
-		atomic.AddUint64(&datastoreWriteFailuresCounter, 1)
+		pgw.stats.recordWriteFailure(0)
 		pgw.lastError <- err
 	}
 
-	// send data to PG, with a separate COPY for all metrics
+	// send data to PG, with a single COPY stream per metric table
 	logger.Debugf("COPY-ing %d metrics to Postgres metricsDB...", rowsBatched)
 	t1 := time.Now()
+	failedMetrics := 0 // failures are recorded per metric, the batch only counts as a success if all metrics were stored
 
 	for metricName, metrics := range metricsToStorePerMetric {
-
-		getTargetTable := func() pgx.Identifier {
-			return pgx.Identifier{metricName}
+		if pgw.opts.Metric.PGRowLevelSecurity {
+			if err := pgw.ensureRowLevelSecurity(metricName, metrics); err != nil {
+				// still storing the rows, a missing reader role only hides them from the group until the next write
+				logger.WithField("metric", metricName).Error("Failed to set up row-level security: ", err)
+			}
 		}
-
-		getTargetColumns := func() []string {
-			return []string{"time", "dbname", "data", "tag_data"}
+		if pgw.MetricSchema == DbStorageSchemaTyped {
+			columns, rows, err := pgw.typedMetricRows(metricName, metrics)
+			if err != nil {
+				logger.WithField("metric", metricName).Error(err)
+				pgw.stats.recordWriteFailure(len(metrics))
+				failedMetrics++
+				continue
+			}
+			tm := time.Now()
+			if err := pgw.copyMetricRows(metricName, columns, rows); err != nil {
+				logger.WithField("metric", metricName).Error(err)
+				failedMetrics++
+				pgw.typedColumnsCache[metricName] = nil // columns could have been dropped manually, re-read on next write
+				delete(pgw.rlsMetrics, metricName)
+				if strings.Contains(err.Error(), "no partition") {
+					pgw.forceRecreatePGMetricPartitions = true
+					logger.Warning("Some metric partitions might have been removed, halting all metric storage. Trying to re-create all needed partitions on next run")
+				}
+			}
+			pgw.stats.postgresWriteDuration.WithLabelValues(metricName).Observe(time.Since(tm).Seconds())
+			pgw.stats.postgresWriteRows.WithLabelValues(metricName).Observe(float64(len(rows)))
+			continue
 		}
-
+		rows := make([][]any, 0, len(metrics))
 		for _, m := range metrics {
-			l := logger.WithField("db", m.DBName).WithField("metric", m.Metric)
 			jsonBytes, err := json.Marshal(m.Data)
 			if err != nil {
 				logger.Errorf("Skipping 1 metric for [%s:%s] due to JSON conversion error: %s", m.DBName, m.Metric, err)
-				atomic.AddUint64(&totalMetricsDroppedCounter, 1)
+				pgw.stats.metricsDropped.Add(1)
 				continue
 			}
-
-			getTagData := func() any {
-				if len(m.TagData) > 0 {
-					jsonBytesTags, err := json.Marshal(m.TagData)
-					if err != nil {
-						l.Error(err)
-						atomic.AddUint64(&datastoreWriteFailuresCounter, 1)
-						return nil
-					}
-					return string(jsonBytesTags)
+			var tagData any
+			if len(m.TagData) > 0 {
+				jsonBytesTags, err := json.Marshal(m.TagData)
+				if err != nil {
+					logger.WithField("db", m.DBName).WithField("metric", m.Metric).Error(err)
+					pgw.stats.recordWriteFailure(0)
+				} else {
+					tagData = string(jsonBytesTags)
 				}
-				return nil
 			}
-
-			rows := [][]any{{m.Time, m.DBName, string(jsonBytes), getTagData()}}
-
-			if _, err = pgw.SinkDb.CopyFrom(context.Background(), getTargetTable(), getTargetColumns(), pgx.CopyFromRows(rows)); err != nil {
-				l.Error(err)
-				atomic.AddUint64(&datastoreWriteFailuresCounter, 1)
-				forceRecreatePGMetricPartitions = strings.Contains(err.Error(), "no partition")
-				if forceRecreatePGMetricPartitions {
-					logger.Warning("Some metric partitions might have been removed, halting all metric storage. Trying to re-create all needed partitions on next run")
-				}
+			row := []any{m.Time, m.DBName, string(jsonBytes), tagData}
+			if pgw.opts.Metric.PGRowLevelSecurity {
+				row = append(row, nullIfEmpty(m.Group))
+			}
+			rows = append(rows, row)
+		}
+		if len(rows) == 0 {
+			continue
+		}
+		columns := targetColumns
+		if pgw.opts.Metric.PGRowLevelSecurity {
+			columns = append(columns[:len(columns):len(columns)], groupColumn)
+		}
+		tm := time.Now()
+		if err := pgw.copyMetricRows(metricName, columns, rows); err != nil {
+			logger.WithField("metric", metricName).Error(err)
+			failedMetrics++
+			delete(pgw.rlsMetrics, metricName)
+			if strings.Contains(err.Error(), "no partition") { // not resetting a flag set for a previous metric
+				pgw.forceRecreatePGMetricPartitions = true
+				logger.Warning("Some metric partitions might have been removed, halting all metric storage. Trying to re-create all needed partitions on next run")
 			}
 		}
+		pgw.stats.postgresWriteDuration.WithLabelValues(metricName).Observe(time.Since(tm).Seconds())
+		pgw.stats.postgresWriteRows.WithLabelValues(metricName).Observe(float64(len(rows)))
 	}
 
 	diff := time.Since(t1)
+	if err == nil && failedMetrics > 0 {
+		err = fmt.Errorf("failed to store %d of %d metrics to Postgres", failedMetrics, len(metricsToStorePerMetric))
+	}
 	if err == nil {
 		if len(msgs) == 1 {
 			logger.Infof("wrote %d/%d rows to Postgres for [%s:%s] in %.1f ms", rowsBatched, totalRows,
@@ -345,24 +465,51 @@ func (pgw *PostgresWriter) write(msgs []metrics.MeasurementMessage) {
 			logger.Infof("wrote %d/%d rows from %d metric sets to Postgres in %.1f ms", rowsBatched, totalRows,
 				len(msgs), float64(diff.Nanoseconds())/1000000)
 		}
-		// atomic.StoreInt64(&lastSuccessfulDatastoreWriteTimeEpoch, t1.Unix())
-		// atomic.AddUint64(&datastoreTotalWriteTimeMicroseconds, uint64(diff.Microseconds()))
-		// atomic.AddUint64(&datastoreWriteSuccessCounter, 1)
+		pgw.stats.recordWriteSuccess(t1)
+		span.SetAttributes(attribute.Int("pgwatch3.rows", rowsBatched))
 		return
 	}
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
 	pgw.lastError <- err
 }
 
+// copyMetricRows stores all rows of a metric via one COPY. If the COPY fails due to the data, i.e. an invalid value
+// (SQLSTATE class 22) or a violated constraint (class 23), the batch is split in halves and retried to isolate and
+// drop only the bad rows
+func (pgw *PostgresWriter) copyMetricRows(metricName string, columns []string, rows [][]any) error {
+	_, err := pgw.SinkDb.CopyFrom(pgw.Ctx, pgx.Identifier{metricName}, columns, pgx.CopyFromRows(rows))
+	if err == nil {
+		return nil
+	}
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) || !isDataError(pgErr) || strings.Contains(err.Error(), "no partition") {
+		pgw.stats.recordWriteFailure(len(rows))
+		return err // connection problems, schema errors or missing partitions, no point in retrying row by row
+	}
+	if len(rows) == 1 {
+		pgw.stats.recordWriteFailure(1)
+		return fmt.Errorf("dropping 1 row for metric '%s': %w", metricName, err)
+	}
+	half := len(rows) / 2
+	return errors.Join(pgw.copyMetricRows(metricName, columns, rows[:half]), pgw.copyMetricRows(metricName, columns, rows[half:]))
+}
+
+// isDataError tells if a row of the batch caused the error, and not the connection, the table or the privileges
+func isDataError(pgErr *pgconn.PgError) bool {
+	return strings.HasPrefix(pgErr.Code, "22") || strings.HasPrefix(pgErr.Code, "23")
+}
+
 func (pgw *PostgresWriter) EnsureMetric(pgPartBounds map[string]ExistingPartitionInfo, force bool) (err error) {
 	logger := log.GetLogger(pgw.Ctx)
 	sqlEnsure := `select * from admin.ensure_partition_metric($1)`
 	for metric := range pgPartBounds {
-		if _, ok := partitionMapMetric[metric]; !ok || force {
+		if _, ok := pgw.partitionMapMetric[metric]; !ok || force {
 			if _, err = pgw.SinkDb.Exec(pgw.Ctx, sqlEnsure, metric); err != nil {
 				logger.Errorf("Failed to create partition on metric '%s': %w", metric, err)
 				return err
 			}
-			partitionMapMetric[metric] = ExistingPartitionInfo{}
+			pgw.partitionMapMetric[metric] = ExistingPartitionInfo{}
 		}
 	}
 	return nil
@@ -380,14 +527,14 @@ func (pgw *PostgresWriter) EnsureMetricTime(pgPartBounds map[string]ExistingPart
 			return fmt.Errorf("zero StartTime/EndTime in partitioning request: [%s:%v]", metric, pb)
 		}
 
-		partInfo, ok := partitionMapMetric[metric]
+		partInfo, ok := pgw.partitionMapMetric[metric]
 		if !ok || (ok && (pb.StartTime.Before(partInfo.StartTime))) || force {
 			err := pgw.SinkDb.QueryRow(pgw.Ctx, sqlEnsure, metric, pb.StartTime).Scan(&partInfo)
 			if err != nil {
 				logger.Error("Failed to create partition on 'metrics':", err)
 				return err
 			}
-			partitionMapMetric[metric] = partInfo
+			pgw.partitionMapMetric[metric] = partInfo
 		}
 		if pb.EndTime.After(partInfo.EndTime) || force {
 			err := pgw.SinkDb.QueryRow(pgw.Ctx, sqlEnsure, metric, pb.EndTime).Scan(&partInfo.EndTime)
@@ -395,25 +542,42 @@ func (pgw *PostgresWriter) EnsureMetricTime(pgPartBounds map[string]ExistingPart
 				logger.Error("Failed to create partition on 'metrics':", err)
 				return err
 			}
-			partitionMapMetric[metric] = partInfo
+			pgw.partitionMapMetric[metric] = partInfo
 		}
 	}
 	return nil
 }
 
-func (pgw *PostgresWriter) EnsureMetricTimescale(pgPartBounds map[string]ExistingPartitionInfo, force bool) (err error) {
+// EnsureMetricTimescale creates the hypertables of new metrics, with a retention policy covering the effective retention
+// of the metric and the DBs written to it, see RetentionPolicy.HypertableDays()
+func (pgw *PostgresWriter) EnsureMetricTimescale(pgPartBounds map[string]ExistingPartitionInfo, metricDBNames map[string]map[string]bool, force bool) (err error) {
 	logger := log.GetLogger(pgw.Ctx)
-	sqlEnsure := `select * from admin.ensure_partition_timescale($1)`
+	sqlEnsure := `select * from admin.ensure_partition_timescale($1, $2::interval, $3::interval, $4 * '1 day'::interval)`
+	var rp *RetentionPolicy
 	for metric := range pgPartBounds {
 		if strings.HasSuffix(metric, "_realtime") {
 			continue
 		}
-		if _, ok := partitionMapMetric[metric]; !ok {
-			if _, err = pgw.SinkDb.Exec(pgw.Ctx, sqlEnsure, metric); err != nil {
+		if _, ok := pgw.partitionMapMetric[metric]; !ok {
+			if rp == nil {
+				policy, err := pgw.GetRetentionPolicy()
+				if err != nil {
+					logger.Warning("Failed to read the retention overrides, using the metric attributes and defaults: ", err)
+				}
+				rp = &policy
+			}
+			dbnames := make([]string, 0, len(metricDBNames[metric]))
+			for dbname := range metricDBNames[metric] {
+				dbnames = append(dbnames, dbname)
+			}
+			attrs := pgw.getMetricAttrs(metric)
+			retentionDays := rp.HypertableDays(metric, dbnames)
+			if _, err = pgw.SinkDb.Exec(pgw.Ctx, sqlEnsure, metric, nullIfEmpty(attrs.TimescaleChunkInterval),
+				nullIfEmpty(attrs.TimescaleCompressAfter), nullIfZero(retentionDays)); err != nil {
 				logger.Errorf("Failed to create a TimescaleDB table for metric '%s': %v", metric, err)
 				return err
 			}
-			partitionMapMetric[metric] = ExistingPartitionInfo{}
+			pgw.partitionMapMetric[metric] = ExistingPartitionInfo{}
 		}
 	}
 	return pgw.EnsureMetricTime(pgPartBounds, force)
@@ -423,16 +587,16 @@ func (pgw *PostgresWriter) EnsureMetricDbnameTime(metricDbnamePartBounds map[str
 	var rows pgx.Rows
 	sqlEnsure := `select * from admin.ensure_partition_metric_dbname_time($1, $2, $3)`
 	for metric, dbnameTimestampMap := range metricDbnamePartBounds {
-		_, ok := partitionMapMetricDbname[metric]
+		_, ok := pgw.partitionMapMetricDbname[metric]
 		if !ok {
-			partitionMapMetricDbname[metric] = make(map[string]ExistingPartitionInfo)
+			pgw.partitionMapMetricDbname[metric] = make(map[string]ExistingPartitionInfo)
 		}
 
 		for dbname, pb := range dbnameTimestampMap {
 			if pb.StartTime.IsZero() || pb.EndTime.IsZero() {
 				return fmt.Errorf("zero StartTime/EndTime in partitioning request: [%s:%v]", metric, pb)
 			}
-			partInfo, ok := partitionMapMetricDbname[metric][dbname]
+			partInfo, ok := pgw.partitionMapMetricDbname[metric][dbname]
 			if !ok || (ok && (pb.StartTime.Before(partInfo.StartTime))) || force {
 				if rows, err = pgw.SinkDb.Query(pgw.Ctx, sqlEnsure, metric, dbname, pb.StartTime); err != nil {
 					return
@@ -440,7 +604,7 @@ func (pgw *PostgresWriter) EnsureMetricDbnameTime(metricDbnamePartBounds map[str
 				if partInfo, err = pgx.CollectOneRow(rows, pgx.RowToStructByPos[ExistingPartitionInfo]); err != nil {
 					return err
 				}
-				partitionMapMetricDbname[metric][dbname] = partInfo
+				pgw.partitionMapMetricDbname[metric][dbname] = partInfo
 			}
 			if pb.EndTime.After(partInfo.EndTime) || pb.EndTime.Equal(partInfo.EndTime) || force {
 				if rows, err = pgw.SinkDb.Query(pgw.Ctx, sqlEnsure, metric, dbname, pb.StartTime); err != nil {
@@ -449,18 +613,15 @@ func (pgw *PostgresWriter) EnsureMetricDbnameTime(metricDbnamePartBounds map[str
 				if partInfo, err = pgx.CollectOneRow(rows, pgx.RowToStructByPos[ExistingPartitionInfo]); err != nil {
 					return err
 				}
-				partitionMapMetricDbname[metric][dbname] = partInfo
+				pgw.partitionMapMetricDbname[metric][dbname] = partInfo
 			}
 		}
 	}
 	return nil
 }
 
+// OldPostgresMetricsDeleter applies the retention policy, see GetRetentionPolicy for the possible overrides
 func (pgw *PostgresWriter) OldPostgresMetricsDeleter() {
-	metricAgeDaysThreshold := pgw.opts.Metric.PGRetentionDays
-	if metricAgeDaysThreshold <= 0 {
-		return
-	}
 	logger := log.GetLogger(pgw.Ctx)
 	select {
 	case <-pgw.Ctx.Done():
@@ -470,36 +631,24 @@ func (pgw *PostgresWriter) OldPostgresMetricsDeleter() {
 	}
 
 	for {
-		if pgw.MetricSchema == DbStorageSchemaTimescale {
-			partsDropped, err := pgw.DropOldTimePartitions(metricAgeDaysThreshold)
-			if err != nil {
-				logger.Errorf("Failed to drop old partitions (>%d days) from Postgres: %v", metricAgeDaysThreshold, err)
-				continue
+		rp, err := pgw.GetRetentionPolicy()
+		if err != nil {
+			logger.Errorf("Failed to read retention settings from admin.config: %v", err)
+		} else {
+			if pgw.MetricSchema == DbStorageSchemaTimescale {
+				chunksDropped, err := pgw.DropOldTimescaleData(rp)
+				if err != nil {
+					logger.Errorf("Failed to drop old Timescale chunks: %v", err)
+				} else {
+					logger.Infof("Dropped %d old metric chunks...", chunksDropped)
+				}
 			}
-			logger.Infof("Dropped %d old metric partitions...", partsDropped)
-		} else if pgw.MetricSchema == DbStorageSchemaPostgres {
-			partsToDrop, err := pgw.GetOldTimePartitions(metricAgeDaysThreshold)
+			// also for Timescale, as realtime metrics use plain Postgres partitions
+			partsDropped, err := pgw.DropOldPostgresPartitions(rp)
 			if err != nil {
-				logger.Errorf("Failed to get a listing of old (>%d days) time partitions from Postgres metrics DB - check that the admin.get_old_time_partitions() function is rolled out: %v", metricAgeDaysThreshold, err)
-				time.Sleep(time.Second * 300)
-				continue
-			}
-			if len(partsToDrop) > 0 {
-				logger.Infof("Dropping %d old metric partitions one by one...", len(partsToDrop))
-				for _, toDrop := range partsToDrop {
-					sqlDropTable := `DROP TABLE IF EXISTS ` + pgx.Identifier{toDrop}.Sanitize()
-					logger.Debugf("Dropping old metric data partition: %s", toDrop)
-
-					if _, err := pgw.SinkDb.Exec(pgw.Ctx, sqlDropTable); err != nil {
-						logger.Errorf("Failed to drop old partition %s from Postgres metrics DB: %w", toDrop, err)
-						time.Sleep(time.Second * 300)
-					} else {
-						time.Sleep(time.Second * 5)
-					}
-				}
-			} else {
-				logger.Infof("No old metric partitions found to drop...")
+				logger.Errorf("Failed to drop old partitions from Postgres metrics DB - check that the admin.get_time_partitions() function is rolled out: %v", err)
 			}
+			logger.Infof("Dropped %d old metric partitions...", partsDropped)
 		}
 		select {
 		case <-pgw.Ctx.Done():
@@ -0,0 +1,248 @@
+package sinks
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cybertec-postgresql/pgwatch3/log"
+	"github.com/cybertec-postgresql/pgwatch3/metrics"
+	"github.com/jackc/pgx/v5"
+)
+
+// admin.config keys for retention overrides, suffixed with the metric, DB unique name or group
+const (
+	retentionKeyMetric = "retention_days.metric."
+	retentionKeyDBName = "retention_days.dbname."
+	retentionKeyGroup  = "retention_days.group."
+)
+
+// partitionDropPause spreads the drops of old partitions, to not block the inserts for too long
+var partitionDropPause = time.Second * 5
+
+// RetentionPolicy resolves how many days of metric data to keep, 0 meaning forever. Precedence is
+// admin.config metric override > "retention_days" metric attribute > admin.config DB override > group override > default
+type RetentionPolicy struct {
+	Default       int
+	Metric        map[string]int
+	MetricAttr    map[string]int
+	DBName        map[string]int
+	Group         map[string]int
+	GroupResolver func(dbUnique string) string
+	KnownMetrics  map[string]bool // metrics with known attributes, i.e. defined or written since the start
+}
+
+// IsKnown tells if the retention of a metric can be resolved, i.e. if no "retention_days" attribute could be missing
+func (rp RetentionPolicy) IsKnown(metric string) bool {
+	if _, ok := rp.Metric[metric]; ok {
+		return true
+	}
+	return rp.KnownMetrics[metric] || strings.Contains(metric, "realtime")
+}
+
+// Days returns the retention for the data of a metric and DB. dbUnique is empty for metric-time partitions
+func (rp RetentionPolicy) Days(metric, dbUnique string) int {
+	if days, ok := rp.Metric[metric]; ok {
+		return days
+	}
+	if days, ok := rp.MetricAttr[metric]; ok {
+		return days
+	}
+	if strings.Contains(metric, "realtime") {
+		return 1 // realtime metrics are only meant for short-term troubleshooting
+	}
+	if dbUnique > "" {
+		if days, ok := rp.DBName[dbUnique]; ok {
+			return days
+		}
+		if rp.GroupResolver != nil {
+			if days, ok := rp.Group[rp.GroupResolver(dbUnique)]; ok {
+				return days
+			}
+		}
+	}
+	return rp.Default
+}
+
+// HypertableDays returns the retention of a whole Timescale hypertable, i.e. the longest of the metric and its DBs,
+// 0 if any of them keeps the data forever
+func (rp RetentionPolicy) HypertableDays(metric string, dbnames []string) int {
+	maxDays := rp.Days(metric, "")
+	for _, dbname := range dbnames {
+		if days := rp.Days(metric, dbname); days <= 0 || maxDays > 0 && days > maxDays {
+			maxDays = days
+		}
+	}
+	return maxDays
+}
+
+// seedMetricAttrs sets the attributes of all defined metrics, so that retention_days attributes are known before the
+// first write of a metric. Tables are named by the metric storage name if set
+func (pgw *PostgresWriter) seedMetricAttrs(metricDefs metrics.MetricVersionDefs) {
+	for metric, versions := range metricDefs {
+		var latest uint
+		for v := range versions {
+			latest = max(latest, v)
+		}
+		attrs := versions[latest].MetricAttrs
+		if attrs.MetricStorageName > "" {
+			metric = attrs.MetricStorageName
+		}
+		pgw.setMetricAttrs(metric, attrs)
+	}
+}
+
+func (pgw *PostgresWriter) setMetricAttrs(metric string, attrs metrics.MetricAttrs) {
+	pgw.metricAttrsLock.Lock()
+	defer pgw.metricAttrsLock.Unlock()
+	pgw.metricAttrs[metric] = attrs
+}
+
+func (pgw *PostgresWriter) getMetricAttrs(metric string) metrics.MetricAttrs {
+	pgw.metricAttrsLock.RLock()
+	defer pgw.metricAttrsLock.RUnlock()
+	return pgw.metricAttrs[metric]
+}
+
+// GetRetentionPolicy combines the retention overrides from admin.config and metric attributes
+func (pgw *PostgresWriter) GetRetentionPolicy() (rp RetentionPolicy, err error) {
+	rp = RetentionPolicy{
+		Default:       pgw.opts.Metric.PGRetentionDays,
+		Metric:        make(map[string]int),
+		MetricAttr:    make(map[string]int),
+		DBName:        make(map[string]int),
+		Group:         make(map[string]int),
+		GroupResolver: pgw.GroupResolver,
+		KnownMetrics:  make(map[string]bool),
+	}
+	pgw.metricAttrsLock.RLock()
+	for k, v := range pgw.metricAttrs {
+		rp.KnownMetrics[k] = true
+		if v.RetentionDays > 0 {
+			rp.MetricAttr[k] = v.RetentionDays
+		}
+	}
+	pgw.metricAttrsLock.RUnlock()
+
+	rows, err := pgw.SinkDb.Query(pgw.Ctx, `select key, value from admin.config where key like 'retention\_days.%'`)
+	if err != nil {
+		return
+	}
+	var key, value string
+	_, err = pgx.ForEachRow(rows, []any{&key, &value}, func() error {
+		days, err := strconv.Atoi(value)
+		if err != nil {
+			log.GetLogger(pgw.Ctx).Warningf("Ignoring invalid retention setting %s = %s", key, value)
+			return nil
+		}
+		switch {
+		case strings.HasPrefix(key, retentionKeyMetric):
+			rp.Metric[strings.TrimPrefix(key, retentionKeyMetric)] = days
+		case strings.HasPrefix(key, retentionKeyDBName):
+			rp.DBName[strings.TrimPrefix(key, retentionKeyDBName)] = days
+		case strings.HasPrefix(key, retentionKeyGroup):
+			rp.Group[strings.TrimPrefix(key, retentionKeyGroup)] = days
+		}
+		return nil
+	})
+	return
+}
+
+// DropOldPostgresPartitions drops the time partitions older than the retention of their metric and DB
+func (pgw *PostgresWriter) DropOldPostgresPartitions(rp RetentionPolicy) (dropped int, err error) {
+	logger := log.GetLogger(pgw.Ctx)
+	rows, err := pgw.SinkDb.Query(pgw.Ctx, `select metric, coalesce(dbname, ''), time_partition_name, part_end from admin.get_time_partitions()`)
+	if err != nil {
+		return
+	}
+	type partition struct {
+		Metric, DBName, Name string
+		End                  time.Time
+	}
+	parts, err := pgx.CollectRows(rows, pgx.RowToStructByPos[partition])
+	if err != nil {
+		return
+	}
+	for _, p := range parts {
+		if strings.Contains(p.Metric, "_rollup_") { // rollup tiers have their own retention
+			continue
+		}
+		if !rp.IsKnown(p.Metric) {
+			logger.Debugf("Not dropping partition %s, the retention of metric %s is unknown", p.Name, p.Metric)
+			continue
+		}
+		days := rp.Days(p.Metric, p.DBName)
+		if days <= 0 || !p.End.Before(time.Now().AddDate(0, 0, -days)) {
+			continue
+		}
+		logger.Debugf("Dropping old metric data partition: %s", p.Name)
+		if _, e := pgw.SinkDb.Exec(pgw.Ctx, `DROP TABLE IF EXISTS `+p.Name); e != nil {
+			logger.Errorf("Failed to drop old metric data partition %s: %v", p.Name, e)
+			err = errors.Join(err, e)
+			continue
+		}
+		dropped++
+		select {
+		case <-pgw.Ctx.Done():
+			return dropped, errors.Join(err, pgw.Ctx.Err())
+		case <-time.After(partitionDropPause):
+		}
+	}
+	return
+}
+
+// DropOldTimescaleData drops whole chunks older than the longest retention of any DB of a metric and
+// deletes the data of DBs having a shorter retention
+func (pgw *PostgresWriter) DropOldTimescaleData(rp RetentionPolicy) (dropped int, err error) {
+	logger := log.GetLogger(pgw.Ctx)
+	rows, err := pgw.SinkDb.Query(pgw.Ctx, `select h.table_name::text from _timescaledb_catalog.hypertable h where h.schema_name = 'public'`)
+	if err != nil {
+		return
+	}
+	hypertables, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return
+	}
+	var dbnames []string
+	for _, metric := range hypertables {
+		if !rp.IsKnown(metric) {
+			logger.Debugf("Not dropping data of metric %s, its retention is unknown", metric)
+			continue
+		}
+		rows, err = pgw.SinkDb.Query(pgw.Ctx, `select dbname from admin.all_distinct_dbname_metrics where metric = $1`, metric)
+		if err != nil {
+			return
+		}
+		if dbnames, err = pgx.CollectRows(rows, pgx.RowTo[string]); err != nil {
+			return
+		}
+		maxDays := rp.HypertableDays(metric, dbnames)
+		if changed, err := pgw.ensureTimescaleRetentionPolicy(metric, maxDays); err != nil {
+			logger.Warningf("Failed to set the retention policy of %s, Timescale 2.0+ needed: %v", metric, err)
+		} else if changed {
+			logger.Infof("Retention policy of %s set to %d days (0 = none)", metric, maxDays)
+		}
+		if maxDays > 0 {
+			var chunks int
+			if err = pgw.SinkDb.QueryRow(pgw.Ctx, `select count(*) from drop_chunks($1::regclass, older_than => $2 * '1 day'::interval)`,
+				pgx.Identifier{"public", metric}.Sanitize(), maxDays).Scan(&chunks); err != nil {
+				return
+			}
+			dropped += chunks
+		}
+		for _, dbname := range dbnames {
+			days := rp.Days(metric, dbname)
+			if days <= 0 || days == maxDays {
+				continue
+			}
+			sql := `DELETE FROM ` + pgx.Identifier{"public", metric}.Sanitize() + ` WHERE dbname = $1 AND time < now() - $2 * '1 day'::interval`
+			tag, err := pgw.SinkDb.Exec(pgw.Ctx, sql, dbname, days)
+			if err != nil {
+				return dropped, err
+			}
+			logger.Debugf("Deleted %d rows older than %d days for [%s:%s]", tag.RowsAffected(), days, dbname, metric)
+		}
+	}
+	return
+}
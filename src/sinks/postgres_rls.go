@@ -0,0 +1,39 @@
+package sinks
+
+import (
+	"errors"
+
+	"github.com/cybertec-postgresql/pgwatch3/log"
+)
+
+// groupColumn holds the monitored DB group with --pg-rls, used by the row-level security policies
+const groupColumn = "dbgroup"
+
+// ensureRowLevelSecurity sets up the group policy of a metric table and the reader roles of all groups of the
+// measurements on first write. See admin.ensure_metric_rls() and admin.ensure_group_role()
+func (pgw *PostgresWriter) ensureRowLevelSecurity(metric string, msgs []MeasurementMessagePostgres) (err error) {
+	logger := log.GetLogger(pgw.Ctx)
+	if !pgw.rlsMetrics[metric] {
+		if _, err := pgw.SinkDb.Exec(pgw.Ctx, `select admin.ensure_metric_rls($1)`, metric); err != nil {
+			return err
+		}
+		pgw.rlsMetrics[metric] = true
+	}
+	failedGroups := make(map[string]bool)
+	for _, m := range msgs {
+		if m.Group == "" || pgw.rlsGroups[m.Group] || failedGroups[m.Group] {
+			continue
+		}
+		var created bool
+		if e := pgw.SinkDb.QueryRow(pgw.Ctx, `select admin.ensure_group_role($1)`, m.Group).Scan(&created); e != nil {
+			failedGroups[m.Group] = true
+			err = errors.Join(err, e)
+			continue
+		}
+		if created {
+			logger.Infof("Created metric reader role pgwatch3_group_%s for group '%s'", m.Group, m.Group)
+		}
+		pgw.rlsGroups[m.Group] = true
+	}
+	return
+}
@@ -0,0 +1,229 @@
+package sinks
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cybertec-postgresql/pgwatch3/log"
+	"github.com/jackc/pgx/v5"
+)
+
+// RollupTier is a downsampling level, e.g. 5 minute averages / maximums kept for 90 days
+type RollupTier struct {
+	Name          string // as specified, used as the "<metric>_rollup_<name>" table suffix
+	Bucket        time.Duration
+	RetentionDays int
+}
+
+var regexRollupBucket = regexp.MustCompile(`^(\d+)([mhd])$`)
+
+// ParseRollupTiers parses the --pg-rollup-tiers value, e.g. "5m:90,1h:365"
+func ParseRollupTiers(s string) (tiers []RollupTier, err error) {
+	for _, tier := range strings.Split(s, ",") {
+		if tier = strings.TrimSpace(tier); tier == "" {
+			continue
+		}
+		bucket, days, ok := strings.Cut(tier, ":")
+		m := regexRollupBucket.FindStringSubmatch(bucket)
+		if !ok || m == nil {
+			return nil, fmt.Errorf("invalid rollup tier '%s', expected bucket:retention_days, e.g. 5m:90", tier)
+		}
+		t := RollupTier{Name: bucket}
+		if t.RetentionDays, err = strconv.Atoi(days); err != nil {
+			return nil, fmt.Errorf("invalid rollup tier '%s' retention days: %w", tier, err)
+		}
+		n, _ := strconv.Atoi(m[1])
+		switch m[2] {
+		case "m":
+			t.Bucket = time.Duration(n) * time.Minute
+		case "h":
+			t.Bucket = time.Duration(n) * time.Hour
+		case "d":
+			t.Bucket = time.Duration(n) * time.Hour * 24
+		}
+		if t.Bucket == 0 {
+			return nil, fmt.Errorf("invalid rollup tier '%s', zero bucket", tier)
+		}
+		tiers = append(tiers, t)
+	}
+	return
+}
+
+// RollupTableName returns the table (or continuous aggregate) name of a metric rollup tier
+func RollupTableName(metric string, tier RollupTier) string {
+	return metric + "_rollup_" + tier.Name
+}
+
+// RollupMaintainer keeps the configured rollup tiers up to date. For Timescale continuous aggregates with refresh and
+// retention policies are created, for plain Postgres complete buckets are aggregated into "*_rollup_*" partitions
+// and old partitions dropped according to the tier retention
+func (pgw *PostgresWriter) RollupMaintainer() {
+	if len(pgw.rollupTiers) == 0 {
+		return
+	}
+	logger := log.GetLogger(pgw.Ctx)
+	ensured := make(map[string]bool)      // Timescale continuous aggregates
+	lastRun := make(map[string]time.Time) // Postgres rollup tables
+	var lastRetentionRun time.Time
+
+	for {
+		select {
+		case <-pgw.Ctx.Done():
+			return
+		case <-time.After(time.Minute):
+		}
+
+		rows, err := pgw.SinkDb.Query(pgw.Ctx, `SELECT table_name FROM admin.get_top_level_metric_tables()`)
+		if err != nil {
+			logger.Error("Failed to list metric tables for rollups: ", err)
+			continue
+		}
+		tables, err := pgx.CollectRows(rows, pgx.RowTo[string])
+		if err != nil {
+			logger.Error("Failed to list metric tables for rollups: ", err)
+			continue
+		}
+
+		for _, table := range tables {
+			metric := strings.Replace(table, "public.", "", 1)
+			if strings.Contains(metric, "_rollup_") || strings.Contains(metric, "realtime") {
+				continue
+			}
+			for _, tier := range pgw.rollupTiers {
+				rollup := RollupTableName(metric, tier)
+				if pgw.MetricSchema == DbStorageSchemaTimescale {
+					if ensured[rollup] {
+						continue
+					}
+					if _, err := pgw.SinkDb.Exec(pgw.Ctx, `select admin.ensure_rollup_timescale($1, $2, $3, $4)`,
+						metric, rollup, tier.Bucket, tier.RetentionDays); err != nil {
+						logger.Errorf("Failed to create continuous aggregate %s: %v", rollup, err)
+						continue
+					}
+					ensured[rollup] = true
+					continue
+				}
+				if time.Since(lastRun[rollup]) < tier.Bucket {
+					continue
+				}
+				if err := pgw.RollupPostgres(metric, tier); err != nil {
+					logger.Errorf("Failed to aggregate %s into %s: %v", metric, rollup, err)
+					continue
+				}
+				lastRun[rollup] = time.Now()
+			}
+		}
+
+		if pgw.MetricSchema == DbStorageSchemaPostgres && time.Since(lastRetentionRun) > time.Hour*12 {
+			pgw.dropOldRollupPartitions(tables)
+			lastRetentionRun = time.Now()
+		}
+	}
+}
+
+// RollupPostgres aggregates all complete buckets since the last run from the metric table into the rollup tier table.
+// The last aggregated bucket end is stored in admin.config as "rollup_watermark_<rollup table>"
+func (pgw *PostgresWriter) RollupPostgres(metric string, tier RollupTier) error {
+	rollup := RollupTableName(metric, tier)
+	tx, err := pgw.SinkDb.Begin(pgw.Ctx)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback(pgw.Ctx) }()
+
+	var locked bool // to only have one gatherer doing the work in case of a "push" setup
+	if err = tx.QueryRow(pgw.Ctx, `select pg_try_advisory_xact_lock(hashtext($1))`, rollup).Scan(&locked); err != nil || !locked {
+		return err
+	}
+
+	var from time.Time
+	watermarkKey := "rollup_watermark_" + rollup
+	err = tx.QueryRow(pgw.Ctx, `select value::timestamptz from admin.config where key = $1`, watermarkKey).Scan(&from)
+	if errors.Is(err, pgx.ErrNoRows) {
+		from = epochBucketStart(time.Now().AddDate(0, 0, -max(pgw.opts.Metric.PGRetentionDays, 1)), tier.Bucket)
+	} else if err != nil {
+		return err
+	} else if start := epochBucketStart(from, tier.Bucket); !start.Equal(from) {
+		from = start.Add(tier.Bucket) // not aggregating the rest of a partially done bucket again
+	}
+	to := epochBucketStart(time.Now().Add(-time.Minute), tier.Bucket) // only complete buckets, allowing for some batching delay
+	if !to.After(from) {
+		return nil
+	}
+
+	rows, err := tx.Query(pgw.Ctx, `select dbname from admin.all_distinct_dbname_metrics where metric = $1`, metric)
+	if err != nil {
+		return err
+	}
+	dbnames, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return err
+	}
+	sqlEnsure := `select * from admin.ensure_partition_metric_dbname_time($1, $2, $3)`
+	for _, dbname := range dbnames {
+		for t := from; t.Before(to); t = t.AddDate(0, 0, 7) { // weekly partitions
+			if _, err = tx.Exec(pgw.Ctx, sqlEnsure, rollup, dbname, t); err != nil {
+				return err
+			}
+		}
+		if _, err = tx.Exec(pgw.Ctx, sqlEnsure, rollup, dbname, to.Add(-time.Nanosecond)); err != nil {
+			return err
+		}
+	}
+
+	sqlRollup := fmt.Sprintf(`insert into %s (time, dbname, data, tag_data)
+		select to_timestamp(floor(extract(epoch from time) / $3) * $3), dbname, admin.rollup_avg_max(data), tag_data
+		from %s
+		where time >= $1 and time < $2
+		group by 1, dbname, tag_data`, pgx.Identifier{"public", rollup}.Sanitize(), pgx.Identifier{"public", metric}.Sanitize())
+	if _, err = tx.Exec(pgw.Ctx, sqlRollup, from, to, tier.Bucket.Seconds()); err != nil {
+		return err
+	}
+	sqlWatermark := `insert into admin.config (key, value) values ($1, $2)
+		on conflict (key) do update set value = excluded.value`
+	if _, err = tx.Exec(pgw.Ctx, sqlWatermark, watermarkKey, to.Format(time.RFC3339)); err != nil {
+		return err
+	}
+	return tx.Commit(pgw.Ctx)
+}
+
+// epochBucketStart returns the start of the bucket containing t, buckets counting from the Unix epoch like the SQL
+// floor(epoch / bucket) * bucket. time.Truncate() counts from the zero time, differing for buckets not dividing a day
+func epochBucketStart(t time.Time, bucket time.Duration) time.Time {
+	ns := t.UnixNano()
+	ns -= ns % bucket.Nanoseconds()
+	return time.Unix(0, ns)
+}
+
+// dropOldRollupPartitions applies the tier retention to the rollup tables, these are skipped by OldPostgresMetricsDeleter
+func (pgw *PostgresWriter) dropOldRollupPartitions(tables []string) {
+	logger := log.GetLogger(pgw.Ctx)
+	for _, table := range tables {
+		metric := strings.Replace(table, "public.", "", 1)
+		for _, tier := range pgw.rollupTiers {
+			if tier.RetentionDays <= 0 || !strings.HasSuffix(metric, "_rollup_"+tier.Name) {
+				continue
+			}
+			rows, err := pgw.SinkDb.Query(pgw.Ctx, `select admin.get_old_time_partitions_for_metric($1, $2)`, metric, tier.RetentionDays)
+			if err != nil {
+				logger.Errorf("Failed to get old partitions of %s: %v", metric, err)
+				continue
+			}
+			partsToDrop, err := pgx.CollectRows(rows, pgx.RowTo[string])
+			if err != nil {
+				logger.Errorf("Failed to get old partitions of %s: %v", metric, err)
+				continue
+			}
+			for _, toDrop := range partsToDrop {
+				logger.Debugf("Dropping old rollup partition: %s", toDrop)
+				if _, err := pgw.SinkDb.Exec(pgw.Ctx, `DROP TABLE IF EXISTS `+toDrop); err != nil {
+					logger.Errorf("Failed to drop old rollup partition %s: %v", toDrop, err)
+				}
+			}
+		}
+	}
+}
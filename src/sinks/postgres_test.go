@@ -3,9 +3,15 @@ package sinks_test
 import (
 	"context"
 	"errors"
+	"regexp"
 	"testing"
+	"time"
 
+	"github.com/cybertec-postgresql/pgwatch3/config"
+	"github.com/cybertec-postgresql/pgwatch3/metrics"
 	"github.com/cybertec-postgresql/pgwatch3/sinks"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/pashagolub/pgxmock/v3"
 	"github.com/stretchr/testify/assert"
 )
@@ -26,7 +32,323 @@ func TestReadMetricSchemaType(t *testing.T) {
 	assert.Error(t, pgw.ReadMetricSchemaType())
 
 	conn.ExpectQuery("SELECT schema_type").
-		WillReturnRows(pgxmock.NewRows([]string{"schema_type"}).AddRow(true))
+		WillReturnRows(pgxmock.NewRows([]string{"schema_type"}).AddRow("timescale"))
 	assert.NoError(t, pgw.ReadMetricSchemaType())
 	assert.Equal(t, sinks.DbStorageSchemaTimescale, pgw.MetricSchema)
+
+	conn.ExpectQuery("SELECT schema_type").
+		WillReturnRows(pgxmock.NewRows([]string{"schema_type"}).AddRow("typed"))
+	assert.NoError(t, pgw.ReadMetricSchemaType())
+	assert.Equal(t, sinks.DbStorageSchemaTyped, pgw.MetricSchema)
+
+	conn.ExpectQuery("SELECT schema_type").
+		WillReturnRows(pgxmock.NewRows([]string{"schema_type"}).AddRow("postgres"))
+	assert.NoError(t, pgw.ReadMetricSchemaType())
+	assert.Equal(t, sinks.DbStorageSchemaPostgres, pgw.MetricSchema)
+}
+
+func TestParseRollupTiers(t *testing.T) {
+	tiers, err := sinks.ParseRollupTiers("5m:90, 1h:365,1d:0")
+	assert.NoError(t, err)
+	assert.Equal(t, []sinks.RollupTier{
+		{Name: "5m", Bucket: 5 * time.Minute, RetentionDays: 90},
+		{Name: "1h", Bucket: time.Hour, RetentionDays: 365},
+		{Name: "1d", Bucket: 24 * time.Hour, RetentionDays: 0},
+	}, tiers)
+	assert.Equal(t, "wal_rollup_5m", sinks.RollupTableName("wal", tiers[0]))
+
+	tiers, err = sinks.ParseRollupTiers("")
+	assert.NoError(t, err)
+	assert.Empty(t, tiers)
+
+	for _, s := range []string{"5m", "5x:90", "5m:abc", "0m:10"} {
+		_, err = sinks.ParseRollupTiers(s)
+		assert.Error(t, err, s)
+	}
+}
+
+func TestEpochBucketStart(t *testing.T) {
+	tests := []struct {
+		t      time.Time
+		bucket time.Duration
+		want   time.Time
+	}{
+		{time.Date(2024, 3, 1, 10, 7, 0, 0, time.UTC), 5 * time.Minute, time.Date(2024, 3, 1, 10, 5, 0, 0, time.UTC)},
+		{time.Date(2024, 3, 1, 10, 7, 0, 0, time.UTC), 90 * time.Minute, time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC)},
+		{time.Date(2024, 3, 1, 10, 7, 0, 0, time.UTC), 7 * 24 * time.Hour, time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC)}, // epoch was a Thursday
+		{time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC), 24 * time.Hour, time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		got := sinks.EpochBucketStart(tt.t, tt.bucket)
+		assert.True(t, tt.want.Equal(got), "%v / %v: got %v", tt.t, tt.bucket, got.UTC())
+		assert.Zero(t, got.Unix()%int64(tt.bucket.Seconds()), "should be aligned like the SQL buckets")
+	}
+}
+
+func TestRetentionPolicy(t *testing.T) {
+	rp := sinks.RetentionPolicy{
+		Default:       14,
+		Metric:        map[string]int{"stat_activity": 3, "change_events": 365},
+		MetricAttr:    map[string]int{"change_events": 30, "recommendations": 365},
+		DBName:        map[string]int{"prod": 60},
+		Group:         map[string]int{"archive": 0},
+		GroupResolver: func(dbUnique string) string { return map[string]string{"old": "archive"}[dbUnique] },
+	}
+	assert.Equal(t, 3, rp.Days("stat_activity", "prod"), "metric override wins")
+	assert.Equal(t, 365, rp.Days("change_events", "test"), "admin.config wins over the metric attribute")
+	assert.Equal(t, 365, rp.Days("recommendations", "prod"), "metric attribute wins over DB")
+	assert.Equal(t, 60, rp.Days("db_stats", "prod"))
+	assert.Equal(t, 0, rp.Days("db_stats", "old"), "group override")
+	assert.Equal(t, 14, rp.Days("db_stats", "test"))
+	assert.Equal(t, 14, rp.Days("db_stats", ""))
+	assert.Equal(t, 1, rp.Days("stat_activity_realtime", "prod"))
+	assert.Equal(t, 60, rp.HypertableDays("db_stats", []string{"test", "prod"}), "the longest DB retention should win")
+	assert.Equal(t, 0, rp.HypertableDays("db_stats", []string{"prod", "old"}), "keeping forever should win")
+	assert.Equal(t, 3, rp.HypertableDays("stat_activity", []string{"prod"}))
+}
+
+func TestEnsureMetricTimescaleRetention(t *testing.T) {
+	conn, err := pgxmock.NewPool()
+	assert.NoError(t, err)
+	pgw := sinks.NewTestPostgresWriter(conn, sinks.DbStorageSchemaTimescale, &config.Options{Metric: config.MetricOpts{PGRetentionDays: 14}})
+	overrides := func() *pgxmock.Rows {
+		return pgxmock.NewRows([]string{"key", "value"}).AddRow("retention_days.dbname.prod", "60").AddRow("retention_days.metric.wal", "7")
+	}
+	now := time.Now()
+	bounds := sinks.ExistingPartitionInfo{StartTime: now, EndTime: now}
+
+	conn.MatchExpectationsInOrder(false) // hypertables are created in map order
+	conn.ExpectQuery("admin.config").WillReturnRows(overrides())
+	conn.ExpectExec("ensure_partition_timescale").WithArgs("db_stats", nil, nil, 60).WillReturnResult(pgxmock.NewResult("SELECT", 1))
+	conn.ExpectExec("ensure_partition_timescale").WithArgs("wal", nil, nil, 7).WillReturnResult(pgxmock.NewResult("SELECT", 1))
+	assert.NoError(t, pgw.EnsureMetricTimescale(map[string]sinks.ExistingPartitionInfo{"db_stats": bounds, "wal": bounds},
+		map[string]map[string]bool{"db_stats": {"test": true, "prod": true}, "wal": {"prod": true}}, false))
+	assert.NoError(t, conn.ExpectationsWereMet(), "the retention overrides should be read once per batch")
+
+	assert.NoError(t, pgw.EnsureMetricTimescale(map[string]sinks.ExistingPartitionInfo{"db_stats": bounds},
+		map[string]map[string]bool{"db_stats": {"prod": true}}, false))
+	assert.NoError(t, conn.ExpectationsWereMet(), "existing hypertables should not need the overrides")
+}
+
+func expectPartition(conn pgxmock.PgxPoolIface) {
+	conn.ExpectQuery("ensure_partition_metric_dbname_time").WithArgs(pgxmock.AnyArg(), pgxmock.AnyArg(), pgxmock.AnyArg()).
+		WillReturnRows(pgxmock.NewRows([]string{"start_time", "end_time"}).AddRow(time.Now().Add(-time.Hour), time.Now().Add(time.Hour)))
+}
+
+func TestPostgresWriteStats(t *testing.T) {
+	conn, err := pgxmock.NewPool()
+	assert.NoError(t, err)
+	pgw := sinks.NewTestPostgresWriter(conn, sinks.DbStorageSchemaPostgres, &config.Options{})
+	msgs := []metrics.MeasurementMessage{{DBName: "db1", MetricName: "db_stats", Data: metrics.Measurements{
+		{"epoch_ns": time.Now().UnixNano(), "numbackends": 3},
+		{"epoch_ns": time.Now().UnixNano(), "numbackends": 4},
+	}}}
+
+	expectPartition(conn)
+	conn.ExpectCopyFrom(pgx.Identifier{"db_stats"}, []string{"time", "dbname", "data", "tag_data"}).
+		WillReturnError(errors.New("connection lost"))
+	assert.Error(t, pgw.WriteBatch(msgs), "a failed COPY should fail the batch")
+	stats := pgw.GetWriteStats()
+	assert.Zero(t, stats.WriteSuccesses)
+	assert.EqualValues(t, 1, stats.WriteFailures)
+	assert.EqualValues(t, 2, stats.MetricsDropped)
+
+	conn.ExpectCopyFrom(pgx.Identifier{"db_stats"}, []string{"time", "dbname", "data", "tag_data"}).WillReturnResult(2)
+	assert.NoError(t, pgw.WriteBatch(msgs))
+	stats = pgw.GetWriteStats()
+	assert.EqualValues(t, 1, stats.WriteSuccesses)
+	assert.EqualValues(t, 1, stats.WriteFailures)
+	assert.NoError(t, conn.ExpectationsWereMet())
+}
+
+func TestCopyMetricRows(t *testing.T) {
+	columns := []string{"time", "dbname", "data", "tag_data"}
+	msgs := []metrics.MeasurementMessage{{DBName: "db1", MetricName: "db_stats", Data: metrics.Measurements{
+		{"epoch_ns": time.Now().UnixNano(), "numbackends": 3},
+		{"epoch_ns": time.Now().UnixNano(), "numbackends": 4},
+	}}}
+	tests := []struct {
+		name     string
+		expect   func(conn pgxmock.PgxPoolIface)
+		failures uint64
+		dropped  uint64
+	}{
+		{
+			name: "one bad row",
+			expect: func(conn pgxmock.PgxPoolIface) {
+				conn.ExpectCopyFrom(pgx.Identifier{"db_stats"}, columns).WillReturnError(&pgconn.PgError{Code: "22P02", Message: "invalid input syntax"})
+				conn.ExpectCopyFrom(pgx.Identifier{"db_stats"}, columns).WillReturnResult(1)
+				conn.ExpectCopyFrom(pgx.Identifier{"db_stats"}, columns).WillReturnError(&pgconn.PgError{Code: "22P02", Message: "invalid input syntax"})
+			},
+			failures: 1,
+			dropped:  1,
+		},
+		{
+			name: "schema error",
+			expect: func(conn pgxmock.PgxPoolIface) {
+				conn.ExpectCopyFrom(pgx.Identifier{"db_stats"}, columns).WillReturnError(&pgconn.PgError{Code: "42703", Message: `column "data" does not exist`})
+			},
+			failures: 1,
+			dropped:  2,
+		},
+		{
+			name: "no partition",
+			expect: func(conn pgxmock.PgxPoolIface) {
+				conn.ExpectCopyFrom(pgx.Identifier{"db_stats"}, columns).WillReturnError(&pgconn.PgError{Code: "23514", Message: `no partition of relation "db_stats" found for row`})
+			},
+			failures: 1,
+			dropped:  2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, err := pgxmock.NewPool()
+			assert.NoError(t, err)
+			pgw := sinks.NewTestPostgresWriter(conn, sinks.DbStorageSchemaPostgres, &config.Options{})
+			expectPartition(conn)
+			tt.expect(conn)
+			assert.Error(t, pgw.WriteBatch(msgs))
+			stats := pgw.GetWriteStats()
+			assert.Equal(t, tt.failures, stats.WriteFailures)
+			assert.Equal(t, tt.dropped, stats.MetricsDropped)
+			assert.NoError(t, conn.ExpectationsWereMet(), "no further COPYs expected")
+		})
+	}
+}
+
+func TestTypedColumnType(t *testing.T) {
+	tests := []struct {
+		value any
+		want  string
+	}{
+		{int64(1), "bigint"},
+		{int32(1), "bigint"},
+		{uint8(1), "bigint"},
+		{1.5, "double precision"},
+		{float32(1.5), "double precision"},
+		{true, "boolean"},
+		{time.Now(), "timestamp with time zone"},
+		{"a", "text"},
+		{[]any{1, 2}, "jsonb"},
+		{map[string]any{"a": 1}, "jsonb"},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, sinks.TypedColumnType(tt.value), "%T", tt.value)
+	}
+}
+
+func TestTypedColumnValue(t *testing.T) {
+	tests := []struct {
+		value      any
+		columnType string
+		want       any
+	}{
+		{int64(3), "double precision", float64(3)},
+		{int32(3), "double precision", float64(3)},
+		{1.5, "double precision", 1.5},
+		{int64(3), "text", "3"},
+		{true, "text", "true"},
+		{"a", "text", "a"},
+		{int64(3), "bigint", int64(3)},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, sinks.TypedColumnValue(tt.value, tt.columnType), "%T to %s", tt.value, tt.columnType)
+	}
+}
+
+func TestTypedWrite(t *testing.T) {
+	conn, err := pgxmock.NewPool()
+	assert.NoError(t, err)
+	pgw := sinks.NewTestPostgresWriter(conn, sinks.DbStorageSchemaTyped, &config.Options{})
+	now := time.Now()
+	msgs := []metrics.MeasurementMessage{{DBName: "db1", MetricName: "db_stats", Data: metrics.Measurements{
+		{"epoch_ns": now.UnixNano(), "numbackends": int64(3), "tag_host": "h1"},
+		{"epoch_ns": now.UnixNano(), "numbackends": 1.5},
+	}}}
+	columns := []string{"time", "dbname", "numbackends", "tag_host"}
+	existingColumns := func() *pgxmock.Rows {
+		return pgxmock.NewRows([]string{"attname", "format_type"}).
+			AddRow("time", "timestamp with time zone").AddRow("dbname", "text").AddRow("numbackends", "bigint")
+	}
+
+	expectPartition(conn)
+	conn.ExpectQuery("from pg_attribute").WithArgs(`"public"."db_stats"`).WillReturnRows(existingColumns())
+	conn.ExpectExec(regexp.QuoteMeta(`ALTER TABLE "public"."db_stats" ADD COLUMN IF NOT EXISTS "tag_host" text`)).
+		WillReturnResult(pgxmock.NewResult("ALTER", 0))
+	conn.ExpectExec(regexp.QuoteMeta(`ALTER TABLE "public"."db_stats" ALTER COLUMN "numbackends" TYPE double precision`)).
+		WillReturnResult(pgxmock.NewResult("ALTER", 0))
+	conn.ExpectCopyFrom(pgx.Identifier{"db_stats"}, columns).
+		WillReturnError(&pgconn.PgError{Code: "23514", Message: `no partition of relation "db_stats" found for row`})
+	assert.Error(t, pgw.WriteBatch(msgs))
+
+	// partitions are re-created and the columns re-read after a failed COPY
+	expectPartition(conn)
+	expectPartition(conn) // forced for the start and the end of the batch
+	conn.ExpectQuery("from pg_attribute").WithArgs(`"public"."db_stats"`).WillReturnRows(existingColumns().
+		AddRow("tag_host", "text"))
+	conn.ExpectExec(regexp.QuoteMeta(`ALTER TABLE "public"."db_stats" ALTER COLUMN "numbackends" TYPE double precision`)).
+		WillReturnResult(pgxmock.NewResult("ALTER", 0))
+	conn.ExpectCopyFrom(pgx.Identifier{"db_stats"}, columns).WillReturnResult(2)
+	assert.NoError(t, pgw.WriteBatch(msgs))
+	assert.NoError(t, conn.ExpectationsWereMet())
+}
+
+func TestRowLevelSecurityFailureStillWrites(t *testing.T) {
+	conn, err := pgxmock.NewPool()
+	assert.NoError(t, err)
+	pgw := sinks.NewTestPostgresWriter(conn, sinks.DbStorageSchemaPostgres, &config.Options{Metric: config.MetricOpts{PGRowLevelSecurity: true}})
+	pgw.GroupResolver = func(string) string { return "team1" }
+	msgs := []metrics.MeasurementMessage{{DBName: "db1", MetricName: "db_stats", Data: metrics.Measurements{
+		{"epoch_ns": time.Now().UnixNano(), "numbackends": 3},
+	}}}
+
+	expectPartition(conn)
+	conn.ExpectExec("ensure_metric_rls").WithArgs("db_stats").WillReturnResult(pgxmock.NewResult("SELECT", 1))
+	conn.ExpectQuery("ensure_group_role").WithArgs("team1").WillReturnError(errors.New("permission denied to create role"))
+	conn.ExpectCopyFrom(pgx.Identifier{"db_stats"}, []string{"time", "dbname", "data", "tag_data", "dbgroup"}).WillReturnResult(1)
+	assert.NoError(t, pgw.WriteBatch(msgs))
+	assert.EqualValues(t, 1, pgw.GetWriteStats().WriteSuccesses)
+	assert.NoError(t, conn.ExpectationsWereMet())
+}
+
+func TestDropOldPostgresPartitions(t *testing.T) {
+	conn, err := pgxmock.NewPool()
+	assert.NoError(t, err)
+	pgw := sinks.NewTestPostgresWriter(conn, sinks.DbStorageSchemaPostgres, &config.Options{Metric: config.MetricOpts{PGRetentionDays: 14}})
+	pgw.SeedMetricAttrs(metrics.MetricVersionDefs{
+		"db_stats":      {11: {}},
+		"stat_activity": {11: {MetricAttrs: metrics.MetricAttrs{MetricStorageName: "activity", RetentionDays: 60}}},
+	})
+
+	conn.ExpectQuery("admin.config").WillReturnRows(pgxmock.NewRows([]string{"key", "value"}))
+	rp, err := pgw.GetRetentionPolicy()
+	assert.NoError(t, err)
+	assert.True(t, rp.IsKnown("db_stats"))
+	assert.Equal(t, 60, rp.Days("activity", "db1"), "attributes of renamed metrics should be seeded by the storage name")
+	assert.False(t, rp.IsKnown("custom_metric"))
+
+	old, recent := time.Now().AddDate(0, 0, -30), time.Now().AddDate(0, 0, -1)
+	conn.ExpectQuery("admin.get_time_partitions").WillReturnRows(pgxmock.NewRows([]string{"metric", "dbname", "time_partition_name", "part_end"}).
+		AddRow("db_stats", "db1", "subpartitions.db_stats_db1_old", old).
+		AddRow("db_stats", "db1", "subpartitions.db_stats_db1_recent", recent).
+		AddRow("activity", "db1", "subpartitions.activity_db1_old", old).
+		AddRow("custom_metric", "db1", "subpartitions.custom_metric_db1_old", old))
+	conn.ExpectExec("DROP TABLE IF EXISTS subpartitions.db_stats_db1_old").WillReturnResult(pgxmock.NewResult("DROP", 0))
+	dropped, err := pgw.DropOldPostgresPartitions(rp)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, dropped, "only partitions of metrics with a known retention should be dropped")
+	assert.NoError(t, conn.ExpectationsWereMet())
+
+	conn.ExpectQuery("admin.get_time_partitions").WillReturnRows(pgxmock.NewRows([]string{"metric", "dbname", "time_partition_name", "part_end"}).
+		AddRow("db_stats", "db1", "subpartitions.db_stats_db1_old", old).
+		AddRow("db_stats", "db2", "subpartitions.db_stats_db2_old", old).
+		AddRow("db_stats", "db3", "subpartitions.db_stats_db3_old", old))
+	conn.ExpectExec("DROP TABLE IF EXISTS subpartitions.db_stats_db1_old").WillReturnError(errors.New("lock timeout"))
+	conn.ExpectExec("DROP TABLE IF EXISTS subpartitions.db_stats_db2_old").WillReturnResult(pgxmock.NewResult("DROP", 0))
+	conn.ExpectExec("DROP TABLE IF EXISTS subpartitions.db_stats_db3_old").WillReturnError(errors.New("permission denied"))
+	dropped, err = pgw.DropOldPostgresPartitions(rp)
+	assert.Equal(t, 1, dropped, "a failed drop should not stop the others")
+	assert.ErrorContains(t, err, "lock timeout")
+	assert.ErrorContains(t, err, "permission denied")
+	assert.NoError(t, conn.ExpectationsWereMet())
 }
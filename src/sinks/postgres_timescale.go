@@ -0,0 +1,76 @@
+package sinks
+
+import (
+	"github.com/jackc/pgx/v5"
+)
+
+// CompressionStats is the chunk compression summary of a metric hypertable
+type CompressionStats struct {
+	TotalChunks      int64   `json:"totalChunks"`
+	CompressedChunks int64   `json:"compressedChunks"`
+	BytesBefore      int64   `json:"bytesBeforeCompression"`
+	BytesAfter       int64   `json:"bytesAfterCompression"`
+	Ratio            float64 `json:"compressionRatio"` // 0 if nothing compressed yet
+}
+
+// CompressionStats returns the compression summary per metric hypertable
+func (pgw *PostgresWriter) CompressionStats() (map[string]CompressionStats, error) {
+	sql := `select h.hypertable_name::text, coalesce(s.total_chunks, 0), coalesce(s.number_compressed_chunks, 0),
+		coalesce(s.before_compression_total_bytes, 0), coalesce(s.after_compression_total_bytes, 0)
+	from timescaledb_information.hypertables h,
+		lateral hypertable_compression_stats(format('%I.%I', h.hypertable_schema, h.hypertable_name)::regclass) s
+	where h.hypertable_schema = 'public'`
+	rows, err := pgw.SinkDb.Query(pgw.Ctx, sql)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		metric string
+		cs     CompressionStats
+	)
+	ret := make(map[string]CompressionStats)
+	_, err = pgx.ForEachRow(rows, []any{&metric, &cs.TotalChunks, &cs.CompressedChunks, &cs.BytesBefore, &cs.BytesAfter}, func() error {
+		cs.Ratio = 0
+		if cs.BytesAfter > 0 {
+			cs.Ratio = float64(cs.BytesBefore) / float64(cs.BytesAfter)
+		}
+		ret[metric] = cs
+		return nil
+	})
+	return ret, err
+}
+
+// CompressionStats returns the hypertable compression summary of the first Timescale sink, empty if there's none
+func (mw *MultiWriter) CompressionStats() (map[string]CompressionStats, error) {
+	mw.Lock()
+	writers := mw.writers
+	mw.Unlock()
+	for _, w := range writers {
+		if pgw, ok := w.(*PostgresWriter); ok && pgw.MetricSchema == DbStorageSchemaTimescale {
+			return pgw.CompressionStats()
+		}
+	}
+	return map[string]CompressionStats{}, nil
+}
+
+// ensureTimescaleRetentionPolicy keeps the hypertable retention policy in sync with the retention settings, so that
+// chunks are also dropped when the gatherer is not running
+func (pgw *PostgresWriter) ensureTimescaleRetentionPolicy(metric string, days int) (changed bool, err error) {
+	err = pgw.SinkDb.QueryRow(pgw.Ctx, `select admin.ensure_timescale_retention_policy($1, $2 * '1 day'::interval)`,
+		metric, nullIfZero(days)).Scan(&changed)
+	return
+}
+
+func nullIfEmpty(s string) any {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+func nullIfZero(i int) any {
+	if i <= 0 {
+		return nil
+	}
+	return i
+}
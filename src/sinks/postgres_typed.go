@@ -0,0 +1,162 @@
+package sinks
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/cybertec-postgresql/pgwatch3/log"
+	"github.com/jackc/pgx/v5"
+)
+
+// typedColumnType maps Go values as returned by metric queries to Postgres column types
+func typedColumnType(v any) string {
+	switch v.(type) {
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		return "bigint"
+	case float32, float64:
+		return "double precision"
+	case bool:
+		return "boolean"
+	case time.Time:
+		return "timestamp with time zone"
+	case string:
+		return "text"
+	}
+	return "jsonb"
+}
+
+// typedColumnValue converts a value to match an already existing column of a different type where possible
+func typedColumnValue(v any, columnType string) any {
+	switch columnType {
+	case "text":
+		if _, ok := v.(string); !ok {
+			return fmt.Sprint(v)
+		}
+	case "double precision":
+		switch n := v.(type) {
+		case int64:
+			return float64(n)
+		case int32:
+			return float64(n)
+		case int:
+			return float64(n)
+		}
+	}
+	return v
+}
+
+// readTypedColumns returns the current columns of a metric table
+func (pgw *PostgresWriter) readTypedColumns(metricName string) (map[string]string, error) {
+	sql := `select attname::text, format_type(atttypid, atttypmod)
+		from pg_attribute where attrelid = to_regclass($1) and attnum > 0 and not attisdropped`
+	rows, err := pgw.SinkDb.Query(pgw.Ctx, sql, pgx.Identifier{"public", metricName}.Sanitize())
+	if err != nil {
+		return nil, err
+	}
+	columns := make(map[string]string)
+	var name, typ string
+	_, err = pgx.ForEachRow(rows, []any{&name, &typ}, func() error {
+		columns[name] = typ
+		return nil
+	})
+	return columns, err
+}
+
+// ensureTypedColumns adds columns for fields and tags not yet seen for the metric. Integer columns
+// getting fractional values are widened to double precision
+func (pgw *PostgresWriter) ensureTypedColumns(metricName string, msgs []MeasurementMessagePostgres) (map[string]string, error) {
+	logger := log.GetLogger(pgw.Ctx)
+	columns, ok := pgw.typedColumnsCache[metricName]
+	if !ok || columns == nil {
+		var err error
+		if columns, err = pgw.readTypedColumns(metricName); err != nil {
+			return nil, err
+		}
+		pgw.typedColumnsCache[metricName] = columns
+	}
+	table := pgx.Identifier{"public", metricName}.Sanitize()
+	ensure := func(column string, v any) error {
+		newType := typedColumnType(v)
+		existingType, ok := columns[column]
+		switch {
+		case !ok:
+			logger.Infof("Adding column %s %s to metric table %s", column, newType, table)
+			sql := fmt.Sprintf(`ALTER TABLE %s ADD COLUMN IF NOT EXISTS %s %s`, table, pgx.Identifier{column}.Sanitize(), newType)
+			if _, err := pgw.SinkDb.Exec(pgw.Ctx, sql); err != nil {
+				return err
+			}
+			columns[column] = newType
+		case existingType == "bigint" && newType == "double precision":
+			logger.Infof("Changing column %s of metric table %s to %s", column, table, newType)
+			sql := fmt.Sprintf(`ALTER TABLE %s ALTER COLUMN %s TYPE %s`, table, pgx.Identifier{column}.Sanitize(), newType)
+			if _, err := pgw.SinkDb.Exec(pgw.Ctx, sql); err != nil {
+				return err
+			}
+			columns[column] = newType
+		}
+		return nil
+	}
+	for _, m := range msgs {
+		for k, v := range m.Data {
+			if err := ensure(k, v); err != nil {
+				return nil, err
+			}
+		}
+		for k, v := range m.TagData {
+			if err := ensure(tagPrefix+k, v); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return columns, nil
+}
+
+// typedMetricRows converts measurements into COPY rows with a column per field and tag
+func (pgw *PostgresWriter) typedMetricRows(metricName string, msgs []MeasurementMessagePostgres) ([]string, [][]any, error) {
+	columnTypes, err := pgw.ensureTypedColumns(metricName, msgs)
+	if err != nil {
+		return nil, nil, err
+	}
+	used := make(map[string]bool)
+	for _, m := range msgs {
+		for k := range m.Data {
+			used[k] = true
+		}
+		for k := range m.TagData {
+			used[tagPrefix+k] = true
+		}
+	}
+	valueColumns := make([]string, 0, len(used))
+	for k := range used {
+		valueColumns = append(valueColumns, k)
+	}
+	sort.Strings(valueColumns)
+
+	rows := make([][]any, 0, len(msgs))
+	for _, m := range msgs {
+		row := make([]any, 0, len(valueColumns)+3)
+		row = append(row, m.Time, m.DBName)
+		for _, c := range valueColumns {
+			v, ok := m.Data[c]
+			if !ok && strings.HasPrefix(c, tagPrefix) {
+				v, ok = m.TagData[strings.TrimPrefix(c, tagPrefix)]
+			}
+			if !ok {
+				row = append(row, nil)
+				continue
+			}
+			row = append(row, typedColumnValue(v, columnTypes[c]))
+		}
+		if pgw.opts.Metric.PGRowLevelSecurity {
+			row = append(row, nullIfEmpty(m.Group))
+		}
+		rows = append(rows, row)
+	}
+	columns := append([]string{"time", "dbname"}, valueColumns...)
+	if pgw.opts.Metric.PGRowLevelSecurity {
+		columns = append(columns, groupColumn)
+	}
+	return columns, rows, nil
+}
@@ -15,6 +15,7 @@ import (
 	"github.com/cybertec-postgresql/pgwatch3/metrics"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	dto "github.com/prometheus/client_model/go"
 )
 
 type PrometheusWriter struct {
@@ -22,6 +23,11 @@ type PrometheusWriter struct {
 	lastScrapeErrors                  prometheus.Gauge
 	totalScrapes, totalScrapeFailures prometheus.Counter
 	PrometheusNamespace               string
+	promAsyncMetricCache              map[string]map[string][]metrics.MeasurementMessage // [dbUnique][metric]lastly_fetched_data
+	promAsyncMetricCacheLock          sync.RWMutex
+	stats                             *sinkStats
+	selfMetrics                       prometheus.Gatherer // served next to the measurements, set by ServeSelfMetrics
+	selfMetricsLock                   sync.RWMutex
 }
 
 const promInstanceUpStateMetric = "instance_up"
@@ -30,8 +36,13 @@ const promInstanceUpStateMetric = "instance_up"
 const promScrapingStalenessHardDropLimit = time.Minute * time.Duration(10)
 
 func NewPrometheusWriter(ctx context.Context, opts *config.Options) (promw *PrometheusWriter, err error) {
+	return newPrometheusWriter(ctx, opts, newSinkStats())
+}
+
+func newPrometheusWriter(ctx context.Context, opts *config.Options, stats *sinkStats) (promw *PrometheusWriter, err error) {
 	promw = &PrometheusWriter{
 		ctx:                 ctx,
+		stats:               stats,
 		PrometheusNamespace: opts.Metric.PrometheusNamespace,
 		lastScrapeErrors: prometheus.NewGauge(prometheus.GaugeOpts{
 			Namespace: opts.Metric.PrometheusNamespace,
@@ -50,12 +61,15 @@ func NewPrometheusWriter(ctx context.Context, opts *config.Options) (promw *Prom
 		}),
 	}
 
-	if err = prometheus.Register(promw); err != nil {
+	// measurements get their own registry, so that the self-monitoring metrics of the gatherer can also be served
+	// without them on the web UI's /metrics endpoint
+	registry := prometheus.NewRegistry()
+	if err = registry.Register(promw); err != nil {
 		return
 	}
 	promServer := &http.Server{
 		Addr:    fmt.Sprintf("%s:%d", opts.Metric.PrometheusListenAddr, opts.Metric.PrometheusPort),
-		Handler: promhttp.Handler(),
+		Handler: promhttp.HandlerFor(promw.gatherer(registry), promhttp.HandlerOpts{}),
 	}
 	go func() {
 		log.GetLogger(ctx).Error(promServer.ListenAndServe())
@@ -63,6 +77,27 @@ func NewPrometheusWriter(ctx context.Context, opts *config.Options) (promw *Prom
 	return
 }
 
+// ServeSelfMetrics makes the scrape endpoint also serve the metrics of the given gatherer, e.g. the registry the
+// self-monitoring metrics are registered on. Only the measurements are served until it is set
+func (promw *PrometheusWriter) ServeSelfMetrics(g prometheus.Gatherer) {
+	promw.selfMetricsLock.Lock()
+	promw.selfMetrics = g
+	promw.selfMetricsLock.Unlock()
+}
+
+// gatherer returns the measurements of the given registry together with the self-monitoring metrics, if any
+func (promw *PrometheusWriter) gatherer(measurements prometheus.Gatherer) prometheus.Gatherer {
+	return prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+		promw.selfMetricsLock.RLock()
+		selfMetrics := promw.selfMetrics
+		promw.selfMetricsLock.RUnlock()
+		if selfMetrics == nil {
+			return measurements.Gather()
+		}
+		return prometheus.Gatherers{selfMetrics, measurements}.Gather()
+	})
+}
+
 func (promw *PrometheusWriter) Write(msgs []metrics.MeasurementMessage) error {
 	if len(msgs) == 0 || len(msgs[0].Data) == 0 { // no batching in async prom mode, so using 0 indexing ok
 		return nil
@@ -73,34 +108,35 @@ func (promw *PrometheusWriter) Write(msgs []metrics.MeasurementMessage) error {
 }
 
 // Async Prom cache
-var promAsyncMetricCache = make(map[string]map[string][]metrics.MeasurementMessage) // [dbUnique][metric]lastly_fetched_data
-var promAsyncMetricCacheLock = sync.RWMutex{}
 
 func (promw *PrometheusWriter) PromAsyncCacheAddMetricData(dbUnique, metric string, msgArr []metrics.MeasurementMessage) { // cache structure: [dbUnique][metric]lastly_fetched_data
-	promAsyncMetricCacheLock.Lock()
-	defer promAsyncMetricCacheLock.Unlock()
-	if _, ok := promAsyncMetricCache[dbUnique]; ok {
-		promAsyncMetricCache[dbUnique][metric] = msgArr
+	promw.promAsyncMetricCacheLock.Lock()
+	defer promw.promAsyncMetricCacheLock.Unlock()
+	if _, ok := promw.promAsyncMetricCache[dbUnique]; ok {
+		promw.promAsyncMetricCache[dbUnique][metric] = msgArr
 	}
 }
 
 func (promw *PrometheusWriter) PromAsyncCacheInitIfRequired(dbUnique, _ string) { // cache structure: [dbUnique][metric]lastly_fetched_data
-	promAsyncMetricCacheLock.Lock()
-	defer promAsyncMetricCacheLock.Unlock()
-	if _, ok := promAsyncMetricCache[dbUnique]; !ok {
+	promw.promAsyncMetricCacheLock.Lock()
+	defer promw.promAsyncMetricCacheLock.Unlock()
+	if promw.promAsyncMetricCache == nil {
+		promw.promAsyncMetricCache = make(map[string]map[string][]metrics.MeasurementMessage)
+	}
+	if _, ok := promw.promAsyncMetricCache[dbUnique]; !ok {
 		metricMap := make(map[string][]metrics.MeasurementMessage)
-		promAsyncMetricCache[dbUnique] = metricMap
+		promw.promAsyncMetricCache[dbUnique] = metricMap
 	}
 }
 
 func (promw *PrometheusWriter) PurgeMetricsFromPromAsyncCacheIfAny(dbUnique, metric string) {
-	promAsyncMetricCacheLock.Lock()
-	defer promAsyncMetricCacheLock.Unlock()
+	promw.promAsyncMetricCacheLock.Lock()
+	defer promw.promAsyncMetricCacheLock.Unlock()
 
 	if metric == "" {
-		delete(promAsyncMetricCache, dbUnique) // whole host removed from config
+		delete(promw.promAsyncMetricCache, dbUnique) // whole host removed from config
 	} else {
-		delete(promAsyncMetricCache[dbUnique], metric)
+		delete(promw.promAsyncMetricCache[dbUnique], metric)
 	}
 }
 
@@ -123,7 +159,7 @@ func (promw *PrometheusWriter) Collect(ch chan<- prometheus.Metric) {
 	promw.totalScrapes.Add(1)
 	ch <- promw.totalScrapes
 
-	if len(promAsyncMetricCache) == 0 {
+	if len(promw.promAsyncMetricCache) == 0 {
 		logger.Warning("No dbs configured for monitoring. Check config")
 		ch <- promw.totalScrapeFailures
 		promw.lastScrapeErrors.Set(0)
@@ -131,7 +167,7 @@ func (promw *PrometheusWriter) Collect(ch chan<- prometheus.Metric) {
 		return
 	}
 
-	for dbname, metricsMessages := range promAsyncMetricCache {
+	for dbname, metricsMessages := range promw.promAsyncMetricCache {
 		promw.setInstanceUpDownState(ch, dbname)
 		for metric, metricMessages := range metricsMessages {
 			if metric == "change_events" {
@@ -151,7 +187,9 @@ func (promw *PrometheusWriter) Collect(ch chan<- prometheus.Metric) {
 	promw.lastScrapeErrors.Set(lastScrapeErrors)
 	ch <- promw.lastScrapeErrors
 
-	// atomic.StoreInt64(&lastSuccessfulDatastoreWriteTimeEpoch, time.Now().Unix())
+	if promw.stats != nil {
+		promw.stats.lastSuccessfulWriteEpoch.Store(time.Now().Unix())
+	}
 }
 
 func (promw *PrometheusWriter) setInstanceUpDownState(ch chan<- prometheus.Metric, dbName string) {
@@ -0,0 +1,293 @@
+package sinks
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/cybertec-postgresql/pgwatch3/metrics"
+	"github.com/jackc/pgx/v5"
+)
+
+// MeasurementQuery selects stored measurements of a metric
+type MeasurementQuery struct {
+	Metric string
+	DBName string            // optional
+	Tags   map[string]string // optional, keys without the "tag_" prefix
+	From   time.Time
+	To     time.Time
+	Bucket time.Duration // optional downsampling to averages per bucket
+	Limit  int           // max rows returned, latest ones. Applies to the buckets when downsampling
+}
+
+// Reader is implemented by sinks able to return stored measurements. Returned rows have a "time" and
+// a "dbname" column, tags are prefixed with "tag_". Readers downsample as in DownsampleMeasurements()
+type Reader interface {
+	ReadMeasurements(q MeasurementQuery) (metrics.Measurements, error)
+}
+
+// ErrNoReader is returned if none of the enabled sinks supports reading
+var ErrNoReader = errors.New("no readable metric storage enabled, Postgres or Prometheus sink needed")
+
+// ReadMeasurements queries the first Postgres sink, or any other readable sink if there's none
+func (mw *MultiWriter) ReadMeasurements(q MeasurementQuery) (metrics.Measurements, error) {
+	if q.Metric == "" {
+		return nil, errors.New("metric not specified")
+	}
+	if !q.To.After(q.From) {
+		return nil, fmt.Errorf("invalid time range: %v - %v", q.From, q.To)
+	}
+	mw.Lock()
+	writers := mw.writers
+	mw.Unlock()
+	var reader Reader
+	for _, w := range writers {
+		if pgw, ok := w.(*PostgresWriter); ok {
+			reader = pgw
+			break
+		}
+		if r, ok := w.(Reader); ok && reader == nil {
+			reader = r
+		}
+	}
+	if reader == nil {
+		return nil, ErrNoReader
+	}
+	return reader.ReadMeasurements(q)
+}
+
+// DownsampleMeasurements averages numeric fields per time bucket, DB and tag set. For non-numeric
+// fields the last value is kept. Rows are expected to be ordered by time
+func DownsampleMeasurements(data metrics.Measurements, bucket time.Duration) metrics.Measurements {
+	type aggregate struct {
+		row    metrics.Measurement
+		sums   map[string]float64
+		counts map[string]int
+	}
+	var keys []string
+	aggregates := make(map[string]*aggregate)
+	for _, row := range data {
+		t, _ := row["time"].(time.Time)
+		t = t.Truncate(bucket)
+		groupKey := []string{t.String(), fmt.Sprint(row["dbname"])}
+		for k, v := range row {
+			if strings.HasPrefix(k, tagPrefix) {
+				groupKey = append(groupKey, k+"="+fmt.Sprint(v))
+			}
+		}
+		sort.Strings(groupKey[2:])
+		key := strings.Join(groupKey, "\x00")
+		agg, ok := aggregates[key]
+		if !ok {
+			agg = &aggregate{row: metrics.Measurement{"time": t}, sums: make(map[string]float64), counts: make(map[string]int)}
+			aggregates[key] = agg
+			keys = append(keys, key)
+		}
+		for k, v := range row {
+			if k == "time" {
+				continue
+			}
+			if f, ok := numericValue(v); ok && !strings.HasPrefix(k, tagPrefix) {
+				agg.sums[k] += f
+				agg.counts[k]++
+				continue
+			}
+			agg.row[k] = v
+		}
+	}
+	ret := make(metrics.Measurements, 0, len(keys))
+	for _, key := range keys {
+		agg := aggregates[key]
+		for k, sum := range agg.sums {
+			agg.row[k] = sum / float64(agg.counts[k])
+		}
+		ret = append(ret, agg.row)
+	}
+	return ret
+}
+
+func matchesTags(m metrics.Measurement, tags map[string]string) bool {
+	for k, v := range tags {
+		if fmt.Sprint(m[tagPrefix+k]) != v {
+			return false
+		}
+	}
+	return true
+}
+
+func numericValue(v any) (float64, bool) {
+	switch n := v.(type) {
+	case int:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case float32:
+		return float64(n), true
+	case float64:
+		return n, true
+	}
+	return 0, false
+}
+
+// ReadMeasurements returns the latest q.Limit rows of a metric in the time range, ordered by time. Downsampling is
+// done in SQL, so that the limit applies to the buckets
+func (pgw *PostgresWriter) ReadMeasurements(q MeasurementQuery) (metrics.Measurements, error) {
+	args := []any{q.From, q.To}
+	where := []string{"time >= $1", "time < $2"}
+	if q.DBName > "" {
+		args = append(args, q.DBName)
+		where = append(where, fmt.Sprintf("dbname = $%d", len(args)))
+	}
+	if pgw.MetricSchema == DbStorageSchemaTyped {
+		for k, v := range q.Tags {
+			args = append(args, v)
+			where = append(where, fmt.Sprintf("%s = $%d", pgx.Identifier{tagPrefix + k}.Sanitize(), len(args)))
+		}
+	} else if len(q.Tags) > 0 {
+		args = append(args, q.Tags)
+		where = append(where, fmt.Sprintf("tag_data @> $%d::jsonb", len(args)))
+	}
+	if q.Limit <= 0 {
+		q.Limit = 1000
+	}
+	table := pgx.Identifier{"public", q.Metric}.Sanitize()
+	var sql string
+	switch {
+	case q.Bucket <= 0 && pgw.MetricSchema == DbStorageSchemaTyped:
+		sql = fmt.Sprintf(`select * from %s where %s order by time desc limit %d`, table, strings.Join(where, " and "), q.Limit)
+	case q.Bucket <= 0:
+		sql = fmt.Sprintf(`select time, dbname, data, tag_data from %s where %s order by time desc limit %d`, table, strings.Join(where, " and "), q.Limit)
+	case pgw.MetricSchema == DbStorageSchemaTyped:
+		columns, err := pgw.readTypedColumns(q.Metric)
+		if err != nil {
+			return nil, err
+		}
+		sql = typedBucketSQL(table, columns, strings.Join(where, " and "), q.Bucket, q.Limit)
+	default:
+		sql = jsonbBucketSQL(table, strings.Join(where, " and "), q.Bucket, q.Limit)
+	}
+	rows, err := pgw.SinkDb.Query(pgw.Ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	data, err := pgx.CollectRows(rows, pgx.RowToMap)
+	if err != nil {
+		return nil, err
+	}
+	ret := make(metrics.Measurements, len(data))
+	for i, row := range data {
+		if pgw.MetricSchema != DbStorageSchemaTyped { // unpack JSONB
+			m := metrics.Measurement{"time": row["time"], "dbname": row["dbname"]}
+			if fields, ok := row["data"].(map[string]any); ok {
+				for k, v := range fields {
+					m[k] = v
+				}
+			}
+			if tags, ok := row["tag_data"].(map[string]any); ok {
+				for k, v := range tags {
+					m[tagPrefix+k] = v
+				}
+			}
+			row = m
+		}
+		ret[len(data)-1-i] = row // ascending by time
+	}
+	return ret, nil
+}
+
+// bucketSQL truncates the time to buckets aligned to the epoch, like time.Truncate() for buckets dividing a day
+func bucketSQL(bucket time.Duration) string {
+	return fmt.Sprintf(`to_timestamp(floor(extract(epoch from time) / %[1]g) * %[1]g)`, bucket.Seconds())
+}
+
+// jsonbBucketSQL averages the numeric fields of the JSONB schema per bucket, DB and tag set, keeping the last value
+// of other fields
+func jsonbBucketSQL(table, where string, bucket time.Duration, limit int) string {
+	return fmt.Sprintf(`select time, dbname, tag_data, jsonb_object_agg(key, value) as data
+from (
+	select %s as time, dbname, tag_data, f.key,
+		case when bool_and(jsonb_typeof(f.value) = 'number') then to_jsonb(avg((f.value #>> '{}')::float8))
+		else (array_agg(f.value order by m.time desc))[1] end as value
+	from %s m, jsonb_each(m.data) f
+	where %s
+	group by 1, 2, 3, 4
+) x
+group by 1, 2, 3
+order by time desc limit %d`, bucketSQL(bucket), table, where, limit)
+}
+
+// typedBucketSQL averages the numeric columns of the typed schema per bucket, DB and tags, keeping the last value of
+// other columns
+func typedBucketSQL(table string, columns map[string]string, where string, bucket time.Duration, limit int) string {
+	names := make([]string, 0, len(columns))
+	for c := range columns {
+		names = append(names, c)
+	}
+	sort.Strings(names)
+	selects := []string{bucketSQL(bucket) + " as time", "dbname"}
+	groupBy := []string{"1", "2"}
+	for _, c := range names {
+		ident := pgx.Identifier{c}.Sanitize()
+		switch {
+		case c == "time" || c == "dbname":
+			continue
+		case strings.HasPrefix(c, tagPrefix):
+			selects = append(selects, ident)
+			groupBy = append(groupBy, fmt.Sprint(len(selects)))
+		case columns[c] == "bigint" || columns[c] == "integer" || columns[c] == "double precision" || columns[c] == "numeric":
+			selects = append(selects, fmt.Sprintf("avg(%[1]s)::float8 as %[1]s", ident))
+		default:
+			selects = append(selects, fmt.Sprintf("(array_agg(%[1]s order by time desc) filter (where %[1]s is not null))[1] as %[1]s", ident))
+		}
+	}
+	return fmt.Sprintf(`select %s from %s where %s group by %s order by time desc limit %d`,
+		strings.Join(selects, ", "), table, where, strings.Join(groupBy, ", "), limit)
+}
+
+// ReadMeasurements returns the last fetched data from the in-memory scraping cache. Only data in the time range is returned
+func (promw *PrometheusWriter) ReadMeasurements(q MeasurementQuery) (metrics.Measurements, error) {
+	promw.promAsyncMetricCacheLock.RLock()
+	defer promw.promAsyncMetricCacheLock.RUnlock()
+	ret := make(metrics.Measurements, 0)
+	for dbname, metricsMessages := range promw.promAsyncMetricCache {
+		if q.DBName > "" && dbname != q.DBName {
+			continue
+		}
+		for _, msg := range metricsMessages[q.Metric] {
+			for _, dr := range msg.Data {
+				m := metrics.Measurement{"dbname": dbname}
+				for k, v := range msg.CustomTags {
+					m[tagPrefix+k] = v
+				}
+				for k, v := range dr {
+					if k == epochColumnName {
+						if ns, ok := v.(int64); ok {
+							m["time"] = time.Unix(0, ns)
+						}
+						continue
+					}
+					m[k] = v
+				}
+				t, _ := m["time"].(time.Time)
+				if t.Before(q.From) || !t.Before(q.To) || !matchesTags(m, q.Tags) {
+					continue
+				}
+				ret = append(ret, m)
+			}
+		}
+	}
+	sort.SliceStable(ret, func(i, j int) bool {
+		return ret[i]["time"].(time.Time).Before(ret[j]["time"].(time.Time))
+	})
+	if q.Bucket > 0 {
+		ret = DownsampleMeasurements(ret, q.Bucket)
+	}
+	if q.Limit > 0 && len(ret) > q.Limit {
+		ret = ret[len(ret)-q.Limit:]
+	}
+	return ret, nil
+}
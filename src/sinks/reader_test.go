@@ -0,0 +1,86 @@
+package sinks_test
+
+import (
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/cybertec-postgresql/pgwatch3/config"
+	"github.com/cybertec-postgresql/pgwatch3/metrics"
+	"github.com/cybertec-postgresql/pgwatch3/sinks"
+	"github.com/pashagolub/pgxmock/v3"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDownsampleMeasurements(t *testing.T) {
+	t0 := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	data := metrics.Measurements{
+		{"time": t0, "dbname": "db1", "tag_table": "a", "calls": int64(10), "state": "x"},
+		{"time": t0.Add(time.Minute), "dbname": "db1", "tag_table": "a", "calls": int64(20), "state": "y"},
+		{"time": t0.Add(time.Minute), "dbname": "db1", "tag_table": "b", "calls": int64(5)},
+		{"time": t0.Add(6 * time.Minute), "dbname": "db1", "tag_table": "a", "calls": 1.5},
+	}
+	res := sinks.DownsampleMeasurements(data, 5*time.Minute)
+	assert.Equal(t, metrics.Measurements{
+		{"time": t0, "dbname": "db1", "tag_table": "a", "calls": 15.0, "state": "y"},
+		{"time": t0, "dbname": "db1", "tag_table": "b", "calls": 5.0},
+		{"time": t0.Add(5 * time.Minute), "dbname": "db1", "tag_table": "a", "calls": 1.5},
+	}, res)
+}
+
+func TestPrometheusReadMeasurements(t *testing.T) {
+	promw := &sinks.PrometheusWriter{}
+	now := time.Now()
+	_ = promw.SyncMetric("db1", "db_stats", "add")
+	promw.PromAsyncCacheAddMetricData("db1", "db_stats", []metrics.MeasurementMessage{{
+		DBName:     "db1",
+		MetricName: "db_stats",
+		CustomTags: map[string]string{"env": "prod"},
+		Data:       metrics.Measurements{{"epoch_ns": now.UnixNano(), "numbackends": int64(3)}},
+	}})
+	defer func() { _ = promw.SyncMetric("db1", "", "remove") }()
+
+	q := sinks.MeasurementQuery{Metric: "db_stats", From: now.Add(-time.Minute), To: now.Add(time.Minute)}
+	res, err := promw.ReadMeasurements(q)
+	assert.NoError(t, err)
+	assert.Equal(t, metrics.Measurements{{"time": time.Unix(0, now.UnixNano()), "dbname": "db1", "tag_env": "prod", "numbackends": int64(3)}}, res)
+
+	q.Tags = map[string]string{"env": "test"}
+	res, err = promw.ReadMeasurements(q)
+	assert.NoError(t, err)
+	assert.Empty(t, res)
+}
+
+func TestPostgresReadMeasurementsBucketed(t *testing.T) {
+	conn, err := pgxmock.NewPool()
+	assert.NoError(t, err)
+	t0 := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	q := sinks.MeasurementQuery{Metric: "db_stats", DBName: "db1", From: t0.Add(-time.Hour), To: t0.Add(time.Hour), Bucket: 5 * time.Minute, Limit: 2}
+
+	pgw := sinks.NewTestPostgresWriter(conn, sinks.DbStorageSchemaPostgres, &config.Options{})
+	conn.ExpectQuery(regexp.QuoteMeta(`to_timestamp(floor(extract(epoch from time) / 300) * 300) as time`)+`.*jsonb_each.*`+
+		regexp.QuoteMeta(`order by time desc limit 2`)).WithArgs(q.From, q.To, "db1").
+		WillReturnRows(pgxmock.NewRows([]string{"time", "dbname", "tag_data", "data"}).
+			AddRow(t0.Add(5*time.Minute), "db1", map[string]any{"table": "a"}, map[string]any{"calls": 1.5}).
+			AddRow(t0, "db1", map[string]any{"table": "a"}, map[string]any{"calls": 15.0, "state": "y"}))
+	res, err := pgw.ReadMeasurements(q)
+	assert.NoError(t, err)
+	assert.Equal(t, metrics.Measurements{
+		{"time": t0, "dbname": "db1", "tag_table": "a", "calls": 15.0, "state": "y"},
+		{"time": t0.Add(5 * time.Minute), "dbname": "db1", "tag_table": "a", "calls": 1.5},
+	}, res, "the limit should apply to the buckets, returned in ascending order")
+
+	pgw = sinks.NewTestPostgresWriter(conn, sinks.DbStorageSchemaTyped, &config.Options{})
+	conn.ExpectQuery("from pg_attribute").WithArgs(`"public"."db_stats"`).WillReturnRows(pgxmock.NewRows([]string{"attname", "format_type"}).
+		AddRow("time", "timestamp with time zone").AddRow("dbname", "text").AddRow("calls", "bigint").
+		AddRow("state", "text").AddRow("tag_table", "text"))
+	conn.ExpectQuery(regexp.QuoteMeta(`select to_timestamp(floor(extract(epoch from time) / 300) * 300) as time, dbname, avg("calls")::float8 as "calls", `+
+		`(array_agg("state" order by time desc) filter (where "state" is not null))[1] as "state", "tag_table" from "public"."db_stats" `+
+		`where time >= $1 and time < $2 and dbname = $3 group by 1, 2, 5 order by time desc limit 2`)).WithArgs(q.From, q.To, "db1").
+		WillReturnRows(pgxmock.NewRows([]string{"time", "dbname", "calls", "state", "tag_table"}).
+			AddRow(t0, "db1", 15.0, "y", "a"))
+	res, err = pgw.ReadMeasurements(q)
+	assert.NoError(t, err)
+	assert.Equal(t, metrics.Measurements{{"time": t0, "dbname": "db1", "calls": 15.0, "state": "y", "tag_table": "a"}}, res)
+	assert.NoError(t, conn.ExpectationsWereMet())
+}
@@ -0,0 +1,117 @@
+package sinks
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// WriteStats are the totals of the sinks of a MultiWriter since its start
+type WriteStats struct {
+	MetricsDropped           uint64
+	WriteFailures            uint64
+	WriteSuccesses           uint64
+	TotalWriteTimeMicros     uint64 // successful writes only
+	LastSuccessfulWriteEpoch int64
+}
+
+// sinkStats are the write statistics shared by the writers of a MultiWriter, so that several gatherers in one process
+// don't mix up their numbers
+type sinkStats struct {
+	metricsDropped           atomic.Uint64
+	writeFailures            atomic.Uint64
+	writeSuccesses           atomic.Uint64
+	totalWriteTimeMicros     atomic.Uint64
+	lastSuccessfulWriteEpoch atomic.Int64
+
+	writeDuration         *prometheus.HistogramVec // per sink
+	writeErrors           *prometheus.CounterVec   // per sink
+	postgresWriteDuration *prometheus.HistogramVec // per metric
+	postgresWriteRows     *prometheus.HistogramVec // per metric
+}
+
+func newSinkStats() *sinkStats {
+	return &sinkStats{
+		writeDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "pgwatch3",
+			Name:      "sink_write_duration_seconds",
+			Help:      "Duration of handing a set of measurements over to a sink",
+			Buckets:   prometheus.ExponentialBuckets(0.0001, 4, 8),
+		}, []string{"sink"}),
+		writeErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "pgwatch3",
+			Name:      "sink_write_errors_total",
+			Help:      "Number of failed writes per sink",
+		}, []string{"sink"}),
+		postgresWriteDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "pgwatch3",
+			Name:      "sink_postgres_write_duration_seconds",
+			Help:      "Duration of storing a batch of a metric to the Postgres metrics DB",
+			Buckets:   prometheus.ExponentialBuckets(0.001, 4, 8),
+		}, []string{"metric"}),
+		postgresWriteRows: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "pgwatch3",
+			Name:      "sink_postgres_write_rows",
+			Help:      "Number of rows per COPY to the Postgres metrics DB",
+			Buckets:   prometheus.ExponentialBuckets(1, 4, 8),
+		}, []string{"metric"}),
+	}
+}
+
+// recordWriteSuccess registers a successful synchronous write, e.g. a COPY batch or a JSON file append
+func (s *sinkStats) recordWriteSuccess(start time.Time) {
+	s.lastSuccessfulWriteEpoch.Store(start.Unix())
+	s.totalWriteTimeMicros.Add(uint64(time.Since(start).Microseconds()))
+	s.writeSuccesses.Add(1)
+}
+
+// recordWriteFailure registers a failed write, dropping so many measurements
+func (s *sinkStats) recordWriteFailure(dropped int) {
+	s.writeFailures.Add(1)
+	s.metricsDropped.Add(uint64(dropped))
+}
+
+func (s *sinkStats) get() WriteStats {
+	return WriteStats{
+		MetricsDropped:           s.metricsDropped.Load(),
+		WriteFailures:            s.writeFailures.Load(),
+		WriteSuccesses:           s.writeSuccesses.Load(),
+		TotalWriteTimeMicros:     s.totalWriteTimeMicros.Load(),
+		LastSuccessfulWriteEpoch: s.lastSuccessfulWriteEpoch.Load(),
+	}
+}
+
+// collectors returns the totals and the per sink statistics for exposing them to Prometheus
+func (s *sinkStats) collectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		s.writeDuration,
+		s.writeErrors,
+		s.postgresWriteDuration,
+		s.postgresWriteRows,
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Namespace: "pgwatch3",
+			Name:      "sink_measurements_dropped_total",
+			Help:      "Number of measurements not stored due to errors or overload",
+		}, func() float64 { return float64(s.metricsDropped.Load()) }),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: "pgwatch3",
+			Name:      "sink_last_successful_write_timestamp_seconds",
+			Help:      "Time of the last successful write to a metrics DB or file",
+		}, func() float64 { return float64(s.lastSuccessfulWriteEpoch.Load()) }),
+	}
+}
+
+// sinkName is the "sink" label value of a writer
+func sinkName(w Writer) string {
+	switch w.(type) {
+	case *PostgresWriter:
+		return "postgres"
+	case *PrometheusWriter:
+		return "prometheus"
+	case *JSONWriter:
+		return "json"
+	default:
+		return "other"
+	}
+}
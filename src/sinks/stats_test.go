@@ -0,0 +1,77 @@
+package sinks_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/cybertec-postgresql/pgwatch3/config"
+	"github.com/cybertec-postgresql/pgwatch3/metrics"
+	"github.com/cybertec-postgresql/pgwatch3/sinks"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetWriteStats(t *testing.T) {
+	newWriter := func() *sinks.MultiWriter {
+		opts := &config.Options{Metric: config.MetricOpts{JSONStorageFile: []string{filepath.Join(t.TempDir(), "metrics.json")}}}
+		mw, err := sinks.NewMultiWriter(ctx, opts, nil)
+		assert.NoError(t, err)
+		return mw
+	}
+	mw, other := newWriter(), newWriter()
+	storageCh := make(chan []metrics.MeasurementMessage, 1)
+	storageCh <- []metrics.MeasurementMessage{{DBName: "db1", MetricName: "db_stats", Data: metrics.Measurements{{"numbackends": 3}}}}
+	stopped, cancel := context.WithCancel(ctx)
+	cancel()
+	mw.WriteMetrics(stopped, storageCh)
+	stats := mw.GetWriteStats()
+	assert.EqualValues(t, 1, stats.WriteSuccesses)
+	assert.Zero(t, stats.WriteFailures)
+	assert.NotZero(t, stats.LastSuccessfulWriteEpoch)
+	assert.Zero(t, other.GetWriteStats(), "writers should not share statistics")
+
+	reg := prometheus.NewRegistry()
+	assert.NoError(t, mw.RegisterMetrics(reg))
+	mfs, err := reg.Gather()
+	assert.NoError(t, err)
+	names := make([]string, 0, len(mfs))
+	for _, mf := range mfs {
+		names = append(names, mf.GetName())
+	}
+	assert.Contains(t, names, "pgwatch3_sink_last_successful_write_timestamp_seconds")
+	assert.Error(t, other.RegisterMetrics(reg), "two writers on one registry should conflict")
+}
+
+func TestPrometheusSelfMetrics(t *testing.T) {
+	gathered := func(g prometheus.Gatherer) []string {
+		mfs, err := g.Gather()
+		assert.NoError(t, err)
+		names := make([]string, 0, len(mfs))
+		for _, mf := range mfs {
+			names = append(names, mf.GetName())
+		}
+		return names
+	}
+	newWriter := func() (*sinks.MultiWriter, prometheus.Gatherer) {
+		opts := &config.Options{Metric: config.MetricOpts{PrometheusListenAddr: "127.0.0.1"}}
+		mw, err := sinks.NewMultiWriter(ctx, opts, nil)
+		assert.NoError(t, err)
+		gatherers := mw.PrometheusGatherers()
+		assert.Len(t, gatherers, 1)
+		return mw, gatherers[0]
+	}
+	mw1, g1 := newWriter()
+	mw2, g2 := newWriter()
+	assert.Empty(t, gathered(g1), "only the measurements should be served without a registry")
+
+	reg1, reg2 := prometheus.NewRegistry(), prometheus.NewRegistry()
+	reg1.MustRegister(prometheus.NewCounter(prometheus.CounterOpts{Name: "first_total", Help: "first"}))
+	reg2.MustRegister(prometheus.NewCounter(prometheus.CounterOpts{Name: "second_total", Help: "second"}))
+	assert.NoError(t, mw1.RegisterMetrics(reg1))
+	assert.NoError(t, mw2.RegisterMetrics(reg2))
+	assert.Contains(t, gathered(g1), "first_total")
+	assert.NotContains(t, gathered(g1), "second_total", "writers should not expose each other's metrics")
+	assert.Contains(t, gathered(g2), "second_total")
+	assert.NotContains(t, gathered(g2), "first_total")
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"os"
+
+	"github.com/cybertec-postgresql/pgwatch3/config"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// InitTracing sets up exporting spans via OTLP or to a local file if --trace-exporter is set. The returned function
+// flushes the spans still buffered and closes the exporter
+func InitTracing(ctx context.Context, opts *config.Options) (shutdown func(context.Context) error, err error) {
+	var exporter sdktrace.SpanExporter
+	var file *os.File
+	switch opts.TraceExporter {
+	case "":
+		return func(context.Context) error { return nil }, nil
+	case "otlp":
+		exporter, err = otlptracehttp.New(ctx)
+	case "file":
+		if file, err = os.OpenFile(opts.TraceFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0640); err != nil {
+			return nil, err
+		}
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(file))
+	}
+	if err != nil {
+		return nil, err
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", "pgwatch3"), attribute.String("service.version", version))),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(opts.TraceSampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+	return func(ctx context.Context) error {
+		err := provider.Shutdown(ctx)
+		if file != nil {
+			_ = file.Close()
+		}
+		return err
+	}, nil
+}
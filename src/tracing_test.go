@@ -0,0 +1,34 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cybertec-postgresql/pgwatch3/config"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+)
+
+func TestInitTracing(t *testing.T) {
+	fname := filepath.Join(t.TempDir(), "traces.json")
+	opts := &config.Options{TraceExporter: "file", TraceFile: fname, TraceSampleRatio: 1}
+
+	ctx := context.Background()
+	shutdown, err := InitTracing(ctx, opts)
+	assert.NoError(t, err)
+	tracer := otel.Tracer("test")
+	ctx, span := tracer.Start(ctx, "FetchMetrics")
+	_, child := tracer.Start(ctx, "DBExecReadByDbUniqueName")
+	child.SetStatus(codes.Error, "connection refused")
+	child.End()
+	span.End()
+	assert.NoError(t, shutdown(ctx))
+
+	content, err := os.ReadFile(fname)
+	assert.NoError(t, err)
+	assert.Contains(t, string(content), `"Name":"FetchMetrics"`)
+	assert.Contains(t, string(content), `"Description":"connection refused"`)
+}
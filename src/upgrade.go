@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/cybertec-postgresql/pgwatch3/config"
+	"github.com/cybertec-postgresql/pgwatch3/db"
+)
+
+// ListPendingMigrations prints the pending schema migrations of the configuration and metric storage databases
+// without applying them, e.g. before running with --upgrade
+func ListPendingMigrations(ctx context.Context, configKind config.Kind) error {
+	list := func(title, connStr string, pending func(context.Context, db.PgxIface) ([]db.Migration, error)) error {
+		conn, err := db.GetPostgresDBConnection(ctx, connStr)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		migrations, err := pending(ctx, conn)
+		if err != nil {
+			return fmt.Errorf("%s: %w", title, err)
+		}
+		fmt.Printf("%s: %d pending migration(s)\n", title, len(migrations))
+		for _, m := range migrations {
+			fmt.Printf("  %s %s\n", m.Version, m.Description)
+		}
+		return nil
+	}
+	if configKind == config.ConfigPgURL {
+		if err := list("configuration database", opts.Connection.Config, db.PendingConfigMigrations); err != nil {
+			return err
+		}
+	}
+	for i, connStr := range opts.Metric.PGMetricStoreConnStr {
+		if err := list(fmt.Sprintf("metric storage database #%d", i+1), connStr, db.PendingMetricMigrations); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UpgradeMetricStores creates or migrates the schema of all Postgres metric storage databases
+func UpgradeMetricStores(ctx context.Context) error {
+	for _, connStr := range opts.Metric.PGMetricStoreConnStr {
+		conn, err := db.InitAndTestMetricStoreConnection(ctx, connStr)
+		if err != nil {
+			return err
+		}
+		conn.Close()
+	}
+	return nil
+}
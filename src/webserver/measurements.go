@@ -0,0 +1,134 @@
+package webserver
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cybertec-postgresql/pgwatch3/metrics"
+	"github.com/cybertec-postgresql/pgwatch3/sinks"
+)
+
+const maxMeasurementsLimit = 100000
+
+// parseQueryTime accepts RFC3339 timestamps or durations meaning "ago", e.g. "1h"
+func parseQueryTime(s string, def time.Time) (time.Time, error) {
+	if s == "" {
+		return def, nil
+	}
+	if d, err := time.ParseDuration(s); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+func parseMeasurementQuery(v url.Values) (q sinks.MeasurementQuery, err error) {
+	q.Metric = v.Get("metric")
+	q.DBName = v.Get("dbname")
+	q.Tags = make(map[string]string)
+	for k := range v {
+		if tag, ok := strings.CutPrefix(k, "tag_"); ok {
+			q.Tags[tag] = v.Get(k)
+		}
+	}
+	now := time.Now()
+	if q.From, err = parseQueryTime(v.Get("from"), now.Add(-time.Hour)); err != nil {
+		return q, fmt.Errorf("invalid 'from': %w", err)
+	}
+	if q.To, err = parseQueryTime(v.Get("to"), now); err != nil {
+		return q, fmt.Errorf("invalid 'to': %w", err)
+	}
+	if b := v.Get("bucket"); b > "" {
+		if q.Bucket, err = time.ParseDuration(b); err != nil {
+			return q, fmt.Errorf("invalid 'bucket': %w", err)
+		}
+	}
+	q.Limit = 1000
+	if l := v.Get("limit"); l > "" {
+		if q.Limit, err = strconv.Atoi(l); err != nil {
+			return q, fmt.Errorf("invalid 'limit': %w", err)
+		}
+	}
+	q.Limit = min(q.Limit, maxMeasurementsLimit)
+	return
+}
+
+// writeMeasurementsCSV outputs "time" and "dbname" first, then all other columns sorted
+func writeMeasurementsCSV(w http.ResponseWriter, data metrics.Measurements) error {
+	seen := make(map[string]bool)
+	columns := []string{}
+	for _, row := range data {
+		for k := range row {
+			if !seen[k] && k != "time" && k != "dbname" {
+				columns = append(columns, k)
+			}
+			seen[k] = true
+		}
+	}
+	sort.Strings(columns)
+	columns = append([]string{"time", "dbname"}, columns...)
+
+	w.Header().Set("Content-Type", "text/csv")
+	cw := csv.NewWriter(w)
+	if err := cw.Write(columns); err != nil {
+		return err
+	}
+	record := make([]string, len(columns))
+	for _, row := range data {
+		for i, c := range columns {
+			switch v := row[c].(type) {
+			case nil:
+				record[i] = ""
+			case time.Time:
+				record[i] = v.Format(time.RFC3339Nano)
+			default:
+				record[i] = fmt.Sprint(v)
+			}
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func (Server *WebUIServer) handleMeasurements(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		// query stored measurements, e.g. /measurements?metric=db_stats&dbname=test&from=2h&bucket=5m&format=csv
+		q, err := parseMeasurementQuery(r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		data, err := Server.api.GetMeasurements(q)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if r.URL.Query().Get("format") == "csv" {
+			err = writeMeasurementsCSV(w, data)
+		} else {
+			w.Header().Set("Content-Type", "application/json")
+			err = json.NewEncoder(w).Encode(data)
+		}
+		if err != nil {
+			Server.l.Error("Failed to write measurements: ", err)
+		}
+
+	case http.MethodOptions:
+		w.Header().Set("Allow", "GET, OPTIONS")
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		w.Header().Set("Allow", "GET, OPTIONS")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
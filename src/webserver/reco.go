@@ -0,0 +1,48 @@
+package webserver
+
+import (
+	"io"
+	"net/http"
+)
+
+func (Server *WebUIServer) handleRecommendationAcks(w http.ResponseWriter, r *http.Request) {
+	var (
+		err    error
+		params []byte
+		res    string
+	)
+
+	defer func() {
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	}()
+
+	switch r.Method {
+	case http.MethodGet:
+		// return acknowledged recommendations, all or for a single DB
+		if res, err = Server.api.GetRecommendationAcks(r.URL.Query().Get("dbname")); err != nil {
+			return
+		}
+		_, err = w.Write([]byte(res))
+
+	case http.MethodPost:
+		// acknowledge or snooze a recommendation
+		if params, err = io.ReadAll(r.Body); err != nil {
+			return
+		}
+		err = Server.api.AddRecommendationAck(params)
+
+	case http.MethodDelete:
+		// remove acknowledgement
+		err = Server.api.DeleteRecommendationAck(r.URL.Query().Get("dbname"), r.URL.Query().Get("id"))
+
+	case http.MethodOptions:
+		w.Header().Set("Allow", "GET, POST, DELETE, OPTIONS")
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		w.Header().Set("Allow", "GET, POST, DELETE, OPTIONS")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -1,6 +1,8 @@
 package webserver_test
 
 import (
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"testing"
 
@@ -21,3 +23,12 @@ func TestStatus(t *testing.T) {
 	// assert.NoError(t, err)
 	// assert.True(t, len(b) > 0)
 }
+
+func TestMetricsAuth(t *testing.T) {
+	for public, code := range map[bool]int{false: http.StatusUnauthorized, true: http.StatusOK} {
+		restsrv := webserver.Init(config.WebUIOpts{WebAddr: "127.0.0.1:0", WebMetricsPublic: public}, os.DirFS("../webui/build"), nil, log.FallbackLogger)
+		w := httptest.NewRecorder()
+		restsrv.Handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+		assert.Equal(t, code, w.Code, "public: %v", public)
+	}
+}
@@ -14,6 +14,9 @@ import (
 
 	"github.com/cybertec-postgresql/pgwatch3/config"
 	"github.com/cybertec-postgresql/pgwatch3/log"
+	"github.com/cybertec-postgresql/pgwatch3/metrics"
+	"github.com/cybertec-postgresql/pgwatch3/sinks"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 type apiHandler interface {
@@ -29,7 +32,13 @@ type apiHandler interface {
 	AddPreset(params []byte) error
 	DeletePreset(name string) error
 	UpdatePreset(id string, params []byte) error
+	GetRecommendationAcks(dbname string) (res string, err error)
+	AddRecommendationAck(params []byte) error
+	DeleteRecommendationAck(dbname, id string) error
+	GetMeasurements(q sinks.MeasurementQuery) (metrics.Measurements, error)
 	GetStats() string
+	GetHealth(dbname string) (string, error)
+	GetMetricOrigins() (string, error)
 	TryConnectToDB(params []byte) error
 }
 
@@ -61,8 +70,17 @@ func Init(opts config.WebUIOpts, webuifs fs.FS, api apiHandler, logger log.Logge
 	mux.Handle("/test-connect", NewEnsureAuth(s.handleTestConnect))
 	mux.Handle("/metric", NewEnsureAuth(s.handleMetrics))
 	mux.Handle("/preset", NewEnsureAuth(s.handlePresets))
+	mux.Handle("/reco_ack", NewEnsureAuth(s.handleRecommendationAcks))
+	mux.Handle("/measurements", NewEnsureAuth(s.handleMeasurements))
 	mux.Handle("/stats", NewEnsureAuth(s.handleStats))
+	mux.Handle("/health", NewEnsureAuth(s.handleHealth))
+	mux.Handle("/metric_origins", NewEnsureAuth(s.handleMetricOrigins))
 	mux.Handle("/log", NewEnsureAuth(s.serveWsLog))
+	if opts.WebMetricsPublic { // self-monitoring in the Prometheus format
+		mux.Handle("/metrics", promhttp.Handler())
+	} else {
+		mux.Handle("/metrics", NewEnsureAuth(promhttp.Handler().ServeHTTP))
+	}
 	mux.HandleFunc("/login", s.handleLogin)
 	mux.HandleFunc("/", s.handleStatic)
 
@@ -76,7 +94,7 @@ func (Server *WebUIServer) handleStatic(w http.ResponseWriter, r *http.Request)
 		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
 		return
 	}
-	routes := []string{"/", "/dashboard", "/metrics", "/presets", "/stats_summary", "/logs"}
+	routes := []string{"/", "/dashboard", "/metric_definitions", "/presets", "/stats_summary", "/logs"}
 	path := r.URL.Path
 	if slices.Contains(routes, path) {
 		path = "index.html"
@@ -120,6 +138,40 @@ func (Server *WebUIServer) handleStats(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+func (Server *WebUIServer) handleHealth(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		// return the health of all monitored DBs or of a single one
+		res, err := Server.api.GetHealth(r.URL.Query().Get("dbname"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(res))
+	default:
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (Server *WebUIServer) handleMetricOrigins(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		// return the layer each effective metric definition came from
+		res, err := Server.api.GetMetricOrigins()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(res))
+	default:
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
 func (Server *WebUIServer) handleTestConnect(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case http.MethodPost:
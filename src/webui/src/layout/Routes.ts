@@ -21,7 +21,7 @@ export const privateRoutes = [
   },
   {
     title: "Metric definitions",
-    link: "/metrics",
+    link: "/metric_definitions",
     element: MetricDefinitions,
   },
   {